@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 )
 
 // 定义颜色常量（ANSI转义序列）
@@ -58,15 +59,38 @@ var (
 	targetFlag         string
 	modelFlag          string
 	targetFileFlag     string
+	inputFormatFlag    string
+	excludeFlag        string
+	excludeFileFlag    string
 	outputFlag         string
+	outputFormatFlag   string
+	nmapXMLFlag        string
+	ipFamilyFlag       string
+	portsFlag          string
+	udpPortsFlag       string
+	resolverFlag       string
+	hostsFileFlag      string
 	webFPFlag          string
 	serviceFPFlag      string
+	udpFPFlag          string
+	portConfigFlag     string
 	featureMapFlag     string
 	threadFlag         int
+	portThreadFlag     int
+	maxConnsFlag       int
+	udpRateFlag        int
+	timeoutFlag        time.Duration
+	minTimeoutFlag     time.Duration
+	maxTimeoutFlag     time.Duration
+	noAdaptiveFlag     bool
 	disableFaviconFlag bool
 	disableTCPFlag     bool
+	udpFlag            bool
+	icsFlag            bool
+	perIPFlag          bool
 	silentFlag         bool
 	jsonOutputFlag     bool
+	jsonSchemaFlag     bool
 	debugFlag          bool
 	bpStatFlag         bool // 添加BP-stat标志
 )
@@ -77,17 +101,42 @@ func init() {
 
 	// 解析命令行参数
 	flag.IntVar(&threadFlag, "c", 5, "并发数")
+	flag.IntVar(&portThreadFlag, "pc", 20, "单个目标同时探测的端口数")
+	flag.IntVar(&maxConnsFlag, "max-conns", 200, "全局同时打开的连接数上限，所有目标和端口共享，0表示不限制")
+	flag.DurationVar(&timeoutFlag, "timeout", 2*time.Second, "HTTP请求超时，也是服务探测在测得RTT之前的初始超时")
+	flag.DurationVar(&minTimeoutFlag, "min-timeout", 300*time.Millisecond, "自适应超时的下限")
+	flag.DurationVar(&maxTimeoutFlag, "max-timeout", 10*time.Second, "自适应超时的上限")
+	flag.BoolVar(&noAdaptiveFlag, "no-adaptive-timeout", false, "禁用自适应超时，所有连接和读取都使用-timeout")
 	flag.BoolVar(&debugFlag, "debug", false, "调试模式")
 	flag.StringVar(&featureMapFlag, "map", "feature_map.json", "特征映射文件路径")
-	flag.StringVar(&targetFileFlag, "f", "", "从文件读取目标列表")
+	flag.StringVar(&targetFileFlag, "f", "", "从文件读取目标列表，\"-\"表示从标准输入读取")
+	flag.StringVar(&inputFormatFlag, "input-format", "auto", "目标文件格式: auto, list, nmap, masscan-json, masscan-list, httpx")
 	flag.StringVar(&modelFlag, "m", "web", "扫描模式: web, service, all")
-	flag.StringVar(&targetFlag, "u", "", "指定扫描的目标")
+	flag.StringVar(&portsFlag, "p", "", "服务扫描端口: 80,443,8000-8100, top-100, top-1000, -（全部端口，可写作-p-），默认使用配置文件中的端口")
+	flag.BoolVar(&udpFlag, "udp", false, "服务扫描时同时做UDP服务识别（DNS、SNMP、NTP、SSDP、NetBIOS、IPMI、TFTP等）")
+	flag.StringVar(&udpPortsFlag, "pu", "", "UDP扫描端口: 53,161,1900-1910，默认使用UDP探针声明的端口")
+	flag.BoolVar(&icsFlag, "ics", false, "服务扫描时识别工控协议（Modbus、S7、EtherNet/IP、DNP3、IEC-104，BACnet需同时启用-udp），只发送只读的识别请求")
+	flag.IntVar(&udpRateFlag, "udp-rate", 100, "每秒发送的UDP报文数上限，所有目标共享，0表示不限制")
+	flag.StringVar(&targetFlag, "u", "", "指定扫描的目标，支持CIDR、IP范围和端口列表，\"-\"表示从标准输入读取")
+	flag.StringVar(&excludeFlag, "exclude", "", "排除的目标，支持IP、CIDR、IP范围和主机名，多个以逗号分隔")
+	flag.StringVar(&excludeFileFlag, "exclude-file", "", "从文件读取排除列表")
+	flag.StringVar(&ipFamilyFlag, "ip-family", "auto", "地址族: auto, ipv4(优先IPv4), ipv6(优先IPv6), dual(分别扫描A和AAAA记录的每个地址)")
+	flag.StringVar(&resolverFlag, "resolver", "", "DNS服务器，格式为IP或IP:端口，多个以逗号分隔，默认使用系统解析")
+	flag.StringVar(&hostsFileFlag, "hosts-file", "", "hosts格式的解析覆盖文件，其中的记录优先于DNS")
+	flag.BoolVar(&perIPFlag, "per-ip", false, "分别扫描主机名解析出的每个地址")
 	flag.BoolVar(&disableFaviconFlag, "no-favicon", false, "禁用Favicon检测")
-	flag.StringVar(&outputFlag, "o", "", "输出html文件")
+	flag.StringVar(&outputFlag, "o", "", "输出文件路径，格式根据扩展名推断（.html, .csv, .md, .json, .jsonl, 其他为文本）")
+	flag.StringVar(&outputFormatFlag, "of", "", "输出格式: csv, md, txt, json, jsonl, html, xml")
+	flag.StringVar(&nmapXMLFlag, "oX", "", "额外输出nmap兼容的XML文件，可被Metasploit db_import等工具导入")
 	flag.BoolVar(&silentFlag, "silent", false, "静默模式，仅输出结果")
-	flag.StringVar(&serviceFPFlag, "s", "configs/service_fingerprint_v4.json", "服务指纹库文件路径")
+	flag.StringVar(&serviceFPFlag, "s", "configs/service_fingerprint_v4.json,configs/nmap-service-probes", "服务指纹库文件路径，支持JSON指纹和nmap-service-probes格式，多个以逗号分隔；默认路径的文件不存在时跳过")
+	flag.StringVar(&udpFPFlag, "su", "configs/udp_fingerprint.json", "UDP指纹库文件路径，启用-udp时必须存在，否则存在时加载以便扫描udp://目标")
+	flag.StringVar(&portConfigFlag, "port-config", "configs/tcp_ports.json", "服务端口配置文件路径，包含默认扫描端口和服务到常用端口的映射")
 	flag.StringVar(&webFPFlag, "w", "configs/web_fingerprint_v4.json", "Web指纹库文件路径")
 	flag.BoolVar(&bpStatFlag, "BP-stat", false, "只输出有指纹匹配的结果，不输出仅有状态码的结果")
+	flag.BoolVar(&jsonOutputFlag, "json", false, "以JSON Lines格式实时输出结果，写入-o指定文件或标准输出")
+	flag.BoolVar(&jsonOutputFlag, "jsonl", false, "同 -json")
+	flag.BoolVar(&jsonSchemaFlag, "json-schema", false, "打印JSON结果格式的JSON Schema后退出")
 }
 
 // 自定义Usage输出
@@ -112,7 +161,7 @@ func customUsage() {
 
 	// 按照用户指定的顺序显示参数
 	orderedFlags := []string{
-		"c", "debug", "f", "m", "u", "no-favicon", "o", "silent", "map", "s", "w", "BP-stat",
+		"c", "pc", "max-conns", "timeout", "min-timeout", "max-timeout", "no-adaptive-timeout", "debug", "f", "input-format", "m", "u", "p", "udp", "pu", "udp-rate", "ics", "exclude", "exclude-file", "ip-family", "resolver", "hosts-file", "per-ip", "no-favicon", "o", "of", "oX", "json", "jsonl", "json-schema", "silent", "map", "s", "su", "w", "port-config", "BP-stat",
 	}
 
 	// 遍历按顺序显示标志
@@ -146,6 +195,24 @@ func customUsage() {
 		ColorBrightYellow, ColorReset)
 	fmt.Fprintf(os.Stderr, "  %s./nebulafinger -f targets.txt -o results.html%s\n",
 		ColorBrightYellow, ColorReset)
-	fmt.Fprintf(os.Stderr, "  %s./nebulafinger -u example.com -m all -c 10%s\n\n",
+	fmt.Fprintf(os.Stderr, "  %s./nebulafinger -u example.com -m all -c 10%s\n",
+		ColorBrightYellow, ColorReset)
+	fmt.Fprintf(os.Stderr, "  %s./nebulafinger -u 10.0.0.0/24 -m service -p top-1000%s\n",
+		ColorBrightYellow, ColorReset)
+	fmt.Fprintf(os.Stderr, "  %s./nebulafinger -u 10.0.0.1 -m service -udp%s\n",
+		ColorBrightYellow, ColorReset)
+	fmt.Fprintf(os.Stderr, "  %s./nebulafinger -u 192.168.1.0/24 -m service -ics -udp%s\n",
+		ColorBrightYellow, ColorReset)
+	fmt.Fprintf(os.Stderr, "  %s./nebulafinger -u 10.0.0.1 -m service -s /usr/share/nmap/nmap-service-probes%s\n",
+		ColorBrightYellow, ColorReset)
+	fmt.Fprintf(os.Stderr, "  %s./nebulafinger -u [2001:db8::1]:8080 -ip-family ipv6%s\n",
+		ColorBrightYellow, ColorReset)
+	fmt.Fprintf(os.Stderr, "  %s./nebulafinger -f targets.txt -jsonl -o results.jsonl%s\n",
+		ColorBrightYellow, ColorReset)
+	fmt.Fprintf(os.Stderr, "  %s./nebulafinger -f targets.txt -of csv -o results.csv%s\n",
+		ColorBrightYellow, ColorReset)
+	fmt.Fprintf(os.Stderr, "  %s./nebulafinger report -o report.html results.jsonl%s\n",
+		ColorBrightYellow, ColorReset)
+	fmt.Fprintf(os.Stderr, "  %s./nebulafinger diff last-week.jsonl this-week.jsonl%s\n\n",
 		ColorBrightYellow, ColorReset)
 }
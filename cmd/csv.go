@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// csvBaseColumns CSV固定列，之后依次为 details.<键> 和 metadata.<键> 列
+var csvBaseColumns = []string{
+	"target", "type", "url", "scheme", "host", "ip", "port", "status_code", "title",
+	"fingerprint_id", "fingerprint_name", "confidence", "tags", "evidence",
+}
+
+// writeCSVReport 以CSV格式输出结果，每个目标/URL/指纹一行，详情和元数据展开为独立列
+func writeCSVReport(w io.Writer, records []ResultRecord) error {
+	detailKeys, metadataKeys := collectMatchKeys(records)
+
+	header := append([]string{}, csvBaseColumns...)
+	for _, k := range detailKeys {
+		header = append(header, "details."+k)
+	}
+	for _, k := range metadataKeys {
+		header = append(header, "metadata."+k)
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		for _, m := range record.Matches {
+			row := []string{
+				record.Target,
+				m.Type,
+				m.URL,
+				m.Scheme,
+				m.Host,
+				m.IP,
+				formatOptionalInt(m.Port),
+				formatOptionalInt(m.StatusCode),
+				m.Title,
+				m.Fingerprint.ID,
+				m.Fingerprint.Name,
+				strconv.FormatFloat(m.Confidence, 'f', 2, 64),
+				strings.Join(m.Fingerprint.Tags, ";"),
+				m.Evidence,
+			}
+			for _, k := range detailKeys {
+				row = append(row, m.Details[k])
+			}
+			for _, k := range metadataKeys {
+				row = append(row, m.Fingerprint.Metadata[k])
+			}
+			for i := range row {
+				row[i] = escapeCSVFormula(row[i])
+			}
+			if err := writer.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// csvFormulaPrefixes 电子表格会把以这些字符开头的单元格当作公式执行
+const csvFormulaPrefixes = "=+-@\t\r"
+
+// escapeCSVFormula 单元格以公式字符开头时加上单引号前缀，避免扫描到的标题、证据等内容在电子表格中作为公式执行
+func escapeCSVFormula(value string) string {
+	if value != "" && strings.ContainsRune(csvFormulaPrefixes, rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// formatOptionalInt 零值输出为空字符串
+func formatOptionalInt(v int) string {
+	if v == 0 {
+		return ""
+	}
+	return strconv.Itoa(v)
+}
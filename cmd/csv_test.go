@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+)
+
+// TestWriteCSVReportEscapesFormulas 来自扫描主机的标题、证据、详情和元数据以公式字符开头时加上单引号前缀
+func TestWriteCSVReportEscapesFormulas(t *testing.T) {
+	record := ResultRecord{
+		SchemaVersion: SchemaVersion,
+		Target:        "example.com",
+		Matches: []MatchRecord{{
+			Type:        "web",
+			URL:         "http://example.com",
+			Title:       `=HYPERLINK("http://evil.example","x")`,
+			Fingerprint: FingerprintRecord{ID: "id", Name: "+name", Metadata: map[string]string{"product": "@SUM(A1)"}},
+			Details:     map[string]string{"server": "-2+3", "banner": "\tcmd", "version": "1.0"},
+			Evidence:    "\r=1",
+		}},
+	}
+
+	var buf bytes.Buffer
+	if err := writeCSVReport(&buf, []ResultRecord{record}); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("行数 = %d，期望 2", len(rows))
+	}
+	want := map[string]string{
+		"title":            `'=HYPERLINK("http://evil.example","x")`,
+		"fingerprint_name": "'+name",
+		"evidence":         "'\r=1",
+		"details.server":   "'-2+3",
+		"details.banner":   "'\tcmd",
+		"details.version":  "1.0",
+		"metadata.product": "'@SUM(A1)",
+		"url":              "http://example.com",
+	}
+	for i, column := range rows[0] {
+		if v, ok := want[column]; ok && rows[1][i] != v {
+			t.Errorf("%s = %q，期望 %q", column, rows[1][i], v)
+		}
+	}
+}
+
+func TestEscapeCSVFormula(t *testing.T) {
+	tests := map[string]string{
+		"":          "",
+		"nginx":     "nginx",
+		"=1+1":      "'=1+1",
+		"+1":        "'+1",
+		"-1":        "'-1",
+		"@A1":       "'@A1",
+		"\tx":       "'\tx",
+		"\rx":       "'\rx",
+		"a=b":       "a=b",
+		"'=already": "'=already",
+	}
+	for in, want := range tests {
+		if got := escapeCSVFormula(in); got != want {
+			t.Errorf("escapeCSVFormula(%q) = %q，期望 %q", in, got, want)
+		}
+	}
+}
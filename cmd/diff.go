@@ -0,0 +1,511 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// diff子命令的退出码
+const (
+	diffExitNoChanges = 0 // 两次扫描结果一致
+	diffExitChanges   = 1 // 存在变化
+	diffExitError     = 2 // 执行出错
+)
+
+// 目标的变化状态
+const (
+	diffTargetAdded   = "added"
+	diffTargetRemoved = "removed"
+	diffTargetChanged = "changed"
+)
+
+// DiffReport 两次扫描结果的比对报告
+type DiffReport struct {
+	SchemaVersion string       `json:"schema_version"`
+	Old           string       `json:"old"`
+	New           string       `json:"new"`
+	GeneratedAt   time.Time    `json:"generated_at"`
+	Summary       DiffSummary  `json:"summary"`
+	Targets       []TargetDiff `json:"targets"`
+}
+
+// DiffSummary 比对结果统计
+type DiffSummary struct {
+	AddedTargets        int `json:"added_targets"`
+	RemovedTargets      int `json:"removed_targets"`
+	ChangedTargets      int `json:"changed_targets"`
+	NewFingerprints     int `json:"new_fingerprints"`
+	RemovedFingerprints int `json:"removed_fingerprints"`
+	DetailChanges       int `json:"detail_changes"`
+	HTTPChanges         int `json:"http_changes"`
+	OpenedPorts         int `json:"opened_ports"`
+	ClosedPorts         int `json:"closed_ports"`
+	CertChanges         int `json:"cert_changes"`
+}
+
+// TargetDiff 单个目标的变化
+type TargetDiff struct {
+	Target              string              `json:"target"`
+	Status              string              `json:"status"`
+	NewFingerprints     []FingerprintChange `json:"new_fingerprints,omitempty"`
+	RemovedFingerprints []FingerprintChange `json:"removed_fingerprints,omitempty"`
+	DetailChanges       []FieldChange       `json:"detail_changes,omitempty"`
+	HTTPChanges         []FieldChange       `json:"http_changes,omitempty"`
+	OpenedPorts         []string            `json:"opened_ports,omitempty"`
+	ClosedPorts         []string            `json:"closed_ports,omitempty"`
+	CertChanges         []FieldChange       `json:"cert_changes,omitempty"`
+}
+
+// FingerprintChange 新出现或消失的指纹
+type FingerprintChange struct {
+	Type     string `json:"type"`
+	Location string `json:"location"`
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+}
+
+// FieldChange 某个位置上字段值的变化，新增或删除的字段对应一侧为空
+type FieldChange struct {
+	Location    string `json:"location"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Field       string `json:"field"`
+	Old         string `json:"old"`
+	New         string `json:"new"`
+}
+
+// hasChanges 目标是否存在任何变化
+func (t *TargetDiff) hasChanges() bool {
+	return len(t.NewFingerprints) > 0 || len(t.RemovedFingerprints) > 0 ||
+		len(t.DetailChanges) > 0 || len(t.HTTPChanges) > 0 ||
+		len(t.OpenedPorts) > 0 || len(t.ClosedPorts) > 0 || len(t.CertChanges) > 0
+}
+
+// runDiff 执行diff子命令：比对两个结果文件，存在变化时退出码为1
+func runDiff(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	var outputPath, outputFormat string
+	fs.StringVar(&outputPath, "o", "", "输出文件路径，为空时输出到终端")
+	fs.StringVar(&outputFormat, "of", "", "输出格式: txt, json, html（默认根据-o扩展名推断）")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "%s%s用法:%s nebulafinger diff [选项] <旧结果文件> <新结果文件>\n\n",
+			StyleBold, ColorBrightCyan, ColorReset)
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n退出码: 0 无变化，1 存在变化，2 执行出错\n")
+		fmt.Fprintf(os.Stderr, "\n%s%s示例:%s\n", StyleBold, ColorBrightYellow, ColorReset)
+		fmt.Fprintf(os.Stderr, "  %s./nebulafinger diff last-week.jsonl this-week.jsonl%s\n",
+			ColorBrightYellow, ColorReset)
+		fmt.Fprintf(os.Stderr, "  %s./nebulafinger diff -o changes.html last-week.jsonl this-week.jsonl%s\n\n",
+			ColorBrightYellow, ColorReset)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return diffExitNoChanges
+		}
+		return diffExitError
+	}
+	if fs.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, ColorRed+"[!] 错误: 必须指定旧结果文件和新结果文件"+ColorReset)
+		fs.Usage()
+		return diffExitError
+	}
+
+	format, err := resolveOutputFormat(outputFormat, outputPath)
+	if err == nil && format != formatText && format != formatJSON && format != formatHTML {
+		err = fmt.Errorf("diff不支持的输出格式: %s（可选: txt, json, html）", format)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, ColorRed+"[!] %v\n"+ColorReset, err)
+		return diffExitError
+	}
+
+	oldPath, newPath := fs.Arg(0), fs.Arg(1)
+	oldRecords, err := readResultFile(oldPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, ColorRed+"[!] 读取结果文件失败: %s: %v\n"+ColorReset, oldPath, err)
+		return diffExitError
+	}
+	newRecords, err := readResultFile(newPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, ColorRed+"[!] 读取结果文件失败: %s: %v\n"+ColorReset, newPath, err)
+		return diffExitError
+	}
+
+	report := diffResults(mergeResultRecords(oldRecords), mergeResultRecords(newRecords))
+	report.Old = oldPath
+	report.New = newPath
+
+	var w io.Writer = os.Stdout
+	if outputPath != "" {
+		file, err := os.Create(outputPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, ColorBrightRed+StyleBold+"[!] 创建输出文件失败: %v\n"+ColorReset, err)
+			return diffExitError
+		}
+		defer file.Close()
+		w = file
+	}
+
+	switch format {
+	case formatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+	case formatHTML:
+		err = writeDiffHTML(w, report)
+	default:
+		// 只有输出到终端时才使用颜色
+		writeDiffText(w, report, outputPath == "")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, ColorBrightRed+StyleBold+"[!] 生成比对报告失败: %v\n"+ColorReset, err)
+		return diffExitError
+	}
+
+	if outputPath != "" && !silentFlag {
+		fmt.Printf(ColorBrightGreen+StyleBold+"[+] 比对报告已保存到: %s\n"+ColorReset, outputPath)
+	}
+
+	if len(report.Targets) > 0 {
+		return diffExitChanges
+	}
+	return diffExitNoChanges
+}
+
+// diffResults 按目标比对两次扫描结果，只返回有变化的目标
+func diffResults(oldRecords, newRecords []ResultRecord) *DiffReport {
+	report := &DiffReport{
+		SchemaVersion: SchemaVersion,
+		GeneratedAt:   time.Now(),
+		Targets:       []TargetDiff{},
+	}
+
+	oldByTarget := make(map[string]ResultRecord)
+	for _, record := range oldRecords {
+		oldByTarget[record.Target] = record
+	}
+	newByTarget := make(map[string]ResultRecord)
+	for _, record := range newRecords {
+		newByTarget[record.Target] = record
+	}
+
+	// 先按新结果的顺序，再追加只在旧结果中出现的目标
+	var targets []string
+	for _, record := range newRecords {
+		targets = append(targets, record.Target)
+	}
+	for _, record := range oldRecords {
+		if _, ok := newByTarget[record.Target]; !ok {
+			targets = append(targets, record.Target)
+		}
+	}
+
+	for _, target := range targets {
+		oldRecord, inOld := oldByTarget[target]
+		newRecord, inNew := newByTarget[target]
+
+		d := diffTarget(oldRecord, newRecord)
+		d.Target = target
+		switch {
+		case !inOld:
+			d.Status = diffTargetAdded
+		case !inNew:
+			d.Status = diffTargetRemoved
+		default:
+			d.Status = diffTargetChanged
+		}
+		if !d.hasChanges() {
+			continue
+		}
+
+		switch d.Status {
+		case diffTargetAdded:
+			report.Summary.AddedTargets++
+		case diffTargetRemoved:
+			report.Summary.RemovedTargets++
+		default:
+			report.Summary.ChangedTargets++
+		}
+		report.Summary.NewFingerprints += len(d.NewFingerprints)
+		report.Summary.RemovedFingerprints += len(d.RemovedFingerprints)
+		report.Summary.DetailChanges += len(d.DetailChanges)
+		report.Summary.HTTPChanges += len(d.HTTPChanges)
+		report.Summary.OpenedPorts += len(d.OpenedPorts)
+		report.Summary.ClosedPorts += len(d.ClosedPorts)
+		report.Summary.CertChanges += len(d.CertChanges)
+		report.Targets = append(report.Targets, d)
+	}
+
+	return report
+}
+
+// diffTarget 比对同一目标的两次结果
+func diffTarget(oldRecord, newRecord ResultRecord) TargetDiff {
+	var d TargetDiff
+
+	// 指纹的出现与消失，以及同一指纹的详情变化
+	oldFingerprints := indexFingerprints(oldRecord)
+	newFingerprints := indexFingerprints(newRecord)
+	for _, key := range sortedKeys(newFingerprints) {
+		m := newFingerprints[key]
+		old, ok := oldFingerprints[key]
+		if !ok {
+			d.NewFingerprints = append(d.NewFingerprints, newFingerprintChange(m))
+			continue
+		}
+		d.DetailChanges = append(d.DetailChanges,
+			diffFields(matchLocation(m), m.Fingerprint.Name, comparableDetails(old), comparableDetails(m))...)
+	}
+	for _, key := range sortedKeys(oldFingerprints) {
+		if _, ok := newFingerprints[key]; !ok {
+			d.RemovedFingerprints = append(d.RemovedFingerprints, newFingerprintChange(oldFingerprints[key]))
+		}
+	}
+
+	// 同一URL的状态码和标题变化
+	oldPages := indexPages(oldRecord)
+	newPages := indexPages(newRecord)
+	for _, u := range sortedKeys(newPages) {
+		if old, ok := oldPages[u]; ok {
+			d.HTTPChanges = append(d.HTTPChanges, diffFields(u, "", old, newPages[u])...)
+		}
+	}
+
+	// 端口的开放与关闭
+	oldPorts := indexPorts(oldRecord)
+	newPorts := indexPorts(newRecord)
+	for _, p := range sortedKeys(newPorts) {
+		if !oldPorts[p] {
+			d.OpenedPorts = append(d.OpenedPorts, p)
+		}
+	}
+	for _, p := range sortedKeys(oldPorts) {
+		if !newPorts[p] {
+			d.ClosedPorts = append(d.ClosedPorts, p)
+		}
+	}
+
+	// 同一服务地址的证书变化
+	oldCerts := indexCerts(oldRecord)
+	newCerts := indexCerts(newRecord)
+	for _, origin := range sortedKeys(newCerts) {
+		if old, ok := oldCerts[origin]; ok {
+			d.CertChanges = append(d.CertChanges, diffFields(origin, "", old, newCerts[origin])...)
+		}
+	}
+
+	return d
+}
+
+// indexFingerprints 以 类型|位置|指纹ID 为键索引真实的指纹命中，忽略仅有状态码的结果
+func indexFingerprints(record ResultRecord) map[string]MatchRecord {
+	index := make(map[string]MatchRecord)
+	for _, m := range record.Matches {
+		if m.Fingerprint.ID == "http-status-code" {
+			continue
+		}
+		index[m.Type+"|"+matchLocation(m)+"|"+m.Fingerprint.ID] = m
+	}
+	return index
+}
+
+// indexPages 按URL索引Web页面的状态码和标题
+func indexPages(record ResultRecord) map[string]map[string]string {
+	index := make(map[string]map[string]string)
+	for _, m := range record.Matches {
+		if m.Type != "web" || m.URL == "" || m.StatusCode == 0 {
+			continue
+		}
+		if _, ok := index[m.URL]; ok {
+			continue
+		}
+		index[m.URL] = map[string]string{
+			"status_code": strconv.Itoa(m.StatusCode),
+			"title":       m.Title,
+		}
+	}
+	return index
+}
+
+// indexPorts 汇总结果中出现的 主机:端口，UDP端口带/udp后缀
+func indexPorts(record ResultRecord) map[string]bool {
+	index := make(map[string]bool)
+	for _, m := range record.Matches {
+		if m.Host != "" && m.Port != 0 {
+			index[portAddress(m.Host, m.Port, matchProtocol(m))] = true
+		}
+	}
+	for _, p := range record.Ports {
+		index[portAddress(p.Host, p.Port, portProtocol(p))] = true
+	}
+	return index
+}
+
+// portAddress 返回 主机:端口 形式的地址，UDP端口带/udp后缀
+func portAddress(host string, port int, protocol string) string {
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	if protocol == "udp" {
+		address += "/udp"
+	}
+	return address
+}
+
+// indexCerts 按服务地址索引HTTPS证书信息
+func indexCerts(record ResultRecord) map[string]map[string]string {
+	index := make(map[string]map[string]string)
+	for _, m := range record.Matches {
+		if m.Details["tls_cert_sha256"] == "" {
+			continue
+		}
+		origin := m.Scheme + "://" + net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+		if _, ok := index[origin]; ok {
+			continue
+		}
+		cert := make(map[string]string)
+		for k, v := range m.Details {
+			if strings.HasPrefix(k, "tls_cert") {
+				cert[k] = v
+			}
+		}
+		index[origin] = cert
+	}
+	return index
+}
+
+// volatileDetailKeys 每次扫描都会变化的详情（服务端时钟、服务启动时间），不参与比对
+var volatileDetailKeys = map[string]bool{
+	"system_time":       true,
+	"server_start_time": true,
+}
+
+// comparableDetails 返回参与版本比对的详情，证书和favicon字段单独处理，服务端时钟等易变字段不比对
+func comparableDetails(m MatchRecord) map[string]string {
+	details := make(map[string]string)
+	for k, v := range m.Details {
+		if strings.HasPrefix(k, "tls_cert") || strings.Contains(k, "favicon") || volatileDetailKeys[k] {
+			continue
+		}
+		details[k] = v
+	}
+	return details
+}
+
+// diffFields 比较两组字段，返回值不同的字段
+func diffFields(location, fingerprint string, oldFields, newFields map[string]string) []FieldChange {
+	keys := make(map[string]bool)
+	for k := range oldFields {
+		keys[k] = true
+	}
+	for k := range newFields {
+		keys[k] = true
+	}
+
+	var changes []FieldChange
+	for _, k := range sortedKeys(keys) {
+		if oldFields[k] != newFields[k] {
+			changes = append(changes, FieldChange{
+				Location:    location,
+				Fingerprint: fingerprint,
+				Field:       k,
+				Old:         oldFields[k],
+				New:         newFields[k],
+			})
+		}
+	}
+	return changes
+}
+
+// matchLocation 指纹命中的位置：Web为URL（没有URL时为 协议://主机:端口），服务为 主机:端口，UDP服务带/udp后缀
+func matchLocation(m MatchRecord) string {
+	if m.Type == "web" {
+		if m.URL != "" {
+			return m.URL
+		}
+		return m.Scheme + "://" + net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	}
+	return portAddress(m.Host, m.Port, matchProtocol(m))
+}
+
+// newFingerprintChange 根据指纹命中生成变化条目
+func newFingerprintChange(m MatchRecord) FingerprintChange {
+	return FingerprintChange{
+		Type:     m.Type,
+		Location: matchLocation(m),
+		ID:       m.Fingerprint.ID,
+		Name:     m.Fingerprint.Name,
+	}
+}
+
+// sortedKeys 返回排序后的映射键
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeDiffText 以文本格式输出比对结果
+func writeDiffText(w io.Writer, report *DiffReport, colored bool) {
+	color := func(c string) string {
+		if colored {
+			return c
+		}
+		return ""
+	}
+	added, removed, changed, reset := color(ColorBrightGreen), color(ColorBrightRed), color(ColorBrightYellow), color(ColorReset)
+
+	if len(report.Targets) == 0 {
+		fmt.Fprintf(w, "两次扫描结果没有变化\n")
+		return
+	}
+
+	for _, t := range report.Targets {
+		marker, c := "~", changed
+		switch t.Status {
+		case diffTargetAdded:
+			marker, c = "+", added
+		case diffTargetRemoved:
+			marker, c = "-", removed
+		}
+		fmt.Fprintf(w, "%s[%s] %s%s\n", c, marker, t.Target, reset)
+
+		for _, f := range t.NewFingerprints {
+			fmt.Fprintf(w, "    %s+ [%s] %s %s (%s)%s\n", added, f.Type, f.Location, f.Name, f.ID, reset)
+		}
+		for _, f := range t.RemovedFingerprints {
+			fmt.Fprintf(w, "    %s- [%s] %s %s (%s)%s\n", removed, f.Type, f.Location, f.Name, f.ID, reset)
+		}
+		for _, c := range t.DetailChanges {
+			fmt.Fprintf(w, "    %s~ %s %s.%s: %s -> %s%s\n", changed, c.Location, c.Fingerprint, c.Field, c.Old, c.New, reset)
+		}
+		for _, c := range t.HTTPChanges {
+			fmt.Fprintf(w, "    %s~ %s %s: %s -> %s%s\n", changed, c.Location, c.Field, c.Old, c.New, reset)
+		}
+		for _, p := range t.OpenedPorts {
+			fmt.Fprintf(w, "    %s+ 端口 %s%s\n", added, p, reset)
+		}
+		for _, p := range t.ClosedPorts {
+			fmt.Fprintf(w, "    %s- 端口 %s%s\n", removed, p, reset)
+		}
+		for _, c := range t.CertChanges {
+			fmt.Fprintf(w, "    %s~ %s %s: %s -> %s%s\n", changed, c.Location, c.Field, c.Old, c.New, reset)
+		}
+	}
+
+	s := report.Summary
+	fmt.Fprintf(w, "\n新增目标: %d, 消失目标: %d, 变化目标: %d, 新增指纹: %d, 消失指纹: %d, 详情变化: %d, HTTP变化: %d, 新开放端口: %d, 关闭端口: %d, 证书变化: %d\n",
+		s.AddedTargets, s.RemovedTargets, s.ChangedTargets, s.NewFingerprints, s.RemovedFingerprints,
+		s.DetailChanges, s.HTTPChanges, s.OpenedPorts, s.ClosedPorts, s.CertChanges)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+)
+
+// diffHTMLData 比对报告模板数据
+type diffHTMLData struct {
+	Nonce       string
+	GeneratedAt string
+	Version     string
+	Report      *DiffReport
+}
+
+// diffHTMLTemplate 比对报告模板，不包含脚本，所有内容由html/template转义
+var diffHTMLTemplate = template.Must(template.New("diff").Parse(diffHTMLSource))
+
+// writeDiffHTML 以HTML格式输出比对结果
+func writeDiffHTML(w io.Writer, report *DiffReport) error {
+	nonce, err := newCSPNonce()
+	if err != nil {
+		return fmt.Errorf("生成CSP nonce失败: %v", err)
+	}
+
+	return diffHTMLTemplate.Execute(w, diffHTMLData{
+		Nonce:       nonce,
+		GeneratedAt: report.GeneratedAt.Format("2006-01-02 15:04:05"),
+		Version:     VERSION,
+		Report:      report,
+	})
+}
+
+// diffHTMLSource 比对报告模板源码
+const diffHTMLSource = `<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+  <meta charset="UTF-8">
+  <meta http-equiv="Content-Security-Policy" content="default-src 'none'; style-src 'nonce-{{.Nonce}}'; base-uri 'none'; form-action 'none'">
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+  <title>NebulaFinger - 扫描结果比对</title>
+  <style nonce="{{.Nonce}}">
+    body {
+      font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, "Helvetica Neue", Arial, sans-serif;
+      margin: 0;
+      padding: 20px;
+      background-color: #f5f7fa;
+      color: #333;
+    }
+
+    .container {
+      max-width: 1200px;
+      margin: 0 auto;
+    }
+
+    h1 {
+      color: #2c3e50;
+    }
+
+    .meta {
+      color: #7f8c8d;
+      margin-bottom: 20px;
+    }
+
+    table {
+      border-collapse: collapse;
+      width: 100%;
+      background: #fff;
+    }
+
+    th, td {
+      border: 1px solid #e1e4e8;
+      padding: 6px 10px;
+      text-align: left;
+      vertical-align: top;
+      word-break: break-all;
+    }
+
+    th {
+      background: #f0f3f6;
+    }
+
+    .target-block {
+      background: #fff;
+      border-radius: 8px;
+      box-shadow: 0 1px 3px rgba(0, 0, 0, 0.1);
+      padding: 15px 20px;
+      margin-bottom: 20px;
+    }
+
+    .target-block h2 {
+      font-size: 18px;
+      margin: 0 0 10px;
+      word-break: break-all;
+    }
+
+    .badge {
+      display: inline-block;
+      border-radius: 4px;
+      padding: 2px 8px;
+      margin-right: 8px;
+      font-size: 12px;
+      color: #fff;
+    }
+
+    .added { color: #1e8e3e; }
+    .removed { color: #d93025; }
+    .changed { color: #b06000; }
+    .badge.added { background: #1e8e3e; color: #fff; }
+    .badge.removed { background: #d93025; color: #fff; }
+    .badge.changed { background: #b06000; color: #fff; }
+
+    ul {
+      margin: 5px 0;
+      padding-left: 20px;
+    }
+
+    li {
+      margin: 3px 0;
+      word-break: break-all;
+    }
+
+    code {
+      background: #f0f3f6;
+      padding: 1px 4px;
+      border-radius: 3px;
+    }
+
+    .footer {
+      text-align: center;
+      color: #7f8c8d;
+      margin-top: 30px;
+      font-size: 13px;
+    }
+  </style>
+</head>
+<body>
+  <div class="container">
+    <h1>扫描结果比对</h1>
+    <div class="meta">
+      <div>旧结果: {{.Report.Old}}</div>
+      <div>新结果: {{.Report.New}}</div>
+      <div>生成时间: {{.GeneratedAt}}</div>
+    </div>
+
+    {{with .Report.Summary}}
+    <table>
+      <tr>
+        <th>新增目标</th><th>消失目标</th><th>变化目标</th><th>新增指纹</th><th>消失指纹</th>
+        <th>详情变化</th><th>HTTP变化</th><th>新开放端口</th><th>关闭端口</th><th>证书变化</th>
+      </tr>
+      <tr>
+        <td>{{.AddedTargets}}</td><td>{{.RemovedTargets}}</td><td>{{.ChangedTargets}}</td>
+        <td>{{.NewFingerprints}}</td><td>{{.RemovedFingerprints}}</td><td>{{.DetailChanges}}</td>
+        <td>{{.HTTPChanges}}</td><td>{{.OpenedPorts}}</td><td>{{.ClosedPorts}}</td><td>{{.CertChanges}}</td>
+      </tr>
+    </table>
+    {{end}}
+
+    <h2>目标变化</h2>
+    {{range .Report.Targets}}
+    <div class="target-block">
+      <h2><span class="badge {{.Status}}">{{.Status}}</span>{{.Target}}</h2>
+      {{if or .NewFingerprints .RemovedFingerprints}}
+      <ul>
+        {{range .NewFingerprints}}<li class="added">+ [{{.Type}}] {{.Location}} <strong>{{.Name}}</strong> ({{.ID}})</li>{{end}}
+        {{range .RemovedFingerprints}}<li class="removed">- [{{.Type}}] {{.Location}} <strong>{{.Name}}</strong> ({{.ID}})</li>{{end}}
+      </ul>
+      {{end}}
+      {{if or .OpenedPorts .ClosedPorts}}
+      <ul>
+        {{range .OpenedPorts}}<li class="added">+ 端口 <code>{{.}}</code></li>{{end}}
+        {{range .ClosedPorts}}<li class="removed">- 端口 <code>{{.}}</code></li>{{end}}
+      </ul>
+      {{end}}
+      {{if or .DetailChanges .HTTPChanges .CertChanges}}
+      <table>
+        <tr><th>位置</th><th>字段</th><th>旧值</th><th>新值</th></tr>
+        {{range .DetailChanges}}<tr class="changed"><td>{{.Location}}</td><td>{{.Fingerprint}}.{{.Field}}</td><td>{{.Old}}</td><td>{{.New}}</td></tr>{{end}}
+        {{range .HTTPChanges}}<tr class="changed"><td>{{.Location}}</td><td>{{.Field}}</td><td>{{.Old}}</td><td>{{.New}}</td></tr>{{end}}
+        {{range .CertChanges}}<tr class="changed"><td>{{.Location}}</td><td>{{.Field}}</td><td>{{.Old}}</td><td>{{.New}}</td></tr>{{end}}
+      </table>
+      {{end}}
+    </div>
+    {{else}}
+    <p>两次扫描结果没有变化</p>
+    {{end}}
+
+    <div class="footer">
+      <p>由 NebulaFinger v{{.Version}} 生成</p>
+    </div>
+  </div>
+</body>
+</html>
+`
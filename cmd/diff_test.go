@@ -0,0 +1,238 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// webMatch 返回一条Web指纹命中
+func webMatch(url, id string, status int, title string, details map[string]string) MatchRecord {
+	return MatchRecord{
+		Type:        "web",
+		URL:         url,
+		Scheme:      "https",
+		Host:        "example.com",
+		Port:        443,
+		StatusCode:  status,
+		Title:       title,
+		Fingerprint: FingerprintRecord{ID: id, Name: id},
+		Details:     details,
+	}
+}
+
+// serviceMatch 返回一条服务指纹命中
+func serviceMatch(port int, id string, details map[string]string) MatchRecord {
+	return MatchRecord{
+		Type:        "service",
+		Scheme:      "tcp",
+		Host:        "example.com",
+		Port:        port,
+		Fingerprint: FingerprintRecord{ID: id, Name: id},
+		Details:     details,
+	}
+}
+
+func TestDiffNoChanges(t *testing.T) {
+	record := ResultRecord{Target: "example.com", Matches: []MatchRecord{
+		webMatch("https://example.com", "nginx", 200, "home", map[string]string{"version": "1.24.0"}),
+		serviceMatch(22, "openssh", nil),
+	}}
+	report := diffResults([]ResultRecord{record}, []ResultRecord{record})
+	if len(report.Targets) != 0 || report.Summary != (DiffSummary{}) {
+		t.Errorf("相同结果不应有变化，得到 %+v", report)
+	}
+}
+
+func TestDiffAddedRemovedTargets(t *testing.T) {
+	kept := ResultRecord{Target: "kept.example.com", Matches: []MatchRecord{serviceMatch(22, "openssh", nil)}}
+	gone := ResultRecord{Target: "gone.example.com", Matches: []MatchRecord{serviceMatch(21, "vsftpd", nil)}}
+	added := ResultRecord{Target: "new.example.com", Matches: []MatchRecord{serviceMatch(3306, "mysql", nil)}}
+	// 新旧都没有命中的目标不算变化
+	empty := ResultRecord{Target: "empty.example.com"}
+
+	report := diffResults([]ResultRecord{kept, gone, empty}, []ResultRecord{kept, added})
+	var statuses []string
+	for _, d := range report.Targets {
+		statuses = append(statuses, d.Target+" "+d.Status)
+	}
+	want := []string{"new.example.com added", "gone.example.com removed"}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Fatalf("目标 = %v，期望 %v", statuses, want)
+	}
+	if len(report.Targets[0].NewFingerprints) != 1 || len(report.Targets[0].OpenedPorts) != 1 {
+		t.Errorf("新增目标的指纹和端口应记为新增: %+v", report.Targets[0])
+	}
+	if len(report.Targets[1].RemovedFingerprints) != 1 || len(report.Targets[1].ClosedPorts) != 1 {
+		t.Errorf("消失目标的指纹和端口应记为消失: %+v", report.Targets[1])
+	}
+	s := report.Summary
+	if s.AddedTargets != 1 || s.RemovedTargets != 1 || s.ChangedTargets != 0 {
+		t.Errorf("统计 = %+v", s)
+	}
+}
+
+func TestDiffChangedTarget(t *testing.T) {
+	oldRecord := ResultRecord{Target: "example.com", Matches: []MatchRecord{
+		webMatch("https://example.com", "nginx", 200, "home", map[string]string{"version": "1.24.0", "favicon_hash": "1"}),
+		webMatch("https://example.com", "wordpress", 200, "home", nil),
+		serviceMatch(21, "vsftpd", nil),
+	}}
+	newRecord := ResultRecord{Target: "example.com", Matches: []MatchRecord{
+		webMatch("https://example.com", "nginx", 403, "Forbidden", map[string]string{"version": "1.26.1", "favicon_hash": "2"}),
+		serviceMatch(6379, "redis", nil),
+	}}
+
+	report := diffResults([]ResultRecord{oldRecord}, []ResultRecord{newRecord})
+	if len(report.Targets) != 1 || report.Targets[0].Status != diffTargetChanged {
+		t.Fatalf("期望 1 个变化的目标，得到 %+v", report.Targets)
+	}
+	d := report.Targets[0]
+
+	if len(d.NewFingerprints) != 1 || d.NewFingerprints[0].ID != "redis" || d.NewFingerprints[0].Location != "example.com:6379" {
+		t.Errorf("新增指纹 = %+v", d.NewFingerprints)
+	}
+	var removed []string
+	for _, f := range d.RemovedFingerprints {
+		removed = append(removed, f.ID)
+	}
+	if !reflect.DeepEqual(removed, []string{"vsftpd", "wordpress"}) {
+		t.Errorf("消失指纹 = %v", removed)
+	}
+	// favicon字段不参与详情比对
+	wantDetail := []FieldChange{{Location: "https://example.com", Fingerprint: "nginx", Field: "version", Old: "1.24.0", New: "1.26.1"}}
+	if !reflect.DeepEqual(d.DetailChanges, wantDetail) {
+		t.Errorf("详情变化 = %+v", d.DetailChanges)
+	}
+	wantHTTP := []FieldChange{
+		{Location: "https://example.com", Field: "status_code", Old: "200", New: "403"},
+		{Location: "https://example.com", Field: "title", Old: "home", New: "Forbidden"},
+	}
+	if !reflect.DeepEqual(d.HTTPChanges, wantHTTP) {
+		t.Errorf("HTTP变化 = %+v", d.HTTPChanges)
+	}
+	if !reflect.DeepEqual(d.OpenedPorts, []string{"example.com:6379"}) || !reflect.DeepEqual(d.ClosedPorts, []string{"example.com:21"}) {
+		t.Errorf("端口变化 = %v / %v", d.OpenedPorts, d.ClosedPorts)
+	}
+
+	s := report.Summary
+	if s.ChangedTargets != 1 || s.NewFingerprints != 1 || s.RemovedFingerprints != 2 || s.DetailChanges != 1 ||
+		s.HTTPChanges != 2 || s.OpenedPorts != 1 || s.ClosedPorts != 1 {
+		t.Errorf("统计 = %+v", s)
+	}
+}
+
+func TestDiffCertChange(t *testing.T) {
+	cert := func(sha, notAfter string) ResultRecord {
+		return ResultRecord{Target: "example.com", Matches: []MatchRecord{
+			webMatch("https://example.com", "nginx", 200, "home", map[string]string{
+				"tls_cert_sha256": sha, "tls_cert_not_after": notAfter,
+			}),
+		}}
+	}
+	report := diffResults([]ResultRecord{cert("aa", "2025-01-01T00:00:00Z")}, []ResultRecord{cert("bb", "2026-01-01T00:00:00Z")})
+	if len(report.Targets) != 1 {
+		t.Fatalf("期望 1 个变化的目标，得到 %d", len(report.Targets))
+	}
+	d := report.Targets[0]
+	if len(d.DetailChanges) != 0 {
+		t.Errorf("证书字段不应计入详情变化: %+v", d.DetailChanges)
+	}
+	if len(d.CertChanges) != 2 || d.CertChanges[0].Location != "https://example.com:443" || d.CertChanges[0].Field != "tls_cert_not_after" {
+		t.Errorf("证书变化 = %+v", d.CertChanges)
+	}
+}
+
+// TestDiffSamePortTCPAndUDP 同一端口上的TCP和UDP服务是不同的命中，互不覆盖
+func TestDiffSamePortTCPAndUDP(t *testing.T) {
+	udp := serviceMatch(53, "bind", map[string]string{"version": "9.18.24"})
+	udp.Scheme = "udp"
+	oldRecord := ResultRecord{Target: "example.com", Matches: []MatchRecord{
+		serviceMatch(53, "bind", map[string]string{"version": "9.16.1"}),
+	}}
+	newRecord := ResultRecord{Target: "example.com", Matches: []MatchRecord{
+		serviceMatch(53, "bind", map[string]string{"version": "9.16.1"}),
+		udp,
+	}}
+
+	report := diffResults([]ResultRecord{oldRecord}, []ResultRecord{newRecord})
+	if len(report.Targets) != 1 {
+		t.Fatalf("期望 1 个变化的目标，得到 %+v", report.Targets)
+	}
+	d := report.Targets[0]
+	want := []FingerprintChange{{Type: "service", Location: "example.com:53/udp", ID: "bind", Name: "bind"}}
+	if !reflect.DeepEqual(d.NewFingerprints, want) {
+		t.Errorf("新增指纹 = %+v，期望 %+v", d.NewFingerprints, want)
+	}
+	// UDP命中的版本不应与TCP命中比对
+	if len(d.DetailChanges) != 0 {
+		t.Errorf("详情变化 = %+v", d.DetailChanges)
+	}
+	if !reflect.DeepEqual(d.OpenedPorts, []string{"example.com:53/udp"}) || len(d.ClosedPorts) != 0 {
+		t.Errorf("端口变化 = %v / %v", d.OpenedPorts, d.ClosedPorts)
+	}
+}
+
+// ntlmRecord 返回一条NTLM服务识别结果，details为详情
+func ntlmRecord(details map[string]string) ResultRecord {
+	return ResultRecord{
+		SchemaVersion: SchemaVersion,
+		Target:        "192.0.2.10",
+		Matches: []MatchRecord{{
+			Type:        "service",
+			Scheme:      "tcp",
+			Host:        "192.0.2.10",
+			Port:        80,
+			Fingerprint: FingerprintRecord{ID: "ntlm", Name: "NTLM"},
+			Details:     details,
+		}},
+	}
+}
+
+// TestDiffIgnoresServerClock 两次扫描只有服务端时钟不同时没有变化
+func TestDiffIgnoresServerClock(t *testing.T) {
+	oldRecord := ntlmRecord(map[string]string{"dns_computer": "dc01.corp.local", "os_version": "10.0.17763", "system_time": "2025-06-20T08:00:00Z"})
+	newRecord := ntlmRecord(map[string]string{"dns_computer": "dc01.corp.local", "os_version": "10.0.17763", "system_time": "2025-06-27T08:00:05Z"})
+
+	report := diffResults([]ResultRecord{oldRecord}, []ResultRecord{newRecord})
+	if len(report.Targets) != 0 || report.Summary.DetailChanges != 0 {
+		t.Fatalf("只有服务端时钟变化时不应报告变化，得到 %+v", report.Targets)
+	}
+}
+
+// TestDiffReportsDetailChange 其他详情变化时仍然报告，且不包含服务端时钟
+func TestDiffReportsDetailChange(t *testing.T) {
+	oldRecord := ntlmRecord(map[string]string{"os_version": "10.0.17763", "system_time": "2025-06-20T08:00:00Z"})
+	newRecord := ntlmRecord(map[string]string{"os_version": "10.0.20348", "system_time": "2025-06-27T08:00:05Z"})
+
+	report := diffResults([]ResultRecord{oldRecord}, []ResultRecord{newRecord})
+	if len(report.Targets) != 1 {
+		t.Fatalf("期望 1 个变化的目标，得到 %d", len(report.Targets))
+	}
+	changes := report.Targets[0].DetailChanges
+	if len(changes) != 1 || changes[0].Field != "os_version" || changes[0].Old != "10.0.17763" || changes[0].New != "10.0.20348" {
+		t.Errorf("详情变化 = %+v，期望只有os_version", changes)
+	}
+}
+
+// TestDiffIgnoresSMBClock SMB的服务端时间和启动时间变化时没有变化，签名要求变化时报告
+func TestDiffIgnoresSMBClock(t *testing.T) {
+	smbRecord := func(details map[string]string) ResultRecord {
+		record := ntlmRecord(details)
+		record.Matches[0].Port = 445
+		record.Matches[0].Fingerprint = FingerprintRecord{ID: "smb", Name: "SMB"}
+		return record
+	}
+	oldRecord := smbRecord(map[string]string{"smb_dialect": "3.1.1", "signing_required": "false", "system_time": "2025-06-20T08:00:00Z", "server_start_time": "2025-06-01T00:00:00Z"})
+	newRecord := smbRecord(map[string]string{"smb_dialect": "3.1.1", "signing_required": "false", "system_time": "2025-06-27T08:00:05Z", "server_start_time": "2025-06-25T00:00:00Z"})
+
+	report := diffResults([]ResultRecord{oldRecord}, []ResultRecord{newRecord})
+	if len(report.Targets) != 0 {
+		t.Fatalf("只有服务端时间变化时不应报告变化，得到 %+v", report.Targets)
+	}
+
+	newRecord.Matches[0].Details["signing_required"] = "true"
+	report = diffResults([]ResultRecord{oldRecord}, []ResultRecord{newRecord})
+	if len(report.Targets) != 1 || len(report.Targets[0].DetailChanges) != 1 || report.Targets[0].DetailChanges[0].Field != "signing_required" {
+		t.Errorf("期望只报告signing_required变化，得到 %+v", report.Targets)
+	}
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"nebulafinger/internal"
+	"nebulafinger/internal/nmap"
 	"os"
+	"strings"
 )
 
 // 加载指纹库和特征映射
@@ -21,15 +25,17 @@ func loadFingerprints() ([]internal.Fingerprint, []internal.Fingerprint, map[int
 	}
 
 	// 加载服务指纹库
-	serviceData, err := os.ReadFile(serviceFPFlag)
+	serviceFingerprints, err := loadServiceFingerprints()
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("读取服务指纹库失败: %v", err)
+		return nil, nil, nil, err
 	}
 
-	var serviceFingerprints []internal.Fingerprint
-	if err := json.Unmarshal(serviceData, &serviceFingerprints); err != nil {
-		return nil, nil, nil, fmt.Errorf("解析服务指纹库失败: %v", err)
+	// 加载UDP指纹库，合并到服务指纹中；启用-udp时文件必须存在，否则存在时才加载
+	udpFingerprints, err := loadUDPFingerprints()
+	if err != nil {
+		return nil, nil, nil, err
 	}
+	serviceFingerprints = append(serviceFingerprints, udpFingerprints...)
 
 	// 加载特征映射，如果不存在则生成
 	featureMap, err := loadOrGenerateFeatureMap(webFingerprints, serviceFingerprints)
@@ -40,6 +46,87 @@ func loadFingerprints() ([]internal.Fingerprint, []internal.Fingerprint, map[int
 	return webFingerprints, serviceFingerprints, featureMap, nil
 }
 
+// loadServiceFingerprints 加载服务指纹库，多个文件以逗号分隔，每个文件可以是JSON指纹或nmap-service-probes
+// 使用默认路径时不存在的文件会被跳过，此时服务识别只使用内置协议模块和找到的指纹库
+func loadServiceFingerprints() ([]internal.Fingerprint, error) {
+	useDefault := serviceFPFlag == flag.Lookup("s").DefValue
+	var fingerprints []internal.Fingerprint
+	var missing []string
+	for _, path := range strings.Split(serviceFPFlag, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if useDefault && os.IsNotExist(err) {
+				missing = append(missing, path)
+				continue
+			}
+			return nil, fmt.Errorf("读取服务指纹库失败: %v", err)
+		}
+
+		if nmap.IsServiceProbes(data) {
+			converted, warnings, err := nmap.ParseServiceProbes(bytes.NewReader(data))
+			if err != nil {
+				return nil, fmt.Errorf("解析nmap服务探针库 %s 失败: %v", path, err)
+			}
+			if !silentFlag {
+				printServiceProbeWarnings(path, len(converted), warnings)
+			}
+			fingerprints = append(fingerprints, converted...)
+			continue
+		}
+
+		var loaded []internal.Fingerprint
+		if err := json.Unmarshal(data, &loaded); err != nil {
+			return nil, fmt.Errorf("解析服务指纹库 %s 失败: %v", path, err)
+		}
+		fingerprints = append(fingerprints, loaded...)
+	}
+
+	if len(fingerprints) == 0 && len(missing) > 0 && !silentFlag {
+		fmt.Printf(ColorYellow+"[!] 未找到服务指纹库（%s），服务识别只使用内置协议模块，可用 -s 指定JSON指纹或nmap-service-probes\n"+ColorReset, strings.Join(missing, ", "))
+	}
+	return fingerprints, nil
+}
+
+// printServiceProbeWarnings 输出nmap服务探针库的转换结果，跳过的规则只列出前几条
+func printServiceProbeWarnings(path string, converted int, warnings []nmap.Warning) {
+	fmt.Printf(ColorGreen+"[+] %s从 %s 转换了 %d 条nmap匹配规则%s\n", ColorBrightCyan, path, converted, ColorReset)
+	if len(warnings) == 0 {
+		return
+	}
+	fmt.Printf(ColorYellow+"[!] %d 条规则无法转换，已跳过:\n"+ColorReset, len(warnings))
+	for i, warning := range warnings {
+		if i == 5 {
+			fmt.Printf(ColorYellow+"    ... 其余 %d 条省略\n"+ColorReset, len(warnings)-i)
+			break
+		}
+		fmt.Printf(ColorYellow+"    %s\n"+ColorReset, warning)
+	}
+}
+
+// loadUDPFingerprints 加载UDP指纹库
+func loadUDPFingerprints() ([]internal.Fingerprint, error) {
+	if udpFPFlag == "" {
+		return nil, nil
+	}
+	udpData, err := os.ReadFile(udpFPFlag)
+	if err != nil {
+		if !udpFlag && os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("读取UDP指纹库失败: %v", err)
+	}
+
+	var udpFingerprints []internal.Fingerprint
+	if err := json.Unmarshal(udpData, &udpFingerprints); err != nil {
+		return nil, fmt.Errorf("解析UDP指纹库失败: %v", err)
+	}
+	return udpFingerprints, nil
+}
+
 // 加载或生成特征映射
 func loadOrGenerateFeatureMap(webFPs []internal.Fingerprint, serviceFPs []internal.Fingerprint) (map[internal.FeatureKey][]string, error) {
 	// 尝试加载现有特征映射
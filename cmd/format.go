@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// 支持的输出格式
+const (
+	formatText     = "txt"
+	formatJSON     = "json"
+	formatJSONL    = "jsonl"
+	formatHTML     = "html"
+	formatCSV      = "csv"
+	formatMarkdown = "md"
+	formatXML      = "xml"
+)
+
+// 输出格式别名
+var outputFormatAliases = map[string]string{
+	"txt":      formatText,
+	"text":     formatText,
+	"json":     formatJSON,
+	"jsonl":    formatJSONL,
+	"ndjson":   formatJSONL,
+	"html":     formatHTML,
+	"htm":      formatHTML,
+	"csv":      formatCSV,
+	"md":       formatMarkdown,
+	"markdown": formatMarkdown,
+	"xml":      formatXML,
+}
+
+// resolveOutputFormat 确定输出格式，显式指定的格式优先，否则根据输出文件扩展名推断，默认为文本
+func resolveOutputFormat(format, outputPath string) (string, error) {
+	if format != "" {
+		if f, ok := outputFormatAliases[strings.ToLower(format)]; ok {
+			return f, nil
+		}
+		return "", fmt.Errorf("不支持的输出格式: %s（可选: csv, md, txt, json, jsonl, html, xml）", format)
+	}
+
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(outputPath)), ".")
+	if f, ok := outputFormatAliases[ext]; ok {
+		return f, nil
+	}
+	return formatText, nil
+}
+
+// keepsEmptyTargets 报告格式是否需要没有任何结果的目标：JSON保留每个扫描过的目标，Markdown统计目标数量，
+// 其他格式只输出有结果的目标
+func keepsEmptyTargets(format string) bool {
+	return format == formatJSON || format == formatMarkdown
+}
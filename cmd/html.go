@@ -1,242 +1,58 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"html/template"
 	"io"
-	"nebulafinger/internal/matcher"
-	"nebulafinger/internal/scanner"
-	"strings"
 	"time"
 )
 
-// getConfidenceLevel 将置信度百分比转换为级别字符串
-func getConfidenceLevel(confidence int) string {
-	if confidence >= 80 {
-		return "high"
-	} else if confidence >= 50 {
-		return "medium"
-	} else {
-		return "low"
-	}
+// htmlReportData HTML报告模板数据
+type htmlReportData struct {
+	Nonce       string         // CSP nonce，仅允许报告自带的脚本和样式
+	GeneratedAt string         // 报告生成时间
+	Version     string         // 工具版本
+	Records     []ResultRecord // 内嵌的结果数据，由页面脚本渲染为指纹卡片
 }
 
-// 输出HTML格式结果
-func outputHTML(result *scanner.ScanResult, writer io.Writer, isFirstResult bool, isLastResult bool) {
-	// 如果是第一个结果，写入HTML头部
-	if isFirstResult {
-		writeHTMLHeader(writer)
-	}
-
-	// 为每个目标添加一个区块
-	fmt.Fprintf(writer, "<div class=\"target-block\" data-target=\"%s\">\n", result.Target)
-	fmt.Fprintf(writer, "  <h2>%s</h2>\n", result.Target)
-
-	// 创建一个唯一的目标ID用于筛选器
-	targetID := strings.ReplaceAll(result.Target, ".", "-")
-
-	// 创建指纹容器，但现在不区分Web和TCP标题
-	fmt.Fprintf(writer, "  <div class=\"fingerprints-container\" id=\"%s-fingerprints\">\n", targetID)
-
-	// 处理Web指纹结果
-	if len(result.WebResults) > 0 {
-		// 按URL对指纹进行分组
-		urlGroups := make(map[string][]matcher.MatchResult)
-		targetURL := result.Target
-		if !strings.HasPrefix(targetURL, "http") {
-			targetURL = "http://" + targetURL
-		}
-
-		for _, webResult := range result.WebResults {
-			url := webResult.Details["url"]
-			// 处理未知URL的情况，尝试使用目标URL
-			if url == "" || url == "未知URL" {
-				// 如果有favicon匹配结果，使用目标URL代替未知URL
-				if _, hasFavicon := webResult.Details["favicon_hash"]; hasFavicon {
-					url = targetURL
-				} else {
-					url = "未知URL"
-				}
-			}
-			urlGroups[url] = append(urlGroups[url], webResult)
-		}
-
-		// 对每个URL分组处理
-		for url, results := range urlGroups {
-			// 记录当前URL共有的状态码和标题
-			statusCode := ""
-			title := ""
-			if len(results) > 0 {
-				statusCode = results[0].Details["status_code"]
-				title = results[0].Details["title"]
-			}
-
-			// 分别创建每个指纹的卡片，而不是合并在一起
-			for _, webResult := range results {
-				// 计算置信度百分比
-				confidencePercent := int(webResult.Confidence * 100)
-				if confidencePercent > 100 {
-					confidencePercent = 100
-				}
+// htmlReportTemplate HTML报告模板，所有扫描数据都经过html/template按上下文转义
+var htmlReportTemplate = template.Must(template.New("report").Parse(htmlReportSource))
 
-				// 为Web结果创建卡片
-				fmt.Fprintf(writer, "    <div class=\"fingerprint-card\" data-type=\"web\" data-status=\"%s\" data-confidence=\"%d\" data-confidence-level=\"%s\" data-fingerprint=\"%s\">\n",
-					statusCode, confidencePercent, getConfidenceLevel(confidencePercent), strings.ToLower(webResult.Name))
-				fmt.Fprintf(writer, "      <div class=\"card-header web\">\n")
-				fmt.Fprintf(writer, "        <span class=\"type-badge\">WEB</span>\n")
-				fmt.Fprintf(writer, "        <span class=\"fingerprint-name\">%s (%d%%)</span>\n",
-					webResult.Name, confidencePercent)
-				fmt.Fprintf(writer, "      </div>\n")
-				fmt.Fprintf(writer, "      <div class=\"card-content\">\n")
-				fmt.Fprintf(writer, "        <div class=\"url\"><a href=\"%s\" target=\"_blank\">%s</a></div>\n",
-					url, url)
-
-				if statusCode != "" || title != "" {
-					fmt.Fprintf(writer, "        <div class=\"meta-info\">\n")
-					if statusCode != "" {
-						// 获取状态码的第一位数字作为类名
-						statusClass := ""
-						if len(statusCode) > 0 {
-							firstChar := string(statusCode[0])
-							statusClass = fmt.Sprintf("status-code-%sxx", firstChar)
-						}
-						fmt.Fprintf(writer, "          <span class=\"status-code %s\">%s</span>\n",
-							statusClass, statusCode)
-					}
-					if title != "" {
-						fmt.Fprintf(writer, "          <span class=\"page-title\">%s</span>\n", title)
-					}
-					fmt.Fprintf(writer, "        </div>\n")
-				}
-
-				// 添加该指纹特有的详情
-				var detailItems []string
-				for k, v := range webResult.Details {
-					// 跳过已经显示的字段、共有字段以及favicon相关字段
-					if k == "url" || k == "status_code" || k == "title" ||
-						strings.Contains(k, "favicon") {
-						continue
-					}
-
-					detailItems = append(detailItems,
-						fmt.Sprintf("<span class=\"detail-item\"><span class=\"detail-name\">%s.%s</span>: <span class=\"detail-value\">%s</span></span>",
-							webResult.Name, k, v))
-				}
-
-				if len(detailItems) > 0 {
-					fmt.Fprintf(writer, "        <div class=\"details\">\n")
-					for _, item := range detailItems {
-						fmt.Fprintf(writer, "          %s\n", item)
-					}
-					fmt.Fprintf(writer, "        </div>\n")
-				}
-
-				fmt.Fprintf(writer, "      </div>\n")
-				fmt.Fprintf(writer, "    </div>\n")
-			}
-		}
+// writeHTMLReport 将结构化结果渲染为HTML报告
+func writeHTMLReport(w io.Writer, records []ResultRecord) error {
+	nonce, err := newCSPNonce()
+	if err != nil {
+		return fmt.Errorf("生成CSP nonce失败: %v", err)
 	}
 
-	// 处理TCP指纹结果
-	if len(result.TCPResults) > 0 {
-		// 按主机和端口对指纹进行分组
-		hostPortGroups := make(map[string][]matcher.MatchResult)
-		for _, tcpResult := range result.TCPResults {
-			host := tcpResult.Details["host"]
-			port := tcpResult.Details["port"]
-			key := host + ":" + port
-			if host == "" || port == "" {
-				key = "未知主机端口"
-			}
-			hostPortGroups[key] = append(hostPortGroups[key], tcpResult)
-		}
-
-		// 对每个主机:端口分组处理
-		for hostPort, results := range hostPortGroups {
-			// 分离主机和端口以便单独显示
-			parts := strings.Split(hostPort, ":")
-			var hostDisplay, portDisplay string
-			if len(parts) == 2 {
-				hostDisplay = parts[0]
-				portDisplay = parts[1]
-			} else {
-				hostDisplay = hostPort
-				portDisplay = ""
-			}
-
-			// 为每个指纹创建独立的卡片
-			for _, tcpResult := range results {
-				// 计算置信度百分比
-				confidencePercent := int(tcpResult.Confidence * 100)
-				if confidencePercent > 100 {
-					confidencePercent = 100
-				}
-
-				// 每个TCP指纹创建一个单独的卡片
-				fmt.Fprintf(writer, "    <div class=\"fingerprint-card\" data-type=\"tcp\" data-port=\"%s\" data-confidence=\"%d\" data-confidence-level=\"%s\" data-fingerprint=\"%s\">\n",
-					portDisplay, confidencePercent, getConfidenceLevel(confidencePercent), strings.ToLower(tcpResult.Name))
-				fmt.Fprintf(writer, "      <div class=\"card-header tcp\">\n")
-				fmt.Fprintf(writer, "        <span class=\"type-badge\">Service</span>\n")
-				fmt.Fprintf(writer, "        <span class=\"fingerprint-name\">%s (%d%%)</span>\n",
-					tcpResult.Name, confidencePercent)
-				fmt.Fprintf(writer, "      </div>\n")
-				fmt.Fprintf(writer, "      <div class=\"card-content\">\n")
-				fmt.Fprintf(writer, "        <div class=\"host\">%s</div>\n", hostDisplay)
-
-				if portDisplay != "" {
-					fmt.Fprintf(writer, "        <div class=\"meta-info\">\n")
-					fmt.Fprintf(writer, "          <span class=\"port\">%s</span>\n", portDisplay)
-					fmt.Fprintf(writer, "        </div>\n")
-				}
-
-				// 添加该指纹特有的详情
-				var detailItems []string
-				for k, v := range tcpResult.Details {
-					// 跳过已经显示的字段
-					if k == "host" || k == "port" {
-						continue
-					}
-
-					detailItems = append(detailItems,
-						fmt.Sprintf("<span class=\"detail-item\"><span class=\"detail-name\">%s.%s</span>: <span class=\"detail-value\">%s</span></span>",
-							tcpResult.Name, k, v))
-				}
-
-				if len(detailItems) > 0 {
-					fmt.Fprintf(writer, "        <div class=\"details\">\n")
-					for _, item := range detailItems {
-						fmt.Fprintf(writer, "          %s\n", item)
-					}
-					fmt.Fprintf(writer, "        </div>\n")
-				}
-
-				fmt.Fprintf(writer, "      </div>\n")
-				fmt.Fprintf(writer, "    </div>\n")
-			}
-		}
-	}
-
-	fmt.Fprintf(writer, "  </div>\n")
-	fmt.Fprintf(writer, "</div>\n")
+	return htmlReportTemplate.Execute(w, htmlReportData{
+		Nonce:       nonce,
+		GeneratedAt: time.Now().Format("2006-01-02 15:04:05"),
+		Version:     VERSION,
+		Records:     records,
+	})
+}
 
-	// 如果是最后一个结果，写入HTML尾部
-	if isLastResult {
-		writeHTMLFooter(writer)
+// newCSPNonce 生成随机的CSP nonce
+func newCSPNonce() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
 	}
+	return hex.EncodeToString(buf), nil
 }
 
-// 写入HTML头部
-func writeHTMLHeader(w io.Writer) {
-	currentTime := time.Now().Format("2006-01-02 15:04:05")
-
-	fmt.Fprintf(w, `<!DOCTYPE html>
+// htmlReportSource HTML报告模板源码
+const htmlReportSource = `<!DOCTYPE html>
 <html lang="zh-CN">
 <head>
   <meta charset="UTF-8">
+  <meta http-equiv="Content-Security-Policy" content="default-src 'none'; script-src 'nonce-{{.Nonce}}'; style-src 'nonce-{{.Nonce}}'; img-src data:; base-uri 'none'; form-action 'none'">
   <meta name="viewport" content="width=device-width, initial-scale=1.0">
-  <title>NebulaFinger - 星云指纹扫描报告</title>`+"\n")
-
-	fmt.Fprintf(w, `
-  <style>
+  <title>NebulaFinger - 星云指纹扫描报告</title>
+  <style nonce="{{.Nonce}}">
     :root {
       --web-color: #26c6da;
       --tcp-color: #ec407a;
@@ -776,24 +592,33 @@ func writeHTMLHeader(w io.Writer) {
     /* 按钮激活状态 */
     .dropdown-button.active {
       background-color: #2980b9;
+    }    
+    .filter-container-column {
+      flex-direction: column;
+    }
+    
+    .filter-row {
+      display: flex;
+      flex-wrap: wrap;
+      gap: 15px;
     }
   </style>
 </head>
 <body>
   <div class="header">
     <h1>NebulaFinger 星云指纹扫描报告</h1>
-    <p>扫描时间: `+currentTime+`</p>
+    <p>扫描时间: {{.GeneratedAt}}</p>
   </div>
   
-  <div class="filter-container" style="flex-direction: column;">
-    <div class="search-container" style="display: flex; justify-content: center; width: 100%;">
+  <div class="filter-container filter-container-column">
+    <div class="search-container">
       <div class="search-box">
         <input type="text" class="search-input" placeholder="搜索指纹、URL、主机..." id="searchInput">
         <button class="clear-button" id="clearSearch">✕</button>
       </div>
     </div>
     
-    <div style="display: flex; flex-wrap: wrap; gap: 15px;">
+    <div class="filter-row">
       <div class="filter-group">
         <h4>指纹类型</h4>
         <div class="dropdown-container">
@@ -860,13 +685,13 @@ func writeHTMLHeader(w io.Writer) {
                 <input type="checkbox" value="all" checked id="allConfidenceCheckbox"> 全部
               </label>
               <label class="dropdown-item">
-                <input type="checkbox" value="high" class="confidence-checkbox"> 高 (≥80%%)
+                <input type="checkbox" value="high" class="confidence-checkbox"> 高 (≥80%)
               </label>
               <label class="dropdown-item">
-                <input type="checkbox" value="medium" class="confidence-checkbox"> 中 (50-79%%)
+                <input type="checkbox" value="medium" class="confidence-checkbox"> 中 (50-79%)
               </label>
               <label class="dropdown-item">
-                <input type="checkbox" value="low" class="confidence-checkbox"> 低 (&lt;50%%)
+                <input type="checkbox" value="low" class="confidence-checkbox"> 低 (&lt;50%)
               </label>
             </div>
           </div>
@@ -879,22 +704,147 @@ func writeHTMLHeader(w io.Writer) {
     <h3>没有匹配的结果</h3>
     <p>请尝试调整筛选条件或清除搜索</p>
   </div>
-`, currentTime)
-}
+  <div id="results"></div>
 
-// 写入HTML尾部
-func writeHTMLFooter(w io.Writer) {
-	fmt.Fprintf(w, `
   <div class="footer">
-        <p>由 NebulaFinger v%s 生成</p>
+        <p>由 NebulaFinger v{{.Version}} 生成</p>
   </div>
 
-  <script>
+  <script type="application/json" id="report-data">{{.Records}}</script>
+  <script nonce="{{.Nonce}}">
     document.addEventListener('DOMContentLoaded', function() {
-      // 初始化过滤器和搜索功能
+      // 根据内嵌的JSON数据生成指纹卡片，再初始化过滤器和搜索功能
+      const dataElement = document.getElementById('report-data');
+      renderReport(JSON.parse(dataElement.textContent) || []);
       initFilters();
     });
     
+    // 创建元素，文本内容一律通过textContent写入，避免被解析为HTML
+    function createElement(tag, className, text) {
+      const element = document.createElement(tag);
+      if (className) {
+        element.className = className;
+      }
+      if (text !== undefined && text !== null) {
+        element.textContent = String(text);
+      }
+      return element;
+    }
+    
+    // 只允许http/https链接，防止javascript:等协议
+    function isSafeURL(value) {
+      const lower = String(value || '').toLowerCase();
+      return lower.startsWith('http://') || lower.startsWith('https://');
+    }
+    
+    // 将置信度百分比转换为级别字符串
+    function getConfidenceLevel(confidence) {
+      if (confidence >= 80) {
+        return 'high';
+      } else if (confidence >= 50) {
+        return 'medium';
+      }
+      return 'low';
+    }
+    
+    // 渲染所有目标
+    function renderReport(records) {
+      const container = document.getElementById('results');
+      records.forEach(record => {
+        const matches = record.matches || [];
+        if (matches.length === 0) {
+          return;
+        }
+        
+        const block = createElement('div', 'target-block');
+        block.setAttribute('data-target', record.target);
+        block.appendChild(createElement('h2', null, record.target));
+        
+        const fingerprints = createElement('div', 'fingerprints-container');
+        matches.forEach(match => {
+          fingerprints.appendChild(renderCard(match));
+        });
+        block.appendChild(fingerprints);
+        container.appendChild(block);
+      });
+    }
+    
+    // 渲染单个指纹卡片
+    function renderCard(match) {
+      const isWeb = match.type === 'web';
+      const fingerprint = match.fingerprint || {};
+      const name = fingerprint.name || fingerprint.id || '';
+      const percent = Math.min(100, Math.floor((match.confidence || 0) * 100));
+      const status = match.status_code ? String(match.status_code) : '';
+      const port = match.port ? String(match.port) : '';
+      
+      const card = createElement('div', 'fingerprint-card');
+      card.setAttribute('data-type', isWeb ? 'web' : 'tcp');
+      if (isWeb) {
+        card.setAttribute('data-status', status);
+      } else {
+        card.setAttribute('data-port', port);
+      }
+      card.setAttribute('data-confidence', String(percent));
+      card.setAttribute('data-confidence-level', getConfidenceLevel(percent));
+      card.setAttribute('data-fingerprint', name.toLowerCase());
+      
+      const header = createElement('div', 'card-header ' + (isWeb ? 'web' : 'tcp'));
+      header.appendChild(createElement('span', 'type-badge', isWeb ? 'WEB' : 'Service'));
+      header.appendChild(createElement('span', 'fingerprint-name', name + ' (' + percent + '%)'));
+      card.appendChild(header);
+      
+      const content = createElement('div', 'card-content');
+      const meta = createElement('div', 'meta-info');
+      if (isWeb) {
+        const urlElement = createElement('div', 'url');
+        if (isSafeURL(match.url)) {
+          const link = createElement('a', null, match.url);
+          link.href = match.url;
+          link.target = '_blank';
+          link.rel = 'noopener noreferrer';
+          urlElement.appendChild(link);
+        } else {
+          urlElement.textContent = match.url || '';
+        }
+        content.appendChild(urlElement);
+        
+        if (status) {
+          meta.appendChild(createElement('span', 'status-code status-code-' + status.charAt(0) + 'xx', status));
+        }
+        if (match.title) {
+          meta.appendChild(createElement('span', 'page-title', match.title));
+        }
+      } else {
+        content.appendChild(createElement('div', 'host', match.host || ''));
+        if (port) {
+          meta.appendChild(createElement('span', 'port', port));
+        }
+      }
+      if (meta.childNodes.length > 0) {
+        content.appendChild(meta);
+      }
+      
+      // 添加该指纹特有的详情，favicon相关字段不显示
+      const details = createElement('div', 'details');
+      Object.keys(match.details || {}).sort().forEach(key => {
+        if (isWeb && key.indexOf('favicon') !== -1) {
+          return;
+        }
+        const item = createElement('span', 'detail-item');
+        item.appendChild(createElement('span', 'detail-name', name + '.' + key));
+        item.appendChild(document.createTextNode(': '));
+        item.appendChild(createElement('span', 'detail-value', match.details[key]));
+        details.appendChild(item);
+      });
+      if (details.childNodes.length > 0) {
+        content.appendChild(details);
+      }
+      
+      card.appendChild(content);
+      return card;
+    }
+    
     function initFilters() {
       // 获取所有指纹卡片
       const cards = document.querySelectorAll('.fingerprint-card');
@@ -1267,5 +1217,4 @@ func writeHTMLFooter(w io.Writer) {
   </script>
 </body>
 </html>
-`, VERSION)
-}
+`
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"nebulafinger/internal/scanner"
+	"os"
+)
+
+// jsonLinesWriter 以JSON Lines格式逐条写出扫描结果，每个目标完成后立即写入一行
+type jsonLinesWriter struct {
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// newJSONLinesWriter 创建JSON Lines输出，outputPath为空时写入标准输出
+func newJSONLinesWriter(outputPath string) (*jsonLinesWriter, error) {
+	var w io.Writer = os.Stdout
+	var file *os.File
+	if outputPath != "" {
+		f, err := os.Create(outputPath)
+		if err != nil {
+			return nil, err
+		}
+		file = f
+		w = f
+	}
+
+	encoder := json.NewEncoder(w)
+	// 保留URL和标题中的原始字符，便于grep/jq处理
+	encoder.SetEscapeHTML(false)
+
+	return &jsonLinesWriter{file: file, encoder: encoder}, nil
+}
+
+// Write 写出一个目标的扫描结果
+func (j *jsonLinesWriter) Write(result *scanner.ScanResult) error {
+	return j.encoder.Encode(newResultRecord(result))
+}
+
+// Close 关闭输出文件
+func (j *jsonLinesWriter) Close() error {
+	if j.file == nil {
+		return nil
+	}
+	return j.file.Close()
+}
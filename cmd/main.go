@@ -5,16 +5,90 @@ import (
 	"fmt"
 	"log"
 	"nebulafinger/internal/scanner"
+	"nebulafinger/internal/targets"
 	"os"
 	"strings"
 	"sync"
-	"time"
+	"sync/atomic"
 )
 
 func main() {
+	// 子命令使用独立的参数
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "report":
+			os.Exit(runReport(os.Args[2:]))
+		case "diff":
+			os.Exit(runDiff(os.Args[2:]))
+		}
+	}
+
+	// "-p-" 是扫描全部端口的惯用写法，flag包会把它当作名为"p-"的参数
+	for i, arg := range os.Args {
+		if arg == "-p-" || arg == "--p-" {
+			os.Args[i] = "-p=-"
+		}
+	}
+
 	// 解析命令行参数
 	flag.Parse()
 
+	// 打印JSON Schema后直接退出
+	if jsonSchemaFlag {
+		data, err := marshalResultSchema()
+		if err != nil {
+			log.Fatalf(ColorRed+"[!] 生成JSON Schema失败: %v"+ColorReset, err)
+		}
+		os.Stdout.Write(data)
+		return
+	}
+
+	// 确定输出格式：-json/-jsonl 等同于 -of jsonl，否则按 -of 或 -o 的扩展名选择
+	if jsonOutputFlag {
+		outputFormatFlag = formatJSONL
+	}
+	outputFormat, err := resolveOutputFormat(outputFormatFlag, outputFlag)
+	if err != nil {
+		fmt.Println(ColorRed + "[!] 错误: " + err.Error() + ColorReset)
+		os.Exit(1)
+	}
+
+	// 地址族选项
+	ipFamily, err := scanner.ParseIPFamily(ipFamilyFlag)
+	if err != nil {
+		fmt.Println(ColorRed + "[!] 错误: " + err.Error() + ColorReset)
+		os.Exit(1)
+	}
+
+	// 服务扫描端口
+	ports, err := targets.ParsePorts(portsFlag)
+	if err != nil {
+		fmt.Println(ColorRed + "[!] 错误: " + err.Error() + ColorReset)
+		os.Exit(1)
+	}
+
+	// UDP扫描端口
+	udpPorts, err := targets.ParsePorts(udpPortsFlag)
+	if err != nil {
+		fmt.Println(ColorRed + "[!] 错误: " + err.Error() + ColorReset)
+		os.Exit(1)
+	}
+	if udpRateFlag < 0 {
+		fmt.Println(ColorRed + "[!] 错误: -udp-rate不能小于0" + ColorReset)
+		os.Exit(1)
+	}
+
+	// 超时选项
+	if timeoutFlag <= 0 || minTimeoutFlag <= 0 || maxTimeoutFlag < minTimeoutFlag {
+		fmt.Println(ColorRed + "[!] 错误: 超时必须大于0，且-max-timeout不能小于-min-timeout" + ColorReset)
+		os.Exit(1)
+	}
+
+	// 结构化格式写入标准输出时，控制台只保留结果数据
+	if outputFormat != formatText && outputFlag == "" {
+		silentFlag = true
+	}
+
 	// 打印横幅
 	if !silentFlag {
 		printBanner()
@@ -41,55 +115,116 @@ func main() {
 
 	// 创建扫描器配置
 	config := &scanner.ScannerConfig{
-		Timeout:          2 * time.Second, // 固定2秒
+		Timeout:          timeoutFlag,
+		AdaptiveTimeout:  !noAdaptiveFlag,
+		MinTimeout:       minTimeoutFlag,
+		MaxTimeout:       maxTimeoutFlag,
 		FeatureThreshold: 1,
 		MaxCandidates:    10,
 		Concurrency:      threadFlag,
 		EnableFavicon:    !disableFaviconFlag,
 		EnableTCP:        !disableTCPFlag,
 		BPStat:           bpStatFlag, // 添加BP-stat选项
+		IPFamily:         ipFamily,
+		PerIP:            perIPFlag,
+		Ports:            ports,
+		PortConcurrency:  portThreadFlag,
+		MaxConnections:   maxConnsFlag,
+		PortConfigFile:   portConfigFlag,
+		EnableUDP:        udpFlag,
+		UDPPorts:         udpPorts,
+		UDPRate:          udpRateFlag,
+		EnableICS:        icsFlag,
 	}
 
-	// 调试模式下打印提示
+	// 调试模式下打印提示，与超时统计一样写入标准错误，不混入输出到标准输出的JSON Lines
 	if debugFlag {
-		fmt.Printf("%s[*] %s调试模式已启用，单个TCP请求超时设置: %s %s\n",
-			ColorGreen, ColorBlue, config.Timeout, ColorReset)
+		if config.AdaptiveTimeout {
+			fmt.Fprintf(os.Stderr, "%s[*] %s调试模式已启用，自适应超时: 初始 %s，范围 %s - %s %s\n",
+				ColorGreen, ColorBlue, config.Timeout, config.MinTimeout, config.MaxTimeout, ColorReset)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s[*] %s调试模式已启用，单个TCP请求超时设置: %s %s\n",
+				ColorGreen, ColorBlue, config.Timeout, ColorReset)
+		}
 	}
 
 	// 创建扫描器
 	s := scanner.NewScanner(webFingerprints, serviceFingerprints, featureMap, config)
+	// 指纹问题写入标准错误，不混入输出到标准输出的结果
+	if !silentFlag {
+		for _, warning := range s.Warnings {
+			fmt.Fprintf(os.Stderr, ColorYellow+"[!] %s\n"+ColorReset, warning)
+		}
+	}
 
-	// 收集目标
-	var targets []string
-	if targetFlag != "" {
-		targets = append(targets, targetFlag)
+	// 指定了DNS服务器或hosts文件时使用自定义解析
+	if resolverFlag != "" || hostsFileFlag != "" {
+		s.Resolver, err = scanner.NewResolver(strings.Split(resolverFlag, ","), hostsFileFlag)
+		if err != nil {
+			log.Fatalf(ColorRed+"[!] 创建DNS解析器失败: %v"+ColorReset, err)
+		}
+	}
+
+	// 收集目标表达式，CIDR、IP范围和端口列表在扫描时再逐个展开；标准输入在扫描时逐行读取
+	var specs []string
+	readStdin := targetFlag == "-" || targetFileFlag == "-"
+	if targetFlag != "" && targetFlag != "-" {
+		specs = append(specs, targetFlag)
 	}
 
-	if targetFileFlag != "" {
-		fileTargets, err := loadTargetsFromFile(targetFileFlag)
+	if targetFileFlag != "" && targetFileFlag != "-" {
+		fileSpecs, err := loadTargetsFromFile(targetFileFlag)
 		if err != nil {
 			log.Fatalf(ColorRed+"[!] 读取目标文件失败: %v"+ColorReset, err)
 		}
-		targets = append(targets, fileTargets...)
+		specs = append(specs, fileSpecs...)
 	}
 
 	// 去重
-	targets = uniqueStrings(targets)
+	specs = uniqueStrings(specs)
 
-	// 显示目标数量
+	// 加载排除列表
+	excluder, err := loadExcluder()
+	if err != nil {
+		log.Fatalf(ColorRed+"[!] 加载排除列表失败: %v"+ColorReset, err)
+	}
+
+	// 显示目标表达式数量，标准输入中的目标数量在读取完之前未知
 	if !silentFlag {
-		fmt.Printf(ColorGreen+"[+] %s目标数量: %d%s\n", ColorBrightCyan, len(targets), ColorReset)
+		if readStdin {
+			fmt.Printf(ColorGreen+"[+] %s目标数量: %d（另从标准输入读取）%s\n", ColorBrightCyan, len(specs), ColorReset)
+		} else {
+			fmt.Printf(ColorGreen+"[+] %s目标数量: %d%s\n", ColorBrightCyan, len(specs), ColorReset)
+		}
+	}
+
+	// 创建JSON Lines输出，每个目标完成后立即写入
+	var jsonWriter *jsonLinesWriter
+	if outputFormat == formatJSONL {
+		jsonWriter, err = newJSONLinesWriter(outputFlag)
+		if err != nil {
+			log.Fatalf(ColorRed+"[!] 创建JSON输出失败: %v"+ColorReset, err)
+		}
+		defer jsonWriter.Close()
 	}
 
 	// 并发扫描，但是实时输出结果
 	var wg sync.WaitGroup
 	resultsCh := make(chan *scanner.ScanResult, threadFlag) // 使用与并发数相同大小的缓冲区
-	errorsCh := make(chan error, len(targets))
-	semaphore := make(chan struct{}, threadFlag)
+	targetsCh := make(chan string, threadFlag)              // 展开后的目标，按需生成
+	var scanErrors []error                                  // 调试模式下收集的扫描错误
+	var errorsMutex sync.Mutex
+	var scannedCount int64 // 实际扫描的目标数量
+
+	// 只有扫描结束后生成报告文件或nmap XML时才保存结果，没有结果的目标只在报告格式需要时保存，
+	// JSON Lines已实时写入，不保存任何结果，内存占用不随目标数量增长
+	writeReportAtEnd := outputFormat != formatJSONL && (outputFlag != "" || outputFormat != formatText)
+	keepResults := writeReportAtEnd || nmapXMLFlag != ""
+	keepEmpty := writeReportAtEnd && keepsEmptyTargets(outputFormat)
 
 	// 创建一个单独的goroutine来处理结果
-	var allResults []*scanner.ScanResult // 保存所有结果用于非HTML文件输出
-	var resultMutex sync.Mutex           // 保护allResults的互斥锁
+	var allResults []*scanner.ScanResult // 保存报告需要的结果用于文件输出
+	var matchedCount int                 // 有指纹命中的目标数量
 
 	// 创建任务完成信号通道
 	scanDone := make(chan struct{})
@@ -98,41 +233,84 @@ func main() {
 	// 启动结果处理goroutine
 	go func() {
 		for result := range resultsCh {
-			// 只有当有结果时才处理
-			if len(result.WebResults) > 0 || len(result.TCPResults) > 0 {
-				// 保存结果到总结果集合
-				resultMutex.Lock()
+			// JSON Lines输出包含所有目标，便于下游确认目标已扫描
+			if jsonWriter != nil {
+				if err := jsonWriter.Write(result); err != nil {
+					fmt.Fprintf(os.Stderr, ColorBrightRed+StyleBold+"[!] 写入JSON结果失败: %v\n"+ColorReset, err)
+				}
+			}
+
+			hasResults := len(result.WebResults) > 0 || len(result.TCPResults) > 0 || len(result.Ports) > 0
+			if keepResults && (hasResults || keepEmpty) {
 				allResults = append(allResults, result)
-				resultMutex.Unlock()
+			}
+
+			// 只有当有结果时才在终端输出，没有识别出指纹的开放端口也输出
+			if hasResults {
+				matchedCount++
 
 				// 立即处理和输出结果
-				processResult(result, outputFlag)
+				processResult(result, outputFlag, outputFormat)
 			}
 		}
 		close(processDone)
 	}()
 
-	// 启动扫描goroutine
-	for _, target := range targets {
+	// 逐个展开目标表达式并送入扫描队列，大网段不会一次性生成全部目标
+	go func() {
+		defer close(targetsCh)
+		expand := func(spec string) bool {
+			err := targets.Expand(spec, func(target string) bool {
+				if !excluder.Excluded(target) {
+					targetsCh <- target
+				}
+				return true
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, ColorRed+"[!] 无效的目标: %v\n"+ColorReset, err)
+			}
+			return true
+		}
+		for _, spec := range specs {
+			expand(spec)
+		}
+		// 标准输入逐行读取后直接展开，不缓存整个输入
+		if readStdin {
+			if err := targets.StreamSpecs(os.Stdin, inputFormatFlag, expand); err != nil {
+				fmt.Fprintf(os.Stderr, ColorRed+"[!] 读取标准输入失败: %v\n"+ColorReset, err)
+			}
+		}
+	}()
+
+	// 启动固定数量的扫描goroutine
+	for i := 0; i < threadFlag; i++ {
 		wg.Add(1)
-		go func(target string) {
+		go func() {
 			defer wg.Done()
-			semaphore <- struct{}{}        // 获取信号量
-			defer func() { <-semaphore }() // 释放信号量
-
-			result, err := s.Scan(target, modelFlag)
+			for target := range targetsCh {
+				atomic.AddInt64(&scannedCount, 1)
+				result, err := s.Scan(target, modelFlag)
+				if debugFlag && result != nil {
+					printTimeoutStats(target, result.Timeouts)
+				}
 
-			if err != nil {
-				if debugFlag {
-					errorsCh <- fmt.Errorf("扫描 %s 失败: %v", target, err)
+				if err != nil {
+					if debugFlag {
+						errorsMutex.Lock()
+						scanErrors = append(scanErrors, fmt.Errorf("扫描 %s 失败: %v", target, err))
+						errorsMutex.Unlock()
+					}
+					// 保留失败记录，结构化输出中体现错误信息；Scan返回的部分结果中已经记录了错误
+					if result == nil {
+						result = &scanner.ScanResult{Target: target, Errors: []string{err.Error()}}
+					}
 				}
-				return
-			}
 
-			if result != nil {
-				resultsCh <- result
+				if result != nil {
+					resultsCh <- result
+				}
 			}
-		}(target)
+		}()
 	}
 
 	// 使用goroutine等待所有扫描完成
@@ -147,54 +325,43 @@ func main() {
 	// 等待所有结果处理完成
 	<-processDone
 
-	// 关闭错误通道
-	close(errorsCh)
-
 	// 输出错误信息
-	for err := range errorsCh {
+	for _, err := range scanErrors {
 		fmt.Fprintf(os.Stderr, ColorRed+"[!] %v\n"+ColorReset, err)
 	}
 
-	// 如果输出到非HTML文件，等所有目标都扫描完成后再一次性输出
-	if outputFlag != "" && !strings.HasSuffix(strings.ToLower(outputFlag), ".html") {
-		if jsonOutputFlag {
-			outputJSON(allResults, outputFlag)
-		} else {
-			outputText(allResults, outputFlag)
-		}
-	} else if outputFlag != "" && strings.HasSuffix(strings.ToLower(outputFlag), ".html") {
-		// 检查是否有结果已经写入HTML文件
-		if _, err := os.Stat(outputFlag); os.IsNotExist(err) && len(allResults) > 0 {
-			// 如果文件不存在但有结果，说明没有实时输出结果（可能只有一个URL）
-			// 手动输出所有结果
-			file, err := os.Create(outputFlag)
-			if err == nil {
-				defer file.Close()
-				// 写入HTML头部
-				writeHTMLHeader(file)
-				// 写入所有结果
-				for i, result := range allResults {
-					isLast := i == len(allResults)-1
-					outputHTML(result, file, i == 0, isLast)
-				}
-				// 如果没有结果是最后一个（所有结果都已写入），则手动写入HTML尾部
-				if len(allResults) > 0 {
-					writeHTMLFooter(file)
-				}
-				if !silentFlag {
-					fmt.Printf(ColorBrightGreen+StyleBold+"[+] HTML报告已完成: %s\n"+ColorReset, outputFlag)
-				}
-			} else {
-				fmt.Fprintf(os.Stderr, ColorBrightRed+StyleBold+"[!] 创建输出文件失败: %v\n"+ColorReset, err)
-			}
-		} else {
-			// 完成HTML报告（添加尾部）
-			finalizeHTMLReport(outputFlag)
+	if !silentFlag {
+		fmt.Printf(ColorGreen+"[+] %s已扫描目标: %d%s\n", ColorBrightCyan, atomic.LoadInt64(&scannedCount), ColorReset)
+	}
+
+	// 文件输出在所有目标都扫描完成后一次性生成（JSON Lines已实时写入）
+	if outputFormat == formatJSONL {
+		if outputFlag != "" && !silentFlag {
+			fmt.Printf(ColorBrightGreen+StyleBold+"[+] 结果已保存到: %s\n"+ColorReset, outputFlag)
+		}
+	} else if writeReportAtEnd {
+		records := make([]ResultRecord, 0, len(allResults))
+		for _, result := range allResults {
+			records = append(records, newResultRecord(result))
+		}
+		if err := writeReport(records, outputFormat, outputFlag); err != nil {
+			fmt.Fprintf(os.Stderr, ColorBrightRed+StyleBold+"[!] 写入输出文件失败: %v\n"+ColorReset, err)
+		}
+	}
+
+	// nmap XML可与其他输出格式同时生成
+	if nmapXMLFlag != "" {
+		records := make([]ResultRecord, 0, len(allResults))
+		for _, result := range allResults {
+			records = append(records, newResultRecord(result))
+		}
+		if err := writeReport(records, formatXML, nmapXMLFlag); err != nil {
+			fmt.Fprintf(os.Stderr, ColorBrightRed+StyleBold+"[!] 写入nmap XML失败: %v\n"+ColorReset, err)
 		}
 	}
 
 	// 如果没有结果
-	if len(allResults) == 0 && !silentFlag {
+	if matchedCount == 0 && !silentFlag {
 		fmt.Println(ColorYellow + "[!] 没有找到任何匹配的指纹" + ColorReset)
 	}
 }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// markdownEscaper 转义会破坏表格、被渲染为HTML或行内格式（链接、强调、代码）的字符
+var markdownEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	"|", "\\|",
+	"[", "\\[",
+	"]", "\\]",
+	"(", "\\(",
+	")", "\\)",
+	"*", "\\*",
+	"_", "\\_",
+	"`", "\\`",
+	"<", "&lt;",
+	">", "&gt;",
+	"\r\n", " ",
+	"\n", " ",
+	"\r", " ",
+)
+
+// technologySummary 技术汇总中的一行
+type technologySummary struct {
+	Name    string
+	Type    string
+	Targets map[string]bool
+	Hits    int
+}
+
+// writeMarkdownReport 以Markdown格式输出结果：技术汇总表加每个目标一张指纹表
+func writeMarkdownReport(w io.Writer, records []ResultRecord) error {
+	out := bufio.NewWriter(w)
+
+	totalMatches := 0
+	for _, record := range records {
+		totalMatches += len(record.Matches)
+	}
+
+	fmt.Fprintf(out, "# NebulaFinger 扫描报告\n\n")
+	fmt.Fprintf(out, "- 生成时间: %s\n", time.Now().Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(out, "- 目标数量: %d\n", len(records))
+	fmt.Fprintf(out, "- 指纹命中: %d\n\n", totalMatches)
+
+	// 技术汇总
+	summaries := summarizeTechnologies(records)
+	fmt.Fprintf(out, "## 技术汇总\n\n")
+	if len(summaries) == 0 {
+		fmt.Fprintf(out, "没有找到任何匹配的指纹\n\n")
+	} else {
+		fmt.Fprintf(out, "| 指纹 | 类型 | 目标数 | 命中数 |\n")
+		fmt.Fprintf(out, "| --- | --- | ---: | ---: |\n")
+		for _, s := range summaries {
+			fmt.Fprintf(out, "| %s | %s | %d | %d |\n",
+				escapeMarkdown(s.Name), s.Type, len(s.Targets), s.Hits)
+		}
+		fmt.Fprintf(out, "\n")
+	}
+
+	// 每个目标的指纹表
+	fmt.Fprintf(out, "## 目标详情\n\n")
+	for _, record := range records {
+		if len(record.Matches) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(out, "### %s\n\n", escapeMarkdown(record.Target))
+		fmt.Fprintf(out, "| 类型 | 地址 | 状态码 | 标题 | 指纹 | 置信度 | 详情 |\n")
+		fmt.Fprintf(out, "| --- | --- | --- | --- | --- | ---: | --- |\n")
+		for _, m := range record.Matches {
+			address := m.URL
+			if m.Type != "web" {
+				address = portAddress(m.Host, m.Port, matchProtocol(m))
+			}
+			fmt.Fprintf(out, "| %s | %s | %s | %s | %s | %d%% | %s |\n",
+				m.Type,
+				escapeMarkdown(address),
+				formatOptionalInt(m.StatusCode),
+				escapeMarkdown(m.Title),
+				escapeMarkdown(m.Fingerprint.Name),
+				confidencePercent(m.Confidence),
+				escapeMarkdown(formatDetails(m.Details)))
+		}
+		fmt.Fprintf(out, "\n")
+	}
+
+	return out.Flush()
+}
+
+// summarizeTechnologies 按指纹统计命中的目标数和次数，按目标数降序排列
+func summarizeTechnologies(records []ResultRecord) []*technologySummary {
+	byName := make(map[string]*technologySummary)
+	for _, record := range records {
+		for _, m := range record.Matches {
+			key := m.Type + "|" + m.Fingerprint.Name
+			s, ok := byName[key]
+			if !ok {
+				s = &technologySummary{Name: m.Fingerprint.Name, Type: m.Type, Targets: make(map[string]bool)}
+				byName[key] = s
+			}
+			s.Targets[record.Target] = true
+			s.Hits++
+		}
+	}
+
+	summaries := make([]*technologySummary, 0, len(byName))
+	for _, s := range byName {
+		summaries = append(summaries, s)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if len(summaries[i].Targets) != len(summaries[j].Targets) {
+			return len(summaries[i].Targets) > len(summaries[j].Targets)
+		}
+		return summaries[i].Name < summaries[j].Name
+	})
+	return summaries
+}
+
+// formatDetails 将详情按键排序格式化为 key=value 列表，跳过favicon和证书相关字段
+func formatDetails(details map[string]string) string {
+	var keys []string
+	for k := range details {
+		if strings.Contains(k, "favicon") || strings.HasPrefix(k, "tls_cert") {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+details[k])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// escapeMarkdown 转义Markdown表格单元格内容
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteMarkdownReport(t *testing.T) {
+	records := []ResultRecord{
+		{
+			SchemaVersion: SchemaVersion,
+			Target:        "example.com",
+			Matches: []MatchRecord{
+				{
+					Type:        "web",
+					URL:         "https://example.com/a_b",
+					StatusCode:  200,
+					Title:       "[click](http://evil.example) *bold* `code` a|b <script>\nnext",
+					Fingerprint: FingerprintRecord{ID: "nginx", Name: "Nginx"},
+					Confidence:  0.9,
+					Details:     map[string]string{"version": "1.24.0", "favicon_hash": "1"},
+				},
+				{
+					Type:        "service",
+					Scheme:      "udp",
+					Host:        "example.com",
+					Port:        53,
+					Fingerprint: FingerprintRecord{ID: "bind", Name: "BIND"},
+					Confidence:  1,
+				},
+				{
+					Type:        "service",
+					Scheme:      "tcp",
+					Host:        "2001:db8::1",
+					Port:        53,
+					Fingerprint: FingerprintRecord{ID: "bind", Name: "BIND"},
+					Confidence:  1,
+				},
+			},
+		},
+		{SchemaVersion: SchemaVersion, Target: "empty.example.com"},
+	}
+
+	var buf bytes.Buffer
+	if err := writeMarkdownReport(&buf, records); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"- 目标数量: 2\n",
+		"- 指纹命中: 3\n",
+		"| BIND | service | 1 | 2 |\n",
+		"| Nginx | web | 1 | 1 |\n",
+		"### example.com\n",
+		"| web | https://example.com/a\\_b | 200 | \\[click\\]\\(http://evil.example\\) \\*bold\\* \\`code\\` a\\|b &lt;script&gt; next | Nginx | 90% | version=1.24.0 |\n",
+		"| service | example.com:53/udp |  |  | BIND | 100% |  |\n",
+		"| service | \\[2001:db8::1\\]:53 |  |  | BIND | 100% |  |\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("输出中缺少 %q\n%s", want, out)
+		}
+	}
+	// 没有命中的目标不输出指纹表
+	if strings.Contains(out, "### empty.example.com") {
+		t.Errorf("没有命中的目标不应输出表格\n%s", out)
+	}
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nmapRun nmap XML根元素，只包含导入工具（如Metasploit db_import）需要的部分
+type nmapRun struct {
+	XMLName          xml.Name       `xml:"nmaprun"`
+	Scanner          string         `xml:"scanner,attr"`
+	Args             string         `xml:"args,attr"`
+	Start            int64          `xml:"start,attr"`
+	StartStr         string         `xml:"startstr,attr"`
+	Version          string         `xml:"version,attr"`
+	XMLOutputVersion string         `xml:"xmloutputversion,attr"`
+	ScanInfo         []nmapScanInfo `xml:"scaninfo"`
+	Hosts            []nmapHost     `xml:"host"`
+	RunStats         nmapRunStats   `xml:"runstats"`
+}
+
+type nmapScanInfo struct {
+	Type        string `xml:"type,attr"`
+	Protocol    string `xml:"protocol,attr"`
+	NumServices int    `xml:"numservices,attr"`
+	Services    string `xml:"services,attr"`
+}
+
+type nmapHost struct {
+	StartTime int64          `xml:"starttime,attr,omitempty"`
+	EndTime   int64          `xml:"endtime,attr,omitempty"`
+	Status    nmapStatus     `xml:"status"`
+	Addresses []nmapAddress  `xml:"address"`
+	Hostnames *nmapHostnames `xml:"hostnames,omitempty"`
+	Ports     nmapPorts      `xml:"ports"`
+}
+
+type nmapStatus struct {
+	State     string `xml:"state,attr"`
+	Reason    string `xml:"reason,attr"`
+	ReasonTTL string `xml:"reason_ttl,attr"`
+}
+
+type nmapAddress struct {
+	Addr     string `xml:"addr,attr"`
+	AddrType string `xml:"addrtype,attr"`
+}
+
+type nmapHostnames struct {
+	Hostnames []nmapHostname `xml:"hostname"`
+}
+
+type nmapHostname struct {
+	Name string `xml:"name,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type nmapPorts struct {
+	Ports []nmapPort `xml:"port"`
+}
+
+type nmapPort struct {
+	Protocol string       `xml:"protocol,attr"`
+	PortID   int          `xml:"portid,attr"`
+	State    nmapStatus   `xml:"state"`
+	Service  nmapService  `xml:"service"`
+	Scripts  []nmapScript `xml:"script,omitempty"`
+}
+
+type nmapService struct {
+	Name      string `xml:"name,attr"`
+	Product   string `xml:"product,attr,omitempty"`
+	Version   string `xml:"version,attr,omitempty"`
+	ExtraInfo string `xml:"extrainfo,attr,omitempty"`
+	Tunnel    string `xml:"tunnel,attr,omitempty"`
+	Method    string `xml:"method,attr"`
+	Conf      int    `xml:"conf,attr"`
+}
+
+type nmapScript struct {
+	ID     string      `xml:"id,attr"`
+	Output string      `xml:"output,attr"`
+	Tables []nmapTable `xml:"table,omitempty"`
+}
+
+type nmapTable struct {
+	Key   string     `xml:"key,attr,omitempty"`
+	Elems []nmapElem `xml:"elem"`
+}
+
+type nmapElem struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type nmapRunStats struct {
+	Finished nmapFinished  `xml:"finished"`
+	Hosts    nmapHostStats `xml:"hosts"`
+}
+
+type nmapFinished struct {
+	Time    int64  `xml:"time,attr"`
+	TimeStr string `xml:"timestr,attr"`
+	Elapsed string `xml:"elapsed,attr"`
+	Summary string `xml:"summary,attr"`
+	Exit    string `xml:"exit,attr"`
+}
+
+type nmapHostStats struct {
+	Up    int `xml:"up,attr"`
+	Down  int `xml:"down,attr"`
+	Total int `xml:"total,attr"`
+}
+
+// nmapWebScriptID Web指纹在nmap XML中的脚本ID
+const nmapWebScriptID = "nebulafinger-web"
+
+// writeNmapXML 以nmap XML格式输出结果：每个目标对应一个<host>，服务指纹为<port>/<service>，Web指纹写入脚本输出
+func writeNmapXML(w io.Writer, records []ResultRecord) error {
+	start, end := time.Now(), time.Time{}
+	for _, record := range records {
+		if !record.Timing.StartedAt.IsZero() && record.Timing.StartedAt.Before(start) {
+			start = record.Timing.StartedAt
+		}
+		if record.Timing.FinishedAt.After(end) {
+			end = record.Timing.FinishedAt
+		}
+	}
+	if end.IsZero() {
+		end = time.Now()
+	}
+
+	run := nmapRun{
+		Scanner:          "nebulafinger",
+		Args:             strings.Join(os.Args, " "),
+		Start:            start.Unix(),
+		StartStr:         start.Format(time.ANSIC),
+		Version:          VERSION,
+		XMLOutputVersion: "1.05",
+	}
+
+	portSets := map[string]map[int]bool{"tcp": {}}
+	var skipped []string
+	for _, record := range records {
+		hosts, noIP := nmapHostsFromRecord(record)
+		skipped = append(skipped, noIP...)
+		for _, host := range hosts {
+			for _, p := range host.Ports.Ports {
+				if portSets[p.Protocol] == nil {
+					portSets[p.Protocol] = make(map[int]bool)
+				}
+				portSets[p.Protocol][p.PortID] = true
+			}
+			run.Hosts = append(run.Hosts, host)
+		}
+	}
+
+	// 每个传输层协议一个<scaninfo>，没有UDP端口时只输出TCP
+	for _, protocol := range []string{"tcp", "udp"} {
+		portSet, ok := portSets[protocol]
+		if !ok {
+			continue
+		}
+		ports := make([]int, 0, len(portSet))
+		for p := range portSet {
+			ports = append(ports, p)
+		}
+		sort.Ints(ports)
+		portStrs := make([]string, len(ports))
+		for i, p := range ports {
+			portStrs[i] = strconv.Itoa(p)
+		}
+		scanType := "connect"
+		if protocol == "udp" {
+			scanType = "udp"
+		}
+		run.ScanInfo = append(run.ScanInfo, nmapScanInfo{
+			Type:        scanType,
+			Protocol:    protocol,
+			NumServices: len(ports),
+			Services:    strings.Join(portStrs, ","),
+		})
+	}
+
+	// 没有记录IP的主机无法生成<address>，跳过并在统计摘要中注明
+	summary := fmt.Sprintf("NebulaFinger done; %d IP address (%d hosts up) scanned", len(run.Hosts), len(run.Hosts))
+	if len(skipped) > 0 {
+		summary += fmt.Sprintf("; %d hosts without recorded IP skipped: %s", len(skipped), strings.Join(skipped, ", "))
+		if !silentFlag {
+			fmt.Fprintf(os.Stderr, ColorYellow+"[!] nmap XML跳过了没有记录IP的主机: %s\n"+ColorReset, strings.Join(skipped, ", "))
+		}
+	}
+	run.RunStats = nmapRunStats{
+		Finished: nmapFinished{
+			Time:    end.Unix(),
+			TimeStr: end.Format(time.ANSIC),
+			Elapsed: fmt.Sprintf("%.2f", end.Sub(start).Seconds()),
+			Summary: summary,
+			Exit:    "success",
+		},
+		Hosts: nmapHostStats{Up: len(run.Hosts), Total: len(run.Hosts)},
+	}
+
+	if _, err := io.WriteString(w, xml.Header+"<!DOCTYPE nmaprun>\n"); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(run); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// nmapHostsFromRecord 将一个目标的结果按主机和连接的IP拆分为<host>元素，没有命中的目标不输出；
+// 只使用扫描时记录的IP作为地址，没有记录IP的主机名不输出，作为第二个返回值返回
+func nmapHostsFromRecord(record ResultRecord) ([]nmapHost, []string) {
+	type hostKey struct {
+		name string
+		ip   string
+	}
+	type portKey struct {
+		host     hostKey
+		port     int
+		protocol string
+	}
+	var hostOrder []hostKey
+	portsByHost := make(map[hostKey][]portKey)
+	matchesByPort := make(map[portKey][]MatchRecord)
+
+	for _, m := range record.Matches {
+		if m.Host == "" || m.Port == 0 {
+			continue
+		}
+		h := hostKey{m.Host, m.IP}
+		key := portKey{h, m.Port, matchProtocol(m)}
+		if _, ok := portsByHost[h]; !ok {
+			hostOrder = append(hostOrder, h)
+		}
+		if _, ok := matchesByPort[key]; !ok {
+			portsByHost[h] = append(portsByHost[h], key)
+		}
+		matchesByPort[key] = append(matchesByPort[key], m)
+	}
+
+	// 没有识别出指纹的开放端口也输出，服务名为unknown；探测到的Web端口按协议填写
+	schemes := make(map[portKey]string)
+	for _, p := range record.Ports {
+		h := hostKey{p.Host, p.IP}
+		key := portKey{h, p.Port, portProtocol(p)}
+		schemes[key] = p.Scheme
+		if _, ok := matchesByPort[key]; ok {
+			continue
+		}
+		if _, ok := portsByHost[h]; !ok {
+			hostOrder = append(hostOrder, h)
+		}
+		portsByHost[h] = append(portsByHost[h], key)
+		matchesByPort[key] = nil
+	}
+
+	var hosts []nmapHost
+	var skipped []string
+	for _, h := range hostOrder {
+		// 使用扫描时实际连接的IP，目标本身是IP时就是该IP；离线生成报告时不重新解析主机名
+		address := h.ip
+		if address == "" {
+			address = h.name
+		}
+		addresses := nmapAddresses(address)
+		if addresses == nil {
+			skipped = appendUnique(skipped, h.name)
+			continue
+		}
+		host := nmapHost{
+			Status:    nmapStatus{State: "up", Reason: "user-set", ReasonTTL: "0"},
+			Addresses: addresses,
+		}
+		if !record.Timing.StartedAt.IsZero() {
+			host.StartTime = record.Timing.StartedAt.Unix()
+			host.EndTime = record.Timing.FinishedAt.Unix()
+		}
+		if net.ParseIP(h.name) == nil {
+			host.Hostnames = &nmapHostnames{Hostnames: []nmapHostname{{Name: h.name, Type: "user"}}}
+		}
+
+		ports := portsByHost[h]
+		sort.Slice(ports, func(i, j int) bool {
+			if ports[i].port != ports[j].port {
+				return ports[i].port < ports[j].port
+			}
+			return ports[i].protocol < ports[j].protocol
+		})
+		for _, key := range ports {
+			port := nmapPortFromMatches(key.port, matchesByPort[key], schemes[key])
+			port.Protocol = key.protocol
+			if key.protocol == "udp" {
+				port.State.Reason = "udp-response"
+			}
+			host.Ports.Ports = append(host.Ports.Ports, port)
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, skipped
+}
+
+// nmapAddresses 返回IP地址对应的<address>，不是IP时返回nil
+func nmapAddresses(address string) []nmapAddress {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return nil
+	}
+	addrType := "ipv4"
+	if ip.To4() == nil {
+		addrType = "ipv6"
+	}
+	return []nmapAddress{{Addr: ip.String(), AddrType: addrType}}
+}
+
+// matchProtocol 返回指纹命中所在端口的传输层协议，只有UDP服务为udp
+func matchProtocol(m MatchRecord) string {
+	if m.Scheme == "udp" {
+		return "udp"
+	}
+	return "tcp"
+}
+
+// portProtocol 返回端口记录的传输层协议，旧结果中没有记录协议的端口为tcp
+func portProtocol(p PortRecord) string {
+	if p.Protocol == "" {
+		return "tcp"
+	}
+	return p.Protocol
+}
+
+// nmapPortFromMatches 将同一端口上的指纹命中合并为一个<port>元素，scheme为探测到的Web协议
+func nmapPortFromMatches(port int, matches []MatchRecord, scheme string) nmapPort {
+	p := nmapPort{
+		Protocol: "tcp",
+		PortID:   port,
+		State:    nmapStatus{State: "open", Reason: "syn-ack", ReasonTTL: "0"},
+	}
+
+	var services, web []MatchRecord
+	for _, m := range matches {
+		if m.Type == "web" {
+			web = append(web, m)
+		} else {
+			services = append(services, m)
+		}
+	}
+
+	// 服务信息取置信度最高的服务指纹，没有服务指纹时按Web协议填写
+	if len(services) > 0 {
+		sort.SliceStable(services, func(i, j int) bool { return services[i].Confidence > services[j].Confidence })
+		p.Service = nmapServiceFromMatch(services[0])
+	} else if len(web) > 0 {
+		p.Service = nmapService{Name: "http", Method: "probed", Conf: 10}
+		if web[0].Scheme == "https" {
+			p.Service.Tunnel = "ssl"
+		}
+		// 取第一个真实的Web指纹作为产品信息
+		for _, m := range web {
+			if m.Fingerprint.ID != "http-status-code" {
+				s := nmapServiceFromMatch(m)
+				p.Service.Product, p.Service.Version, p.Service.ExtraInfo = s.Product, s.Version, s.ExtraInfo
+				break
+			}
+		}
+	} else if scheme != "" {
+		p.Service = nmapService{Name: "http", Method: "probed", Conf: 10}
+		if scheme == "https" {
+			p.Service.Tunnel = "ssl"
+		}
+	} else {
+		p.Service = nmapService{Name: "unknown", Method: "table", Conf: 3}
+	}
+
+	if len(web) > 0 {
+		p.Scripts = append(p.Scripts, nmapWebScript(web))
+	}
+	return p
+}
+
+// nmapServiceNames 协议模块和常见指纹名称对应的nmap服务名（nmap-services中的名称）
+var nmapServiceNames = map[string]string{
+	"ssh":           "ssh",
+	"openssh":       "ssh",
+	"ftp":           "ftp",
+	"smtp":          "smtp",
+	"telnet":        "telnet",
+	"dns":           "domain",
+	"bind":          "domain",
+	"mysql":         "mysql",
+	"mariadb":       "mysql",
+	"postgresql":    "postgresql",
+	"mssql":         "ms-sql-s",
+	"oracle":        "oracle-tns",
+	"mongodb":       "mongodb",
+	"redis":         "redis",
+	"elasticsearch": "http",
+	"clickhouse":    "http",
+	"modbus":        "modbus",
+	"s7":            "iso-tsap",
+	"enip":          "EtherNetIP-2",
+	"dnp3":          "dnp",
+	"iec104":        "iec-104",
+	"bacnet":        "bacnet",
+	"kafka":         "kafka",
+	"zookeeper":     "zookeeper",
+	"amqp":          "amqp",
+	"rabbitmq":      "amqp",
+	"mqtt":          "mqtt",
+	"nats":          "nats",
+	"openwire":      "activemq",
+	"activemq":      "activemq",
+	"rocketmq":      "rocketmq",
+	"memcached":     "memcache",
+	"etcd":          "etcd-client",
+	"rdp":           "ms-wbt-server",
+	"smb":           "microsoft-ds",
+	"snmp":          "snmp",
+	"ntp":           "ntp",
+}
+
+// nmapServiceName 返回指纹命中对应的nmap服务名：nmap探针转换的指纹名称就是服务名，
+// 协议模块和常见指纹按nmapServiceNames映射，其余将名称转换为小写并以-连接
+func nmapServiceName(m MatchRecord) string {
+	for _, tag := range m.Fingerprint.Tags {
+		if tag == "nmap" {
+			return m.Fingerprint.Name
+		}
+	}
+	for _, name := range []string{m.Details["service"], m.Details["module"], m.Fingerprint.Metadata["application"], m.Fingerprint.Name} {
+		if service, ok := nmapServiceNames[strings.ToLower(name)]; ok {
+			return service
+		}
+	}
+
+	name := m.Details["service"]
+	if name == "" {
+		name = m.Fingerprint.Name
+	}
+	name = strings.Trim(nmapServiceNameInvalid.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if name == "" {
+		return "unknown"
+	}
+	return name
+}
+
+// nmapServiceNameInvalid nmap服务名中不允许出现的字符
+var nmapServiceNameInvalid = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// nmapServiceFromMatch 根据指纹元数据和提取的详情生成<service>元素，只填写产品、版本和附加信息（nmap的p/v/i字段）
+func nmapServiceFromMatch(m MatchRecord) nmapService {
+	meta := m.Fingerprint.Metadata
+	first := func(values ...string) string {
+		for _, v := range values {
+			if v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+
+	conf := int(m.Confidence * 10)
+	if conf > 10 {
+		conf = 10
+	}
+	return nmapService{
+		Name:      nmapServiceName(m),
+		Product:   first(m.Details["product"], meta["product"], m.Fingerprint.Name),
+		Version:   first(m.Details["version"], meta["version"]),
+		ExtraInfo: first(m.Details["info"], meta["info"]),
+		Method:    "probed",
+		Conf:      conf,
+	}
+}
+
+// nmapWebScript 将同一端口上的Web指纹写入脚本输出，每个指纹一个table
+func nmapWebScript(matches []MatchRecord) nmapScript {
+	script := nmapScript{ID: nmapWebScriptID}
+
+	var lines []string
+	for _, m := range matches {
+		line := fmt.Sprintf("%s %s (%d%%)", m.URL, m.Fingerprint.Name, confidencePercent(m.Confidence))
+		if m.StatusCode != 0 {
+			line += fmt.Sprintf(" [%d]", m.StatusCode)
+		}
+		if m.Title != "" {
+			line += " " + m.Title
+		}
+		lines = append(lines, line)
+
+		table := nmapTable{Elems: []nmapElem{
+			{Key: "url", Value: m.URL},
+			{Key: "fingerprint", Value: m.Fingerprint.Name},
+			{Key: "id", Value: m.Fingerprint.ID},
+			{Key: "confidence", Value: strconv.FormatFloat(m.Confidence, 'f', 2, 64)},
+		}}
+		if m.StatusCode != 0 {
+			table.Elems = append(table.Elems, nmapElem{Key: "status_code", Value: strconv.Itoa(m.StatusCode)})
+		}
+		if m.Title != "" {
+			table.Elems = append(table.Elems, nmapElem{Key: "title", Value: m.Title})
+		}
+		for _, k := range sortedKeys(m.Details) {
+			table.Elems = append(table.Elems, nmapElem{Key: k, Value: m.Details[k]})
+		}
+		script.Tables = append(script.Tables, table)
+	}
+
+	script.Output = "\n  " + strings.Join(lines, "\n  ")
+	return script
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+// nmapTestRecords IPv4主机上的TCP/UDP服务和Web指纹、IPv6主机上的nmap探针指纹和未识别端口，以及没有记录IP的旧结果
+func nmapTestRecords() []ResultRecord {
+	start := time.Date(2025, 6, 20, 8, 0, 0, 0, time.UTC)
+	timing := TimingRecord{StartedAt: start, FinishedAt: start.Add(90 * time.Second), DurationMS: 90000}
+	return []ResultRecord{
+		{
+			SchemaVersion: SchemaVersion,
+			Target:        "example.com",
+			Timing:        timing,
+			Matches: []MatchRecord{
+				{
+					Type: "service", Scheme: "tcp", Host: "example.com", IP: "192.0.2.10", Port: 22,
+					Fingerprint: FingerprintRecord{ID: "ssh", Name: "SSH Server", Tags: []string{"detect", "remote"}},
+					Confidence:  1,
+					Details:     map[string]string{"module": "ssh", "port_match": "expected", "product": "OpenSSH", "version": "9.6p1", "hassh": "ec7378c1a92f5a8dde7e8b7a1ddf33d1"},
+				},
+				{
+					Type: "service", Scheme: "udp", Host: "example.com", IP: "192.0.2.10", Port: 53,
+					Fingerprint: FingerprintRecord{ID: "bind", Name: "BIND", Metadata: map[string]string{"product": "ISC BIND"}},
+					Confidence:  0.8,
+					Details:     map[string]string{"version": "9.18.24", "protocol": "udp"},
+				},
+				{
+					Type: "web", URL: "https://example.com", Scheme: "https", Host: "example.com", IP: "192.0.2.10", Port: 443,
+					StatusCode: 200, Title: "Welcome",
+					Fingerprint: FingerprintRecord{ID: "nginx", Name: "Nginx"},
+					Confidence:  0.9,
+					Details:     map[string]string{"version": "1.24.0"},
+				},
+			},
+			Ports: []PortRecord{
+				{Host: "example.com", IP: "192.0.2.10", Port: 22, Protocol: "tcp", State: "open"},
+				{Host: "example.com", IP: "192.0.2.10", Port: 53, Protocol: "udp", State: "open"},
+				{Host: "example.com", IP: "192.0.2.10", Port: 443, Protocol: "tcp", State: "open", Scheme: "https", URL: "https://example.com"},
+			},
+		},
+		{
+			SchemaVersion: SchemaVersion,
+			Target:        "2001:db8::1",
+			Timing:        timing,
+			Matches: []MatchRecord{
+				{
+					Type: "service", Scheme: "tcp", Host: "2001:db8::1", Port: 8443,
+					Fingerprint: FingerprintRecord{ID: "nmap-http-18", Name: "http", Tags: []string{"nmap", "http"}},
+					Confidence:  0.7,
+					Details:     map[string]string{"product": "nginx", "version": "1.18.0", "info": "Ubuntu", "cpe": "cpe:/a:igor_sysoev:nginx:1.18.0"},
+				},
+			},
+			Ports: []PortRecord{
+				{Host: "2001:db8::1", Port: 8443, Protocol: "tcp", State: "open"},
+				{Host: "2001:db8::1", Port: 9000, Protocol: "tcp", State: "open"},
+			},
+		},
+		{
+			SchemaVersion: "1.0",
+			Target:        "old.example.com",
+			Timing:        timing,
+			Matches: []MatchRecord{
+				{
+					Type: "service", Scheme: "tcp", Host: "old.example.com", Port: 6379,
+					Fingerprint: FingerprintRecord{ID: "redis", Name: "Redis"},
+					Confidence:  1,
+				},
+			},
+		},
+	}
+}
+
+// TestWriteNmapXML 输出与testdata/nmap.xml一致：只使用记录的IP、服务名为nmap服务名、extrainfo只有附加信息
+func TestWriteNmapXML(t *testing.T) {
+	args := os.Args
+	os.Args = []string{"nebulafinger", "report", "-of", "xml", "results.jsonl"}
+	defer func() { os.Args = args }()
+	silent := silentFlag
+	silentFlag = true
+	defer func() { silentFlag = silent }()
+
+	var buf bytes.Buffer
+	if err := writeNmapXML(&buf, nmapTestRecords()); err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("testdata/nmap.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := bytes.ReplaceAll(buf.Bytes(), []byte(VERSION), []byte("VERSION")); !bytes.Equal(got, want) {
+		t.Errorf("nmap XML与testdata/nmap.xml不一致:\n%s", got)
+	}
+}
+
+func TestNmapServiceName(t *testing.T) {
+	tests := []struct {
+		name string
+		m    MatchRecord
+		want string
+	}{
+		{"nmap探针", MatchRecord{Fingerprint: FingerprintRecord{Name: "ms-sql-s", Tags: []string{"nmap"}}}, "ms-sql-s"},
+		{"协议模块", MatchRecord{Fingerprint: FingerprintRecord{Name: "Microsoft SQL Server"}, Details: map[string]string{"module": "mssql"}}, "ms-sql-s"},
+		{"常见指纹名称", MatchRecord{Fingerprint: FingerprintRecord{Name: "OpenSSH"}}, "ssh"},
+		{"应用元数据", MatchRecord{Fingerprint: FingerprintRecord{Name: "Broker", Metadata: map[string]string{"application": "RabbitMQ"}}}, "amqp"},
+		{"名称带空格", MatchRecord{Fingerprint: FingerprintRecord{Name: "Apache Tomcat (AJP)"}}, "apache-tomcat-ajp"},
+		{"没有可用字符", MatchRecord{Fingerprint: FingerprintRecord{Name: "管理后台"}}, "unknown"},
+	}
+	for _, tt := range tests {
+		if got := nmapServiceName(tt.m); got != tt.want {
+			t.Errorf("%s = %q，期望 %q", tt.name, got, tt.want)
+		}
+	}
+}
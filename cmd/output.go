@@ -1,38 +1,18 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
 	"nebulafinger/internal/matcher"
 	"nebulafinger/internal/scanner"
+	"net"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
-// 输出JSON格式结果
-func outputJSON(results []*scanner.ScanResult, outputPath string) {
-	data, err := json.MarshalIndent(results, "", "  ")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, ColorBrightRed+StyleBold+"[!] 序列化JSON失败: %v\n"+ColorReset, err)
-		return
-	}
-
-	if outputPath == "" {
-		// 仅在非静默模式下输出到控制台
-		if !silentFlag {
-			fmt.Println(string(data))
-		}
-	} else {
-		if err := os.WriteFile(outputPath, data, 0644); err != nil {
-			fmt.Fprintf(os.Stderr, ColorBrightRed+StyleBold+"[!] 写入输出文件失败: %v\n"+ColorReset, err)
-		} else if !silentFlag {
-			fmt.Printf(ColorBrightGreen+StyleBold+"[+] 结果已保存到: %s\n"+ColorReset, outputPath)
-		}
-	}
-}
-
 // 输出文本格式结果
 func outputText(results []*scanner.ScanResult, outputPath string) {
 	var output io.Writer
@@ -41,7 +21,6 @@ func outputText(results []*scanner.ScanResult, outputPath string) {
 
 	// 判断是否输出到文件
 	toFile := outputPath != ""
-	isHTML := toFile && strings.HasSuffix(strings.ToLower(outputPath), ".html")
 
 	if toFile {
 		file, err = os.Create(outputPath)
@@ -53,7 +32,6 @@ func outputText(results []*scanner.ScanResult, outputPath string) {
 				return // 静默模式下直接返回
 			}
 			toFile = false
-			isHTML = false
 		} else {
 			defer file.Close()
 			output = file
@@ -64,19 +42,13 @@ func outputText(results []*scanner.ScanResult, outputPath string) {
 		return // 静默模式下不输出到控制台直接返回
 	}
 
-	for i, result := range results {
-		// 如果是HTML格式，使用HTML输出函数
-		if isHTML {
-			outputHTML(result, file, i == 0, i == len(results)-1)
-			continue
-		}
-
+	for _, result := range results {
 		// 打印Web指纹结果
 		if len(result.WebResults) > 0 {
 			if !toFile {
 				fmt.Fprintf(output, "\n")
 			} else {
-				fmt.Fprintf(output, "\nWeb指纹:\n")
+				fmt.Fprintf(output, "\n[ WEB-FINGERPRINTS ]\n")
 			}
 
 			// 按URL对指纹进行分组
@@ -201,9 +173,9 @@ func outputText(results []*scanner.ScanResult, outputPath string) {
 							detailsCount := 0
 							for _, result := range pathResults {
 								for k, v := range result.Details {
-									// 跳过已经显示的字段、共有字段以及favicon相关字段
+									// 跳过已经显示的字段、共有字段以及favicon和证书相关字段
 									if k == "url" || k == "status_code" || k == "title" ||
-										strings.Contains(k, "favicon") {
+										strings.Contains(k, "favicon") || strings.HasPrefix(k, "tls_cert") {
 										continue
 									}
 
@@ -259,10 +231,8 @@ func outputText(results []*scanner.ScanResult, outputPath string) {
 
 						fmt.Fprintf(output, "  [WEB] [%s] %s\n", strings.Join(names, " • "), pathUrl)
 						if statusCode != "" || title != "" {
-							// 使用根据状态码选择的颜色
-							statusColor := getStatusColor(statusCode)
-							fmt.Fprintf(output, "    └─ %s%s%s | %s\n",
-								statusColor, statusCode, ColorReset, title)
+							// 文件输出不带颜色控制符
+							fmt.Fprintf(output, "    └─ %s | %s\n", statusCode, title)
 						}
 
 						// 跳过favicon相关的详情信息
@@ -279,9 +249,9 @@ func outputText(results []*scanner.ScanResult, outputPath string) {
 							var detailsShown bool = false
 							for _, result := range pathResults {
 								for k, v := range result.Details {
-									// 跳过已经显示的字段、共有字段以及favicon相关字段
+									// 跳过已经显示的字段、共有字段以及favicon和证书相关字段
 									if k == "url" || k == "status_code" || k == "title" ||
-										strings.Contains(k, "favicon") {
+										strings.Contains(k, "favicon") || strings.HasPrefix(k, "tls_cert") {
 										continue
 									}
 
@@ -310,7 +280,7 @@ func outputText(results []*scanner.ScanResult, outputPath string) {
 			if !toFile {
 				fmt.Fprintf(output, "\n")
 			} else {
-				fmt.Fprintf(output, "\n服务指纹:\n")
+				fmt.Fprintf(output, "\n[ SERVICES-FINGERPRINTS ]\n")
 			}
 
 			// 按主机和端口对指纹进行分组
@@ -318,7 +288,10 @@ func outputText(results []*scanner.ScanResult, outputPath string) {
 			for _, tcpResult := range result.TCPResults {
 				host := tcpResult.Details["host"]
 				port := tcpResult.Details["port"]
-				key := host + ":" + port
+				if tcpResult.Details["protocol"] == scanner.ProtocolUDP {
+					port += "/udp"
+				}
+				key := net.JoinHostPort(host, port)
 				if host == "" || port == "" {
 					key = "未知主机端口"
 				}
@@ -328,12 +301,8 @@ func outputText(results []*scanner.ScanResult, outputPath string) {
 			// 将端口结果按主机分组
 			hostGroups := make(map[string]map[string][]matcher.MatchResult)
 			for hostPort, results := range hostPortGroups {
-				parts := strings.Split(hostPort, ":")
-				var host, port string
-				if len(parts) == 2 {
-					host = parts[0]
-					port = parts[1]
-				} else {
+				host, port, err := net.SplitHostPort(hostPort)
+				if err != nil {
 					host = hostPort
 					port = ""
 				}
@@ -439,7 +408,11 @@ func outputText(results []*scanner.ScanResult, outputPath string) {
 							names = append(names, fmt.Sprintf("%s (%d%%)", r.Name, confidencePercent))
 						}
 
-						fmt.Fprintf(output, "  [TCP] [%s] %s\n", strings.Join(names, " • "), host)
+						label := "TCP"
+						if strings.HasSuffix(port, "/udp") {
+							label = "UDP"
+						}
+						fmt.Fprintf(output, "  [%s] [%s] %s\n", label, strings.Join(names, " • "), host)
 						if port != "" {
 							fmt.Fprintf(output, "    └─ %s\n", port)
 						}
@@ -472,6 +445,17 @@ func outputText(results []*scanner.ScanResult, outputPath string) {
 			}
 		}
 
+		// 打印没有识别出指纹的开放端口
+		if unknown := unidentifiedPorts(result); len(unknown) > 0 {
+			if !toFile {
+				fmt.Fprintf(output, "\n  %s┌─[ %sOPEN-PORTS%s ]%s\n  %s└─%s %s\n",
+					ColorBrightYellow, ColorBrightYellow, ColorBrightYellow, ColorReset,
+					ColorBrightYellow, ColorReset, strings.Join(unknown, ", "))
+			} else {
+				fmt.Fprintf(output, "\n[ OPEN-PORTS ]\n  %s\n", strings.Join(unknown, ", "))
+			}
+		}
+
 		fmt.Fprintf(output, "\n")
 	}
 
@@ -480,18 +464,38 @@ func outputText(results []*scanner.ScanResult, outputPath string) {
 	}
 }
 
-// 处理单个扫描结果
-func processResult(result *scanner.ScanResult, outputPath string) {
-	// 如果启用了静默模式且没有输出文件，则直接返回
-	if silentFlag && outputPath == "" {
-		return
+// unidentifiedPorts 返回没有服务指纹命中、也不是Web服务的开放端口，格式为 主机:端口，UDP端口带/udp后缀
+func unidentifiedPorts(result *scanner.ScanResult) []string {
+	identified := make(map[string]bool)
+	for _, r := range result.TCPResults {
+		port, _ := strconv.Atoi(r.Details["port"])
+		identified[portAddress(r.Details["host"], port, r.Details["protocol"])] = true
 	}
 
-	// 如果有输出文件但不是HTML，则先不处理，等所有结果收集完再一起处理
-	if outputPath != "" && !strings.HasSuffix(strings.ToLower(outputPath), ".html") {
-		return
+	var ports []string
+	for _, p := range result.Ports {
+		address := portAddress(p.Host, p.Port, p.Protocol)
+		if !identified[address] && len(p.Web) == 0 {
+			identified[address] = true
+			ports = append(ports, address)
+		}
+	}
+	return ports
+}
+
+// printTimeoutStats 调试模式下输出目标每个地址的RTT估计和超时，写入标准错误以免混入结构化输出
+func printTimeoutStats(target string, stats []scanner.TimeoutStats) {
+	for _, t := range stats {
+		fmt.Fprintf(os.Stderr, "%s[*] %s%s [%s] RTT样本: %d, 超时: %d, SRTT: %s, RTTVAR: %s, RTT范围: %s - %s, 连接超时: %s, 读取超时: %s%s\n",
+			ColorGreen, ColorBlue, target, t.IP, t.Samples, t.Timeouts,
+			t.SRTT.Round(time.Microsecond), t.RTTVar.Round(time.Microsecond),
+			t.MinRTT.Round(time.Microsecond), t.MaxRTT.Round(time.Microsecond),
+			t.ConnectTimeout.Round(time.Millisecond), t.ReadTimeout.Round(time.Millisecond), ColorReset)
 	}
+}
 
+// 处理单个扫描结果
+func processResult(result *scanner.ScanResult, outputPath string, format string) {
 	// 对每个结果中的WebResults和TCPResults进行去重
 	if len(result.WebResults) > 0 {
 		result.WebResults = scanner.UniqueResults(result.WebResults)
@@ -500,61 +504,14 @@ func processResult(result *scanner.ScanResult, outputPath string) {
 		result.TCPResults = scanner.UniqueResults(result.TCPResults)
 	}
 
-	// 如果是输出到HTML文件
-	if outputPath != "" && strings.HasSuffix(strings.ToLower(outputPath), ".html") {
-		// 检查文件是否存在，决定是否需要写入头部
-		isFirstResult := false
-		if _, err := os.Stat(outputPath); os.IsNotExist(err) {
-			isFirstResult = true
-		}
-
-		// 追加模式打开文件
-		file, err := os.OpenFile(outputPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, ColorBrightRed+StyleBold+"[!] 打开输出文件失败: %v\n"+ColorReset, err)
-			return
-		}
-		defer file.Close()
-
-		// 输出HTML格式，永远不是最后一个结果，因为我们实时输出
-		outputHTML(result, file, isFirstResult, false)
-
-		// 同时输出到命令行，除非启用了静默模式
-		if !silentFlag {
-			// 创建一个只包含当前结果的切片
-			results := []*scanner.ScanResult{result}
-			outputText(results, "")
-		}
-
-		// 这里不显示保存成功信息，防止过多输出
-	} else if !silentFlag {
-		// 输出到终端，但只在非静默模式下
-		// 创建一个只包含当前结果的切片
-		results := []*scanner.ScanResult{result}
-		outputText(results, "")
-	}
-}
-
-// 完成HTML报告
-func finalizeHTMLReport(outputPath string) {
-	if !strings.HasSuffix(strings.ToLower(outputPath), ".html") {
-		return
-	}
-
-	// 打开文件追加尾部
-	file, err := os.OpenFile(outputPath, os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, ColorBrightRed+StyleBold+"[!] 打开输出文件失败: %v\n"+ColorReset, err)
+	// 静默模式不输出到终端；输出到文本文件时，等所有结果收集完再一起写入文件，终端不重复显示
+	if silentFlag || (outputPath != "" && format == formatText) {
 		return
 	}
-	defer file.Close()
-
-	// 写入HTML尾部
-	writeHTMLFooter(file)
 
-	if !silentFlag {
-		fmt.Printf(ColorBrightGreen+StyleBold+"[+] HTML报告已完成: %s\n"+ColorReset, outputPath)
-	}
+	// 创建一个只包含当前结果的切片
+	results := []*scanner.ScanResult{result}
+	outputText(results, "")
 }
 
 // getConfidenceColor 根据置信度选择颜色
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"nebulafinger/internal"
+	"nebulafinger/internal/matcher"
+	"nebulafinger/internal/scanner"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SchemaVersion 结构化结果格式版本，字段发生不兼容变化时递增主版本号，新增字段时递增次版本号
+const SchemaVersion = "1.2"
+
+// ResultRecord 结构化输出中每个目标对应的一条记录
+type ResultRecord struct {
+	SchemaVersion   string                 `json:"schema_version" desc:"结果格式版本"`
+	Target          string                 `json:"target" desc:"扫描目标（原始输入）"`
+	Matches         []MatchRecord          `json:"matches" desc:"命中的指纹列表，每个目标/URL/端口/指纹一条"`
+	Ports           []PortRecord           `json:"ports,omitempty" desc:"服务扫描发现的开放端口，包括没有识别出指纹的端口"`
+	PortSummary     *PortSummary           `json:"port_summary,omitempty" desc:"服务扫描的端口状态统计"`
+	Errors          []string               `json:"errors,omitempty" desc:"扫描过程中出现的错误"`
+	Timing          TimingRecord           `json:"timing" desc:"扫描耗时信息"`
+	Timeouts        []TimeoutRecord        `json:"timeouts,omitempty" desc:"每个连接地址的RTT估计和自适应超时（启用自适应超时时）"`
+	SchemeDecisions []SchemeDecisionRecord `json:"scheme_decisions,omitempty" desc:"没有协议头的目标和开放端口的Web协议探测结论"`
+}
+
+// SchemeDecisionRecord 单个 主机:端口 的Web协议探测结论
+type SchemeDecisionRecord struct {
+	Host    string   `json:"host" desc:"主机名或IP"`
+	Port    int      `json:"port" desc:"端口"`
+	Schemes []string `json:"schemes,omitempty" desc:"选用的协议，为空时没有做Web扫描"`
+	Reason  string   `json:"reason" desc:"结论依据: tls, plain, https-error-page, redirect-to-https, both-different, both-same, no-response"`
+}
+
+// TimingRecord 扫描耗时信息
+type TimingRecord struct {
+	StartedAt  time.Time `json:"started_at" desc:"开始扫描时间（RFC3339）"`
+	FinishedAt time.Time `json:"finished_at" desc:"完成扫描时间（RFC3339）"`
+	DurationMS int64     `json:"duration_ms" desc:"扫描耗时（毫秒）"`
+}
+
+// TimeoutRecord 单个地址的RTT估计和超时统计，时间单位为毫秒
+type TimeoutRecord struct {
+	IP               string  `json:"ip,omitempty" desc:"连接的IP地址"`
+	Samples          int     `json:"samples" desc:"RTT样本数（连接成功或被拒绝的次数）"`
+	Timeouts         int     `json:"timeouts" desc:"连接超时次数"`
+	SRTTMS           float64 `json:"srtt_ms" desc:"平滑RTT（毫秒）"`
+	RTTVarMS         float64 `json:"rttvar_ms" desc:"RTT偏差（毫秒）"`
+	MinRTTMS         float64 `json:"min_rtt_ms" desc:"最小RTT（毫秒）"`
+	MaxRTTMS         float64 `json:"max_rtt_ms" desc:"最大RTT（毫秒）"`
+	ConnectTimeoutMS float64 `json:"connect_timeout_ms" desc:"扫描结束时的连接超时（毫秒）"`
+	ReadTimeoutMS    float64 `json:"read_timeout_ms" desc:"扫描结束时的读取超时（毫秒）"`
+}
+
+// MatchRecord 单条指纹命中记录
+type MatchRecord struct {
+	Type        string            `json:"type" desc:"结果类型: web 或 service"`
+	URL         string            `json:"url,omitempty" desc:"命中的URL（仅web）"`
+	Scheme      string            `json:"scheme,omitempty" desc:"协议: http, https, tcp, udp"`
+	Host        string            `json:"host,omitempty" desc:"主机名或IP"`
+	IP          string            `json:"ip,omitempty" desc:"实际连接的IP地址（主机名扫描前解析得到）"`
+	Port        int               `json:"port,omitempty" desc:"端口"`
+	StatusCode  int               `json:"status_code,omitempty" desc:"HTTP状态码（仅web）"`
+	Title       string            `json:"title,omitempty" desc:"页面标题（仅web）"`
+	Fingerprint FingerprintRecord `json:"fingerprint" desc:"命中的指纹"`
+	Confidence  float64           `json:"confidence" desc:"置信度，取值0-1"`
+	Details     map[string]string `json:"details,omitempty" desc:"提取器获得的其他详细信息（如版本）"`
+	Evidence    string            `json:"evidence,omitempty" desc:"命中的匹配规则（关键词、正则或哈希）"`
+}
+
+// PortRecord 开放端口记录
+type PortRecord struct {
+	Host     string          `json:"host" desc:"主机名或IP"`
+	IP       string          `json:"ip,omitempty" desc:"实际连接的IP地址"`
+	Port     int             `json:"port" desc:"端口"`
+	Protocol string          `json:"protocol,omitempty" desc:"传输层协议: tcp, udp"`
+	State    string          `json:"state" desc:"端口状态: open, closed, filtered"`
+	Scheme   string          `json:"scheme,omitempty" desc:"端口是Web服务时探测到的协议: http, https；两种协议都提供服务时为web中的第一项"`
+	URL      string          `json:"url,omitempty" desc:"端口是Web服务时做Web指纹识别的URL；两种协议都提供服务时为web中的第一项"`
+	Web      []PortWebRecord `json:"web,omitempty" desc:"端口上探测到的全部Web服务，HTTP和HTTPS都提供服务时各一项"`
+}
+
+// PortWebRecord 端口上的Web服务记录
+type PortWebRecord struct {
+	Scheme string `json:"scheme" desc:"协议: http, https"`
+	URL    string `json:"url" desc:"做Web指纹识别的URL，对应的结果见matches中该URL的web记录"`
+}
+
+// PortSummary 端口状态统计
+type PortSummary struct {
+	Open         int `json:"open" desc:"开放端口数"`
+	Closed       int `json:"closed" desc:"关闭端口数（连接被拒绝）"`
+	Filtered     int `json:"filtered" desc:"被过滤端口数（连接超时或不可达）"`
+	OpenFiltered int `json:"open_filtered,omitempty" desc:"没有任何响应的UDP端口数（open|filtered）"`
+}
+
+// FingerprintRecord 指纹标识信息
+type FingerprintRecord struct {
+	ID       string            `json:"id" desc:"指纹ID"`
+	Name     string            `json:"name" desc:"指纹名称"`
+	Tags     []string          `json:"tags,omitempty" desc:"指纹标签"`
+	Metadata map[string]string `json:"metadata,omitempty" desc:"指纹元数据（vendor, product, version等）"`
+}
+
+// 已经被提升为MatchRecord独立字段的详情键
+var promotedDetailKeys = map[string]bool{
+	"url":         true,
+	"host":        true,
+	"ip":          true,
+	"port":        true,
+	"status_code": true,
+	"title":       true,
+}
+
+// newResultRecord 将扫描结果转换为结构化记录
+func newResultRecord(result *scanner.ScanResult) ResultRecord {
+	record := ResultRecord{
+		SchemaVersion: SchemaVersion,
+		Target:        result.Target,
+		Matches:       []MatchRecord{},
+		Errors:        result.Errors,
+		Timing: TimingRecord{
+			StartedAt:  result.StartTime,
+			FinishedAt: result.EndTime,
+		},
+	}
+	if !result.StartTime.IsZero() && !result.EndTime.IsZero() {
+		record.Timing.DurationMS = result.EndTime.Sub(result.StartTime).Milliseconds()
+	}
+
+	// 目标本身的URL，用于补全favicon等没有URL的结果
+	targetURL := result.Target
+	if !strings.HasPrefix(targetURL, "http") {
+		targetURL = "http://" + targetURL
+	}
+
+	for _, r := range result.WebResults {
+		match := newMatchRecord("web", r)
+		if match.URL == "" {
+			match.URL = targetURL
+		}
+		if u, err := url.Parse(match.URL); err == nil {
+			match.Scheme = u.Scheme
+			match.Host = u.Hostname()
+			match.Port = urlPort(u)
+		}
+		record.Matches = append(record.Matches, match)
+	}
+
+	for _, r := range result.TCPResults {
+		match := newMatchRecord("service", r)
+		match.Scheme = "tcp"
+		if r.Details["protocol"] == scanner.ProtocolUDP {
+			match.Scheme = "udp"
+		}
+		record.Matches = append(record.Matches, match)
+	}
+
+	for _, p := range result.Ports {
+		port := PortRecord{Host: p.Host, IP: p.IP, Port: p.Port, Protocol: p.Protocol, State: p.State}
+		for _, web := range p.Web {
+			port.Web = append(port.Web, PortWebRecord{Scheme: web.Scheme, URL: web.URL})
+		}
+		if len(port.Web) > 0 {
+			port.Scheme, port.URL = port.Web[0].Scheme, port.Web[0].URL
+		}
+		record.Ports = append(record.Ports, port)
+	}
+	if result.PortSummary != nil {
+		record.PortSummary = &PortSummary{
+			Open:         result.PortSummary.Open,
+			Closed:       result.PortSummary.Closed,
+			Filtered:     result.PortSummary.Filtered,
+			OpenFiltered: result.PortSummary.OpenFiltered,
+		}
+	}
+
+	for _, t := range result.Timeouts {
+		record.Timeouts = append(record.Timeouts, TimeoutRecord{
+			IP:               t.IP,
+			Samples:          t.Samples,
+			Timeouts:         t.Timeouts,
+			SRTTMS:           durationMS(t.SRTT),
+			RTTVarMS:         durationMS(t.RTTVar),
+			MinRTTMS:         durationMS(t.MinRTT),
+			MaxRTTMS:         durationMS(t.MaxRTT),
+			ConnectTimeoutMS: durationMS(t.ConnectTimeout),
+			ReadTimeoutMS:    durationMS(t.ReadTimeout),
+		})
+	}
+
+	for _, d := range result.SchemeDecisions {
+		record.SchemeDecisions = append(record.SchemeDecisions, SchemeDecisionRecord(d))
+	}
+
+	return record
+}
+
+// durationMS 将时长转换为毫秒，保留3位小数
+func durationMS(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// msDuration 将毫秒转换为时长
+func msDuration(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// newMatchRecord 将单个匹配结果转换为结构化记录
+func newMatchRecord(matchType string, r matcher.MatchResult) MatchRecord {
+	match := MatchRecord{
+		Type: matchType,
+		Fingerprint: FingerprintRecord{
+			ID:       r.ID,
+			Name:     r.Name,
+			Tags:     splitTags(r.Tags),
+			Metadata: metadataToMap(r.Metadata),
+		},
+		Confidence: r.Confidence,
+		Evidence:   r.Evidence,
+		URL:        r.Details["url"],
+		Host:       r.Details["host"],
+		IP:         r.Details["ip"],
+		Title:      r.Details["title"],
+	}
+	match.Port, _ = strconv.Atoi(r.Details["port"])
+	match.StatusCode, _ = strconv.Atoi(r.Details["status_code"])
+
+	for k, v := range r.Details {
+		if promotedDetailKeys[k] {
+			continue
+		}
+		if match.Details == nil {
+			match.Details = make(map[string]string)
+		}
+		match.Details[k] = v
+	}
+
+	return match
+}
+
+// scanResultFromRecord 将结构化记录还原为扫描结果，供文本输出等基于ScanResult的渲染复用
+func scanResultFromRecord(record ResultRecord) *scanner.ScanResult {
+	result := &scanner.ScanResult{
+		Target:    record.Target,
+		Errors:    record.Errors,
+		StartTime: record.Timing.StartedAt,
+		EndTime:   record.Timing.FinishedAt,
+	}
+
+	for _, p := range record.Ports {
+		port := scanner.PortResult{Host: p.Host, IP: p.IP, Port: p.Port, Protocol: p.Protocol, State: p.State}
+		for _, web := range p.Web {
+			port.Web = append(port.Web, scanner.PortWeb{Scheme: web.Scheme, URL: web.URL})
+		}
+		// 1.1及之前的结果只有scheme和url
+		if len(port.Web) == 0 && p.Scheme != "" {
+			port.Web = []scanner.PortWeb{{Scheme: p.Scheme, URL: p.URL}}
+		}
+		result.Ports = append(result.Ports, port)
+	}
+	if record.PortSummary != nil {
+		result.PortSummary = &scanner.PortSummary{
+			Open:         record.PortSummary.Open,
+			Closed:       record.PortSummary.Closed,
+			Filtered:     record.PortSummary.Filtered,
+			OpenFiltered: record.PortSummary.OpenFiltered,
+		}
+	}
+
+	for _, t := range record.Timeouts {
+		result.Timeouts = append(result.Timeouts, scanner.TimeoutStats{
+			IP:             t.IP,
+			Samples:        t.Samples,
+			Timeouts:       t.Timeouts,
+			SRTT:           msDuration(t.SRTTMS),
+			RTTVar:         msDuration(t.RTTVarMS),
+			MinRTT:         msDuration(t.MinRTTMS),
+			MaxRTT:         msDuration(t.MaxRTTMS),
+			ConnectTimeout: msDuration(t.ConnectTimeoutMS),
+			ReadTimeout:    msDuration(t.ReadTimeoutMS),
+		})
+	}
+
+	for _, d := range record.SchemeDecisions {
+		result.SchemeDecisions = append(result.SchemeDecisions, scanner.SchemeDecision(d))
+	}
+
+	for _, m := range record.Matches {
+		r := matcher.MatchResult{
+			ID:         m.Fingerprint.ID,
+			Name:       m.Fingerprint.Name,
+			Confidence: m.Confidence,
+			Details:    make(map[string]string),
+			Tags:       m.Fingerprint.Tags,
+			Evidence:   m.Evidence,
+		}
+		for k, v := range m.Details {
+			r.Details[k] = v
+		}
+		if m.IP != "" {
+			r.Details["ip"] = m.IP
+		}
+
+		if m.Type == "web" {
+			r.Details["url"] = m.URL
+			if m.StatusCode != 0 {
+				r.Details["status_code"] = strconv.Itoa(m.StatusCode)
+			}
+			if m.Title != "" {
+				r.Details["title"] = m.Title
+			}
+			result.WebResults = append(result.WebResults, r)
+			continue
+		}
+
+		r.Details["host"] = m.Host
+		if m.Port != 0 {
+			r.Details["port"] = strconv.Itoa(m.Port)
+		}
+		result.TCPResults = append(result.TCPResults, r)
+	}
+
+	return result
+}
+
+// urlPort 返回URL中的端口，未显式指定时按协议推断
+func urlPort(u *url.URL) int {
+	if p, err := strconv.Atoi(u.Port()); err == nil {
+		return p
+	}
+	switch u.Scheme {
+	case "http":
+		return 80
+	case "https":
+		return 443
+	}
+	return 0
+}
+
+// collectMatchKeys 汇总所有指纹命中中出现过的详情键和元数据键，用于生成表格列，按字母排序
+func collectMatchKeys(records []ResultRecord) (detailKeys, metadataKeys []string) {
+	details := make(map[string]bool)
+	metadata := make(map[string]bool)
+	for _, record := range records {
+		for _, m := range record.Matches {
+			for k := range m.Details {
+				details[k] = true
+			}
+			for k := range m.Fingerprint.Metadata {
+				metadata[k] = true
+			}
+		}
+	}
+	for k := range details {
+		detailKeys = append(detailKeys, k)
+	}
+	for k := range metadata {
+		metadataKeys = append(metadataKeys, k)
+	}
+	sort.Strings(detailKeys)
+	sort.Strings(metadataKeys)
+	return detailKeys, metadataKeys
+}
+
+// confidencePercent 将0-1的置信度转换为不超过100的整数百分比
+func confidencePercent(confidence float64) int {
+	percent := int(confidence * 100)
+	if percent > 100 {
+		percent = 100
+	}
+	return percent
+}
+
+// splitTags 将指纹中以逗号分隔的标签字符串拆分为标签列表
+func splitTags(tags []string) []string {
+	var result []string
+	for _, t := range tags {
+		for _, tag := range strings.Split(t, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag != "" {
+				result = appendUnique(result, tag)
+			}
+		}
+	}
+	return result
+}
+
+// metadataToMap 将指纹元数据转换为snake_case键的映射，忽略空值和搜索引擎查询语句
+func metadataToMap(m *internal.Metadata) map[string]string {
+	if m == nil {
+		return nil
+	}
+	fields := map[string]string{
+		"application": m.Application,
+		"vendor":      m.Vendor,
+		"product":     m.Product,
+		"version":     m.Version,
+		"update":      m.Update,
+		"edition":     m.Edition,
+		"language":    m.Language,
+		"sw_edition":  m.SwEdition,
+		"target_sw":   m.TargetSw,
+		"target_hw":   m.TargetHw,
+		"other":       m.Other,
+		"info":        m.InfoField,
+	}
+	if m.Rarity != 0 {
+		fields["rarity"] = strconv.Itoa(m.Rarity)
+	}
+	if m.Verified {
+		fields["verified"] = "true"
+	}
+
+	result := make(map[string]string)
+	for k, v := range fields {
+		if v != "" {
+			result[k] = v
+		}
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"nebulafinger/internal/matcher"
+	"nebulafinger/internal/scanner"
+	"testing"
+)
+
+// TestNewResultRecordIP 按地址分别扫描的结果转换为记录时，详情中的ip提升为matches[].ip
+func TestNewResultRecordIP(t *testing.T) {
+	result := &scanner.ScanResult{
+		Target: "multi.nebula.test:2121",
+		TCPResults: []matcher.MatchResult{
+			{ID: "ftp", Name: "ftp", Details: map[string]string{"host": "multi.nebula.test", "port": "2121", "ip": "192.0.2.1", "version": "1"}},
+			{ID: "ftp", Name: "ftp", Details: map[string]string{"host": "multi.nebula.test", "port": "2121", "ip": "2001:db8::1", "version": "2"}},
+		},
+		Ports: []scanner.PortResult{
+			{Host: "multi.nebula.test", IP: "192.0.2.1", Port: 2121, State: scanner.PortOpen},
+			{Host: "multi.nebula.test", IP: "2001:db8::1", Port: 2121, State: scanner.PortOpen},
+		},
+	}
+
+	record := newResultRecord(result)
+	if len(record.Matches) != 2 {
+		t.Fatalf("记录数 = %d，期望 2", len(record.Matches))
+	}
+	want := map[string]string{"192.0.2.1": "1", "2001:db8::1": "2"}
+	for _, m := range record.Matches {
+		if want[m.IP] != m.Details["version"] {
+			t.Errorf("ip %q 对应的版本 = %q", m.IP, m.Details["version"])
+		}
+		if _, ok := m.Details["ip"]; ok {
+			t.Errorf("ip已提升为独立字段，不应保留在details中")
+		}
+		if m.Host != "multi.nebula.test" || m.Port != 2121 {
+			t.Errorf("host/port = %s/%d", m.Host, m.Port)
+		}
+	}
+	for i, p := range record.Ports {
+		if p.IP != result.Ports[i].IP {
+			t.Errorf("ports[%d].ip = %q，期望 %q", i, p.IP, result.Ports[i].IP)
+		}
+	}
+}
+
+// TestPortWebRecord 同一端口的HTTP和HTTPS都写入ports[].web，旧版本只有scheme和url的记录也能还原
+func TestPortWebRecord(t *testing.T) {
+	result := &scanner.ScanResult{
+		Target: "example.com:8080",
+		Ports: []scanner.PortResult{{Host: "example.com", Port: 8080, State: scanner.PortOpen, Web: []scanner.PortWeb{
+			{Scheme: "http", URL: "http://example.com:8080"},
+			{Scheme: "https", URL: "https://example.com:8080"},
+		}}},
+	}
+
+	record := newResultRecord(result)
+	p := record.Ports[0]
+	if len(p.Web) != 2 || p.Web[1].Scheme != "https" || p.Web[1].URL != "https://example.com:8080" {
+		t.Fatalf("web = %+v", p.Web)
+	}
+	if p.Scheme != "http" || p.URL != "http://example.com:8080" {
+		t.Errorf("scheme/url = %s/%s，期望第一个Web服务", p.Scheme, p.URL)
+	}
+	if got := scanResultFromRecord(record).Ports[0].Web; len(got) != 2 || got[1].Scheme != "https" {
+		t.Errorf("还原后的web = %+v", got)
+	}
+
+	record.Ports[0].Web = nil
+	if got := scanResultFromRecord(record).Ports[0].Web; len(got) != 1 || got[0].URL != "http://example.com:8080" {
+		t.Errorf("只有scheme和url的记录还原为 %+v", got)
+	}
+}
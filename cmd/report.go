@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"nebulafinger/internal/scanner"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// reportFilter 离线报告的过滤条件，为空的条件不生效
+type reportFilter struct {
+	MinConfidence float64
+	Tags          map[string]bool
+	StatusCodes   map[int]bool
+	IDs           map[string]bool
+}
+
+// runReport 执行report子命令：读取保存的JSON/JSON Lines结果，合并去重后重新生成报告
+func runReport(args []string) int {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	var (
+		outputPath    string
+		outputFormat  string
+		minConfidence float64
+		tags          string
+		statusCodes   string
+		ids           string
+	)
+	fs.StringVar(&outputPath, "o", "", "输出文件路径，为空时输出到终端")
+	fs.StringVar(&outputFormat, "of", "", "输出格式: html, md, csv, txt, json, jsonl, xml（默认根据-o扩展名推断）")
+	fs.Float64Var(&minConfidence, "min-confidence", 0, "只保留置信度不低于该值的指纹（0-1）")
+	fs.StringVar(&tags, "tag", "", "只保留包含任一标签的指纹，多个标签以逗号分隔")
+	fs.StringVar(&statusCodes, "status", "", "只保留指定HTTP状态码的Web指纹（服务指纹不受影响），多个状态码以逗号分隔")
+	fs.StringVar(&ids, "id", "", "只保留指定指纹ID，多个ID以逗号分隔")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "%s%s用法:%s nebulafinger report [选项] <结果文件>...\n\n",
+			StyleBold, ColorBrightCyan, ColorReset)
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n%s%s示例:%s\n", StyleBold, ColorBrightYellow, ColorReset)
+		fmt.Fprintf(os.Stderr, "  %s./nebulafinger report -o report.html results.jsonl%s\n",
+			ColorBrightYellow, ColorReset)
+		fmt.Fprintf(os.Stderr, "  %s./nebulafinger report -of md -min-confidence 0.8 -tag cms a.jsonl b.jsonl%s\n\n",
+			ColorBrightYellow, ColorReset)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 1
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, ColorRed+"[!] 错误: 必须指定至少一个结果文件"+ColorReset)
+		fs.Usage()
+		return 1
+	}
+
+	format, err := resolveOutputFormat(outputFormat, outputPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, ColorRed+"[!] %v\n"+ColorReset, err)
+		return 1
+	}
+
+	filter, err := newReportFilter(minConfidence, tags, statusCodes, ids)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, ColorRed+"[!] %v\n"+ColorReset, err)
+		return 1
+	}
+
+	records, err := readResultFiles(fs.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, ColorRed+"[!] 读取结果文件失败: %v\n"+ColorReset, err)
+		return 1
+	}
+	records = filter.apply(mergeResultRecords(records))
+
+	if err := writeReport(records, format, outputPath); err != nil {
+		fmt.Fprintf(os.Stderr, ColorBrightRed+StyleBold+"[!] 生成报告失败: %v\n"+ColorReset, err)
+		return 1
+	}
+	return 0
+}
+
+// writeReport 使用与实时扫描相同的渲染函数输出结构化结果
+func writeReport(records []ResultRecord, format, outputPath string) error {
+	// 文本格式复用实时扫描的文本输出
+	if format == formatText {
+		results := make([]*scanner.ScanResult, 0, len(records))
+		for _, record := range records {
+			if len(record.Matches) > 0 || len(record.Ports) > 0 {
+				results = append(results, scanResultFromRecord(record))
+			}
+		}
+		outputText(results, outputPath)
+		return nil
+	}
+
+	var w io.Writer = os.Stdout
+	if outputPath != "" {
+		file, err := os.Create(outputPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	var err error
+	switch format {
+	case formatHTML:
+		err = writeHTMLReport(w, records)
+	case formatCSV:
+		err = writeCSVReport(w, records)
+	case formatMarkdown:
+		err = writeMarkdownReport(w, records)
+	case formatXML:
+		err = writeNmapXML(w, records)
+	case formatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(records)
+	case formatJSONL:
+		encoder := json.NewEncoder(w)
+		encoder.SetEscapeHTML(false)
+		for _, record := range records {
+			if err = encoder.Encode(record); err != nil {
+				break
+			}
+		}
+	default:
+		err = fmt.Errorf("不支持的输出格式: %s", format)
+	}
+	if err != nil {
+		return err
+	}
+
+	if outputPath != "" && !silentFlag {
+		fmt.Printf(ColorBrightGreen+StyleBold+"[+] 报告已保存到: %s\n"+ColorReset, outputPath)
+	}
+	return nil
+}
+
+// readResultFiles 读取多个结果文件
+func readResultFiles(paths []string) ([]ResultRecord, error) {
+	var records []ResultRecord
+	for _, path := range paths {
+		fileRecords, err := readResultFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		records = append(records, fileRecords...)
+	}
+	return records, nil
+}
+
+// readResultFile 读取单个结果文件，支持JSON Lines和JSON数组两种格式
+func readResultFile(path string) ([]ResultRecord, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []ResultRecord
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &records); err != nil {
+			return nil, err
+		}
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(trimmed))
+		for {
+			var record ResultRecord
+			if err := decoder.Decode(&record); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			records = append(records, record)
+		}
+	}
+
+	for _, record := range records {
+		if !compatibleSchemaVersion(record.SchemaVersion) {
+			return nil, fmt.Errorf("不支持的结果格式版本: %s（当前版本: %s）", record.SchemaVersion, SchemaVersion)
+		}
+	}
+	return records, nil
+}
+
+// compatibleSchemaVersion 主版本号相同的结果格式可以互相读取
+func compatibleSchemaVersion(version string) bool {
+	major := func(v string) string {
+		return strings.SplitN(v, ".", 2)[0]
+	}
+	return major(version) == major(SchemaVersion)
+}
+
+// mergeResultRecords 按目标合并多个结果，重复的指纹只保留置信度最高的一条
+func mergeResultRecords(records []ResultRecord) []ResultRecord {
+	var merged []ResultRecord
+	index := make(map[string]int)
+	matchIndex := make(map[string]map[string]int)
+
+	for _, record := range records {
+		i, exists := index[record.Target]
+		if !exists {
+			i = len(merged)
+			index[record.Target] = i
+			matchIndex[record.Target] = make(map[string]int)
+			merged = append(merged, ResultRecord{
+				SchemaVersion: SchemaVersion,
+				Target:        record.Target,
+				Matches:       []MatchRecord{},
+				Timing:        record.Timing,
+			})
+		}
+		target := &merged[i]
+
+		for _, e := range record.Errors {
+			target.Errors = appendUnique(target.Errors, e)
+		}
+
+		if !record.Timing.StartedAt.IsZero() &&
+			(target.Timing.StartedAt.IsZero() || record.Timing.StartedAt.Before(target.Timing.StartedAt)) {
+			target.Timing.StartedAt = record.Timing.StartedAt
+		}
+		if record.Timing.FinishedAt.After(target.Timing.FinishedAt) {
+			target.Timing.FinishedAt = record.Timing.FinishedAt
+		}
+		if !target.Timing.StartedAt.IsZero() && !target.Timing.FinishedAt.IsZero() {
+			target.Timing.DurationMS = target.Timing.FinishedAt.Sub(target.Timing.StartedAt).Milliseconds()
+		}
+
+		// 开放端口按地址去重，统计信息保留最先读取到的一份
+		for _, p := range record.Ports {
+			if !containsPort(target.Ports, p) {
+				target.Ports = append(target.Ports, p)
+			}
+		}
+		if target.PortSummary == nil {
+			target.PortSummary = record.PortSummary
+		}
+		if len(target.Timeouts) == 0 {
+			target.Timeouts = record.Timeouts
+		}
+		if len(target.SchemeDecisions) == 0 {
+			target.SchemeDecisions = record.SchemeDecisions
+		}
+
+		for _, m := range record.Matches {
+			key := matchRecordKey(m)
+			j, seen := matchIndex[record.Target][key]
+			if !seen {
+				matchIndex[record.Target][key] = len(target.Matches)
+				target.Matches = append(target.Matches, m)
+				continue
+			}
+
+			existing := &target.Matches[j]
+			if m.Confidence > existing.Confidence {
+				existing.Confidence = m.Confidence
+				existing.Evidence = m.Evidence
+			}
+			// 合并详情，已有的值不覆盖
+			for k, v := range m.Details {
+				if existing.Details == nil {
+					existing.Details = make(map[string]string)
+				}
+				if _, ok := existing.Details[k]; !ok {
+					existing.Details[k] = v
+				}
+			}
+		}
+	}
+
+	return merged
+}
+
+// matchRecordKey 指纹命中的去重键，同一端口上TCP和UDP的服务、HTTP和HTTPS的Web命中分别保留
+func matchRecordKey(m MatchRecord) string {
+	scheme := matchProtocol(m)
+	if m.Type == "web" {
+		scheme = m.Scheme
+	}
+	return strings.Join([]string{m.Type, scheme, m.URL, m.Host, m.IP, strconv.Itoa(m.Port), m.Fingerprint.ID}, "|")
+}
+
+// containsPort 判断端口列表中是否已有相同主机、IP、端口和协议的记录
+func containsPort(ports []PortRecord, p PortRecord) bool {
+	for _, existing := range ports {
+		if existing.Host == p.Host && existing.IP == p.IP && existing.Port == p.Port && portProtocol(existing) == portProtocol(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// newReportFilter 根据命令行参数创建过滤条件
+func newReportFilter(minConfidence float64, tags, statusCodes, ids string) (*reportFilter, error) {
+	if minConfidence < 0 || minConfidence > 1 {
+		return nil, fmt.Errorf("置信度必须在0-1之间: %v", minConfidence)
+	}
+
+	filter := &reportFilter{MinConfidence: minConfidence}
+	for _, tag := range splitList(tags) {
+		if filter.Tags == nil {
+			filter.Tags = make(map[string]bool)
+		}
+		filter.Tags[strings.ToLower(tag)] = true
+	}
+	for _, code := range splitList(statusCodes) {
+		c, err := strconv.Atoi(code)
+		if err != nil {
+			return nil, fmt.Errorf("无效的状态码: %s", code)
+		}
+		if filter.StatusCodes == nil {
+			filter.StatusCodes = make(map[int]bool)
+		}
+		filter.StatusCodes[c] = true
+	}
+	for _, id := range splitList(ids) {
+		if filter.IDs == nil {
+			filter.IDs = make(map[string]bool)
+		}
+		filter.IDs[id] = true
+	}
+	return filter, nil
+}
+
+// apply 过滤每个目标的指纹命中，目标本身保留
+func (f *reportFilter) apply(records []ResultRecord) []ResultRecord {
+	for i := range records {
+		matches := []MatchRecord{}
+		for _, m := range records[i].Matches {
+			if f.match(m) {
+				matches = append(matches, m)
+			}
+		}
+		records[i].Matches = matches
+	}
+	return records
+}
+
+// match 判断单条指纹命中是否满足过滤条件
+func (f *reportFilter) match(m MatchRecord) bool {
+	if m.Confidence < f.MinConfidence {
+		return false
+	}
+	if f.IDs != nil && !f.IDs[m.Fingerprint.ID] {
+		return false
+	}
+	// 状态码只对Web指纹有意义，服务指纹不受状态码条件影响
+	if f.StatusCodes != nil && m.Type == "web" && !f.StatusCodes[m.StatusCode] {
+		return false
+	}
+	if f.Tags != nil {
+		found := false
+		for _, tag := range m.Fingerprint.Tags {
+			if f.Tags[strings.ToLower(tag)] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// splitList 拆分逗号分隔的参数值
+func splitList(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergeResultRecords(t *testing.T) {
+	start := time.Date(2025, 6, 20, 8, 0, 0, 0, time.UTC)
+	first := ResultRecord{
+		Target: "example.com",
+		Matches: []MatchRecord{
+			{Type: "web", URL: "https://example.com", Fingerprint: FingerprintRecord{ID: "nginx"}, Confidence: 0.6, Evidence: "header",
+				Details: map[string]string{"version": "1.24.0"}},
+		},
+		Ports:  []PortRecord{{Host: "example.com", IP: "192.0.2.10", Port: 443, Protocol: "tcp", State: "open"}},
+		Errors: []string{"timeout"},
+		Timing: TimingRecord{StartedAt: start.Add(time.Minute), FinishedAt: start.Add(2 * time.Minute)},
+	}
+	second := ResultRecord{
+		Target: "example.com",
+		Matches: []MatchRecord{
+			{Type: "web", URL: "https://example.com", Fingerprint: FingerprintRecord{ID: "nginx"}, Confidence: 0.9, Evidence: "body",
+				Details: map[string]string{"version": "1.26.1", "os": "linux"}},
+			{Type: "web", URL: "https://example.com", Fingerprint: FingerprintRecord{ID: "wordpress"}, Confidence: 0.8},
+		},
+		Ports:  []PortRecord{{Host: "example.com", IP: "192.0.2.10", Port: 443, Protocol: "tcp", State: "open"}},
+		Errors: []string{"timeout", "refused"},
+		Timing: TimingRecord{StartedAt: start, FinishedAt: start.Add(90 * time.Second)},
+	}
+	other := ResultRecord{Target: "other.example.com"}
+
+	merged := mergeResultRecords([]ResultRecord{first, other, second})
+	if len(merged) != 2 || merged[0].Target != "example.com" || merged[1].Target != "other.example.com" {
+		t.Fatalf("合并结果 = %+v", merged)
+	}
+	target := merged[0]
+	if len(target.Matches) != 2 {
+		t.Fatalf("指纹 = %+v，期望按指纹去重为2条", target.Matches)
+	}
+	// 重复的指纹保留最高置信度及其证据，已有的详情不被覆盖
+	nginx := target.Matches[0]
+	if nginx.Confidence != 0.9 || nginx.Evidence != "body" {
+		t.Errorf("置信度/证据 = %v/%s", nginx.Confidence, nginx.Evidence)
+	}
+	if want := map[string]string{"version": "1.24.0", "os": "linux"}; !reflect.DeepEqual(nginx.Details, want) {
+		t.Errorf("详情 = %v，期望 %v", nginx.Details, want)
+	}
+	if len(target.Ports) != 1 {
+		t.Errorf("端口 = %+v，期望去重为1条", target.Ports)
+	}
+	if !reflect.DeepEqual(target.Errors, []string{"timeout", "refused"}) {
+		t.Errorf("错误 = %v", target.Errors)
+	}
+	// 耗时取最早的开始时间和最晚的完成时间
+	if !target.Timing.StartedAt.Equal(start) || !target.Timing.FinishedAt.Equal(start.Add(2*time.Minute)) ||
+		target.Timing.DurationMS != (2*time.Minute).Milliseconds() {
+		t.Errorf("耗时 = %+v", target.Timing)
+	}
+	if target.SchemaVersion != SchemaVersion {
+		t.Errorf("schema_version = %s", target.SchemaVersion)
+	}
+}
+
+// TestMergeSamePortProtocols 同一端口上的TCP/UDP服务和HTTP/HTTPS页面合并时各自保留
+func TestMergeSamePortProtocols(t *testing.T) {
+	first := ResultRecord{
+		Target: "example.com",
+		Matches: []MatchRecord{
+			{Type: "service", Scheme: "tcp", Host: "example.com", Port: 53, Fingerprint: FingerprintRecord{ID: "bind"}},
+			{Type: "web", Scheme: "http", Host: "example.com", Port: 8443, Fingerprint: FingerprintRecord{ID: "nginx"}},
+		},
+		Ports: []PortRecord{{Host: "example.com", Port: 53, Protocol: "tcp", State: "open"}},
+	}
+	second := ResultRecord{
+		Target: "example.com",
+		Matches: []MatchRecord{
+			{Type: "service", Scheme: "udp", Host: "example.com", Port: 53, Fingerprint: FingerprintRecord{ID: "bind"}},
+			{Type: "service", Host: "example.com", Port: 53, Fingerprint: FingerprintRecord{ID: "bind"}},
+			{Type: "web", Scheme: "https", Host: "example.com", Port: 8443, Fingerprint: FingerprintRecord{ID: "nginx"}},
+		},
+		// 旧结果中没有协议的端口视为TCP
+		Ports: []PortRecord{{Host: "example.com", Port: 53, Protocol: "udp", State: "open"}, {Host: "example.com", Port: 53, State: "open"}},
+	}
+
+	merged := mergeResultRecords([]ResultRecord{first, second})
+	var matches []string
+	for _, m := range merged[0].Matches {
+		matches = append(matches, m.Type+"/"+m.Scheme)
+	}
+	if want := []string{"service/tcp", "web/http", "service/udp", "web/https"}; !reflect.DeepEqual(matches, want) {
+		t.Errorf("指纹 = %v，期望 %v", matches, want)
+	}
+	var ports []string
+	for _, p := range merged[0].Ports {
+		ports = append(ports, portProtocol(p))
+	}
+	if want := []string{"tcp", "udp"}; !reflect.DeepEqual(ports, want) {
+		t.Errorf("端口 = %v，期望 %v", ports, want)
+	}
+}
+
+func TestReportFilter(t *testing.T) {
+	matches := []MatchRecord{
+		{Type: "web", StatusCode: 200, Fingerprint: FingerprintRecord{ID: "wordpress", Tags: []string{"CMS"}}, Confidence: 0.9},
+		{Type: "web", StatusCode: 403, Fingerprint: FingerprintRecord{ID: "nginx", Tags: []string{"server"}}, Confidence: 0.9},
+		{Type: "web", StatusCode: 200, Fingerprint: FingerprintRecord{ID: "jquery", Tags: []string{"js"}}, Confidence: 0.5},
+		{Type: "service", Port: 22, Fingerprint: FingerprintRecord{ID: "openssh", Tags: []string{"ssh"}}, Confidence: 1},
+	}
+	tests := []struct {
+		name          string
+		minConfidence float64
+		tags          string
+		statusCodes   string
+		ids           string
+		want          []string
+	}{
+		{"没有条件", 0, "", "", "", []string{"wordpress", "nginx", "jquery", "openssh"}},
+		{"置信度", 0.8, "", "", "", []string{"wordpress", "nginx", "openssh"}},
+		{"标签不区分大小写", 0, "cms, ssh", "", "", []string{"wordpress", "openssh"}},
+		// 状态码只过滤Web指纹，服务指纹保留
+		{"状态码", 0, "", "200", "", []string{"wordpress", "jquery", "openssh"}},
+		{"指纹ID", 0, "", "", "nginx,openssh", []string{"nginx", "openssh"}},
+		{"组合条件", 0.8, "", "200", "", []string{"wordpress", "openssh"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := newReportFilter(tt.minConfidence, tt.tags, tt.statusCodes, tt.ids)
+			if err != nil {
+				t.Fatal(err)
+			}
+			records := filter.apply([]ResultRecord{{Target: "example.com", Matches: append([]MatchRecord(nil), matches...)}})
+			var got []string
+			for _, m := range records[0].Matches {
+				got = append(got, m.Fingerprint.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("保留 = %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+
+	for _, bad := range []struct {
+		minConfidence float64
+		statusCodes   string
+	}{{1.5, ""}, {-0.1, ""}, {0, "ok"}} {
+		if _, err := newReportFilter(bad.minConfidence, "", bad.statusCodes, ""); err == nil {
+			t.Errorf("%v %q 应返回错误", bad.minConfidence, bad.statusCodes)
+		}
+	}
+}
+
+func TestReadResultFile(t *testing.T) {
+	major := strings.SplitN(SchemaVersion, ".", 2)[0]
+	tests := []struct {
+		name    string
+		content string
+		targets []string
+		wantErr bool
+	}{
+		{"JSON Lines", `{"schema_version":"` + SchemaVersion + `","target":"a"}` + "\n" + `{"schema_version":"` + SchemaVersion + `","target":"b"}` + "\n",
+			[]string{"a", "b"}, false},
+		{"JSON数组", `[{"schema_version":"` + SchemaVersion + `","target":"a"}]`, []string{"a"}, false},
+		{"主版本相同的旧版本", `{"schema_version":"` + major + `.0","target":"a"}`, []string{"a"}, false},
+		{"主版本相同的新版本", `{"schema_version":"` + major + `.99","target":"a"}`, []string{"a"}, false},
+		{"主版本不同", `{"schema_version":"99.0","target":"a"}`, nil, true},
+		{"格式错误", `{"schema_version":`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "results.jsonl")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			records, err := readResultFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v，期望出错 %v", err, tt.wantErr)
+			}
+			var targets []string
+			for _, record := range records {
+				targets = append(targets, record.Target)
+			}
+			if !reflect.DeepEqual(targets, tt.targets) {
+				t.Errorf("目标 = %v，期望 %v", targets, tt.targets)
+			}
+		})
+	}
+}
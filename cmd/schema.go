@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// jsonSchemaID 结果格式JSON Schema的标识
+const jsonSchemaID = "urn:nebulafinger:result:" + SchemaVersion
+
+// buildResultSchema 根据ResultRecord的Go类型定义生成JSON Schema文档
+func buildResultSchema() map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(ResultRecord{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["$id"] = jsonSchemaID
+	schema["title"] = "NebulaFinger scan result"
+	schema["description"] = "NebulaFinger JSON Lines输出中每一行的结构，schema_version: " + SchemaVersion
+	return schema
+}
+
+// marshalResultSchema 以缩进格式序列化JSON Schema
+func marshalResultSchema() ([]byte, error) {
+	data, err := json.MarshalIndent(buildResultSchema(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// typeSchema 生成单个Go类型对应的Schema片段
+func typeSchema(t reflect.Type) map[string]interface{} {
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, omitempty := parseJSONTag(field)
+			if name == "-" {
+				continue
+			}
+			prop := typeSchema(field.Type)
+			if desc := field.Tag.Get("desc"); desc != "" {
+				prop["description"] = desc
+			}
+			properties[name] = prop
+			if !omitempty {
+				required = append(required, name)
+			}
+		}
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"required":             required,
+			"additionalProperties": false,
+		}
+	}
+	return map[string]interface{}{}
+}
+
+// parseJSONTag 解析字段的json标签，返回字段名和是否omitempty
+func parseJSONTag(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "" {
+		return field.Name, false
+	}
+	parts := strings.Split(tag, ",")
+	name := parts[0]
+	if name == "" {
+		name = field.Name
+	}
+	omitempty := false
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitempty = true
+		}
+	}
+	return name, omitempty
+}
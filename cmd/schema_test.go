@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestResultSchemaUpToDate docs/result.schema.json 必须与 -json-schema 的输出一致
+func TestResultSchemaUpToDate(t *testing.T) {
+	want, err := marshalResultSchema()
+	if err != nil {
+		t.Fatalf("生成JSON Schema失败: %v", err)
+	}
+	got, err := os.ReadFile("../docs/result.schema.json")
+	if err != nil {
+		t.Fatalf("读取docs/result.schema.json失败: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("docs/result.schema.json 已过期，请运行 go run ./cmd -json-schema > docs/result.schema.json 重新生成")
+	}
+}
+
+// TestResultRecordMatchesSchema 所有字段都填充的记录不能出现Schema中没有声明的字段
+func TestResultRecordMatchesSchema(t *testing.T) {
+	now := time.Now()
+	record := ResultRecord{
+		SchemaVersion: SchemaVersion,
+		Target:        "example.com",
+		Matches: []MatchRecord{{
+			Type: "service", URL: "http://example.com", Scheme: "udp", Host: "example.com", IP: "192.0.2.1", Port: 53,
+			StatusCode: 200, Title: "t",
+			Fingerprint: FingerprintRecord{ID: "id", Name: "name", Tags: []string{"a"}, Metadata: map[string]string{"product": "p"}},
+			Confidence:  1, Details: map[string]string{"version": "1"}, Evidence: "e",
+		}},
+		Ports:           []PortRecord{{Host: "example.com", IP: "192.0.2.1", Port: 80, Protocol: "tcp", State: "open", Scheme: "http", URL: "http://example.com", Web: []PortWebRecord{{Scheme: "http", URL: "http://example.com"}}}},
+		PortSummary:     &PortSummary{Open: 1, Closed: 1, Filtered: 1, OpenFiltered: 1},
+		Errors:          []string{"e"},
+		Timing:          TimingRecord{StartedAt: now, FinishedAt: now, DurationMS: 1},
+		Timeouts:        []TimeoutRecord{{IP: "192.0.2.1", Samples: 1, Timeouts: 1, SRTTMS: 1, RTTVarMS: 1, MinRTTMS: 1, MaxRTTMS: 1, ConnectTimeoutMS: 1, ReadTimeoutMS: 1}},
+		SchemeDecisions: []SchemeDecisionRecord{{Host: "example.com", Port: 80, Schemes: []string{"http"}, Reason: "plain"}},
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		t.Fatal(err)
+	}
+	schemaData, err := os.ReadFile("../docs/result.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(schemaData, &schema); err != nil {
+		t.Fatal(err)
+	}
+	checkSchemaFields(t, "$", value, schema)
+}
+
+// checkSchemaFields 递归检查值中的对象字段都在Schema的properties中声明，必需字段都存在
+func checkSchemaFields(t *testing.T, path string, value interface{}, schema map[string]interface{}) {
+	t.Helper()
+	switch v := value.(type) {
+	case map[string]interface{}:
+		properties, ok := schema["properties"].(map[string]interface{})
+		if !ok {
+			// 详情、元数据等map类型只声明了值的类型
+			return
+		}
+		for key, field := range v {
+			prop, ok := properties[key].(map[string]interface{})
+			if !ok {
+				t.Errorf("%s.%s 没有在Schema中声明", path, key)
+				continue
+			}
+			checkSchemaFields(t, path+"."+key, field, prop)
+		}
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := v[name.(string)]; !ok {
+				t.Errorf("%s 缺少必需字段 %s", path, name)
+			}
+		}
+	case []interface{}:
+		items, _ := schema["items"].(map[string]interface{})
+		for _, item := range v {
+			checkSchemaFields(t, path+"[]", item, items)
+		}
+	}
+}
@@ -1,33 +1,26 @@
 package main
 
 import (
-	"bufio"
-	"os"
+	"nebulafinger/internal/targets"
 	"strings"
 )
 
-// 从文件加载目标
+// 从文件加载目标，支持普通目标列表以及nmap、masscan、httpx的输出
 func loadTargetsFromFile(filePath string) ([]string, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
+	return targets.LoadFile(filePath, inputFormatFlag)
+}
 
-	var targets []string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		target := strings.TrimSpace(scanner.Text())
-		if target != "" && !strings.HasPrefix(target, "#") {
-			targets = append(targets, target)
+// 加载 -exclude 和 -exclude-file 指定的排除列表
+func loadExcluder() (*targets.Excluder, error) {
+	specs := strings.Split(excludeFlag, ",")
+	if excludeFileFlag != "" {
+		fileSpecs, err := targets.LoadFile(excludeFileFlag, targets.FormatList)
+		if err != nil {
+			return nil, err
 		}
+		specs = append(specs, fileSpecs...)
 	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, err
-	}
-
-	return targets, nil
+	return targets.NewExcluder(specs)
 }
 
 // 去重
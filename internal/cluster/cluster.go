@@ -35,6 +35,8 @@ type ClusteredFingerprint struct {
 	Info       internal.Info         // 指纹信息
 	Matchers   []internal.Matchers   // 匹配器
 	Extractors []internal.Extractors // 提取器
+	TLS        internal.TLSMode      // 对TLS的要求
+	Probe      string                // 使用的内置协议模块
 }
 
 // PortRange 结构体用于表示端口范围
@@ -253,12 +255,19 @@ func createTCPClusters(fingerprints []internal.Fingerprint) (map[string]ClusterE
 	for _, fp := range fingerprints {
 		// 处理每个TCP请求
 		for _, tcp := range fp.TCP {
+			// 发送数据或带匹配器的请求由扫描器作为TCP探针逐个发送和匹配，不参与聚类
+			if tcp.Probed() {
+				continue
+			}
+
 			// 创建聚类指纹
 			clustered := ClusteredFingerprint{
 				ID:         fp.ID,
 				Info:       fp.Info,
 				Matchers:   tcp.Matchers,
 				Extractors: tcp.Extractors,
+				TLS:        tcp.TLS,
+				Probe:      strings.ToLower(strings.TrimSpace(tcp.Probe)),
 			}
 
 			// 检查是否为null名称
@@ -355,32 +364,6 @@ func createTCPClusters(fingerprints []internal.Fingerprint) (map[string]ClusterE
 	return TCPOtherclusters, tcpNullClusters
 }
 
-// getDefaultPortForService 根据服务名获取默认端口
-func getDefaultPortForService(serviceName string) string {
-	serviceToPorts := map[string]string{
-		"ssh":        "22",
-		"ftp":        "21",
-		"http":       "80,443,8080,8443",
-		"https":      "443,8443",
-		"smtp":       "25,587",
-		"smb":        "445",
-		"mysql":      "3306",
-		"postgresql": "5432",
-		"redis":      "6379",
-		"mongodb":    "27017,27018",
-		"dns":        "53",
-		"telnet":     "23",
-		"mssql":      "1433",
-		"rdp":        "3389",
-	}
-
-	if port, ok := serviceToPorts[serviceName]; ok {
-		return port
-	}
-
-	return "0" // 通配端口
-}
-
 // mergePorts 合并多个端口字符串为单一范围表示
 func mergePorts(ports []string) string {
 	if len(ports) == 0 {
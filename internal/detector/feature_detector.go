@@ -220,7 +220,8 @@ func FetchFavicon(baseURL string) (string, error) {
 					// 绝对路径
 					baseURLParsed, err := url.Parse(baseURL)
 					if err == nil {
-						absoluteURL = fmt.Sprintf("%s://%s%s", baseURLParsed.Scheme, baseURLParsed.Host, iconURL)
+						// 通过url.URL拼接，IPv6地址的zone ID会被正确转义
+						absoluteURL = (&url.URL{Scheme: baseURLParsed.Scheme, Host: baseURLParsed.Host}).String() + iconURL
 					}
 				} else {
 					// 相对路径
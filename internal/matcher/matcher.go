@@ -1,19 +1,26 @@
 package matcher
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
 	"nebulafinger/internal"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"unicode/utf8"
 )
 
 // MatchResult 表示指纹匹配结果
 type MatchResult struct {
-	ID         string            // 指纹ID
-	Name       string            // 指纹名称
-	Confidence float64           // 匹配置信度
-	Details    map[string]string // 提取的详细信息（如版本）
-	Tags       []string          // 相关标签
+	ID         string             `json:"id"`                 // 指纹ID
+	Name       string             `json:"name"`               // 指纹名称
+	Confidence float64            `json:"confidence"`         // 匹配置信度
+	Details    map[string]string  `json:"details,omitempty"`  // 提取的详细信息（如版本）
+	Tags       []string           `json:"tags,omitempty"`     // 相关标签
+	Metadata   *internal.Metadata `json:"metadata,omitempty"` // 指纹元数据（厂商、产品等）
+	Evidence   string             `json:"evidence,omitempty"` // 命中的匹配规则（关键词、正则或哈希）
 }
 
 // Matcher 负责精确匹配指纹
@@ -48,6 +55,49 @@ type TCPResponse struct {
 	Host     string
 	Port     string
 	Response string
+	Raw      []byte            // 原始响应字节，binary匹配器使用，为空时使用Response
+	Parts    map[string]string // 协议模块识别出的字段（product、version、auth_required等），匹配器用part选择
+}
+
+// isResponsePart 判断匹配器的part是否指向完整响应：为空、response、body或all
+func isResponsePart(part string) bool {
+	switch strings.ToLower(part) {
+	case "", "response", "body", "all":
+		return true
+	}
+	return false
+}
+
+// content 返回匹配器part对应的内容：完整响应或协议模块的同名字段
+func (r *TCPResponse) content(part string) string {
+	if isResponsePart(part) {
+		return r.Response
+	}
+	return r.Parts[strings.ToLower(part)]
+}
+
+// regexCache 已编译的正则，编译失败的正则也会缓存，避免每次匹配重复编译
+var regexCache sync.Map
+
+// regexEntry 正则的编译结果
+type regexEntry struct {
+	regex *regexp.Regexp
+	err   error
+}
+
+// compileRegex 编译正则并缓存结果
+func compileRegex(expr string) (*regexp.Regexp, error) {
+	if entry, ok := regexCache.Load(expr); ok {
+		return entry.(regexEntry).regex, entry.(regexEntry).err
+	}
+	regex, err := regexp.Compile(expr)
+	regexCache.Store(expr, regexEntry{regex, err})
+	return regex, err
+}
+
+// CompileRegex 编译正则并缓存结果，供扫描器在每个端口上匹配服务指纹时复用
+func CompileRegex(expr string) (*regexp.Regexp, error) {
+	return compileRegex(expr)
 }
 
 // 辅助函数
@@ -181,6 +231,19 @@ func isMatcherHitTCP(matcher internal.Matchers, resp *TCPResponse) bool {
 		}
 	}
 
+	// 检查binary匹配器
+	if len(matcher.Binary) > 0 {
+		hasMatchers = true
+		if matchBinaryTCP(matcher, resp) {
+			if condition == "or" {
+				return !matcher.Negative // 如果是OR条件，一个匹配成功就返回
+			}
+			result = true
+		} else if condition == "and" {
+			return matcher.Negative // 如果是AND条件，一个失败就返回negative
+		}
+	}
+
 	// 如果没有任何匹配器，返回false
 	if !hasMatchers {
 		return false
@@ -190,6 +253,16 @@ func isMatcherHitTCP(matcher internal.Matchers, resp *TCPResponse) bool {
 	return result != matcher.Negative
 }
 
+// MatchTCP 检查匹配器是否命中TCP或UDP响应
+func MatchTCP(matcher internal.Matchers, resp *TCPResponse) bool {
+	return isMatcherHitTCP(matcher, resp)
+}
+
+// ExtractTCP 从TCP或UDP响应中提取值
+func ExtractTCP(extractor internal.Extractors, resp *TCPResponse) string {
+	return extractValueTCP(extractor, resp)
+}
+
 // matchWords 匹配关键词
 func matchWords(matcher internal.Matchers, resp *HTTPResponse) bool {
 	part := strings.ToLower(matcher.Part)
@@ -351,7 +424,7 @@ func matchRegex(matcher internal.Matchers, resp *HTTPResponse) bool {
 		}
 
 		// 编译正则
-		regex, err := regexp.Compile(regexStr)
+		regex, err := compileRegex(regexStr)
 		if err != nil {
 			continue
 		}
@@ -405,7 +478,7 @@ func matchFavicon(matcher internal.Matchers, resp *HTTPResponse) bool {
 
 // matchWordsTCP 匹配TCP响应中的关键词
 func matchWordsTCP(matcher internal.Matchers, resp *TCPResponse) bool {
-	content := resp.Response
+	content := resp.content(matcher.Part)
 
 	// 大小写处理
 	// 注意：现在我们在预处理阶段已经处理了Words数组的大小写
@@ -448,7 +521,7 @@ func matchWordsTCP(matcher internal.Matchers, resp *TCPResponse) bool {
 
 // matchRegexTCP 匹配TCP响应中的正则表达式
 func matchRegexTCP(matcher internal.Matchers, resp *TCPResponse) bool {
-	content := resp.Response
+	content := resp.content(matcher.Part)
 
 	// 大小写处理
 	if matcher.CaseInsensitive {
@@ -465,7 +538,7 @@ func matchRegexTCP(matcher internal.Matchers, resp *TCPResponse) bool {
 		}
 
 		// 编译正则
-		regex, err := regexp.Compile(regexStr)
+		regex, err := compileRegex(regexStr)
 		if err != nil {
 			continue
 		}
@@ -491,6 +564,31 @@ func matchRegexTCP(matcher internal.Matchers, resp *TCPResponse) bool {
 	return matched > 0 && !matcher.Negative
 }
 
+// matchBinaryTCP 匹配响应中的十六进制字节序列，and条件要求全部出现，否则出现任一即可
+func matchBinaryTCP(matcher internal.Matchers, resp *TCPResponse) bool {
+	content := resp.Raw
+	if content == nil || !isResponsePart(matcher.Part) {
+		content = []byte(resp.content(matcher.Part))
+	}
+
+	matched := 0
+	for _, pattern := range matcher.Binary {
+		seq, err := hex.DecodeString(strings.ReplaceAll(pattern, " ", ""))
+		if err != nil || len(seq) == 0 {
+			continue
+		}
+		if bytes.Contains(content, seq) {
+			matched++
+			if matcher.Condition != "and" {
+				return true
+			}
+		} else if matcher.Condition == "and" {
+			return false
+		}
+	}
+	return matched > 0
+}
+
 // extractValue 从HTTP响应中提取值
 func extractValue(extractor internal.Extractors, resp *HTTPResponse) string {
 	if extractor.Type != "regex" || len(extractor.Regex) == 0 {
@@ -501,7 +599,7 @@ func extractValue(extractor internal.Extractors, resp *HTTPResponse) string {
 	regexStr := extractor.Regex[0]
 
 	// 编译正则
-	regex, err := regexp.Compile(regexStr)
+	regex, err := compileRegex(regexStr)
 	if err != nil {
 		return ""
 	}
@@ -527,13 +625,16 @@ func extractValueTCP(extractor internal.Extractors, resp *TCPResponse) string {
 	regexStr := extractor.Regex[0]
 
 	// 编译正则
-	regex, err := regexp.Compile(regexStr)
+	regex, err := compileRegex(regexStr)
 	if err != nil {
 		return ""
 	}
 
 	// 匹配内容
 	matches := regex.FindStringSubmatch(resp.Response)
+	if matches != nil && extractor.Template != "" {
+		return expandTemplate(extractor.Template, matches)
+	}
 	if len(matches) > 1 {
 		return matches[1] // 返回第一个捕获组
 	} else if len(matches) == 1 {
@@ -542,3 +643,118 @@ func extractValueTCP(extractor internal.Extractors, resp *TCPResponse) string {
 
 	return ""
 }
+
+// expandTemplate 按nmap版本信息的模板语法替换分组，结果去除首尾空白
+// 响应按字节转换为字符串后匹配时，结果中的字节是合法UTF-8则还原为UTF-8文本
+func expandTemplate(template string, groups []string) string {
+	group := func(arg string) string {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 || n >= len(groups) {
+			return ""
+		}
+		return groups[n]
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(template); i++ {
+		if template[i] != '$' || i+1 == len(template) {
+			b.WriteByte(template[i])
+			continue
+		}
+		rest := template[i+1:]
+		if rest[0] >= '1' && rest[0] <= '9' {
+			b.WriteString(group(rest[:1]))
+			i++
+			continue
+		}
+		name, args, length := parseTemplateCall(rest)
+		switch {
+		case name == "P" && len(args) == 1:
+			for _, r := range group(args[0]) {
+				if r >= 0x20 && r < 0x7f {
+					b.WriteRune(r)
+				}
+			}
+		case name == "SUBST" && len(args) == 3:
+			b.WriteString(strings.ReplaceAll(group(args[0]), args[1], args[2]))
+		case name == "I" && len(args) == 2:
+			b.WriteString(unpackInteger(group(args[0]), args[1] == "<"))
+		default:
+			b.WriteByte('$')
+			continue
+		}
+		i += length
+	}
+	return strings.TrimSpace(fromLatin1(b.String()))
+}
+
+// parseTemplateCall 解析模板中的函数调用 名称(参数,"字符串参数",...)，返回名称、参数和调用的长度，不是函数调用时长度为0
+func parseTemplateCall(s string) (string, []string, int) {
+	open := strings.IndexByte(s, '(')
+	if open <= 0 {
+		return "", nil, 0
+	}
+	var args []string
+	for i := open + 1; i < len(s); {
+		var arg string
+		if s[i] == '"' {
+			end := strings.IndexByte(s[i+1:], '"')
+			if end < 0 {
+				return "", nil, 0
+			}
+			arg, i = s[i+1:i+1+end], i+2+end
+		} else {
+			end := strings.IndexAny(s[i:], ",)")
+			if end < 0 {
+				return "", nil, 0
+			}
+			arg, i = strings.TrimSpace(s[i:i+end]), i+end
+		}
+		args = append(args, arg)
+		if i >= len(s) {
+			break
+		}
+		switch s[i] {
+		case ')':
+			return s[:open], args, i + 1
+		case ',':
+			i++
+		default:
+			return "", nil, 0
+		}
+	}
+	return "", nil, 0
+}
+
+// unpackInteger 将分组的字节解析为无符号整数，littleEndian为false时按大端
+func unpackInteger(group string, littleEndian bool) string {
+	var data []byte
+	for _, r := range group {
+		data = append(data, byte(r))
+	}
+	if len(data) == 0 || len(data) > 8 {
+		return ""
+	}
+	padded := make([]byte, 8)
+	if littleEndian {
+		copy(padded, data)
+		return strconv.FormatUint(binary.LittleEndian.Uint64(padded), 10)
+	}
+	copy(padded[8-len(data):], data)
+	return strconv.FormatUint(binary.BigEndian.Uint64(padded), 10)
+}
+
+// fromLatin1 将每个字符都小于256的字符串还原为字节，字节是合法UTF-8时返回还原后的文本，否则原样返回
+func fromLatin1(s string) string {
+	data := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xff {
+			return s
+		}
+		data = append(data, byte(r))
+	}
+	if !utf8.Valid(data) {
+		return s
+	}
+	return string(data)
+}
@@ -0,0 +1,57 @@
+package matcher
+
+import (
+	"nebulafinger/internal"
+	"testing"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		groups   []string
+		want     string
+	}{
+		{"分组引用", "$1 $2", []string{"", "OpenSSH", "9.6p1"}, "OpenSSH 9.6p1"},
+		{"不存在的分组为空", "v$3", []string{"", "a"}, "v"},
+		{"$0不引用整个匹配", "$0", []string{"all", "a"}, "$0"},
+		{"去除首尾空白", " $1 ", []string{"", " 2.4 "}, "2.4"},
+		{"P去除不可打印字符", "$P(1)", []string{"", "W\x00O\x00R\x00K\x00G\x00R\x00O\x00U\x00P\x00"}, "WORKGROUP"},
+		{"SUBST替换", `$SUBST(1,"_",".")`, []string{"", "5_7_42"}, "5.7.42"},
+		{"SUBST和分组混用", `MySQL $SUBST(2,"-",".") ($1)`, []string{"", "ubuntu", "8-0-36"}, "MySQL 8.0.36 (ubuntu)"},
+		{"I大端", `$I(1,">")`, []string{"", "\x01\x02"}, "258"},
+		{"I小端", `$I(1,"<")`, []string{"", "\x01\x02"}, "513"},
+		{"I超过8字节", `$I(1,">")`, []string{"", "123456789"}, ""},
+		{"未知函数保留$", "$X(1)", []string{"", "a"}, "$X(1)"},
+		{"参数不完整保留$", `$SUBST(1,"_"`, []string{"", "a_b"}, `$SUBST(1,"_"`},
+		{"结尾的$", "price$", []string{""}, "price$"},
+		{"Latin-1字节还原为UTF-8", "$1", []string{"", "ä¸­æ\u0096\u0087"}, "中文"},
+		{"非UTF-8字节保持原样", "$1", []string{"", "ÿ"}, "ÿ"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := expandTemplate(tt.template, tt.groups); got != tt.want {
+				t.Errorf("expandTemplate(%q) = %q，期望 %q", tt.template, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestExtractTCPTemplate 提取器带模板时按模板生成值，否则返回第一个分组
+func TestExtractTCPTemplate(t *testing.T) {
+	resp := &TCPResponse{Response: "220 ProFTPD 1.3.8b Server (Debian) [::ffff:10.0.0.5]\r\n"}
+	pattern := `^220 ProFTPD (\d\S+) Server \((\w+)\)`
+
+	withTemplate := internal.Extractors{Type: "regex", Name: "info", Regex: []string{pattern}, Template: "$2 build $1"}
+	if got := ExtractTCP(withTemplate, resp); got != "Debian build 1.3.8b" {
+		t.Errorf("模板提取 = %q", got)
+	}
+	withoutTemplate := internal.Extractors{Type: "regex", Name: "version", Regex: []string{pattern}}
+	if got := ExtractTCP(withoutTemplate, resp); got != "1.3.8b" {
+		t.Errorf("分组提取 = %q", got)
+	}
+	cpe := internal.Extractors{Type: "regex", Name: "cpe", Regex: []string{pattern}, Template: "cpe:/a:proftpd:proftpd:$1"}
+	if got := ExtractTCP(cpe, resp); got != "cpe:/a:proftpd:proftpd:1.3.8b" {
+		t.Errorf("cpe提取 = %q", got)
+	}
+}
@@ -0,0 +1,513 @@
+// Package nmap 将nmap的服务探针库（nmap-service-probes）转换为服务指纹
+package nmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"nebulafinger/internal"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// defaultRarity 没有rarity指令的探针的稀有度，与nmap一致
+const defaultRarity = 5
+
+// lineLimit 单行的长度上限，nmap-service-probes中部分match行很长
+const lineLimit = 1024 * 1024
+
+// detailNames 版本信息字段对应的详情名
+var detailNames = map[string]string{
+	"p":   "product",
+	"v":   "version",
+	"i":   "info",
+	"h":   "hostname",
+	"o":   "os",
+	"d":   "device",
+	"cpe": "cpe",
+}
+
+// Warning 转换时跳过或无法处理的规则
+type Warning struct {
+	Line    int    // 所在行号
+	Message string // 原因
+}
+
+// String 返回带行号的说明
+func (w Warning) String() string {
+	return fmt.Sprintf("第%d行: %s", w.Line, w.Message)
+}
+
+// probe nmap-service-probes中的一个探针及其指令
+type probe struct {
+	protocol   string   // TCP或UDP
+	name       string   // 探针名
+	payload    []byte   // 发送的数据
+	ports      string   // ports指令
+	sslPorts   string   // sslports指令
+	rarity     int      // rarity指令
+	totalWait  int      // totalwaitms指令（毫秒）
+	fallback   []string // fallback指令
+	matches    []match  // 探针的match和softmatch
+	line       int      // Probe指令所在行号
+	hasPayload bool     // 是否有数据，NULL探针没有
+}
+
+// match 一条match或softmatch规则
+type match struct {
+	service string  // 服务名
+	pattern string  // 转换后的正则
+	fields  []field // 版本信息字段，按出现顺序
+	soft    bool    // 是否为softmatch
+	line    int     // 所在行号
+}
+
+// field 版本信息字段：p/v/i/h/o/d/cpe及其模板
+type field struct {
+	key      string // p, v, i, h, o, d, cpe
+	template string // nmap模板，cpe包含cpe:/前缀
+}
+
+// IsServiceProbes 判断文件内容是否为nmap-service-probes格式：不是JSON且包含Probe指令
+func IsServiceProbes(data []byte) bool {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') {
+		return false
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if bytes.HasPrefix(bytes.TrimSpace(line), []byte("Probe ")) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadServiceProbes 读取并转换nmap-service-probes文件
+func LoadServiceProbes(path string) ([]internal.Fingerprint, []Warning, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	return ParseServiceProbes(file)
+}
+
+// ParseServiceProbes 将nmap-service-probes转换为服务指纹，每条match或softmatch转换为一个指纹：
+// TCP探针转换为TCP请求，探针数据作为输入；UDP探针转换为UDP请求，探针数据为十六进制payload。
+// 正则的标志和PCRE专有语法转换为Go正则，无法转换的规则（如反向引用和环视）跳过并记录在警告中
+func ParseServiceProbes(r io.Reader) ([]internal.Fingerprint, []Warning, error) {
+	var probes []*probe
+	var warnings []Warning
+	var current *probe
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), lineLimit)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		// 按字节转换为字符串，使正则和数据中的每个字节对应一个字符
+		line := strings.TrimSpace(latin1(scanner.Bytes()))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		directive, rest, _ := strings.Cut(line, " ")
+		rest = strings.TrimSpace(rest)
+
+		if directive == "Probe" {
+			p, err := parseProbe(rest)
+			if err != nil {
+				return nil, warnings, fmt.Errorf("第%d行: %v", lineNo, err)
+			}
+			p.line = lineNo
+			probes = append(probes, p)
+			current = p
+			continue
+		}
+		if directive == "Exclude" {
+			continue
+		}
+		if current == nil {
+			return nil, warnings, fmt.Errorf("第%d行: %s指令之前没有Probe", lineNo, directive)
+		}
+
+		switch directive {
+		case "match", "softmatch":
+			m, err := parseMatch(rest)
+			if err != nil {
+				warnings = append(warnings, Warning{Line: lineNo, Message: err.Error()})
+				continue
+			}
+			m.soft = directive == "softmatch"
+			m.line = lineNo
+			current.matches = append(current.matches, m)
+		case "ports":
+			current.ports = rest
+		case "sslports":
+			current.sslPorts = rest
+		case "rarity":
+			rarity, err := strconv.Atoi(rest)
+			if err != nil {
+				return nil, warnings, fmt.Errorf("第%d行: 无效的rarity: %s", lineNo, rest)
+			}
+			current.rarity = rarity
+		case "totalwaitms":
+			wait, err := strconv.Atoi(rest)
+			if err != nil {
+				return nil, warnings, fmt.Errorf("第%d行: 无效的totalwaitms: %s", lineNo, rest)
+			}
+			current.totalWait = wait
+		case "fallback":
+			for _, name := range strings.Split(rest, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					current.fallback = append(current.fallback, name)
+				}
+			}
+		case "tcpwrappedms":
+			// 只用于判断tcpwrapped，不影响识别
+		default:
+			warnings = append(warnings, Warning{Line: lineNo, Message: "未知指令 " + directive})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, warnings, err
+	}
+	if len(probes) == 0 {
+		return nil, warnings, fmt.Errorf("没有找到Probe指令")
+	}
+
+	var fingerprints []internal.Fingerprint
+	for _, p := range probes {
+		if p.protocol == "UDP" && !p.hasPayload {
+			warnings = append(warnings, Warning{Line: p.line, Message: "UDP探针 " + p.name + " 没有数据，已跳过"})
+			continue
+		}
+		for _, m := range p.matches {
+			fingerprints = append(fingerprints, p.fingerprint(m))
+		}
+	}
+	return fingerprints, warnings, nil
+}
+
+// parseProbe 解析Probe指令：协议 名称 q|数据| [no-payload]
+func parseProbe(rest string) (*probe, error) {
+	fields := strings.SplitN(rest, " ", 3)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("无效的Probe指令: %s", rest)
+	}
+	protocol := strings.ToUpper(fields[0])
+	if protocol != "TCP" && protocol != "UDP" {
+		return nil, fmt.Errorf("不支持的协议: %s", fields[0])
+	}
+	data := strings.TrimSpace(fields[2])
+	if !strings.HasPrefix(data, "q") || len(data) < 3 {
+		return nil, fmt.Errorf("无效的探针数据: %s", data)
+	}
+	value, _, ok := delimited(data[1:])
+	if !ok {
+		return nil, fmt.Errorf("探针数据缺少结束分隔符: %s", data)
+	}
+	payload := unescape(value)
+	return &probe{
+		protocol:   protocol,
+		name:       fields[1],
+		payload:    payload,
+		rarity:     defaultRarity,
+		hasPayload: len(payload) > 0,
+	}, nil
+}
+
+// parseMatch 解析match指令：服务名 m/正则/标志 版本信息字段
+func parseMatch(rest string) (match, error) {
+	service, rest, _ := strings.Cut(rest, " ")
+	rest = strings.TrimSpace(rest)
+	if service == "" || !strings.HasPrefix(rest, "m") {
+		return match{}, fmt.Errorf("无效的match规则")
+	}
+	pattern, rest, ok := delimited(rest[1:])
+	if !ok {
+		return match{}, fmt.Errorf("正则缺少结束分隔符")
+	}
+	flags, rest := leadingFlags(rest)
+
+	translated, err := translatePattern(pattern, flags)
+	if err != nil {
+		return match{}, fmt.Errorf("服务 %s 的正则无法转换: %v", service, err)
+	}
+
+	m := match{service: service, pattern: translated}
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimSpace(rest) {
+		key, prefix := rest[:1], 1
+		if strings.HasPrefix(rest, "cpe:") {
+			key, prefix = "cpe", len("cpe:")
+		}
+		value, next, ok := delimited(rest[prefix:])
+		if !ok {
+			return match{}, fmt.Errorf("服务 %s 的版本信息字段 %s 缺少结束分隔符", service, key)
+		}
+		// cpe的a标志等字段标志不影响结果
+		_, rest = leadingFlags(next)
+		if detailNames[key] == "" {
+			continue
+		}
+		if key == "cpe" {
+			value = "cpe:/" + value
+		}
+		m.fields = append(m.fields, field{key: key, template: value})
+	}
+	return m, nil
+}
+
+// delimited 以s的第一个字符为分隔符，返回到下一个分隔符之间的内容和之后的剩余部分；nmap不支持转义分隔符
+func delimited(s string) (string, string, bool) {
+	delim, size := utf8.DecodeRuneInString(s)
+	if size == 0 {
+		return "", "", false
+	}
+	end := strings.IndexRune(s[size:], delim)
+	if end < 0 {
+		return "", "", false
+	}
+	return s[size : size+end], s[size+end+size:], true
+}
+
+// leadingFlags 返回s开头的标志字母和剩余部分
+func leadingFlags(s string) (string, string) {
+	i := 0
+	for i < len(s) && (s[i] >= 'a' && s[i] <= 'z') {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// translatePattern 将PCRE正则转换为Go正则：s和i标志转换为内联标志，\Z、\h、\e、\cX转换为等价写法，
+// 占有量词和原子分组按普通量词和非捕获分组处理；Go不支持的语法（反向引用、环视等）返回编译错误
+func translatePattern(pattern, flags string) (string, error) {
+	var b strings.Builder
+	var inline string
+	for _, flag := range flags {
+		switch flag {
+		case 's', 'i':
+			inline += string(flag)
+		default:
+			return "", fmt.Errorf("不支持的标志 %c", flag)
+		}
+	}
+	if inline != "" {
+		b.WriteString("(?" + inline + ")")
+	}
+
+	inClass := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\' && i+1 < len(pattern):
+			next := pattern[i+1]
+			i++
+			switch {
+			case next == 'Z' && !inClass:
+				b.WriteString(`\n?\z`)
+			case next == 'h':
+				if inClass {
+					b.WriteString(`\t `)
+				} else {
+					b.WriteString(`[\t ]`)
+				}
+			case next == 'e':
+				b.WriteString(`\x1b`)
+			case next == 'c' && i+1 < len(pattern):
+				i++
+				fmt.Fprintf(&b, `\x%02x`, (pattern[i]&^0x20)^0x40)
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(next)
+			}
+		case c == '[' && !inClass:
+			inClass = true
+			b.WriteByte(c)
+			// 类开头的^和]是字面值的一部分
+			if i+1 < len(pattern) && pattern[i+1] == '^' {
+				i++
+				b.WriteByte('^')
+			}
+			if i+1 < len(pattern) && pattern[i+1] == ']' {
+				i++
+				b.WriteString(`\]`)
+			}
+		case c == ']' && inClass:
+			inClass = false
+			b.WriteByte(c)
+		case c == '(' && !inClass && strings.HasPrefix(pattern[i:], "(?>"):
+			b.WriteString("(?:")
+			i += 2
+		case strings.IndexByte("*+?}", c) >= 0 && !inClass && i+1 < len(pattern) && pattern[i+1] == '+':
+			// 占有量词
+			b.WriteByte(c)
+			i++
+		default:
+			b.WriteString(pattern[i : i+1])
+		}
+	}
+
+	translated := b.String()
+	if _, err := regexp.Compile(translated); err != nil {
+		return "", err
+	}
+	return translated, nil
+}
+
+// unescape 解码探针数据中的转义：\\ \0 \a \b \f \n \r \t \v \xHH，其他字符前的\表示该字符本身
+func unescape(s string) []byte {
+	var data []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i+1 == len(s) {
+			data = append(data, byteOf(s, &i))
+			continue
+		}
+		i++
+		switch s[i] {
+		case '0':
+			data = append(data, 0)
+		case 'a':
+			data = append(data, '\a')
+		case 'b':
+			data = append(data, '\b')
+		case 'f':
+			data = append(data, '\f')
+		case 'n':
+			data = append(data, '\n')
+		case 'r':
+			data = append(data, '\r')
+		case 't':
+			data = append(data, '\t')
+		case 'v':
+			data = append(data, '\v')
+		case 'x':
+			if i+2 < len(s) {
+				if b, err := hex.DecodeString(s[i+1 : i+3]); err == nil {
+					data = append(data, b[0])
+					i += 2
+					continue
+				}
+			}
+			data = append(data, 'x')
+		default:
+			data = append(data, byteOf(s, &i))
+		}
+	}
+	return data
+}
+
+// byteOf 返回s中第*i个位置的字符对应的字节：行按Latin-1转换，每个字符都小于256；*i移到该字符的最后一个字节
+func byteOf(s string, i *int) byte {
+	r, size := utf8.DecodeRuneInString(s[*i:])
+	*i += size - 1
+	return byte(r)
+}
+
+// latin1 将每个字节转换为同值的字符
+func latin1(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// fingerprint 将探针的一条规则转换为服务指纹：静态的版本信息写入元数据，带分组引用的由提取器在命中时生成
+func (p *probe) fingerprint(m match) internal.Fingerprint {
+	tags := "nmap," + m.service
+	if m.soft {
+		tags += ",softmatch"
+	}
+	metadata := internal.Metadata{Rarity: p.rarity}
+	var extractors []internal.Extractors
+	cpeCount := 0
+	for _, f := range m.fields {
+		name := detailNames[f.key]
+		if f.key == "cpe" {
+			cpeCount++
+			if cpeCount > 1 {
+				name = fmt.Sprintf("cpe%d", cpeCount)
+			}
+		}
+		extractors = append(extractors, internal.Extractors{Name: name, Type: "regex", Regex: []string{m.pattern}, Template: f.template})
+		if strings.Contains(f.template, "$") {
+			continue
+		}
+		switch f.key {
+		case "p":
+			metadata.Product = f.template
+		case "v":
+			metadata.Version = f.template
+		case "i":
+			metadata.InfoField = f.template
+		case "o":
+			metadata.TargetSw = f.template
+		case "d":
+			metadata.TargetHw = f.template
+		case "cpe":
+			// cpe:/部件:厂商:产品:版本
+			if parts := strings.Split(strings.TrimPrefix(f.template, "cpe:/"), ":"); metadata.Vendor == "" && len(parts) > 1 {
+				metadata.Vendor = parts[1]
+			}
+		}
+	}
+
+	fp := internal.Fingerprint{
+		ID: fmt.Sprintf("nmap-%s-%d", m.service, m.line),
+		Info: internal.Info{
+			Name:     m.service,
+			Author:   "nmap",
+			Tags:     tags,
+			Severity: "info",
+			Metadata: metadata,
+		},
+	}
+	matchers := []internal.Matchers{{Type: "regex", Regex: []string{m.pattern}}}
+	name := p.name
+	if !p.hasPayload {
+		name = "null"
+	}
+
+	if p.protocol == "UDP" {
+		fp.UDP = []internal.UDPRequest{{
+			Name:       name,
+			Port:       p.ports,
+			Payload:    hex.EncodeToString(p.payload),
+			Matchers:   matchers,
+			Extractors: extractors,
+		}}
+		return fp
+	}
+
+	req := internal.TCPRequest{
+		Name:       name,
+		Port:       joinPorts(p.ports, p.sslPorts),
+		Softmatch:  m.soft,
+		Fallback:   p.fallback,
+		Matchers:   matchers,
+		Extractors: extractors,
+	}
+	if p.hasPayload {
+		req.Inputs = []internal.Input{{Data: string(p.payload), Wait: p.totalWait}}
+	}
+	fp.TCP = []internal.TCPRequest{req}
+	return fp
+}
+
+// joinPorts 合并ports和sslports，sslports的服务由扫描器在隐式TLS连接上探测
+func joinPorts(ports, sslPorts string) string {
+	switch {
+	case ports == "":
+		return sslPorts
+	case sslPorts == "":
+		return ports
+	}
+	return ports + "," + sslPorts
+}
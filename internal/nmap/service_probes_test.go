@@ -0,0 +1,339 @@
+package nmap
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestTranslatePattern(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		flags   string
+		want    string
+		match   []string // 应命中的文本
+		noMatch []string // 不应命中的文本
+	}{
+		{
+			name:    "i标志",
+			pattern: `^220 proftpd`,
+			flags:   "i",
+			want:    `(?i)^220 proftpd`,
+			match:   []string{"220 ProFTPD 1.3.8"},
+		},
+		{
+			name:    "s标志",
+			pattern: `^HTTP/1\.1 .*Server: nginx`,
+			flags:   "s",
+			want:    `(?s)^HTTP/1\.1 .*Server: nginx`,
+			match:   []string{"HTTP/1.1 200 OK\r\nServer: nginx"},
+		},
+		{
+			name:    "s和i标志",
+			pattern: `^a.b`,
+			flags:   "si",
+			want:    `(?si)^a.b`,
+			match:   []string{"A\nB"},
+		},
+		{
+			name:    "没有s标志时.不匹配换行",
+			pattern: `^a.b`,
+			want:    `^a.b`,
+			noMatch: []string{"a\nb"},
+		},
+		{
+			name:    `\Z允许结尾的换行`,
+			pattern: `^SSH-([\d.]+)\Z`,
+			want:    `^SSH-([\d.]+)\n?\z`,
+			match:   []string{"SSH-2.0", "SSH-2.0\n"},
+			noMatch: []string{"SSH-2.0\n\n", "SSH-2.0 x"},
+		},
+		{
+			name:    `\h为空格和制表符`,
+			pattern: `^a\hb[\h:]c`,
+			want:    `^a[\t ]b[\t :]c`,
+			match:   []string{"a b\tc", "a\tb:c"},
+			noMatch: []string{"a\nb c"},
+		},
+		{
+			name:    `\e为ESC`,
+			pattern: `^\e\[0m`,
+			want:    `^\x1b\[0m`,
+			match:   []string{"\x1b[0m"},
+		},
+		{
+			name:    `\cX为控制字符`,
+			pattern: `^\cA\cm\cM`,
+			want:    `^\x01\x0d\x0d`,
+			match:   []string{"\x01\r\r"},
+		},
+		{
+			name:    "类开头的]是字面值",
+			pattern: `^[]a]+$`,
+			want:    `^[\]a]+$`,
+			match:   []string{"]a]"},
+		},
+		{
+			name:    "类开头的^]是字面值",
+			pattern: `^[^]]+$`,
+			want:    `^[^\]]+$`,
+			match:   []string{"abc"},
+			noMatch: []string{"a]c"},
+		},
+		{
+			name:    "原子分组按非捕获分组处理",
+			pattern: `^(?>\d+)\.(\d+)`,
+			want:    `^(?:\d+)\.(\d+)`,
+			match:   []string{"10.5"},
+		},
+		{
+			name:    "占有量词按普通量词处理",
+			pattern: `^a*+b++c?+d{2}+`,
+			want:    `^a*b+c?d{2}`,
+			match:   []string{"bbcdd", "aabdd"},
+		},
+		{
+			name:    "类中的+不是占有量词",
+			pattern: `^[*+]+`,
+			want:    `^[*+]+`,
+			match:   []string{"+*"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := translatePattern(tt.pattern, tt.flags)
+			if err != nil {
+				t.Fatalf("translatePattern(%q) 出错: %v", tt.pattern, err)
+			}
+			if got != tt.want {
+				t.Errorf("translatePattern(%q) = %q，期望 %q", tt.pattern, got, tt.want)
+			}
+			re := regexp.MustCompile(got)
+			for _, s := range tt.match {
+				if !re.MatchString(s) {
+					t.Errorf("%q 应命中 %q", got, s)
+				}
+			}
+			for _, s := range tt.noMatch {
+				if re.MatchString(s) {
+					t.Errorf("%q 不应命中 %q", got, s)
+				}
+			}
+		})
+	}
+}
+
+func TestTranslatePatternUnsupported(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		flags   string
+	}{
+		{"反向引用", `^(\w)\1`, ""},
+		{"先行断言", `^foo(?=bar)`, ""},
+		{"否定先行断言", `^foo(?!bar)`, ""},
+		{"后行断言", `(?<=a)b`, ""},
+		{"不支持的标志", `^a`, "x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := translatePattern(tt.pattern, tt.flags); err == nil {
+				t.Errorf("translatePattern(%q, %q) = %q，期望返回错误", tt.pattern, tt.flags, got)
+			}
+		})
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []byte
+	}{
+		{"HTTP请求", `GET / HTTP/1.0\r\n\r\n`, []byte("GET / HTTP/1.0\r\n\r\n")},
+		{"控制字符", `\0\a\b\f\n\r\t\v`, []byte{0, '\a', '\b', '\f', '\n', '\r', '\t', '\v'}},
+		{"十六进制", `\x00\x0BAbc\xff`, []byte{0x00, 0x0b, 'A', 'b', 'c', 0xff}},
+		{"无效的十六进制", `\xZZ`, []byte("xZZ")},
+		{"不完整的十六进制", `\x4`, []byte("x4")},
+		{"转义的反斜杠和分隔符", `a\\b\|c`, []byte(`a\b|c`)},
+		{"结尾的反斜杠", `ab\`, []byte(`ab\`)},
+		{"Latin-1字符还原为字节", latin1([]byte{0xe9, 0x80}), []byte{0xe9, 0x80}},
+		{"空数据", ``, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unescape(tt.input); !bytes.Equal(got, tt.want) {
+				t.Errorf("unescape(%q) = %q，期望 %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+// testProbes 包含NULL、GetRequest、GenericLines和UDP探针的小型探针库
+const testProbes = `# 测试用探针库
+Exclude T:9100-9107
+
+Probe TCP NULL q||
+totalwaitms 6000
+tcpwrappedms 3000
+match ftp m/^220 ProFTPD (\d\S+) Server/ p/ProFTPD/ v/$1/ cpe:/a:proftpd:proftpd:$1/
+match ssh m|^SSH-([\d.]+)-OpenSSH_([\w._-]+)\r?\n|i p/OpenSSH/ v/$2/ i/protocol $1/ cpe:/a:openbsd:openssh:$2/a cpe:/o:linux:linux_kernel/
+softmatch ftp m/^220[- ]/
+match foo m/^(a)\1/ p/backref/
+
+Probe TCP GetRequest q|GET / HTTP/1.0\r\n\r\n|
+rarity 1
+ports 1,70,79,80-85,8080
+sslports 443,8443
+fallback GenericLines, NULL
+match http m|^HTTP/1\.[01] \d\d\d.*\r\nServer: nginx/([\d.]+)|s p/nginx/ v/$1/ cpe:/a:f5:nginx:$1/
+match http m|^HTTP/1\.[01] \d\d\d.*\r\nServer: Apache\r\n|s p/Apache httpd/ cpe:/a:apache:http_server/
+match bar m/^x(?=y)/ p/lookahead/
+
+Probe TCP GenericLines q|\r\n\r\n|
+rarity 8
+match echo m/^\r\n\r\n$/ p/echo/
+
+Probe UDP DNSStatusRequest q|\0\0\x10\0\0\0\0\0\0\0\0\0|
+rarity 1
+ports 53
+match domain m/^\0\0\x90\x04/ p/DNS/
+
+Probe UDP Empty q||
+match empty m/^/ p/empty/
+
+unknowndirective value
+`
+
+func TestParseServiceProbes(t *testing.T) {
+	fingerprints, warnings, err := ParseServiceProbes(strings.NewReader(testProbes))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 反向引用、先行断言、没有数据的UDP探针和未知指令记录为警告
+	wantWarnings := map[int]string{10: "foo", 19: "bar", 30: "Empty", 33: "unknowndirective"}
+	if len(warnings) != len(wantWarnings) {
+		t.Errorf("警告 = %v，期望 %d 条", warnings, len(wantWarnings))
+	}
+	for _, w := range warnings {
+		if !strings.Contains(w.String(), wantWarnings[w.Line]) {
+			t.Errorf("意外的警告: %s", w)
+		}
+	}
+
+	byID := make(map[string]int)
+	for i, fp := range fingerprints {
+		byID[fp.ID] = i
+	}
+	if len(fingerprints) != 7 {
+		t.Fatalf("指纹数 = %d，期望 7: %v", len(fingerprints), byID)
+	}
+
+	// NULL探针：不发送数据，静态产品写入元数据，带分组引用的版本和cpe由提取器生成，第二个cpe命名为cpe2
+	ftp := fingerprints[byID["nmap-ftp-7"]]
+	req := ftp.TCP[0]
+	if req.Name != "null" || len(req.Inputs) != 0 || req.Port != "" || req.Softmatch {
+		t.Errorf("NULL探针的请求 = %+v", req)
+	}
+	if ftp.Info.Metadata.Product != "ProFTPD" || ftp.Info.Metadata.Version != "" || ftp.Info.Metadata.Vendor != "" || ftp.Info.Metadata.Rarity != defaultRarity {
+		t.Errorf("ftp元数据 = %+v", ftp.Info.Metadata)
+	}
+	ssh := fingerprints[byID["nmap-ssh-8"]]
+	var names []string
+	for _, e := range ssh.TCP[0].Extractors {
+		names = append(names, e.Name)
+	}
+	if got := strings.Join(names, ","); got != "product,version,info,cpe,cpe2" {
+		t.Errorf("ssh提取器 = %s", got)
+	}
+	if !strings.HasPrefix(ssh.TCP[0].Matchers[0].Regex[0], "(?i)") {
+		t.Errorf("i标志未转换: %s", ssh.TCP[0].Matchers[0].Regex[0])
+	}
+	soft := fingerprints[byID["nmap-ftp-9"]]
+	if !soft.TCP[0].Softmatch || !strings.Contains(soft.Info.Tags, "softmatch") {
+		t.Errorf("softmatch未标记: %+v", soft.TCP[0])
+	}
+
+	// GetRequest：数据、ports和sslports合并、rarity、fallback，totalwaitms只属于NULL探针
+	nginx := fingerprints[byID["nmap-http-17"]]
+	req = nginx.TCP[0]
+	if req.Name != "GetRequest" || len(req.Inputs) != 1 || req.Inputs[0].Data != "GET / HTTP/1.0\r\n\r\n" || req.Inputs[0].Wait != 0 {
+		t.Errorf("GetRequest的输入 = %+v", req.Inputs)
+	}
+	if req.Port != "1,70,79,80-85,8080,443,8443" {
+		t.Errorf("端口 = %q", req.Port)
+	}
+	if strings.Join(req.Fallback, ",") != "GenericLines,NULL" {
+		t.Errorf("fallback = %v", req.Fallback)
+	}
+	if nginx.Info.Metadata.Rarity != 1 || nginx.Info.Metadata.Product != "nginx" {
+		t.Errorf("nginx元数据 = %+v", nginx.Info.Metadata)
+	}
+	// 静态cpe的厂商写入元数据
+	if apache := fingerprints[byID["nmap-http-18"]]; apache.Info.Metadata.Vendor != "apache" || apache.Info.Metadata.Product != "Apache httpd" {
+		t.Errorf("apache元数据 = %+v", apache.Info.Metadata)
+	}
+	if fingerprints[byID["nmap-echo-23"]].Info.Metadata.Rarity != 8 {
+		t.Errorf("GenericLines的rarity未生效")
+	}
+
+	// UDP探针：数据转换为十六进制payload
+	dns := fingerprints[byID["nmap-domain-28"]]
+	if len(dns.UDP) != 1 || dns.UDP[0].Payload != "000010000000000000000000" || dns.UDP[0].Port != "53" {
+		t.Errorf("UDP请求 = %+v", dns.UDP)
+	}
+}
+
+func TestParseServiceProbesTotalWait(t *testing.T) {
+	data := "Probe TCP Help q|HELP\\r\\n|\ntotalwaitms 2500\nmatch x m/^x/\n"
+	fingerprints, _, err := ParseServiceProbes(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fingerprints) != 1 || fingerprints[0].TCP[0].Inputs[0].Wait != 2500 {
+		t.Errorf("totalwaitms未生效: %+v", fingerprints)
+	}
+}
+
+func TestParseServiceProbesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"Probe之前的指令", "match ftp m/^220/\n", "第1行"},
+		{"无效的rarity", "Probe TCP NULL q||\nrarity high\n", "rarity"},
+		{"无效的totalwaitms", "Probe TCP NULL q||\ntotalwaitms soon\n", "totalwaitms"},
+		{"不支持的协议", "Probe SCTP NULL q||\n", "SCTP"},
+		{"探针数据缺少分隔符", "Probe TCP Help q|HELP\n", "分隔符"},
+		{"没有Probe", "# 空文件\n", "Probe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := ParseServiceProbes(strings.NewReader(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("错误 = %v，期望包含 %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsServiceProbes(t *testing.T) {
+	tests := []struct {
+		data string
+		want bool
+	}{
+		{testProbes, true},
+		{`[{"id":"nginx"}]`, false},
+		{`{"Probe TCP": 1}`, false},
+		{"# 只有注释\n", false},
+	}
+	for _, tt := range tests {
+		if got := IsServiceProbes([]byte(tt.data)); got != tt.want {
+			t.Errorf("IsServiceProbes(%.20q) = %v，期望 %v", tt.data, got, tt.want)
+		}
+	}
+}
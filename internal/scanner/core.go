@@ -1,6 +1,7 @@
 package scanner
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"nebulafinger/internal"
@@ -15,9 +16,16 @@ import (
 
 // ScanResult 表示扫描结果
 type ScanResult struct {
-	Target     string                // 目标地址
-	WebResults []matcher.MatchResult // Web指纹结果
-	TCPResults []matcher.MatchResult // TCP服务结果
+	Target          string                `json:"target"`                     // 目标地址
+	WebResults      []matcher.MatchResult `json:"web_results,omitempty"`      // Web指纹结果
+	TCPResults      []matcher.MatchResult `json:"tcp_results,omitempty"`      // TCP服务结果
+	Ports           []PortResult          `json:"ports,omitempty"`            // 服务扫描发现的开放端口
+	PortSummary     *PortSummary          `json:"port_summary,omitempty"`     // 服务扫描的端口状态统计
+	Timeouts        []TimeoutStats        `json:"timeouts,omitempty"`         // 每个连接地址的RTT估计和超时统计
+	SchemeDecisions []SchemeDecision      `json:"scheme_decisions,omitempty"` // 没有协议头的目标和开放端口的Web协议探测结论
+	Errors          []string              `json:"errors,omitempty"`           // 扫描过程中出现的错误
+	StartTime       time.Time             `json:"start_time"`                 // 开始扫描时间
+	EndTime         time.Time             `json:"end_time"`                   // 完成扫描时间
 }
 
 // Scanner 定义扫描器
@@ -30,21 +38,46 @@ type Scanner struct {
 	ServiceCluster      *cluster.ClusterType             // 服务指纹聚类
 	Config              *ScannerConfig                   // 扫描器配置
 	ConfidenceConfig    *internal.ConfidenceConfig       // 置信度配置
+	Resolver            *Resolver                        // 主机名解析器，为nil时使用系统解析
+	Warnings            []string                         // 创建时发现的指纹问题（如无效的探针），由调用方决定是否输出
+
+	budget     connBudget    // 全局连接预算，所有目标和端口共享
+	pinnedHost string        // 固定连接地址的主机名
+	pinnedIP   string        // pinnedHost实际连接的地址
+	rtt        *rttEstimator // pinnedIP的RTT估计，未启用自适应超时时为nil
+
+	portServices map[uint16][]string // 端口到服务名的映射，由ServicePorts反转得到
+	tcpProbes    []*tcpProbe         // 从服务指纹中收集的TCP探针
+	udpProbes    []udpProbe          // 从服务指纹中收集的UDP探针
+	udpLimiter   *rateLimiter        // 全局UDP发包限速，所有目标和端口共享
 }
 
 // ScannerConfig 扫描器配置
 type ScannerConfig struct {
-	Timeout            time.Duration // HTTP请求超时时间
-	FeatureThreshold   int           // 特征匹配阈值
-	MaxCandidates      int           // 最大候选指纹数
-	Concurrency        int           // 并发数
-	EnableFavicon      bool          // 是否启用favicon检测
-	EnableTCP          bool          // 是否启用TCP服务检测
-	CustomPorts        []string      // 自定义TCP扫描端口
-	MaxPortsPerService int           // 每个服务最多扫描的端口数
-	AdaptiveTimeout    bool          // 是否启用自适应超时
-	DefaultTCPPorts    []uint16      // 默认TCP端口列表，从配置文件加载
-	BPStat             bool          // 是否只输出有指纹匹配的结果
+	Timeout            time.Duration       // HTTP请求超时时间，也是自适应超时在没有RTT样本时的初始超时
+	FeatureThreshold   int                 // 特征匹配阈值
+	MaxCandidates      int                 // 最大候选指纹数
+	Concurrency        int                 // 并发数
+	EnableFavicon      bool                // 是否启用favicon检测
+	EnableTCP          bool                // 是否启用TCP服务检测
+	CustomPorts        []string            // 自定义TCP扫描端口
+	MaxPortsPerService int                 // 每个服务最多扫描的端口数
+	AdaptiveTimeout    bool                // 是否启用自适应超时：按连接耗时估计每个主机的RTT，推导连接和读取超时
+	MinTimeout         time.Duration       // 自适应超时的下限
+	MaxTimeout         time.Duration       // 自适应超时的上限
+	DefaultTCPPorts    []uint16            // 默认TCP端口列表，从配置文件加载
+	ServicePorts       map[string][]uint16 // 服务名到常用端口的映射，决定各端口上指纹的匹配顺序，为空时从配置文件加载
+	PortConfigFile     string              // TCP端口配置文件路径，为空时使用configs/tcp_ports.json
+	BPStat             bool                // 是否只输出有指纹匹配的结果
+	IPFamily           string              // 地址族: auto, ipv4, ipv6, dual
+	PerIP              bool                // 是否分别扫描主机名解析出的每个地址
+	Ports              []uint16            // 服务扫描的端口，为空时使用配置文件中的默认端口
+	PortConcurrency    int                 // 单个目标同时探测的端口数
+	MaxConnections     int                 // 全局同时打开的连接数上限，所有目标和端口共享，0表示不限制
+	EnableUDP          bool                // 服务扫描时是否同时做UDP服务识别
+	UDPPorts           []uint16            // UDP扫描的端口，为空时使用各UDP探针声明的端口
+	UDPRate            int                 // 每秒发送的UDP报文数上限，所有目标共享，0表示不限制
+	EnableICS          bool                // 是否启用工控协议识别（Modbus、S7、BACnet等），只发送只读的识别请求
 
 	// HTTP客户端配置
 	HTTP internal.HTTPConfig // HTTP客户端配置
@@ -90,8 +123,14 @@ func DefaultConfig() *ScannerConfig {
 		CustomPorts:        []string{},
 		MaxPortsPerService: 5,
 		AdaptiveTimeout:    true,
+		MinTimeout:         defaultMinTimeout,
+		MaxTimeout:         defaultMaxTimeout,
 		HTTP:               internal.DefaultHTTPConfig(),
 		BPStat:             false, // 默认关闭BP-stat选项
+		IPFamily:           IPFamilyAuto,
+		PortConcurrency:    20,
+		MaxConnections:     200,
+		UDPRate:            defaultUDPRate,
 	}
 }
 
@@ -140,7 +179,11 @@ func NewScanner(
 	}
 
 	// 尝试加载TCP端口配置
-	tcpPortConfig, err := LoadTCPPortConfig("configs/tcp_ports.json")
+	portConfigFile := "configs/tcp_ports.json"
+	if config != nil && config.PortConfigFile != "" {
+		portConfigFile = config.PortConfigFile
+	}
+	tcpPortConfig, err := LoadTCPPortConfig(portConfigFile)
 	if err != nil {
 		fmt.Printf("警告: 加载TCP端口配置失败: %v，将使用默认值\n", err)
 	} else if config != nil {
@@ -149,8 +192,31 @@ func NewScanner(
 
 		// 还可以设置其他配置项
 		config.MaxPortsPerService = tcpPortConfig.ScanOptions.MaxPortCount
+
+		// 未显式指定服务端口映射时使用配置文件中的
+		if config.ServicePorts == nil {
+			config.ServicePorts = tcpPortConfig.ServicePorts
+		}
+	}
+
+	warnings := checkModuleProbes(serviceFingerprints)
+
+	var portServices map[uint16][]string
+	if config != nil {
+		portServices = indexServicePorts(config.ServicePorts)
+	}
+
+	// 创建全局连接预算和UDP发包限速
+	var budget connBudget
+	var udpLimiter *rateLimiter
+	if config != nil {
+		budget = newConnBudget(config.MaxConnections)
+		udpLimiter = newRateLimiter(config.UDPRate)
 	}
 
+	udpProbes, udpWarnings := buildUDPProbes(serviceFingerprints)
+	warnings = append(warnings, udpWarnings...)
+
 	return &Scanner{
 		WebFingerprints:     webFingerprints,
 		ServiceFingerprints: serviceFingerprints,
@@ -159,6 +225,12 @@ func NewScanner(
 		FeatureDetector:     featureDetector,
 		Config:              config,
 		ConfidenceConfig:    confidenceConfig,
+		budget:              budget,
+		portServices:        portServices,
+		tcpProbes:           buildTCPProbes(serviceFingerprints),
+		Warnings:            warnings,
+		udpProbes:           udpProbes,
+		udpLimiter:          udpLimiter,
 	}
 }
 
@@ -206,8 +278,8 @@ func preprocessFingerprints(fingerprints []internal.Fingerprint) []internal.Fing
 	return processedFingerprints
 }
 func processURL(target string) bool {
-	// 检查是否已有协议头（http:// 或 https:// 或 tcp://）
-	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") || strings.HasPrefix(target, "tcp://") {
+	// 检查是否已有协议头（http:// 或 https:// 或 tcp:// 或 udp://）
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") || strings.HasPrefix(target, "tcp://") || strings.HasPrefix(target, "udp://") {
 		// 已有协议头，保持不变
 		return true
 	}
@@ -277,53 +349,96 @@ func deletehttpstatuscode(results []matcher.MatchResult) []matcher.MatchResult {
 }
 
 // Scan 扫描目标
+// 扫描前先解析主机名并固定连接地址，同一目标的所有探测都连接同一IP，结果中记录该IP；
+// 启用PerIP或dual时分别扫描解析出的每个地址；只有一个地址且扫描出错时，返回错误的同时返回带有RTT统计的部分结果
 func (s *Scanner) Scan(target string, modelFlag string) (*ScanResult, error) {
+	if s.pinnedIP != "" {
+		return s.scan(target, modelFlag)
+	}
+
+	host := hostnameOf(target)
+	addrs, err := s.resolveHost(context.Background(), host)
+	if err != nil {
+		return nil, fmt.Errorf("解析 %s 失败: %v", host, err)
+	}
+	if !s.scanEachIP() {
+		addrs = addrs[:1]
+	}
+
+	result := &ScanResult{
+		Target:    target,
+		StartTime: time.Now(),
+	}
+	defer func() { result.EndTime = time.Now() }()
+
+	for _, addr := range addrs {
+		pinned := s.withPinnedIP(host, addr)
+		addrResult, err := pinned.scan(target, modelFlag)
+		if pinned.rtt != nil {
+			result.Timeouts = append(result.Timeouts, pinned.rtt.stats(addr))
+		}
+		if err != nil {
+			if len(addrs) == 1 {
+				result.Errors = append(result.Errors, err.Error())
+				return result, err
+			}
+			result.Errors = append(result.Errors, fmt.Sprintf("[%s] %v", addr, err))
+			continue
+		}
+		result.WebResults = append(result.WebResults, withIP(addrResult.WebResults, addr)...)
+		result.TCPResults = append(result.TCPResults, withIP(addrResult.TCPResults, addr)...)
+		result.Ports = append(result.Ports, addrResult.Ports...)
+		result.SchemeDecisions = append(result.SchemeDecisions, addrResult.SchemeDecisions...)
+		if addrResult.PortSummary != nil {
+			if result.PortSummary == nil {
+				result.PortSummary = &PortSummary{}
+			}
+			result.PortSummary.add(*addrResult.PortSummary)
+		}
+		for _, e := range addrResult.Errors {
+			if len(addrs) > 1 {
+				e = fmt.Sprintf("[%s] %s", addr, e)
+			}
+			result.Errors = append(result.Errors, e)
+		}
+	}
+	return result, nil
+}
+
+// withIP 在结果详情中记录实际连接的IP地址
+func withIP(results []matcher.MatchResult, ip string) []matcher.MatchResult {
+	for i := range results {
+		if results[i].Details == nil {
+			results[i].Details = make(map[string]string)
+		}
+		results[i].Details["ip"] = ip
+	}
+	return results
+}
+
+// scan 扫描单个目标
+func (s *Scanner) scan(target string, modelFlag string) (*ScanResult, error) {
 	// 创建扫描结果
 	result := &ScanResult{
-		Target: target,
+		Target:    target,
+		StartTime: time.Now(),
 	}
+	defer func() { result.EndTime = time.Now() }()
 
-	// 检测target有无协议头
+	// 检测target有无协议头，IPv6地址补全方括号以便拼接URL
 	var protocol_target string
 	hasProtocol := processURL(target)
+	if hasProtocol {
+		target = escapeZone(target)
+	} else {
+		target = urlHost(target)
+	}
 
 	if !hasProtocol {
 		switch modelFlag {
 		case "web":
-			//fmt.Println("[+] Web模式：同时尝试HTTP和HTTPS协议")
-			var allResults []matcher.MatchResult
-
-			// 先尝试HTTP协议
-			httpTarget := "http://" + target
-			httpURL, err := parseURL(httpTarget)
-			if err == nil {
-				//fmt.Printf("[+] 尝试HTTP协议: %s\n", httpTarget)
-				httpResults, httpErr := s.httpScan(httpURL)
-				if httpErr == nil && len(httpResults) > 0 {
-					//fmt.Printf("[+] HTTP协议探测成功，找到 %d 个匹配结果\n", len(httpResults))
-					allResults = append(allResults, httpResults...)
-					allResults = deletehttpstatuscode(allResults)
-				}
-			}
-
-			// 再尝试HTTPS协议
-			httpsTarget := "https://" + target
-			httpsURL, err := parseURL(httpsTarget)
-			if err == nil {
-				//fmt.Printf("[+] 尝试HTTPS协议: %s\n", httpsTarget)
-				httpsResults, httpsErr := s.httpScan(httpsURL)
-				if httpsErr == nil && len(httpsResults) > 0 {
-					//fmt.Printf("[+] HTTPS协议探测成功，找到 %d 个匹配结果\n", len(httpsResults))
-					allResults = append(allResults, httpsResults...)
-					allResults = deletehttpstatuscode(allResults)
-				}
-			}
-
-			// 对结果去重
-			if len(allResults) > 0 {
-				result.WebResults = UniqueResults(allResults)
-				//fmt.Printf("[+] 合并后共有 %d 个唯一匹配结果\n", len(result.WebResults))
-			}
+			// 先探测目标端口实际使用的协议，每个真实的Web服务只扫描一次
+			s.scanWebEndpoints(result, target)
 		case "service":
 			protocol_target = "tcp://" + target
 			parsedURL, err := parseURL(protocol_target)
@@ -331,25 +446,16 @@ func (s *Scanner) Scan(target string, modelFlag string) (*ScanResult, error) {
 				return nil, fmt.Errorf("无法解析目标URL: %v", err)
 			}
 
-			tcpResults, err := s.tcpScan(parsedURL)
+			tcpResults, portStates, err := s.serviceScan(parsedURL)
 			if err != nil {
 				return nil, err
 			}
 			result.TCPResults = tcpResults
+			result.setPorts(portStates)
 
 		case "all", "": // 默认为all
-			// HTTP扫描
-			httpTarget := "http://" + target
-			parsedURL, err := parseURL(httpTarget)
-			if err != nil {
-				return nil, fmt.Errorf("无法解析HTTP目标URL: %v", err)
-			}
-
-			webResults, err := s.httpScan(parsedURL)
-			if err == nil { // 即使出错也继续TCP扫描
-				result.WebResults = webResults
-				result.WebResults = deletehttpstatuscode(result.WebResults)
-			}
+			// HTTP扫描，按探测到的协议进行
+			sniffed := s.scanWebEndpoints(result, target)
 
 			// Service扫描
 			tcpTarget := "tcp://" + target
@@ -358,10 +464,16 @@ func (s *Scanner) Scan(target string, modelFlag string) (*ScanResult, error) {
 				return nil, fmt.Errorf("无法解析Service目标URL: %v", err)
 			}
 
-			tcpResults, err := s.tcpScan(parsedTCPURL)
+			tcpResults, portStates, err := s.serviceScan(parsedTCPURL)
 			if err == nil {
 				result.TCPResults = tcpResults
+				result.setPorts(portStates)
+			} else {
+				result.Errors = append(result.Errors, err.Error())
 			}
+
+			// 服务扫描发现的其他HTTP/HTTPS端口交给Web指纹识别
+			s.scanWebPorts(result, sniffed)
 		}
 	} else {
 		// 已有协议头，直接解析
@@ -379,11 +491,16 @@ func (s *Scanner) Scan(target string, modelFlag string) (*ScanResult, error) {
 			result.WebResults = webResults
 			result.WebResults = deletehttpstatuscode(result.WebResults)
 		} else if parsedURL.Scheme == "tcp" {
-			tcpResults, err := s.tcpScan(parsedURL)
+			tcpResults, portStates, err := s.tcpScan(parsedURL)
 			if err != nil {
 				return nil, err
 			}
 			result.TCPResults = tcpResults
+			result.setPorts(portStates)
+		} else if parsedURL.Scheme == "udp" {
+			udpResults, portStates := s.udpScan(parsedURL)
+			result.TCPResults = udpResults
+			result.setPorts(portStates)
 		}
 	}
 
@@ -415,7 +532,7 @@ func (s *Scanner) httpScan(parsedURL *url.URL) ([]matcher.MatchResult, error) {
 	return results, nil
 }
 
-func (s *Scanner) tcpScan(parsedURL *url.URL) ([]matcher.MatchResult, error) {
+func (s *Scanner) tcpScan(parsedURL *url.URL) ([]matcher.MatchResult, []PortResult, error) {
 	var results []matcher.MatchResult
 	/*
 		// 第一阶段：快速探测收集特征
@@ -430,11 +547,31 @@ func (s *Scanner) tcpScan(parsedURL *url.URL) ([]matcher.MatchResult, error) {
 	// 如果需要测试某些特定的指纹ID，可以取消下面的注释并添加指纹ID
 	candidates = []string{"thinkphp", "nginx", "wordpress"}
 	// 第二阶段：精确匹配TCP指纹
-	results, err := s.preciseTCPMatch(parsedURL.String(), candidates)
+	results, portStates, err := s.preciseTCPMatch(parsedURL.String(), candidates)
 	if err != nil {
-		return nil, fmt.Errorf("精确TCP探测失败: %v", err)
+		return nil, nil, fmt.Errorf("精确TCP探测失败: %v", err)
 	}
-	return results, nil
+	return results, portStates, nil
+}
+
+// serviceScan 服务扫描：TCP服务识别，启用UDP时再做UDP服务识别，结果和端口状态合并返回
+func (s *Scanner) serviceScan(parsedURL *url.URL) ([]matcher.MatchResult, []PortResult, error) {
+	results, portStates, err := s.tcpScan(parsedURL)
+	if err != nil || s.Config == nil || !s.Config.EnableUDP {
+		return results, portStates, err
+	}
+	udpResults, udpStates := s.udpScan(parsedURL)
+	return append(results, udpResults...), append(portStates, udpStates...), nil
+}
+
+// setPorts 记录服务扫描的开放端口和端口状态统计
+func (r *ScanResult) setPorts(portStates []PortResult) {
+	if len(portStates) == 0 {
+		return
+	}
+	open, summary := summarizePorts(portStates)
+	r.Ports = open
+	r.PortSummary = &summary
 }
 
 // selectCommonFingerprints 返回常见指纹ID作为回退
@@ -445,15 +582,25 @@ func (s *Scanner) selectCommonFingerprints() []string {
 	return []string{}
 }
 
+// urlOrigin 返回URL的协议和主机部分，同一站点不同路径的结果视为同一站点
+func urlOrigin(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return baseURL(u)
+}
+
 // UniqueResults 结果去重
 func UniqueResults(results []matcher.MatchResult) []matcher.MatchResult {
 	seen := make(map[string]bool)
 	var unique []matcher.MatchResult
 
 	for _, result := range results {
-		// 使用ID作为唯一键
-		if !seen[result.ID] {
-			seen[result.ID] = true
+		// 使用ID作为唯一键，不同IP、端口、传输层协议和站点（协议+主机+端口）上的结果分别保留
+		key := result.ID + "|" + result.Details["ip"] + "|" + result.Details["port"] + "|" + result.Details["protocol"] + "|" + urlOrigin(result.Details["url"])
+		if !seen[key] {
+			seen[key] = true
 			unique = append(unique, result)
 		}
 	}
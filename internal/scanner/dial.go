@@ -0,0 +1,262 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IP地址族选择
+const (
+	IPFamilyAuto = "auto" // 按系统解析结果的顺序连接
+	IPFamily4    = "ipv4" // 优先IPv4，没有A记录时回退到IPv6
+	IPFamily6    = "ipv6" // 优先IPv6，没有AAAA记录时回退到IPv4
+	IPFamilyDual = "dual" // 同时解析A和AAAA记录，分别扫描每个地址
+)
+
+// ParseIPFamily 校验地址族选项，空值等同于auto
+func ParseIPFamily(family string) (string, error) {
+	switch strings.ToLower(family) {
+	case "", IPFamilyAuto:
+		return IPFamilyAuto, nil
+	case IPFamily4, "4":
+		return IPFamily4, nil
+	case IPFamily6, "6":
+		return IPFamily6, nil
+	case IPFamilyDual:
+		return IPFamilyDual, nil
+	}
+	return "", fmt.Errorf("不支持的地址族: %s（可选: auto, ipv4, ipv6, dual）", family)
+}
+
+// dial 建立连接，所有TCP探测都通过这里连接目标
+func (s *Scanner) dial(network, address string) (net.Conn, error) {
+	return s.dialContext(context.Background(), network, address)
+}
+
+// dialContext 在全局连接预算内建立连接，连接关闭时归还预算
+// HTTP客户端也使用这个函数，URL中保留原始主机名，Host头和SNI不受影响
+func (s *Scanner) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if err := s.budget.acquire(ctx); err != nil {
+		return nil, err
+	}
+	return s.withBudget(s.dialAddress(ctx, network, address))
+}
+
+// dialTimeout 在全局连接预算内以指定超时建立连接，等待预算的时间不计入超时
+func (s *Scanner) dialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	if err := s.budget.acquire(context.Background()); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.withBudget(s.dialAddress(ctx, network, address))
+}
+
+// withBudget 连接失败时立即归还预算，成功时包装连接使其关闭时归还
+func (s *Scanner) withBudget(conn net.Conn, err error) (net.Conn, error) {
+	if err != nil {
+		s.budget.release()
+		return nil, err
+	}
+	if s.budget == nil {
+		return conn, nil
+	}
+	return &budgetConn{Conn: conn, budget: s.budget}, nil
+}
+
+// dialAddress 连接目标：目标主机连接扫描开始时解析出的固定地址，连接耗时计入该主机的RTT估计；
+// 其他主机（如重定向目标）按地址族偏好依次尝试解析出的各个地址
+func (s *Scanner) dialAddress(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if s.pinnedIP != "" && strings.EqualFold(host, s.pinnedHost) {
+		dialer := &net.Dialer{Timeout: s.connectTimeout()}
+		start := time.Now()
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(s.pinnedIP, port))
+		s.rtt.observe(time.Since(start), err)
+		return conn, err
+	}
+
+	dialer := &net.Dialer{Timeout: s.Config.Timeout}
+	if s.ipFamily() == IPFamilyAuto && s.Resolver == nil {
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	addrs, err := s.resolveHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error
+	for _, addr := range addrs {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(addr, port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// connBudget 全局连接预算，所有目标和端口共享，为nil时不限制
+type connBudget chan struct{}
+
+// newConnBudget 创建最多同时占用n个连接的预算，n<=0时不限制
+func newConnBudget(n int) connBudget {
+	if n <= 0 {
+		return nil
+	}
+	return make(connBudget, n)
+}
+
+// acquire 占用一个连接，预算用尽时等待其他连接关闭
+func (b connBudget) acquire(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	select {
+	case b <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release 归还一个连接
+func (b connBudget) release() {
+	if b != nil {
+		<-b
+	}
+}
+
+// budgetConn 关闭时归还连接预算，重复关闭只归还一次
+type budgetConn struct {
+	net.Conn
+	budget connBudget
+	once   sync.Once
+}
+
+// Close 关闭连接并归还预算
+func (c *budgetConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.budget.release)
+	return err
+}
+
+// resolveHost 解析主机的全部地址，并按地址族偏好排序；IP字面量直接返回
+func (s *Scanner) resolveHost(ctx context.Context, host string) ([]string, error) {
+	if _, err := netip.ParseAddr(host); err == nil {
+		return []string{host}, nil
+	}
+
+	var addrs []string
+	var err error
+	if s.Resolver != nil {
+		addrs, err = s.Resolver.LookupHost(ctx, host)
+	} else {
+		addrs, err = net.DefaultResolver.LookupHost(ctx, host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%s 没有可用的地址", host)
+	}
+
+	// ipv4和ipv6时偏好的地址族排在前面，同一地址族内保持解析顺序；auto和dual保持解析顺序
+	family := s.ipFamily()
+	if family != IPFamily4 && family != IPFamily6 {
+		return addrs, nil
+	}
+	prefer4 := family == IPFamily4
+	is4 := func(addr string) bool {
+		a, err := netip.ParseAddr(addr)
+		return err == nil && a.Unmap().Is4()
+	}
+	sort.SliceStable(addrs, func(i, j int) bool {
+		return is4(addrs[i]) != is4(addrs[j]) && is4(addrs[i]) == prefer4
+	})
+	return addrs, nil
+}
+
+// ipFamily 返回配置的地址族
+func (s *Scanner) ipFamily() string {
+	if s.Config == nil || s.Config.IPFamily == "" {
+		return IPFamilyAuto
+	}
+	return s.Config.IPFamily
+}
+
+// scanEachIP 是否分别扫描主机解析出的每个地址
+func (s *Scanner) scanEachIP() bool {
+	return s.ipFamily() == IPFamilyDual || (s.Config != nil && s.Config.PerIP)
+}
+
+// withPinnedIP 返回将host固定连接到ip的扫描器副本，同一目标的所有探测都使用同一地址，
+// 副本带有该地址独立的RTT估计
+func (s *Scanner) withPinnedIP(host, ip string) *Scanner {
+	pinned := *s
+	pinned.pinnedHost = host
+	pinned.pinnedIP = ip
+	pinned.rtt = newRTTEstimator(s.Config)
+	return &pinned
+}
+
+// urlHost 为不带协议头的目标补全IPv6方括号，并转义zone ID中的%，使其可以拼接成URL
+//
+//	2001:db8::1        -> [2001:db8::1]
+//	fe80::1%eth0       -> [fe80::1%25eth0]
+//	[fe80::1%eth0]:80  -> [fe80::1%25eth0]:80
+func urlHost(target string) string {
+	if addr, err := netip.ParseAddr(target); err == nil && addr.Is6() {
+		return escapeZone("[" + target + "]")
+	}
+	return escapeZone(target)
+}
+
+// escapeZone 将方括号内zone ID的%转义为%25，已转义的保持不变
+func escapeZone(target string) string {
+	start := strings.Index(target, "[")
+	end := strings.Index(target, "]")
+	if start < 0 || end < start {
+		return target
+	}
+	i := strings.Index(target[start:end], "%")
+	if i < 0 || strings.HasPrefix(target[start+i:], "%25") {
+		return target
+	}
+	i += start
+	return target[:i] + "%25" + target[i+1:]
+}
+
+// hostnameOf 从URL或host:port形式的目标中提取主机名，IPv6地址不带方括号
+func hostnameOf(target string) string {
+	if !strings.Contains(target, "://") {
+		target = "tcp://" + urlHost(target)
+	}
+	if u, err := url.Parse(escapeZone(target)); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	return target
+}
+
+// hostHeader 返回HTTP Host头使用的主机，IPv6地址加方括号并去掉zone ID
+func hostHeader(host string) string {
+	if addr, err := netip.ParseAddr(host); err == nil && addr.Is6() {
+		return "[" + addr.WithZone("").String() + "]"
+	}
+	return host
+}
+
+// baseURL 返回URL的协议和主机部分，主机中的zone ID会被正确转义
+func baseURL(u *url.URL) string {
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host}).String()
+}
@@ -0,0 +1,95 @@
+package scanner
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestParallel 每个下标只执行一次，同时执行的数量不超过limit（-pc）
+func TestParallel(t *testing.T) {
+	for _, tt := range []struct{ n, limit, wantMax int }{
+		{50, 4, 4},
+		{3, 10, 3},
+		{5, 0, 5},
+	} {
+		var running, peak int64
+		var mu sync.Mutex
+		seen := make(map[int]int)
+		parallel(tt.n, tt.limit, func(i int) {
+			current := atomic.AddInt64(&running, 1)
+			for {
+				old := atomic.LoadInt64(&peak)
+				if current <= old || atomic.CompareAndSwapInt64(&peak, old, current) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt64(&running, -1)
+			mu.Lock()
+			seen[i]++
+			mu.Unlock()
+		})
+		if len(seen) != tt.n {
+			t.Errorf("n=%d limit=%d 执行了 %d 个下标", tt.n, tt.limit, len(seen))
+		}
+		for i, count := range seen {
+			if count != 1 {
+				t.Errorf("下标 %d 执行了 %d 次", i, count)
+			}
+		}
+		if peak > int64(tt.wantMax) {
+			t.Errorf("n=%d limit=%d 同时执行 %d 个，超过 %d", tt.n, tt.limit, peak, tt.wantMax)
+		}
+	}
+}
+
+// TestConnBudgetLimitsDials 端口并发大于连接预算（-max-conns）时，同时占用的连接数不超过预算
+func TestConnBudgetLimitsDials(t *testing.T) {
+	const budget = 3
+	port := tcpStandIn(t, func(conn net.Conn) {
+		conn.Read(make([]byte, 1))
+	})
+	closed := closedPort(t)
+	s := newTestScanner(nil, ScannerConfig{MaxConnections: budget, PortConcurrency: 20})
+
+	var inFlight, peak int64
+	observe := func() {
+		current := atomic.AddInt64(&inFlight, 1)
+		for {
+			old := atomic.LoadInt64(&peak)
+			if current <= old || atomic.CompareAndSwapInt64(&peak, old, current) {
+				break
+			}
+		}
+		if n := len(s.budget); n > budget {
+			t.Errorf("占用的预算 %d 超过 %d", n, budget)
+		}
+	}
+
+	parallel(40, s.portConcurrency(), func(i int) {
+		// 开放和关闭的端口交替，失败的连接也要归还预算
+		target := port
+		if i%2 == 1 {
+			target = closed
+		}
+		conn, err := s.dialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(target))), time.Second)
+		if err != nil {
+			return
+		}
+		observe()
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt64(&inFlight, -1)
+		conn.Close()
+	})
+
+	if peak == 0 || peak > budget {
+		t.Errorf("同时持有的连接最多 %d 个，期望 1-%d", peak, budget)
+	}
+	if n := len(s.budget); n != 0 {
+		t.Errorf("全部完成后仍占用 %d 个连接预算", n)
+	}
+}
@@ -0,0 +1,196 @@
+package scanner
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"nebulafinger/internal"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fixture 解码十六进制的协议报文，忽略其中的空白
+func fixture(s string) []byte {
+	data, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// newTestScanner 创建使用fingerprints的扫描器，config.Timeout为0时使用1秒
+func newTestScanner(fingerprints []internal.Fingerprint, config ScannerConfig) *Scanner {
+	if config.Timeout == 0 {
+		config.Timeout = time.Second
+	}
+	return NewScanner(nil, fingerprints, nil, &config)
+}
+
+// localTarget 返回本地端口的模块探测目标
+func localTarget(port uint16) moduleTarget {
+	return moduleTarget{hostname: "127.0.0.1", port: port, banner: &tcpBanner{}}
+}
+
+// tcpStandIn 在本地启动TCP服务，每个连接交给handle处理，返回服务端口
+func tcpStandIn(t *testing.T, handle func(conn net.Conn)) uint16 {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				handle(conn)
+			}()
+		}
+	}()
+	return uint16(listener.Addr().(*net.TCPAddr).Port)
+}
+
+// udpStandIn 在本地启动UDP服务，reply返回nil时不回复，返回服务端口和收到的报文数
+func udpStandIn(t *testing.T, reply func([]byte) []byte) (uint16, *int64) {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	var received int64
+	go func() {
+		buffer := make([]byte, 65535)
+		for {
+			n, from, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			atomic.AddInt64(&received, 1)
+			if data := reply(buffer[:n]); data != nil {
+				conn.WriteToUDP(data, from)
+			}
+		}
+	}()
+	return uint16(conn.LocalAddr().(*net.UDPAddr).Port), &received
+}
+
+// httpStandIn 启动本地HTTP服务，返回端口
+func httpStandIn(t *testing.T, handler http.Handler) uint16 {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return uint16(server.Listener.Addr().(*net.TCPAddr).Port)
+}
+
+// dualStandIn 在host上启动同时接受明文和TLS的HTTP服务，按连接的第一个字节区分；
+// handler为nil时直接关闭对应协议的连接，返回服务端口
+func dualStandIn(t *testing.T, host string, plain, overTLS http.Handler) uint16 {
+	t.Helper()
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		t.Skipf("无法监听 %s: %v", host, err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	config := selfSignedTLSConfig(t, "localhost")
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				reader := bufio.NewReader(conn)
+				first, err := reader.Peek(1)
+				if err != nil {
+					return
+				}
+				var c net.Conn = &peekedConn{Conn: conn, reader: reader}
+				handler := plain
+				if first[0] == 0x16 {
+					handler = overTLS
+					c = tls.Server(c, config)
+				}
+				if handler != nil {
+					serveOne(c, handler)
+				}
+			}()
+		}
+	}()
+	return uint16(listener.Addr().(*net.TCPAddr).Port)
+}
+
+// peekedConn 从已经预读过的reader中读取的连接
+type peekedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// serveOne 在连接上处理一个HTTP请求
+func serveOne(conn net.Conn, handler http.Handler) {
+	request, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil {
+		return
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	resp := recorder.Result()
+	resp.ContentLength = int64(recorder.Body.Len())
+	resp.Close = true
+	resp.Write(conn)
+}
+
+// selfSignedTLSConfig 返回使用自签名证书的服务端TLS配置
+func selfSignedTLSConfig(t *testing.T, commonName string) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+// closedPort 返回本地一个没有监听的端口
+func closedPort(t *testing.T) uint16 {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+	listener.Close()
+	return port
+}
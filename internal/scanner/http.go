@@ -1,7 +1,9 @@
 package scanner
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"nebulafinger/internal"
@@ -14,6 +16,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // headerContains 检查HTTP头是否包含指定的字符串
@@ -63,8 +66,8 @@ func (s *Scanner) quickHTTPProbe(parsedURL *url.URL) ([]internal.FeatureKey, err
 	resp, err := utils.SendRequest(client, request, clientOptions)
 	if err != nil {
 		// 尝试添加端口号，如果没有指定端口
-		if !strings.Contains(parsedURL.Host, ":") {
-			altURL := parsedURL.Scheme + "://" + parsedURL.Host + ":80" + parsedURL.Path
+		if parsedURL.Port() == "" {
+			altURL := baseURL(parsedURL) + ":80" + parsedURL.Path
 			fmt.Printf("原始请求失败，尝试使用显式端口: %s\n", altURL)
 
 			// 尝试使用备用URL
@@ -284,6 +287,26 @@ func (s *Scanner) matchHttpOther(parsedURL *url.URL, port uint16, candidates []s
 
 }
 
+// tlsCertDetails 提取服务端证书的指纹、主题、颁发者和有效期，非HTTPS响应返回nil
+func tlsCertDetails(state *tls.ConnectionState) map[string]string {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	cert := state.PeerCertificates[0]
+	sum := sha256.Sum256(cert.Raw)
+
+	details := map[string]string{
+		"tls_cert_sha256":    hex.EncodeToString(sum[:]),
+		"tls_cert_subject":   cert.Subject.String(),
+		"tls_cert_issuer":    cert.Issuer.String(),
+		"tls_cert_not_after": cert.NotAfter.UTC().Format(time.RFC3339),
+	}
+	if len(cert.DNSNames) > 0 {
+		details["tls_cert_dns_names"] = strings.Join(cert.DNSNames, ",")
+	}
+	return details
+}
+
 // probeHttpService 探测HTTP服务
 func (s *Scanner) probeHttpService(parsedURL *url.URL, port uint16, matchingClusters []HttpClusterInfo, candidates []string, pathClusters []HttpClusterInfo, faviconClusters []HttpClusterInfo) (bool, []matcher.MatchResult) {
 	// 创建一个切片收集所有匹配的结果
@@ -302,9 +325,13 @@ func (s *Scanner) probeHttpService(parsedURL *url.URL, port uint16, matchingClus
 			TLSClientConfig: &tls.Config{
 				InsecureSkipVerify: true, // 跳过SSL证书验证
 			},
+			DialContext: s.dialContext, // 按地址族偏好连接，URL中保留主机名
 		},
 	}
 
+	// 探测结束后关闭空闲连接，归还全局连接预算
+	defer client.CloseIdleConnections()
+
 	// 预先获取favicon哈希（如果启用）
 	var faviconHash string
 	if s.Config.EnableFavicon {
@@ -317,7 +344,7 @@ func (s *Scanner) probeHttpService(parsedURL *url.URL, port uint16, matchingClus
 	// 对每个路径执行请求并进行匹配
 	for _, cluster := range pathClusters {
 		// 构建请求URL
-		reqURL := parsedURL.Scheme + "://" + parsedURL.Host + cluster.Path
+		reqURL := baseURL(parsedURL) + cluster.Path
 		//fmt.Printf("[HTTP] 请求路径: %s\n", reqURL)
 
 		req, err := http.NewRequest("GET", reqURL, nil)
@@ -347,6 +374,9 @@ func (s *Scanner) probeHttpService(parsedURL *url.URL, port uint16, matchingClus
 		}
 		body := strings.ToLower(string(bodyBytes))
 
+		// 记录HTTPS证书信息，用于资产变化比对
+		certDetails := tlsCertDetails(resp.TLS)
+
 		// 转换Headers格式
 		headers := make(map[string][]string)
 		for name, values := range resp.Header {
@@ -368,6 +398,7 @@ func (s *Scanner) probeHttpService(parsedURL *url.URL, port uint16, matchingClus
 			// 遍历集群中的每个操作符（指纹）
 			for _, fingerprint := range clusterInfo.Cluster.Operators {
 				matched := false
+				evidence := ""
 
 				// 遍历所有Extractors进行匹配
 				for _, matcher := range fingerprint.Matchers {
@@ -383,12 +414,16 @@ func (s *Scanner) probeHttpService(parsedURL *url.URL, port uint16, matchingClus
 										break
 									}
 								}
+								if matched {
+									evidence = strings.Join(matcher.Words, " && ")
+								}
 							} else if matcher.Condition == "or" || matcher.Condition == "" {
 								matched = false // 默认匹配失败，除非有一个词匹配
 								for _, word := range matcher.Words {
 									// 只要body或header匹配任一个就算成功
 									if strings.Contains(httpResp.Body, word) || headerContains(httpResp.Headers, word) {
 										matched = true
+										evidence = word
 										break
 									}
 								}
@@ -399,6 +434,7 @@ func (s *Scanner) probeHttpService(parsedURL *url.URL, port uint16, matchingClus
 							// 只要body或header匹配任一个就算成功
 							if strings.Contains(httpResp.Body, word) || headerContains(httpResp.Headers, word) {
 								matched = true
+								evidence = word
 							}
 						}
 					}
@@ -417,6 +453,7 @@ func (s *Scanner) probeHttpService(parsedURL *url.URL, port uint16, matchingClus
 							httpResp.Body = strings.TrimRight(httpResp.Body, "\r")
 							if regex.MatchString(httpResp.Body) {
 								matched = true
+								evidence = regexStr
 								break
 							}
 
@@ -480,16 +517,22 @@ func (s *Scanner) probeHttpService(parsedURL *url.URL, port uint16, matchingClus
 						confidence = s.ConfidenceConfig.MinConfidence
 					}
 
+					metadata := fingerprint.Info.Metadata
 					result := matcher.MatchResult{
 						ID:         fingerprint.ID,
 						Name:       fingerprint.Info.Name,
 						Confidence: confidence, // 使用计算的置信度
 						Details:    make(map[string]string),
 						Tags:       []string{fingerprint.Info.Tags},
+						Metadata:   &metadata,
+						Evidence:   evidence,
 					}
 
 					// 添加请求URL路径
 					result.Details["url"] = httpResp.URL
+					for k, v := range certDetails {
+						result.Details[k] = v
+					}
 
 					// 添加状态码
 					result.Details["status_code"] = fmt.Sprintf("%d", httpResp.StatusCode)
@@ -549,6 +592,9 @@ func (s *Scanner) probeHttpService(parsedURL *url.URL, port uint16, matchingClus
 			}
 			result.Details["url"] = httpResp.URL
 			result.Details["status_code"] = fmt.Sprintf("%d", httpResp.StatusCode)
+			for k, v := range certDetails {
+				result.Details[k] = v
+			}
 			// 提取并添加网页标题
 			titleRegex := regexp.MustCompile(`(?i)<title[^>]*>(.*?)</title>`)
 			titleMatches := titleRegex.FindStringSubmatch(httpResp.Body)
@@ -590,12 +636,15 @@ func (s *Scanner) probeHttpService(parsedURL *url.URL, port uint16, matchingClus
 
 				// 如果匹配成功，创建结果并添加到结果列表中
 				if matched {
+					metadata := fingerprint.Info.Metadata
 					result := matcher.MatchResult{
 						ID:         fingerprint.ID,
 						Name:       fingerprint.Info.Name,
 						Confidence: s.ConfidenceConfig.MatcherWeights.Favicon, // Favicon有最高置信度
 						Details:    make(map[string]string),
 						Tags:       []string{fingerprint.Info.Tags},
+						Metadata:   &metadata,
+						Evidence:   "favicon:" + faviconHash,
 					}
 					result.Details["favicon_match"] = "true"
 					result.Details["favicon_hash"] = faviconHash
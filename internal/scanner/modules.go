@@ -0,0 +1,426 @@
+package scanner
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"nebulafinger/internal"
+	"nebulafinger/internal/cluster"
+	"nebulafinger/internal/matcher"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 协议模块结果详情中的通用字段
+const (
+	DetailProduct      = "product"       // 产品名
+	DetailVersion      = "version"       // 版本号
+	DetailAuthRequired = "auth_required" // 是否需要认证: true, false，无法判断时不记录
+)
+
+// moduleReplyLimit 协议模块读取响应（HTTP为响应体）的上限
+const moduleReplyLimit = 64 * 1024
+
+// serviceModule 内置的协议模块：按服务自身的协议握手识别服务，从二进制响应中提取产品、版本和认证要求
+type serviceModule struct {
+	name   string                                              // 模块名，对应指纹的probe字段和端口配置中的服务名
+	ports  []uint16                                            // 服务的常用端口
+	tags   string                                              // 内置结果的标签
+	detect func(banner string) bool                            // 根据服务端主动发送的banner识别协议，可为nil
+	probe  func(s *Scanner, target moduleTarget) *moduleResult // 执行协议握手，不是该服务时返回nil
+	udp    bool                                                // 是否为UDP协议，UDP模块在UDP服务识别中执行
+	ics    bool                                                // 是否为工控协议，只在启用工控协议识别时执行
+	extra  bool                                                // 是否为补充模块，在端口识别完成后执行，结果追加到其他结果之后
+}
+
+// moduleTarget 协议模块探测的目标
+type moduleTarget struct {
+	hostname string
+	port     uint16
+	banner   *tcpBanner // 端口上已读取的banner，隐式TLS端口上模块也使用TLS连接
+}
+
+// address 返回目标的 主机:端口
+func (t moduleTarget) address() string {
+	return net.JoinHostPort(t.hostname, strconv.Itoa(int(t.port)))
+}
+
+// moduleResult 协议模块的识别结果
+type moduleResult struct {
+	product      string            // 产品名
+	version      string            // 版本号
+	authRequired string            // 是否需要认证: true, false, 空表示无法判断
+	details      map[string]string // 其他详细信息
+	response     string            // 服务的响应，声明了probe的指纹用提取器从中提取信息
+}
+
+// serviceModules 已注册的协议模块，按模块名索引
+var serviceModules = map[string]*serviceModule{}
+
+// registerModules 注册协议模块，各协议的模块在自己文件的init中注册
+func registerModules(modules ...*serviceModule) {
+	for _, m := range modules {
+		serviceModules[m.name] = m
+	}
+}
+
+// ModuleNames 返回已注册的协议模块名，按名称排序
+func ModuleNames() []string {
+	names := make([]string, 0, len(serviceModules))
+	for name := range serviceModules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkModuleProbes 检查服务指纹中probe字段引用的协议模块是否存在，返回不存在时的警告
+func checkModuleProbes(fingerprints []internal.Fingerprint) []string {
+	var warnings []string
+	for _, fp := range fingerprints {
+		for _, req := range fp.TCP {
+			name := strings.ToLower(strings.TrimSpace(req.Probe))
+			if name != "" && serviceModules[name] == nil {
+				warnings = append(warnings, fmt.Sprintf("指纹 %s 引用了不存在的协议模块 %s（可选: %s），已忽略", fp.ID, req.Probe, strings.Join(ModuleNames(), ", ")))
+			}
+		}
+	}
+	return warnings
+}
+
+// matchModules 对端口执行协议模块探测，第一个识别成功的模块即为结果，补充模块不参与
+func (s *Scanner) matchModules(host string, port uint16, banner *tcpBanner) ([]matcher.MatchResult, bool) {
+	target := moduleTarget{hostname: hostnameOf(host), port: port, banner: banner}
+	for _, module := range s.modulesForPort(port, banner) {
+		if module.extra {
+			continue
+		}
+		result := module.probe(s, target)
+		if result == nil {
+			continue
+		}
+		return []matcher.MatchResult{s.moduleMatchResult(target, module, result)}, true
+	}
+	return nil, false
+}
+
+// matchExtraModules 对端口执行补充模块，返回全部识别成功的结果
+func (s *Scanner) matchExtraModules(host string, port uint16, banner *tcpBanner) []matcher.MatchResult {
+	target := moduleTarget{hostname: hostnameOf(host), port: port, banner: banner}
+	var results []matcher.MatchResult
+	for _, module := range s.modulesForPort(port, banner) {
+		if !module.extra {
+			continue
+		}
+		if result := module.probe(s, target); result != nil {
+			results = append(results, s.moduleMatchResult(target, module, result))
+		}
+	}
+	return results
+}
+
+// modulesForPort 返回在端口上执行的协议模块：banner可以识别的模块最先执行，
+// 其次是端口配置中该端口对应服务的模块、常用端口包含该端口的模块和指纹通过probe声明了该端口的模块
+func (s *Scanner) modulesForPort(port uint16, banner *tcpBanner) []*serviceModule {
+	var modules []*serviceModule
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if module := serviceModules[name]; module != nil && !seen[name] && !module.udp && s.moduleEnabled(module) {
+			seen[name] = true
+			modules = append(modules, module)
+		}
+	}
+
+	for _, name := range ModuleNames() {
+		if module := serviceModules[name]; module.detect != nil && banner.response != "" && module.detect(banner.response) {
+			add(name)
+		}
+	}
+	for _, service := range s.portServices[port] {
+		add(service)
+	}
+	for _, name := range ModuleNames() {
+		for _, p := range serviceModules[name].ports {
+			if p == port {
+				add(name)
+			}
+		}
+	}
+	for _, c := range s.tcpClusters() {
+		if !portInList(c.Port, int(port)) {
+			continue
+		}
+		for _, fingerprint := range c.Operators {
+			add(fingerprint.Probe)
+		}
+	}
+	return modules
+}
+
+// moduleEnabled 判断模块是否可以执行：工控协议模块需要显式启用
+func (s *Scanner) moduleEnabled(module *serviceModule) bool {
+	return !module.ics || s.Config != nil && s.Config.EnableICS
+}
+
+// moduleDefaultPorts 返回需要加入默认端口探测的模块常用端口，udp选择UDP或TCP模块，按端口号排序
+// 常见服务的端口已在默认端口配置中，目前只有显式启用的工控协议模块会加入自己的常用端口
+func (s *Scanner) moduleDefaultPorts(udp bool) []uint16 {
+	var ports []uint16
+	for _, name := range ModuleNames() {
+		if module := serviceModules[name]; module.ics && module.udp == udp && s.moduleEnabled(module) {
+			ports = appendPorts(ports, module.ports)
+		}
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+	return ports
+}
+
+// appendPorts 将extra中尚未出现的端口追加到ports之后
+func appendPorts(ports, extra []uint16) []uint16 {
+	result := append([]uint16{}, ports...)
+	for _, port := range extra {
+		found := false
+		for _, p := range result {
+			found = found || p == port
+		}
+		if !found {
+			result = append(result, port)
+		}
+	}
+	return result
+}
+
+// tcpClusters 返回全部TCP指纹聚类
+func (s *Scanner) tcpClusters() []cluster.ClusterExecute {
+	if s.WebCluster == nil {
+		return nil
+	}
+	clusters := append([]cluster.ClusterExecute{}, s.WebCluster.TCPNull...)
+	for _, c := range s.WebCluster.TCPOther {
+		clusters = append(clusters, c)
+	}
+	return clusters
+}
+
+// fields 返回模块识别出的全部字段：详细信息以及product、version、auth_required，指纹匹配器可以用part选择这些字段
+func (r *moduleResult) fields() map[string]string {
+	fields := make(map[string]string, len(r.details)+3)
+	for k, v := range r.details {
+		fields[k] = v
+	}
+	for k, v := range map[string]string{DetailProduct: r.product, DetailVersion: r.version, DetailAuthRequired: r.authRequired} {
+		if v != "" {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+// moduleMatchResult 将模块结果转换为匹配结果：有指纹通过probe声明了该模块且命中时使用该指纹，否则使用模块的内置结果
+func (s *Scanner) moduleMatchResult(target moduleTarget, module *serviceModule, result *moduleResult) matcher.MatchResult {
+	resp := &matcher.TCPResponse{
+		Host:     target.hostname,
+		Port:     strconv.Itoa(int(target.port)),
+		Response: result.response,
+		Parts:    result.fields(),
+	}
+	resp.Parts["module"] = module.name
+	fingerprint, ports, hit := s.moduleFingerprint(target, module, resp)
+
+	metadata := fingerprint.Info.Metadata
+	if metadata.Version == "" {
+		metadata.Version = result.version
+	}
+	match := matcher.MatchResult{
+		ID:         fingerprint.ID,
+		Name:       fingerprint.Info.Name,
+		Confidence: internal.CalculateMatcherConfidence(hit, resp.Response, nil, s.ConfidenceConfig),
+		Details:    make(map[string]string),
+		Tags:       []string{fingerprint.Info.Tags},
+		Metadata:   &metadata,
+		Evidence:   matcherEvidence(hit),
+	}
+
+	// 添加主机和端口信息，并记录服务是否运行在常用端口上
+	match.Details["host"] = target.hostname
+	match.Details["port"] = resp.Port
+	match.Details["port_match"] = s.portMatch(target.port, ports, fingerprint)
+	if module.udp {
+		match.Details["protocol"] = ProtocolUDP
+	}
+	for k, v := range resp.Parts {
+		match.Details[k] = v
+	}
+	for k, v := range target.banner.details() {
+		match.Details[k] = v
+	}
+	for _, extractor := range fingerprint.Extractors {
+		if value := extractValue(extractor, result.response); value != "" {
+			match.Details[extractor.Name] = value
+		}
+	}
+	return match
+}
+
+// moduleFingerprint 查找通过probe声明了该模块并命中的指纹，返回指纹、指纹声明的端口和命中的匹配器：
+// 指纹有匹配器时任一匹配器命中即可，匹配器可以用part匹配模块识别出的字段；只有提取器时至少一个提取器命中；都没有时直接命中
+func (s *Scanner) moduleFingerprint(target moduleTarget, module *serviceModule, resp *matcher.TCPResponse) (cluster.ClusteredFingerprint, string, internal.Matchers) {
+	probeHit := internal.Matchers{Name: "probe:" + module.name, Type: "regex"}
+	for _, c := range s.tcpClusters() {
+		for _, fingerprint := range c.Operators {
+			if fingerprint.Probe != module.name || !fingerprint.TLS.Allows(target.banner.overTLS()) {
+				continue
+			}
+			switch {
+			case len(fingerprint.Matchers) > 0:
+				for _, m := range fingerprint.Matchers {
+					if matcher.MatchTCP(m, resp) {
+						return fingerprint, c.Port, m
+					}
+				}
+			case len(fingerprint.Extractors) > 0:
+				for _, extractor := range fingerprint.Extractors {
+					if extractValue(extractor, resp.Response) != "" {
+						return fingerprint, c.Port, internal.Matchers{Type: extractor.Type, Regex: extractor.Regex}
+					}
+				}
+			default:
+				return fingerprint, c.Port, probeHit
+			}
+		}
+	}
+
+	ports := make([]string, len(module.ports))
+	for i, p := range module.ports {
+		ports[i] = strconv.Itoa(int(p))
+	}
+	builtin := cluster.ClusteredFingerprint{
+		ID: module.name,
+		Info: internal.Info{
+			Name:     resp.Parts[DetailProduct],
+			Author:   "nebulafinger",
+			Tags:     module.tags,
+			Severity: "info",
+			Metadata: internal.Metadata{Product: strings.ToLower(resp.Parts[DetailProduct]), Version: resp.Parts[DetailVersion]},
+		},
+	}
+	return builtin, strings.Join(ports, ","), probeHit
+}
+
+// extractValue 用提取器从响应中提取信息：word类型命中时返回关键字，regex类型返回第一个分组（没有分组时返回整个匹配）
+func extractValue(extractor internal.Extractors, response string) string {
+	if len(extractor.Regex) == 0 {
+		return ""
+	}
+	switch extractor.Type {
+	case "word":
+		if strings.Contains(response, extractor.Regex[0]) {
+			return extractor.Regex[0]
+		}
+	case "regex":
+		regex, err := regexp.Compile(extractor.Regex[0])
+		if err != nil {
+			return ""
+		}
+		if matches := regex.FindStringSubmatch(response); len(matches) > 1 {
+			return matches[1]
+		} else if len(matches) == 1 {
+			return matches[0]
+		}
+	}
+	return ""
+}
+
+// moduleDial 建立模块使用的连接：端口已确认是隐式TLS时完成TLS握手，读写超时按主机的RTT估计推导
+func (s *Scanner) moduleDial(target moduleTarget) (net.Conn, error) {
+	conn, err := s.dial("tcp", target.address())
+	if err != nil {
+		return nil, err
+	}
+	if target.banner.tls == TLSImplicit {
+		tlsConn, _, err := s.handshake(conn, target.hostname)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	conn.SetDeadline(time.Now().Add(s.readTimeout()))
+	return conn, nil
+}
+
+// moduleExchange 建立新连接发送请求并读取响应，直到complete返回true
+func (s *Scanner) moduleExchange(target moduleTarget, request []byte, complete func([]byte) bool) ([]byte, error) {
+	conn, err := s.moduleDial(target)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	reply, err := exchangeLimit(conn, string(request), complete, moduleReplyLimit)
+	return []byte(reply), err
+}
+
+// moduleUDPExchange 发送UDP请求并返回响应，没有响应时按retransmits重发；端口不可达时返回ECONNREFUSED
+func (s *Scanner) moduleUDPExchange(target moduleTarget, request []byte, retransmits int) ([]byte, error) {
+	probe := udpProbe{request: internal.UDPRequest{Retransmits: retransmits}, payload: request}
+	return s.sendUDPProbe(target.hostname, target.port, probe)
+}
+
+// moduleGreeting 返回服务端主动发送的数据：优先使用已读取的banner，没有时重新连接读取
+func (s *Scanner) moduleGreeting(target moduleTarget) []byte {
+	if target.banner.response != "" {
+		return []byte(target.banner.response)
+	}
+	conn, err := s.moduleDial(target)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	return []byte(s.readBanner(conn))
+}
+
+// moduleHTTPGet 发送HTTP GET请求并返回响应和响应体（最多moduleReplyLimit字节）
+func (s *Scanner) moduleHTTPGet(target moduleTarget, path string) (*http.Response, []byte, error) {
+	return s.moduleHTTPRequest(target, "GET", path, "")
+}
+
+// moduleHTTPRequest 发送HTTP请求并返回响应和响应体（最多moduleReplyLimit字节），body不为空时按JSON发送，headers为附加的请求头行
+func (s *Scanner) moduleHTTPRequest(target moduleTarget, method, path, body string, headers ...string) (*http.Response, []byte, error) {
+	conn, err := s.moduleDial(target)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+
+	request := fmt.Sprintf("%s %s HTTP/1.1\r\nHost: %s:%d\r\nUser-Agent: Mozilla/5.0\r\nAccept: */*\r\nConnection: close\r\n", method, path, hostHeader(target.hostname), target.port)
+	if body != "" {
+		request += fmt.Sprintf("Content-Type: application/json\r\nContent-Length: %d\r\n", len(body))
+	}
+	for _, header := range headers {
+		request += header + "\r\n"
+	}
+	request += "\r\n" + body
+	if _, err := conn.Write([]byte(request)); err != nil {
+		return nil, nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, moduleReplyLimit))
+	return resp, data, nil
+}
+
+// lengthComplete 返回按消息头中的长度判断消息是否读取完整的函数，length从已读取的数据中解析总长度，数据不足时返回-1
+func lengthComplete(length func([]byte) int) func([]byte) bool {
+	return func(b []byte) bool {
+		n := length(b)
+		return n >= 0 && len(b) >= n
+	}
+}
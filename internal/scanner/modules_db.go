@@ -0,0 +1,764 @@
+package scanner
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerModules(
+		&serviceModule{name: "mysql", ports: []uint16{3306}, tags: "detect,database,mysql", detect: isMySQLGreeting, probe: probeMySQL},
+		&serviceModule{name: "postgresql", ports: []uint16{5432}, tags: "detect,database,postgresql", probe: probePostgreSQL},
+		&serviceModule{name: "mssql", ports: []uint16{1433}, tags: "detect,database,mssql", probe: probeMSSQL},
+		&serviceModule{name: "oracle", ports: []uint16{1521}, tags: "detect,database,oracle", probe: probeOracleTNS},
+		&serviceModule{name: "mongodb", ports: []uint16{27017, 27018}, tags: "detect,database,mongodb", probe: probeMongoDB},
+		&serviceModule{name: "redis", ports: []uint16{6379}, tags: "detect,database,redis", probe: probeRedis},
+		&serviceModule{name: "elasticsearch", ports: []uint16{9200}, tags: "detect,database,elasticsearch", probe: probeElasticsearch},
+		&serviceModule{name: "clickhouse", ports: []uint16{8123}, tags: "detect,database,clickhouse", probe: probeClickHouse},
+	)
+}
+
+// ---------------- MySQL / MariaDB ----------------
+
+// MySQL握手包中的能力标志
+const mysqlClientSSL = 0x00000800
+
+// isMySQLGreeting 判断banner是否为MySQL初始握手包或错误包
+func isMySQLGreeting(banner string) bool {
+	data := []byte(banner)
+	if len(data) < 5 || data[3] != 0 {
+		return false
+	}
+	length := int(data[0]) | int(data[1])<<8 | int(data[2])<<16
+	if length < 1 || length > 1024 {
+		return false
+	}
+	return data[4] == 0x0a || data[4] == 0xff
+}
+
+// probeMySQL 解析服务端主动发送的初始握手包：协议版本10、版本字符串、能力标志和认证插件
+func probeMySQL(s *Scanner, target moduleTarget) *moduleResult {
+	greeting := s.moduleGreeting(target)
+	if !isMySQLGreeting(string(greeting)) {
+		return nil
+	}
+	length := int(greeting[0]) | int(greeting[1])<<8 | int(greeting[2])<<16
+	payload := greeting[4:]
+	if len(payload) > length {
+		payload = payload[:length]
+	}
+
+	result := &moduleResult{product: "MySQL", details: map[string]string{}, response: latin1(greeting)}
+
+	// 错误包：主机不允许连接、连接数过多等
+	if payload[0] == 0xff {
+		if len(payload) < 3 {
+			return nil
+		}
+		message := payload[3:]
+		if len(message) > 6 && message[0] == '#' {
+			message = message[6:]
+		}
+		result.details["error_code"] = strconv.Itoa(int(binary.LittleEndian.Uint16(payload[1:3])))
+		result.details["error"] = string(message)
+		if strings.Contains(string(message), "MariaDB") {
+			result.product = "MariaDB"
+		}
+		return result
+	}
+
+	// 协议版本10: 版本字符串以NUL结尾
+	end := bytes.IndexByte(payload[1:], 0)
+	if end < 0 {
+		return nil
+	}
+	version := string(payload[1 : 1+end])
+	rest := payload[1+end+1:]
+	result.details["protocol_version"] = "10"
+	result.details["server_version"] = version
+
+	// MariaDB为兼容旧客户端在版本前加上5.5.5-
+	result.version = version
+	if strings.Contains(version, "MariaDB") {
+		result.product = "MariaDB"
+		result.version = strings.TrimPrefix(version, "5.5.5-")
+	}
+	if i := strings.IndexAny(result.version, "-+ "); i > 0 {
+		result.version = result.version[:i]
+	}
+
+	// 连接ID(4) 认证数据前8字节(8) 填充(1) 能力标志低16位(2)
+	if len(rest) < 15 {
+		return result
+	}
+	capabilities := uint32(binary.LittleEndian.Uint16(rest[13:15]))
+	rest = rest[15:]
+	// 字符集(1) 状态(2) 能力标志高16位(2) 认证数据长度(1) 保留(10) 认证数据后半部分 认证插件名
+	if len(rest) >= 16 {
+		capabilities |= uint32(binary.LittleEndian.Uint16(rest[3:5])) << 16
+		authLength := int(rest[5])
+		rest = rest[16:]
+		skip := authLength - 8
+		if skip < 13 {
+			skip = 13
+		}
+		if len(rest) > skip {
+			plugin := rest[skip:]
+			if i := bytes.IndexByte(plugin, 0); i >= 0 {
+				plugin = plugin[:i]
+			}
+			if len(plugin) > 0 {
+				result.details["auth_plugin"] = string(plugin)
+			}
+		}
+	}
+	result.details["capabilities"] = fmt.Sprintf("0x%08x", capabilities)
+	result.details["ssl"] = strconv.FormatBool(capabilities&mysqlClientSSL != 0)
+	return result
+}
+
+// ---------------- PostgreSQL ----------------
+
+// postgresAuthMethods AuthenticationRequest中的认证方式
+var postgresAuthMethods = map[uint32]string{
+	0:  "trust",
+	2:  "kerberos",
+	3:  "password",
+	5:  "md5",
+	7:  "gss",
+	9:  "sspi",
+	10: "sasl",
+}
+
+// probePostgreSQL 先发送SSLRequest判断是否支持SSL，再用新连接发送StartupMessage，
+// 根据认证请求判断认证方式，根据错误响应的字段（错误码、消息、源文件、函数）记录拒绝原因；无需认证时从ParameterStatus中读取版本
+func probePostgreSQL(s *Scanner, target moduleTarget) *moduleResult {
+	result := &moduleResult{product: "PostgreSQL", details: map[string]string{}}
+
+	reply, err := s.moduleExchange(target, []byte("\x00\x00\x00\x08\x04\xd2\x16\x2f"), func(b []byte) bool { return len(b) > 0 })
+	switch {
+	case err == nil && string(reply) == "S":
+		result.details["ssl"] = "true"
+	case err == nil && string(reply) == "N":
+		result.details["ssl"] = "false"
+	case target.banner.protocol == "postgresql":
+		result.details["ssl"] = "true"
+	}
+
+	// StartupMessage: 协议版本3.0，用户和数据库为不存在的名称
+	var params bytes.Buffer
+	for _, kv := range []string{"user", "nebulafinger", "database", "nebulafinger", "application_name", "nebulafinger", ""} {
+		params.WriteString(kv)
+		params.WriteByte(0)
+	}
+	startup := make([]byte, 8, 8+params.Len())
+	binary.BigEndian.PutUint32(startup[0:4], uint32(8+params.Len()))
+	binary.BigEndian.PutUint32(startup[4:8], 0x00030000)
+	startup = append(startup, params.Bytes()...)
+
+	conn, err := s.moduleDial(target)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	response, _ := exchange(conn, string(startup), postgresStartupComplete)
+	result.response = latin1([]byte(response))
+
+	identified := false
+	for _, msg := range postgresMessages([]byte(response)) {
+		switch msg.kind {
+		case 'R':
+			if len(msg.body) < 4 {
+				continue
+			}
+			code := binary.BigEndian.Uint32(msg.body[:4])
+			method, ok := postgresAuthMethods[code]
+			if !ok {
+				continue
+			}
+			identified = true
+			if code == 0 {
+				result.authRequired = "false"
+			} else {
+				result.authRequired = "true"
+				result.details["auth_method"] = method
+			}
+			if code == 10 {
+				result.details["sasl_mechanisms"] = strings.Trim(strings.ReplaceAll(string(msg.body[4:]), "\x00", ","), ",")
+			}
+		case 'S':
+			fields := bytes.Split(msg.body, []byte{0})
+			if len(fields) >= 2 && string(fields[0]) == "server_version" {
+				result.version = strings.Fields(string(fields[1]) + " ")[0]
+				result.details["server_version"] = string(fields[1])
+			}
+		case 'E':
+			fields := postgresErrorFields(msg.body)
+			if fields['C'] == "" || fields['M'] == "" {
+				continue
+			}
+			identified = true
+			for code, key := range map[byte]string{'S': "error_severity", 'C': "error_code", 'M': "error", 'F': "error_file", 'L': "error_line", 'R': "error_routine"} {
+				if fields[code] != "" {
+					result.details[key] = fields[code]
+				}
+			}
+			switch fields['C'] {
+			case "28000", "28P01":
+				// pg_hba拒绝或认证失败
+				result.authRequired = "true"
+			case "3D000":
+				// 数据库不存在的错误发生在认证通过之后
+				result.authRequired = "false"
+			}
+		}
+	}
+	if !identified {
+		return nil
+	}
+	conn.Write([]byte("X\x00\x00\x00\x04"))
+	return result
+}
+
+// postgresMessage PostgreSQL后端消息
+type postgresMessage struct {
+	kind byte
+	body []byte
+}
+
+// postgresMessages 解析连续的后端消息，不完整的消息被忽略
+func postgresMessages(data []byte) []postgresMessage {
+	var messages []postgresMessage
+	for len(data) >= 5 {
+		length := int(binary.BigEndian.Uint32(data[1:5]))
+		if length < 4 || len(data) < 1+length {
+			break
+		}
+		messages = append(messages, postgresMessage{kind: data[0], body: data[5 : 1+length]})
+		data = data[1+length:]
+	}
+	return messages
+}
+
+// postgresStartupComplete 收到错误、需要认证或ReadyForQuery时启动阶段的响应完整
+func postgresStartupComplete(data []byte) bool {
+	for _, msg := range postgresMessages(data) {
+		switch msg.kind {
+		case 'E', 'Z':
+			return true
+		case 'R':
+			if len(msg.body) >= 4 && binary.BigEndian.Uint32(msg.body[:4]) != 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// postgresErrorFields 解析ErrorResponse中的字段，字段以类型字节开头、以NUL结尾
+func postgresErrorFields(body []byte) map[byte]string {
+	fields := make(map[byte]string)
+	for _, field := range bytes.Split(body, []byte{0}) {
+		if len(field) > 1 {
+			fields[field[0]] = string(field[1:])
+		}
+	}
+	return fields
+}
+
+// ---------------- MSSQL ----------------
+
+// mssqlReleases 主版本号对应的SQL Server发行版
+var mssqlReleases = map[int]string{
+	8:  "2000",
+	9:  "2005",
+	10: "2008",
+	11: "2012",
+	12: "2014",
+	13: "2016",
+	14: "2017",
+	15: "2019",
+	16: "2022",
+	17: "2025",
+}
+
+// mssqlEncryption PRELOGIN中ENCRYPTION选项的取值
+var mssqlEncryption = map[byte]string{
+	0: "off",
+	1: "on",
+	2: "not_supported",
+	3: "required",
+}
+
+// mssqlPrelogin TDS PRELOGIN请求：VERSION、ENCRYPTION、INSTOPT、THREADID、MARS选项
+var mssqlPrelogin = []byte{
+	0x12, 0x01, 0x00, 0x2f, 0x00, 0x00, 0x01, 0x00, // TDS包头：类型PRELOGIN，长度47
+	0x00, 0x00, 0x1a, 0x00, 0x06, // VERSION 偏移26 长度6
+	0x01, 0x00, 0x20, 0x00, 0x01, // ENCRYPTION 偏移32 长度1
+	0x02, 0x00, 0x21, 0x00, 0x01, // INSTOPT 偏移33 长度1
+	0x03, 0x00, 0x22, 0x00, 0x04, // THREADID 偏移34 长度4
+	0x04, 0x00, 0x26, 0x00, 0x01, // MARS 偏移38 长度1
+	0xff,                               // 结束
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // VERSION
+	0x00,                   // ENCRYPTION: off
+	0x00,                   // INSTOPT
+	0x00, 0x00, 0x00, 0x00, // THREADID
+	0x00, // MARS
+}
+
+// probeMSSQL 发送TDS PRELOGIN，从响应的VERSION选项中读取版本，记录加密要求
+func probeMSSQL(s *Scanner, target moduleTarget) *moduleResult {
+	reply, _ := s.moduleExchange(target, mssqlPrelogin, lengthComplete(func(b []byte) int {
+		if len(b) < 4 {
+			return -1
+		}
+		return int(binary.BigEndian.Uint16(b[2:4]))
+	}))
+	if len(reply) < 8+6 || reply[0] != 0x04 {
+		return nil
+	}
+	payload := reply[8:]
+
+	result := &moduleResult{product: "Microsoft SQL Server", details: map[string]string{}, response: latin1(reply)}
+	identified := false
+	for i := 0; i+5 <= len(payload) && payload[i] != 0xff; i += 5 {
+		token := payload[i]
+		offset := int(binary.BigEndian.Uint16(payload[i+1 : i+3]))
+		length := int(binary.BigEndian.Uint16(payload[i+3 : i+5]))
+		if offset+length > len(payload) {
+			return nil
+		}
+		data := payload[offset : offset+length]
+		switch {
+		case token == 0x00 && length >= 6:
+			major, minor, build := int(data[0]), int(data[1]), int(binary.BigEndian.Uint16(data[2:4]))
+			result.version = fmt.Sprintf("%d.%d.%d", major, minor, build)
+			if release, ok := mssqlReleases[major]; ok {
+				result.details["release"] = release
+			}
+			identified = true
+		case token == 0x01 && length >= 1:
+			if encryption, ok := mssqlEncryption[data[0]]; ok {
+				result.details["encryption"] = encryption
+			}
+		}
+	}
+	if !identified {
+		return nil
+	}
+	return result
+}
+
+// ---------------- Oracle TNS ----------------
+
+// oracleTNSVersion TNS CONNECT包，连接数据为(CONNECT_DATA=(COMMAND=version))
+var oracleTNSVersion = []byte("\x00\x5a\x00\x00\x01\x00\x00\x00" + // TNS包头：长度90，类型CONNECT
+	"\x01\x36\x01\x2c\x00\x00\x08\x00\x7f\xff\x7f\x08\x00\x00\x00\x01" + // 版本、兼容版本、服务选项、SDU、TDU、协议特性、字节序
+	"\x00\x20\x00\x3a\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00" + // 连接数据长度32、偏移58、最大接收数据、连接标志
+	"\x00\x00\x00\x00\x00\x00\x34\xe6\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00" + // 跟踪信息
+	"(CONNECT_DATA=(COMMAND=version))")
+
+// TNS包类型
+const (
+	tnsAccept   = 2
+	tnsRefuse   = 4
+	tnsRedirect = 5
+	tnsData     = 6
+	tnsResend   = 11
+)
+
+var (
+	oracleVSNNum  = regexp.MustCompile(`VSNNUM=(\d+)`)
+	oracleVersion = regexp.MustCompile(`(?i)Version ([\d.]+)`)
+	oracleErr     = regexp.MustCompile(`ERR=(\d+)`)
+)
+
+// probeOracleTNS 向监听器发送version命令，从响应的VSNNUM或版本文本中读取版本
+func probeOracleTNS(s *Scanner, target moduleTarget) *moduleResult {
+	conn, err := s.moduleDial(target)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+
+	complete := lengthComplete(func(b []byte) int {
+		if len(b) < 8 {
+			return -1
+		}
+		return int(binary.BigEndian.Uint16(b[0:2]))
+	})
+	response, _ := exchange(conn, string(oracleTNSVersion), complete)
+	// 监听器要求重发时在同一连接上重发一次
+	if len(response) >= 8 && response[4] == tnsResend {
+		response, _ = exchange(conn, string(oracleTNSVersion), complete)
+	}
+	if len(response) < 8 || response[2] != 0 || response[3] != 0 {
+		return nil
+	}
+	switch response[4] {
+	case tnsAccept, tnsRefuse, tnsRedirect, tnsData:
+	default:
+		return nil
+	}
+	// 后续的DATA包中可能带有版本文本
+	if response[4] == tnsAccept {
+		if more := s.readBanner(conn); more != "" {
+			response += more
+		}
+	}
+
+	result := &moduleResult{product: "Oracle TNS Listener", details: map[string]string{}, response: response}
+	if m := oracleVSNNum.FindStringSubmatch(response); m != nil {
+		if vsn, err := strconv.ParseUint(m[1], 10, 32); err == nil {
+			result.version = fmt.Sprintf("%d.%d.%d.%d.%d", vsn>>24, vsn>>20&0xf, vsn>>12&0xff, vsn>>8&0xf, vsn&0xff)
+			result.details["vsnnum"] = m[1]
+		}
+	}
+	if m := oracleVersion.FindStringSubmatch(response); m != nil {
+		result.version = m[1]
+	}
+	if m := oracleErr.FindStringSubmatch(response); m != nil {
+		result.details["error_code"] = m[1]
+		// TNS-01189: 监听器无法认证用户，需要监听器口令或本地OS认证
+		if m[1] == "1189" || m[1] == "1169" {
+			result.authRequired = "true"
+		}
+	}
+	return result
+}
+
+// ---------------- MongoDB ----------------
+
+// probeMongoDB 用OP_MSG依次发送hello、buildInfo和listDatabases：
+// hello确认服务和节点角色，buildInfo读取版本，listDatabases判断是否需要认证
+func probeMongoDB(s *Scanner, target moduleTarget) *moduleResult {
+	conn, err := s.moduleDial(target)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+
+	command := func(id int32, name string) map[string]interface{} {
+		request := mongoOpMsg(id, bsonDocument(name, int32(1), "$db", "admin"))
+		response, _ := exchangeLimit(conn, string(request), lengthComplete(func(b []byte) int {
+			if len(b) < 4 {
+				return -1
+			}
+			return int(binary.LittleEndian.Uint32(b[0:4]))
+		}), moduleReplyLimit)
+		return parseMongoOpMsg([]byte(response))
+	}
+
+	hello := command(1, "hello")
+	if hello == nil || (hello["maxWireVersion"] == nil && hello["errmsg"] == nil) {
+		return nil
+	}
+
+	result := &moduleResult{product: "MongoDB", details: map[string]string{}}
+	if v, ok := hello["maxWireVersion"]; ok {
+		result.details["max_wire_version"] = fmt.Sprint(v)
+	}
+	switch {
+	case hello["msg"] == "isdbgrid":
+		result.details["role"] = "mongos"
+	case hello["isWritablePrimary"] == true || hello["ismaster"] == true:
+		result.details["role"] = "primary"
+	case hello["secondary"] == true:
+		result.details["role"] = "secondary"
+	}
+	if name, ok := hello["setName"].(string); ok {
+		result.details["replica_set"] = name
+	}
+
+	if build := command(2, "buildInfo"); build != nil {
+		if version, ok := build["version"].(string); ok {
+			result.version = version
+		}
+	}
+	if list := command(3, "listDatabases"); list != nil {
+		switch {
+		case list["ok"] == float64(1):
+			result.authRequired = "false"
+		case list["code"] == int32(13) || list["codeName"] == "Unauthorized":
+			result.authRequired = "true"
+		}
+	}
+
+	response, _ := json.Marshal(hello)
+	result.response = string(response)
+	return result
+}
+
+// mongoOpMsg 构造OP_MSG请求：消息头、flagBits和一个类型0的section
+func mongoOpMsg(requestID int32, document []byte) []byte {
+	message := make([]byte, 16+4+1, 16+4+1+len(document))
+	binary.LittleEndian.PutUint32(message[0:4], uint32(16+4+1+len(document)))
+	binary.LittleEndian.PutUint32(message[4:8], uint32(requestID))
+	binary.LittleEndian.PutUint32(message[12:16], 2013) // OP_MSG
+	return append(message, document...)
+}
+
+// parseMongoOpMsg 解析OP_MSG响应中类型0 section的文档，返回顶层字段
+func parseMongoOpMsg(data []byte) map[string]interface{} {
+	if len(data) < 21 || binary.LittleEndian.Uint32(data[12:16]) != 2013 || data[20] != 0 {
+		return nil
+	}
+	return parseBSON(data[21:])
+}
+
+// bsonDocument 按键值对构造BSON文档，值支持int32、float64、bool和string
+func bsonDocument(pairs ...interface{}) []byte {
+	var body bytes.Buffer
+	for i := 0; i+1 < len(pairs); i += 2 {
+		key := pairs[i].(string)
+		var kind byte
+		var value []byte
+		switch v := pairs[i+1].(type) {
+		case int32:
+			kind, value = 0x10, binary.LittleEndian.AppendUint32(nil, uint32(v))
+		case float64:
+			kind, value = 0x01, binary.LittleEndian.AppendUint64(nil, math.Float64bits(v))
+		case bool:
+			kind, value = 0x08, []byte{0}
+			if v {
+				value[0] = 1
+			}
+		case string:
+			kind = 0x02
+			value = binary.LittleEndian.AppendUint32(nil, uint32(len(v)+1))
+			value = append(append(value, v...), 0)
+		}
+		body.WriteByte(kind)
+		body.WriteString(key)
+		body.WriteByte(0)
+		body.Write(value)
+	}
+	document := binary.LittleEndian.AppendUint32(nil, uint32(4+body.Len()+1))
+	return append(append(document, body.Bytes()...), 0)
+}
+
+// parseBSON 解析BSON文档的顶层字段：double、string、bool、int32、int64转换为对应的Go类型，其他类型跳过
+func parseBSON(data []byte) map[string]interface{} {
+	if len(data) < 5 {
+		return nil
+	}
+	size := int(binary.LittleEndian.Uint32(data[0:4]))
+	if size < 5 || size > len(data) {
+		return nil
+	}
+	fields := make(map[string]interface{})
+	data = data[4 : size-1]
+	for len(data) > 0 {
+		kind := data[0]
+		end := bytes.IndexByte(data[1:], 0)
+		if end < 0 {
+			return fields
+		}
+		key := string(data[1 : 1+end])
+		data = data[1+end+1:]
+
+		var n int
+		switch kind {
+		case 0x01: // double
+			if len(data) < 8 {
+				return fields
+			}
+			fields[key] = math.Float64frombits(binary.LittleEndian.Uint64(data[:8]))
+			n = 8
+		case 0x02, 0x0d, 0x0e: // string, javascript, symbol
+			if len(data) < 4 {
+				return fields
+			}
+			n = 4 + int(binary.LittleEndian.Uint32(data[:4]))
+			if n > len(data) || n < 5 {
+				return fields
+			}
+			if kind == 0x02 {
+				fields[key] = string(data[4 : n-1])
+			}
+		case 0x03, 0x04: // 文档、数组
+			if len(data) < 4 {
+				return fields
+			}
+			n = int(binary.LittleEndian.Uint32(data[:4]))
+		case 0x05: // binary
+			if len(data) < 4 {
+				return fields
+			}
+			n = 4 + 1 + int(binary.LittleEndian.Uint32(data[:4]))
+		case 0x07: // ObjectId
+			n = 12
+		case 0x08: // bool
+			if len(data) < 1 {
+				return fields
+			}
+			fields[key] = data[0] == 1
+			n = 1
+		case 0x09, 0x11, 0x12: // datetime, timestamp, int64
+			if len(data) < 8 {
+				return fields
+			}
+			if kind == 0x12 {
+				fields[key] = int64(binary.LittleEndian.Uint64(data[:8]))
+			}
+			n = 8
+		case 0x0a, 0x06, 0xff, 0x7f: // null, undefined, minKey, maxKey
+			n = 0
+		case 0x10: // int32
+			if len(data) < 4 {
+				return fields
+			}
+			fields[key] = int32(binary.LittleEndian.Uint32(data[:4]))
+			n = 4
+		case 0x13: // decimal128
+			n = 16
+		default:
+			return fields
+		}
+		if n > len(data) {
+			return fields
+		}
+		data = data[n:]
+	}
+	return fields
+}
+
+// ---------------- Redis ----------------
+
+// probeRedis 发送INFO server：无需认证时从返回的信息中读取版本和运行模式，
+// 需要认证时返回NOAUTH，保护模式下返回DENIED
+func probeRedis(s *Scanner, target moduleTarget) *moduleResult {
+	reply, _ := s.moduleExchange(target, []byte("INFO server\r\n"), redisReplyComplete)
+	response := string(reply)
+	result := &moduleResult{product: "Redis", details: map[string]string{}, response: response}
+
+	switch {
+	case strings.HasPrefix(response, "-NOAUTH"), strings.HasPrefix(response, "-ERR operation not permitted"):
+		result.authRequired = "true"
+		return result
+	case strings.HasPrefix(response, "-DENIED") && strings.Contains(response, "Redis"):
+		result.details["protected_mode"] = "true"
+		return result
+	case !strings.HasPrefix(response, "$"):
+		return nil
+	}
+
+	info := make(map[string]string)
+	for _, line := range strings.Split(response, "\n") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(line), ":"); ok {
+			info[key] = value
+		}
+	}
+	switch {
+	case info["valkey_version"] != "" || info["server_name"] == "valkey":
+		result.product = "Valkey"
+		result.version = info["valkey_version"]
+		result.details["redis_version"] = info["redis_version"]
+	case info["redis_version"] != "":
+		result.version = info["redis_version"]
+	default:
+		return nil
+	}
+	result.authRequired = "false"
+	for _, key := range []string{"redis_mode", "os", "arch_bits"} {
+		if info[key] != "" {
+			result.details[key] = info[key]
+		}
+	}
+	return result
+}
+
+// redisReplyComplete 错误和状态回复读到行尾即完整，批量回复读到声明的长度
+func redisReplyComplete(data []byte) bool {
+	line := bytes.IndexByte(data, '\n')
+	if line < 0 {
+		return false
+	}
+	if data[0] != '$' {
+		return true
+	}
+	size, err := strconv.Atoi(strings.TrimSpace(string(data[1:line])))
+	if err != nil || size < 0 {
+		return true
+	}
+	return len(data) >= line+1+size+2
+}
+
+// ---------------- Elasticsearch / ClickHouse ----------------
+
+// probeElasticsearch 请求根路径，从JSON中读取版本和集群名；需要认证时返回401，
+// 7.14之后的版本在所有响应中带有X-Elastic-Product头
+func probeElasticsearch(s *Scanner, target moduleTarget) *moduleResult {
+	resp, body, err := s.moduleHTTPGet(target, "/")
+	if err != nil {
+		return nil
+	}
+	result := &moduleResult{product: "Elasticsearch", details: map[string]string{}, response: string(body)}
+
+	if resp.StatusCode == 401 {
+		authenticate := resp.Header.Get("WWW-Authenticate")
+		if resp.Header.Get("X-Elastic-Product") == "" && !strings.Contains(authenticate, `realm="security"`) && !strings.Contains(authenticate, "OpenSearch") {
+			return nil
+		}
+		result.authRequired = "true"
+		return result
+	}
+
+	var root struct {
+		Name        string `json:"name"`
+		ClusterName string `json:"cluster_name"`
+		Tagline     string `json:"tagline"`
+		Version     struct {
+			Number        string `json:"number"`
+			Distribution  string `json:"distribution"`
+			BuildFlavor   string `json:"build_flavor"`
+			LuceneVersion string `json:"lucene_version"`
+		} `json:"version"`
+	}
+	if json.Unmarshal(body, &root) != nil || root.Version.Number == "" || root.Tagline == "" {
+		return nil
+	}
+	if root.Version.Distribution == "opensearch" {
+		result.product = "OpenSearch"
+	}
+	result.version = root.Version.Number
+	result.authRequired = "false"
+	for key, value := range map[string]string{"cluster_name": root.ClusterName, "node_name": root.Name, "build_flavor": root.Version.BuildFlavor, "lucene_version": root.Version.LuceneVersion} {
+		if value != "" {
+			result.details[key] = value
+		}
+	}
+	return result
+}
+
+// probeClickHouse 请求根路径确认HTTP接口（返回"Ok."），再执行SELECT version()读取版本；
+// 默认用户需要密码时返回516错误码
+func probeClickHouse(s *Scanner, target moduleTarget) *moduleResult {
+	resp, body, err := s.moduleHTTPGet(target, "/")
+	if err != nil || (strings.TrimSpace(string(body)) != "Ok." && resp.Header.Get("X-ClickHouse-Summary") == "") {
+		return nil
+	}
+	result := &moduleResult{product: "ClickHouse", details: map[string]string{}, response: string(body)}
+	if name := resp.Header.Get("X-ClickHouse-Server-Display-Name"); name != "" {
+		result.details["display_name"] = name
+	}
+
+	resp, body, err = s.moduleHTTPGet(target, "/?query=SELECT%20version()")
+	if err != nil {
+		return result
+	}
+	switch {
+	case resp.StatusCode == 200:
+		result.version = strings.TrimSpace(string(body))
+		result.authRequired = "false"
+	case resp.Header.Get("X-ClickHouse-Exception-Code") == "516" || resp.Header.Get("X-ClickHouse-Exception-Code") == "194":
+		result.authRequired = "true"
+	}
+	return result
+}
@@ -0,0 +1,146 @@
+package scanner
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// MySQL 8.0.36的初始握手包，能力标志带CLIENT_SSL，认证插件caching_sha2_password
+const mysql8Greeting = "4a0000000a382e302e3336000b000000123456789abcdef000ffffff0200ffdf15" +
+	"000000000000000000006162636465666768696a6b6c0063616368696e675f736861325f70617373776f726400"
+
+// MariaDB 10.11.6的初始握手包，版本前带5.5.5-，不支持SSL
+const mariaDBGreeting = "630000000a352e352e352d31302e31312e362d4d6172696144422d302b64656231327531001f000000" +
+	"414243444546474800fef72d0200ff811500000000000000000000696a6b6c6d6e6f7071727374006d7973716c5f6e61746976655f70617373776f726400"
+
+// 客户端地址不在允许列表中时的错误包（1130）
+const mysqlHostBlocked = "42000000ff6a04486f737420273139322e302e322e3927206973206e6f7420616c6c6f77656420746f20636f6e6e65637420746f2074686973204d7953514c20736572766572"
+
+func TestProbeMySQLGreeting(t *testing.T) {
+	tests := []struct {
+		name     string
+		greeting string
+		product  string
+		version  string
+		details  map[string]string
+	}{
+		{"MySQL 8", mysql8Greeting, "MySQL", "8.0.36", map[string]string{
+			"server_version": "8.0.36", "protocol_version": "10", "capabilities": "0xdfffffff", "ssl": "true", "auth_plugin": "caching_sha2_password",
+		}},
+		{"MariaDB", mariaDBGreeting, "MariaDB", "10.11.6", map[string]string{
+			"server_version": "5.5.5-10.11.6-MariaDB-0+deb12u1", "capabilities": "0x81fff7fe", "ssl": "false", "auth_plugin": "mysql_native_password",
+		}},
+		{"主机被拒绝", mysqlHostBlocked, "MySQL", "", map[string]string{
+			"error_code": "1130", "error": "Host '192.0.2.9' is not allowed to connect to this MySQL server",
+		}},
+	}
+	s := newTestScanner(nil, ScannerConfig{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			greeting := string(fixture(tt.greeting))
+			if !isMySQLGreeting(greeting) {
+				t.Fatal("没有识别为MySQL握手包")
+			}
+			target := localTarget(0)
+			target.banner.response = greeting
+			result := probeMySQL(s, target)
+			if result == nil {
+				t.Fatal("没有识别为MySQL")
+			}
+			if result.product != tt.product || result.version != tt.version {
+				t.Errorf("product/version = %s/%s，期望 %s/%s", result.product, result.version, tt.product, tt.version)
+			}
+			for k, v := range tt.details {
+				if result.details[k] != v {
+					t.Errorf("%s = %q，期望 %q", k, result.details[k], v)
+				}
+			}
+		})
+	}
+
+	for _, banner := range []string{"", "SSH-2.0-OpenSSH_9.6\r\n", "\x05\x00\x00\x01\x0a"} {
+		if isMySQLGreeting(banner) {
+			t.Errorf("%q 不应识别为MySQL握手包", banner)
+		}
+	}
+}
+
+// SQL Server 2019（15.0.2000）的PRELOGIN响应，ENCRYPTION为off
+const mssql2019Prelogin = "0401002b0000010000001a00060100200001020021000103002200000400220001ff0f0007d00000000000"
+
+// SQL Server 2022（16.0.1000）的PRELOGIN响应，ENCRYPTION为required
+const mssql2022Prelogin = "0401002b0000010000001a00060100200001020021000103002200000400220001ff100003e80000030000"
+
+func TestProbeMSSQLPrelogin(t *testing.T) {
+	tests := []struct {
+		reply, version, release, encryption string
+	}{
+		{mssql2019Prelogin, "15.0.2000", "2019", "off"},
+		{mssql2022Prelogin, "16.0.1000", "2022", "required"},
+	}
+	for _, tt := range tests {
+		port := tcpStandIn(t, func(conn net.Conn) {
+			request := make([]byte, len(mssqlPrelogin))
+			if _, err := io.ReadFull(conn, request); err != nil {
+				return
+			}
+			conn.Write(fixture(tt.reply))
+		})
+		result := probeMSSQL(newTestScanner(nil, ScannerConfig{}), localTarget(port))
+		if result == nil {
+			t.Fatalf("%s 没有识别为SQL Server", tt.version)
+		}
+		if result.version != tt.version || result.details["release"] != tt.release || result.details["encryption"] != tt.encryption {
+			t.Errorf("结果 = %s %v，期望 %s %s %s", result.version, result.details, tt.version, tt.release, tt.encryption)
+		}
+	}
+}
+
+func TestMSSQLPreloginRequest(t *testing.T) {
+	if length := int(binary.BigEndian.Uint16(mssqlPrelogin[2:4])); length != len(mssqlPrelogin) {
+		t.Errorf("TDS包头长度 = %d，实际 %d", length, len(mssqlPrelogin))
+	}
+}
+
+// TestOracleTNSVersionPacket CONNECT包头中的长度和连接数据的长度、偏移与实际内容一致
+func TestOracleTNSVersionPacket(t *testing.T) {
+	packet := oracleTNSVersion
+	if length := int(binary.BigEndian.Uint16(packet[0:2])); length != len(packet) {
+		t.Errorf("包长度字段 = %d，实际 %d", length, len(packet))
+	}
+	dataLength := int(binary.BigEndian.Uint16(packet[24:26]))
+	offset := int(binary.BigEndian.Uint16(packet[26:28]))
+	if offset+dataLength != len(packet) || string(packet[offset:]) != "(CONNECT_DATA=(COMMAND=version))" {
+		t.Errorf("连接数据偏移 %d 长度 %d，包长度 %d", offset, dataLength, len(packet))
+	}
+}
+
+// Oracle 19c监听器对version命令的REFUSE响应，要求监听器认证（TNS-01189）
+const oracleRefuse = "006500000400000022000059284445534352495054494f4e3d28544d503d292856534e4e554d3d33313837363731303429" +
+	"284552523d3131383929284552524f525f535441434b3d284552524f523d28434f44453d313138392928454d46493d3429292929"
+
+func TestProbeOracleTNS(t *testing.T) {
+	port := tcpStandIn(t, func(conn net.Conn) {
+		request := make([]byte, len(oracleTNSVersion))
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+		conn.Write(fixture(oracleRefuse))
+	})
+	result := probeOracleTNS(newTestScanner(nil, ScannerConfig{}), localTarget(port))
+	if result == nil {
+		t.Fatal("没有识别为Oracle TNS")
+	}
+	if result.version != "19.0.0.0.0" || result.details["vsnnum"] != "318767104" {
+		t.Errorf("version = %s，vsnnum = %s", result.version, result.details["vsnnum"])
+	}
+	if result.details["error_code"] != "1189" || result.authRequired != "true" {
+		t.Errorf("error_code = %s，authRequired = %s", result.details["error_code"], result.authRequired)
+	}
+	if !strings.Contains(result.response, "DESCRIPTION") {
+		t.Errorf("response = %q", result.response)
+	}
+}
@@ -0,0 +1,519 @@
+package scanner
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 工控协议模块都需要通过 -ics 显式启用，只发送协议规定的只读识别请求（读设备标识、读系统状态列表、
+// 读属性、链路状态、测试帧），不建立会话之外的任何状态，也不发送写入和控制命令
+
+func init() {
+	registerModules(
+		&serviceModule{name: "modbus", ports: []uint16{502}, tags: "detect,ics,modbus", probe: probeModbus, ics: true},
+		&serviceModule{name: "s7", ports: []uint16{102}, tags: "detect,ics,s7,siemens", probe: probeS7, ics: true},
+		&serviceModule{name: "enip", ports: []uint16{44818}, tags: "detect,ics,enip", probe: probeENIP, ics: true},
+		&serviceModule{name: "dnp3", ports: []uint16{20000}, tags: "detect,ics,dnp3", probe: probeDNP3, ics: true},
+		&serviceModule{name: "iec104", ports: []uint16{2404}, tags: "detect,ics,iec104", probe: probeIEC104, ics: true},
+		&serviceModule{name: "bacnet", ports: []uint16{47808}, tags: "detect,ics,bacnet", probe: probeBACnet, udp: true, ics: true},
+	)
+}
+
+// 工控协议模块结果详情中的设备字段
+const (
+	DetailVendor   = "vendor"   // 设备厂商
+	DetailModel    = "model"    // 设备型号
+	DetailFirmware = "firmware" // 固件版本
+)
+
+// setDetail 记录去掉首尾空白和NUL后不为空的可打印字段
+func (r *moduleResult) setDetail(key, value string) {
+	value = strings.TrimSpace(strings.Trim(value, "\x00"))
+	if value != "" && isPrintable(value) {
+		r.details[key] = value
+	}
+}
+
+// ---------------- Modbus/TCP ----------------
+
+// modbusObjects 读设备标识（功能码43/14）返回的对象ID对应的字段
+var modbusObjects = map[byte]string{
+	0x00: DetailVendor,
+	0x01: "product_code",
+	0x02: DetailFirmware,
+	0x03: "vendor_url",
+	0x04: "product_name",
+	0x05: DetailModel,
+	0x06: "application_name",
+}
+
+// probeModbus 发送读设备标识请求（功能码43，MEI类型14，基本标识），设备不支持时的异常响应同样可以确认Modbus
+func probeModbus(s *Scanner, target moduleTarget) *moduleResult {
+	const transactionID = 0x4e46
+	request := []byte{0x4e, 0x46, 0x00, 0x00, 0x00, 0x05, 0x00, 0x2b, 0x0e, 0x01, 0x00}
+	reply, _ := s.moduleExchange(target, request, lengthComplete(func(b []byte) int {
+		if len(b) < 6 {
+			return -1
+		}
+		return 6 + int(binary.BigEndian.Uint16(b[4:6]))
+	}))
+	if len(reply) < 9 || binary.BigEndian.Uint16(reply[0:2]) != transactionID || binary.BigEndian.Uint16(reply[2:4]) != 0 {
+		return nil
+	}
+
+	result := &moduleResult{product: "Modbus", details: map[string]string{}, response: latin1(reply)}
+	result.details["unit_id"] = strconv.Itoa(int(reply[6]))
+	pdu := reply[7:]
+	switch {
+	case pdu[0] == 0x2b|0x80:
+		result.details["exception"] = fmt.Sprintf("0x%02x", pdu[1])
+		return result
+	case pdu[0] != 0x2b || len(pdu) < 7 || pdu[1] != 0x0e:
+		return nil
+	}
+
+	// 对象列表：对象ID、长度、值
+	objects := pdu[7:]
+	for i := 0; i < int(pdu[6]) && len(objects) >= 2 && len(objects) >= 2+int(objects[1]); i++ {
+		id, value := objects[0], string(objects[2:2+int(objects[1])])
+		if key, ok := modbusObjects[id]; ok {
+			result.setDetail(key, value)
+		}
+		objects = objects[2+int(objects[1]):]
+	}
+	if result.details[DetailModel] == "" && result.details["product_code"] != "" {
+		result.details[DetailModel] = result.details["product_code"]
+	}
+	result.version = result.details[DetailFirmware]
+	return result
+}
+
+// ---------------- Siemens S7comm ----------------
+
+// s7ConnectRequests COTP连接请求，目标TSAP依次为0x0102（机架0槽位2，S7-300/400）和0x0200（S7-1200/1500等）
+var s7ConnectRequests = [][]byte{
+	[]byte("\x03\x00\x00\x16\x11\xe0\x00\x00\x00\x01\x00\xc0\x01\x0a\xc1\x02\x01\x00\xc2\x02\x01\x02"),
+	[]byte("\x03\x00\x00\x16\x11\xe0\x00\x00\x00\x01\x00\xc0\x01\x0a\xc1\x02\x01\x00\xc2\x02\x02\x00"),
+}
+
+// s7SetupCommunication S7协商通信参数请求
+var s7SetupCommunication = []byte("\x03\x00\x00\x19\x02\xf0\x80\x32\x01\x00\x00\x00\x00\x00\x08\x00\x00\xf0\x00\x00\x01\x00\x01\x01\xe0")
+
+// s7ReadSZL 返回读取系统状态列表（SZL）的用户数据请求
+func s7ReadSZL(id, index uint16) []byte {
+	request := []byte("\x03\x00\x00\x21\x02\xf0\x80\x32\x07\x00\x00\x00\x00\x00\x08\x00\x08\x00\x01\x12\x04\x11\x44\x01\x00\xff\x09\x00\x04")
+	request = binary.BigEndian.AppendUint16(request, id)
+	return binary.BigEndian.AppendUint16(request, index)
+}
+
+// s7ComponentFields SZL 0x001C（组件标识）各索引对应的字段
+var s7ComponentFields = map[uint16]string{
+	0x0001: "system_name",
+	0x0002: "module_name",
+	0x0003: "plant_id",
+	0x0004: "copyright",
+	0x0005: "serial",
+	0x0007: "module_type",
+	0x0008: "memory_card_serial",
+	0x000b: "location",
+}
+
+// tpktComplete 按TPKT头中的长度判断报文是否读取完整
+var tpktComplete = lengthComplete(func(b []byte) int {
+	if len(b) < 4 {
+		return -1
+	}
+	return int(binary.BigEndian.Uint16(b[2:4]))
+})
+
+// probeS7 建立COTP连接并协商S7通信参数，然后读取模块标识（SZL 0x0011）和组件标识（SZL 0x001C）
+func probeS7(s *Scanner, target moduleTarget) *moduleResult {
+	for _, connect := range s7ConnectRequests {
+		conn, err := s.moduleDial(target)
+		if err != nil {
+			return nil
+		}
+		reply, _ := exchangeLimit(conn, string(connect), tpktComplete, moduleReplyLimit)
+		if len(reply) < 6 || reply[0] != 0x03 || reply[5] != 0xd0 {
+			conn.Close()
+			// 不是COTP时不再尝试其他TSAP
+			if len(reply) < 6 || reply[0] != 0x03 {
+				return nil
+			}
+			continue
+		}
+
+		reply, _ = exchangeLimit(conn, string(s7SetupCommunication), tpktComplete, moduleReplyLimit)
+		if len(reply) < 9 || reply[7] != 0x32 || reply[8] != 0x03 {
+			conn.Close()
+			continue
+		}
+
+		result := &moduleResult{product: "Siemens S7", details: map[string]string{DetailVendor: "Siemens"}}
+		var responses []string
+		for _, id := range []uint16{0x0011, 0x001c} {
+			reply, _ := exchangeLimit(conn, string(s7ReadSZL(id, 0)), tpktComplete, moduleReplyLimit)
+			responses = append(responses, reply)
+			for _, record := range s7SZLRecords([]byte(reply)) {
+				parseS7Record(result, id, record)
+			}
+		}
+		conn.Close()
+
+		// 型号优先使用模块类型名（如CPU 315-2 PN/DP），没有时使用订货号
+		if result.details["module_type"] != "" {
+			result.details[DetailModel] = result.details["module_type"]
+		}
+		result.version = result.details[DetailFirmware]
+		result.response = strings.Join(responses, "")
+		return result
+	}
+	return nil
+}
+
+// s7SZLRecords 解析读SZL的响应，返回各数据记录
+// 响应依次为TPKT、COTP、S7头（用户数据为10字节）、参数、数据头（返回码、传输类型、长度）和SZL头（ID、索引、记录长度、记录数）
+func s7SZLRecords(reply []byte) [][]byte {
+	if len(reply) < 17 || reply[7] != 0x32 || reply[8] != 0x07 {
+		return nil
+	}
+	paramLength := int(binary.BigEndian.Uint16(reply[13:15]))
+	data := reply[17:]
+	if len(data) < paramLength+12 {
+		return nil
+	}
+	data = data[paramLength:]
+	if data[0] != 0xff {
+		return nil
+	}
+	szl := data[4:]
+	recordLength := int(binary.BigEndian.Uint16(szl[4:6]))
+	count := int(binary.BigEndian.Uint16(szl[6:8]))
+	szl = szl[8:]
+	if recordLength < 2 {
+		return nil
+	}
+
+	var records [][]byte
+	for i := 0; i < count && len(szl) >= recordLength; i++ {
+		records = append(records, szl[:recordLength])
+		szl = szl[recordLength:]
+	}
+	return records
+}
+
+// parseS7Record 从SZL数据记录中提取字段
+// SZL 0x0011的记录为索引、订货号（20字节）、模块类型、版本（2+2字节），索引1为模块订货号，索引7为固件版本
+func parseS7Record(result *moduleResult, id uint16, record []byte) {
+	index := binary.BigEndian.Uint16(record[0:2])
+	switch id {
+	case 0x0011:
+		if len(record) < 28 {
+			return
+		}
+		switch index {
+		case 0x0001:
+			result.setDetail("order_number", string(record[2:22]))
+			result.setDetail(DetailModel, string(record[2:22]))
+		case 0x0006:
+			result.details["hardware"] = fmt.Sprintf("%d", binary.BigEndian.Uint16(record[26:28]))
+		case 0x0007:
+			result.details[DetailFirmware] = fmt.Sprintf("V%d.%d.%d", record[25], record[26], record[27])
+		}
+	case 0x001c:
+		if key, ok := s7ComponentFields[index]; ok {
+			result.setDetail(key, string(record[2:]))
+		}
+	}
+}
+
+// ---------------- EtherNet/IP ----------------
+
+// enipVendors 常见的CIP厂商ID
+var enipVendors = map[uint16]string{
+	1:  "Rockwell Automation/Allen-Bradley",
+	47: "OMRON Corporation",
+}
+
+// enipDeviceTypes 常见的CIP设备类型
+var enipDeviceTypes = map[uint16]string{
+	0x00: "Generic Device",
+	0x02: "AC Drive",
+	0x07: "General Purpose Discrete I/O",
+	0x0c: "Communications Adapter",
+	0x0e: "Programmable Logic Controller",
+	0x18: "Human-Machine Interface",
+	0x2b: "Generic Device (keyable)",
+}
+
+// probeENIP 发送ListIdentity封装命令，该命令不需要注册会话
+func probeENIP(s *Scanner, target moduleTarget) *moduleResult {
+	request := make([]byte, 24)
+	binary.LittleEndian.PutUint16(request[0:2], 0x0063)
+	copy(request[12:20], "nebulafi")
+	reply, _ := s.moduleExchange(target, request, lengthComplete(func(b []byte) int {
+		if len(b) < 4 {
+			return -1
+		}
+		return 24 + int(binary.LittleEndian.Uint16(b[2:4]))
+	}))
+	if len(reply) < 26 || binary.LittleEndian.Uint16(reply[0:2]) != 0x0063 || !bytes.Equal(reply[12:20], request[12:20]) {
+		return nil
+	}
+
+	result := &moduleResult{product: "EtherNet/IP", details: map[string]string{}, response: latin1(reply)}
+	// 身份项：类型0x0C、长度、协议版本、套接字地址（16字节）、厂商、设备类型、产品代码、版本、状态、序列号、产品名、状态
+	items := reply[24:]
+	if binary.LittleEndian.Uint16(items[0:2]) == 0 || len(items) < 2+4+2+16+15 || binary.LittleEndian.Uint16(items[2:4]) != 0x0c {
+		return result
+	}
+	item := items[6:]
+	vendor := binary.LittleEndian.Uint16(item[18:20])
+	deviceType := binary.LittleEndian.Uint16(item[20:22])
+	result.details["vendor_id"] = strconv.Itoa(int(vendor))
+	if name, ok := enipVendors[vendor]; ok {
+		result.details[DetailVendor] = name
+	}
+	result.details["device_type"] = strconv.Itoa(int(deviceType))
+	if name, ok := enipDeviceTypes[deviceType]; ok {
+		result.details["device_type"] = name
+	}
+	result.details["product_code"] = strconv.Itoa(int(binary.LittleEndian.Uint16(item[22:24])))
+	result.details[DetailFirmware] = fmt.Sprintf("%d.%d", item[24], item[25])
+	result.details["serial"] = fmt.Sprintf("0x%08x", binary.LittleEndian.Uint32(item[28:32]))
+	if n := int(item[32]); len(item) >= 33+n {
+		result.setDetail("product_name", string(item[33:33+n]))
+		result.setDetail(DetailModel, string(item[33:33+n]))
+	}
+	result.version = result.details[DetailFirmware]
+	return result
+}
+
+// ---------------- DNP3 ----------------
+
+// dnp3Master 探测使用的主站地址
+const dnp3Master = 3
+
+// dnp3Outstations 探测的子站地址，请求链路状态时目标地址必须与子站配置一致
+var dnp3Outstations = []uint16{1, 0, 2, 3, 4, 5, 10, 100, 1024}
+
+// dnp3Functions 子站回复的链路层功能码
+var dnp3Functions = map[byte]string{
+	0x00: "ACK",
+	0x01: "NACK",
+	0x0b: "LINK_STATUS",
+	0x0f: "NOT_SUPPORTED",
+}
+
+// dnp3CRC 计算DNP3链路层的CRC（多项式0x3D65，反射，结果取反）
+func dnp3CRC(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xa6bc
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return ^crc
+}
+
+// dnp3LinkStatus 返回请求链路状态的链路层帧（控制字节0xC9：主站方向、启动站、功能码9）
+func dnp3LinkStatus(destination uint16) []byte {
+	frame := []byte{0x05, 0x64, 0x05, 0xc9}
+	frame = binary.LittleEndian.AppendUint16(frame, destination)
+	frame = binary.LittleEndian.AppendUint16(frame, dnp3Master)
+	return binary.LittleEndian.AppendUint16(frame, dnp3CRC(frame))
+}
+
+// probeDNP3 向常见子站地址一次发送链路状态请求，只有地址匹配的子站会回复，回复的源地址即为子站地址
+func probeDNP3(s *Scanner, target moduleTarget) *moduleResult {
+	var request []byte
+	for _, address := range dnp3Outstations {
+		request = append(request, dnp3LinkStatus(address)...)
+	}
+	reply, _ := s.moduleExchange(target, request, func(b []byte) bool { return len(b) >= 10 })
+	if len(reply) < 10 || reply[0] != 0x05 || reply[1] != 0x64 || binary.LittleEndian.Uint16(reply[8:10]) != dnp3CRC(reply[:8]) {
+		return nil
+	}
+
+	result := &moduleResult{product: "DNP3", details: map[string]string{}, response: latin1(reply[:10])}
+	result.details["outstation_address"] = strconv.Itoa(int(binary.LittleEndian.Uint16(reply[6:8])))
+	result.details["master_address"] = strconv.Itoa(int(binary.LittleEndian.Uint16(reply[4:6])))
+	if name, ok := dnp3Functions[reply[3]&0x0f]; ok {
+		result.details["link_function"] = name
+	}
+	return result
+}
+
+// ---------------- IEC 60870-5-104 ----------------
+
+// probeIEC104 发送TESTFR激活帧，服务端回复TESTFR确认帧
+func probeIEC104(s *Scanner, target moduleTarget) *moduleResult {
+	reply, _ := s.moduleExchange(target, []byte{0x68, 0x04, 0x43, 0x00, 0x00, 0x00}, func(b []byte) bool { return len(b) >= 6 })
+	if len(reply) < 6 || reply[0] != 0x68 || reply[1] < 4 {
+		return nil
+	}
+	// 回复可能是尚未确认的其他APCI帧，U帧的控制域低两位为11
+	if reply[2]&0x03 != 0x03 {
+		return nil
+	}
+	result := &moduleResult{product: "IEC 60870-5-104", details: map[string]string{}, response: latin1(reply[:6])}
+	if reply[2] == 0x83 {
+		result.details["testfr"] = "con"
+	}
+	return result
+}
+
+// ---------------- BACnet/IP ----------------
+
+// bacnetProperties 读取的设备对象属性及对应字段，第一个属性（厂商ID）用于确认BACnet
+var bacnetProperties = []struct {
+	id  byte
+	key string
+}{
+	{120, "vendor_id"},
+	{121, DetailVendor},
+	{70, DetailModel},
+	{44, DetailFirmware},
+	{12, "application_software"},
+	{77, "object_name"},
+	{28, "description"},
+	{58, "location"},
+}
+
+// bacnetVendors 常见的BACnet厂商ID
+var bacnetVendors = map[uint64]string{
+	2:  "The Trane Company",
+	5:  "Johnson Controls",
+	7:  "Siemens",
+	8:  "Delta Controls",
+	10: "Schneider Electric",
+	17: "Honeywell",
+	24: "Automated Logic Corporation",
+	36: "Tridium",
+}
+
+// bacnetReadProperty 返回读取设备对象属性的ReadProperty请求，设备实例使用通配实例号4194303
+func bacnetReadProperty(invokeID, property byte) []byte {
+	return []byte{
+		0x81, 0x0a, 0x00, 0x11, // BVLC：原始单播，长度17
+		0x01, 0x04, // NPDU：版本1，期望回复
+		0x00, 0x05, invokeID, 0x0c, // 确认请求，最大APDU 1476，ReadProperty
+		0x0c, 0x02, 0x3f, 0xff, 0xff, // 上下文标签0：设备对象，实例4194303
+		0x19, property, // 上下文标签1：属性ID
+	}
+}
+
+// probeBACnet 读取设备对象的厂商、型号和固件版本等属性，设备不支持某个属性时回复错误，同样可以确认BACnet
+func probeBACnet(s *Scanner, target moduleTarget) *moduleResult {
+	var result *moduleResult
+	for i, property := range bacnetProperties {
+		retransmits := 0
+		if i == 0 {
+			retransmits = 1
+		}
+		reply, _ := s.moduleUDPExchange(target, bacnetReadProperty(byte(i+1), property.id), retransmits)
+		apdu := bacnetAPDU(reply)
+		if apdu == nil {
+			if result == nil {
+				return nil
+			}
+			continue
+		}
+		if result == nil {
+			result = &moduleResult{product: "BACnet", details: map[string]string{}, response: latin1(reply)}
+		}
+		parseBACnetAck(result, property.key, apdu)
+	}
+
+	if id, err := strconv.ParseUint(result.details["vendor_id"], 10, 16); err == nil && result.details[DetailVendor] == "" {
+		if name, ok := bacnetVendors[id]; ok {
+			result.details[DetailVendor] = name
+		}
+	}
+	result.version = result.details[DetailFirmware]
+	return result
+}
+
+// bacnetAPDU 校验BVLC和NPDU，返回APDU；不是BACnet/IP报文或为网络层消息时返回nil
+func bacnetAPDU(reply []byte) []byte {
+	if len(reply) < 6 || reply[0] != 0x81 || int(binary.BigEndian.Uint16(reply[2:4])) != len(reply) {
+		return nil
+	}
+	npdu := reply[4:]
+	if npdu[0] != 0x01 || npdu[1]&0x80 != 0 {
+		return nil
+	}
+	control, offset := npdu[1], 2
+	if control&0x20 != 0 { // 目标网络：网络号、地址长度、地址
+		if len(npdu) < offset+3 {
+			return nil
+		}
+		offset += 3 + int(npdu[offset+2])
+	}
+	if control&0x08 != 0 { // 源网络
+		if len(npdu) < offset+3 {
+			return nil
+		}
+		offset += 3 + int(npdu[offset+2])
+	}
+	if control&0x20 != 0 { // 跳数
+		offset++
+	}
+	if len(npdu) < offset+2 {
+		return nil
+	}
+	return npdu[offset:]
+}
+
+// parseBACnetAck 解析ReadProperty的确认：复杂确认的属性值在开标签3和闭标签3之间；错误、拒绝和中止只说明属性不可读
+func parseBACnetAck(result *moduleResult, key string, apdu []byte) {
+	if len(apdu) < 3 || apdu[0]&0xf0 != 0x30 || apdu[2] != 0x0c {
+		return
+	}
+	body := apdu[3:]
+	// 对象标识：上下文标签0，4字节，低22位为实例号
+	if len(body) < 7 || body[0] != 0x0c {
+		return
+	}
+	result.details["instance"] = strconv.Itoa(int(binary.BigEndian.Uint32(body[1:5]) & 0x3fffff))
+	// 属性ID：上下文标签1；可选的数组索引：上下文标签2
+	offset := 5 + 1 + int(body[5]&0x07)
+	if offset < len(body) && body[offset]&0xf8 == 0x28 {
+		offset += 1 + int(body[offset]&0x07)
+	}
+	if offset+1 >= len(body) || body[offset] != 0x3e {
+		return
+	}
+	value := body[offset+1:]
+
+	tag, length := value[0]>>4, int(value[0]&0x07)
+	value = value[1:]
+	if length == 5 && len(value) > 0 {
+		length = int(value[0])
+		value = value[1:]
+	}
+	if len(value) < length {
+		return
+	}
+	value = value[:length]
+	switch tag {
+	case 2: // 无符号整数
+		var n uint64
+		for _, b := range value {
+			n = n<<8 | uint64(b)
+		}
+		result.details[key] = strconv.FormatUint(n, 10)
+	case 7: // 字符串，第一个字节为编码，只解析UTF-8
+		if len(value) > 1 && value[0] == 0 {
+			result.setDetail(key, string(value[1:]))
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package scanner
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+// Modbus读设备标识的响应：厂商、产品代码和固件版本三个基本对象
+const modbusDeviceID = "4e4600000031012b0e010100000300125363686e656964657220456c656374726963010c424d58205033342032303230020576322e3730"
+
+// 不支持读设备标识时的异常响应（非法功能）
+const modbusException = "4e460000000301ab01"
+
+func TestProbeModbus(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		want  map[string]string
+	}{
+		{"设备标识", modbusDeviceID, map[string]string{
+			"unit_id": "1", DetailVendor: "Schneider Electric", "product_code": "BMX P34 2020", DetailModel: "BMX P34 2020", DetailFirmware: "v2.70",
+		}},
+		{"异常响应", modbusException, map[string]string{"unit_id": "1", "exception": "0x01"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := tcpStandIn(t, func(conn net.Conn) {
+				io.ReadFull(conn, make([]byte, 11))
+				conn.Write(fixture(tt.reply))
+			})
+			result := probeModbus(newTestScanner(nil, ScannerConfig{}), localTarget(port))
+			if result == nil {
+				t.Fatal("没有识别为Modbus")
+			}
+			for k, v := range tt.want {
+				if result.details[k] != v {
+					t.Errorf("%s = %q，期望 %q", k, result.details[k], v)
+				}
+			}
+			if result.version != tt.want[DetailFirmware] {
+				t.Errorf("version = %q", result.version)
+			}
+		})
+	}
+
+	// 事务ID不一致的响应不是对本次请求的回复
+	port := tcpStandIn(t, func(conn net.Conn) {
+		io.ReadFull(conn, make([]byte, 11))
+		reply := fixture(modbusDeviceID)
+		reply[1] = 0x47
+		conn.Write(reply)
+	})
+	if result := probeModbus(newTestScanner(nil, ScannerConfig{}), localTarget(port)); result != nil {
+		t.Errorf("事务ID不一致时不应识别，得到 %v", result.details)
+	}
+}
+
+// S7-300 CPU读SZL 0x0011（模块标识）的响应：订货号、硬件版本和固件版本V3.2.6
+const s7SZLModule = "0300007d02f080320700000000000c0060000112081284010100000000ff09005c00110000001c0003000136455337203331352d32454831342d30414230" +
+	"2000c000040001000636455337203331352d32454831342d304142302000c0000400010007202020202020202020202020202020202020202000c056030206"
+
+// 读SZL 0x001C（组件标识）的响应：站名、模块名、序列号和模块类型
+const s7SZLComponent = "030000b102f080320700000000000c0094000112081284010100000000ff090090001c000000220004000153494d41544943203330302831290000000000" +
+	"000000000000000000000000000002504c435f3100000000000000000000000000000000000000000000000000000000055320432d43325552323839323230" +
+	"3132000000000000000000000000000000000007435055203331352d3220504e2f44500000000000000000000000000000000000"
+
+// tpktStandIn 按TPKT分帧读取请求并交给reply处理，reply返回nil时关闭连接
+func tpktStandIn(t *testing.T, reply func(request []byte) []byte) uint16 {
+	t.Helper()
+	return tcpStandIn(t, func(conn net.Conn) {
+		for {
+			header := make([]byte, 4)
+			if _, err := io.ReadFull(conn, header); err != nil {
+				return
+			}
+			body := make([]byte, int(binary.BigEndian.Uint16(header[2:4]))-4)
+			if _, err := io.ReadFull(conn, body); err != nil {
+				return
+			}
+			response := reply(append(header, body...))
+			if response == nil {
+				return
+			}
+			conn.Write(response)
+		}
+	})
+}
+
+func TestProbeS7(t *testing.T) {
+	// 只接受目标TSAP 0x0200，对0x0102回复断开请求
+	port := tpktStandIn(t, func(request []byte) []byte {
+		switch {
+		case request[5] == 0xe0 && request[20] == 0x02:
+			return fixture("0300001611d00001000100c0010ac1020100c2020200")
+		case request[5] == 0xe0:
+			return fixture("0300000b06800000000100")
+		case request[8] == 0x01:
+			return fixture("0300001b02f080320300000000000800000000f0000001000101e0")
+		case request[8] == 0x07 && binary.BigEndian.Uint16(request[len(request)-4:]) == 0x0011:
+			return fixture(s7SZLModule)
+		case request[8] == 0x07:
+			return fixture(s7SZLComponent)
+		}
+		return nil
+	})
+
+	result := probeS7(newTestScanner(nil, ScannerConfig{}), localTarget(port))
+	if result == nil {
+		t.Fatal("没有识别为S7")
+	}
+	want := map[string]string{
+		DetailVendor:   "Siemens",
+		"order_number": "6ES7 315-2EH14-0AB0",
+		"hardware":     "1",
+		DetailFirmware: "V3.2.6",
+		"system_name":  "SIMATIC 300(1)",
+		"module_name":  "PLC_1",
+		"serial":       "S C-C2UR28922012",
+		"module_type":  "CPU 315-2 PN/DP",
+		DetailModel:    "CPU 315-2 PN/DP",
+	}
+	for k, v := range want {
+		if result.details[k] != v {
+			t.Errorf("%s = %q，期望 %q", k, result.details[k], v)
+		}
+	}
+	if result.version != "V3.2.6" {
+		t.Errorf("version = %q", result.version)
+	}
+}
+
+func TestS7SZLRecords(t *testing.T) {
+	if records := s7SZLRecords(fixture(s7SZLModule)); len(records) != 3 || len(records[0]) != 28 {
+		t.Errorf("SZL 0x0011 记录 = %d", len(records))
+	}
+	if records := s7SZLRecords(fixture(s7SZLComponent)); len(records) != 4 || len(records[0]) != 34 {
+		t.Errorf("SZL 0x001C 记录 = %d", len(records))
+	}
+	// 返回码不是0xff（如对象不存在）时没有记录
+	reply := fixture(s7SZLModule)
+	reply[29] = 0x0a
+	if records := s7SZLRecords(reply); records != nil {
+		t.Errorf("错误响应不应有记录: %d", len(records))
+	}
+	if records := s7SZLRecords(fixture(s7SZLModule)[:40]); records != nil {
+		t.Errorf("截断的响应不应有记录: %d", len(records))
+	}
+}
+
+// ControlLogix 1756-L61对ListIdentity的响应
+const enipListIdentity = "63003c0000000000000000006e6562756c6166690000000001000c00360001000002af12c0000201000000000000000001000e003700140b6000eeffc000" +
+	"14313735362d4c36312f42204c4f4749583535363103"
+
+func TestProbeENIP(t *testing.T) {
+	port := tcpStandIn(t, func(conn net.Conn) {
+		io.ReadFull(conn, make([]byte, 24))
+		conn.Write(fixture(enipListIdentity))
+	})
+	result := probeENIP(newTestScanner(nil, ScannerConfig{}), localTarget(port))
+	if result == nil {
+		t.Fatal("没有识别为EtherNet/IP")
+	}
+	want := map[string]string{
+		"vendor_id":    "1",
+		DetailVendor:   "Rockwell Automation/Allen-Bradley",
+		"device_type":  "Programmable Logic Controller",
+		"product_code": "55",
+		DetailFirmware: "20.11",
+		"serial":       "0x00c0ffee",
+		"product_name": "1756-L61/B LOGIX5561",
+		DetailModel:    "1756-L61/B LOGIX5561",
+	}
+	for k, v := range want {
+		if result.details[k] != v {
+			t.Errorf("%s = %q，期望 %q", k, result.details[k], v)
+		}
+	}
+	if result.version != "20.11" {
+		t.Errorf("version = %q", result.version)
+	}
+}
+
+// BACnet设备对实例123的ReadProperty的确认：厂商ID、型号和固件版本，其他属性回复unknown-property错误
+var bacnetAcks = map[byte]string{
+	120: "810a0014010030010c0c0200007b19783e21053f",
+	70:  "810a001c010030010c0c0200007b19463e7508004e4145353531303f",
+	44:  "810a001b010030010c0c0200007b192c3e75070031302e312e303f",
+}
+
+const bacnetError = "810a000d010050010c91029120"
+
+func TestProbeBACnet(t *testing.T) {
+	port, _ := udpStandIn(t, func(request []byte) []byte {
+		if len(request) != 17 {
+			return nil
+		}
+		if ack, ok := bacnetAcks[request[16]]; ok {
+			return fixture(ack)
+		}
+		return fixture(bacnetError)
+	})
+	result := probeBACnet(newTestScanner(nil, ScannerConfig{}), localTarget(port))
+	if result == nil {
+		t.Fatal("没有识别为BACnet")
+	}
+	want := map[string]string{
+		"vendor_id":    "5",
+		DetailVendor:   "Johnson Controls",
+		DetailModel:    "NAE5510",
+		DetailFirmware: "10.1.0",
+		"instance":     "123",
+	}
+	for k, v := range want {
+		if result.details[k] != v {
+			t.Errorf("%s = %q，期望 %q", k, result.details[k], v)
+		}
+	}
+	if _, ok := result.details["object_name"]; ok {
+		t.Errorf("错误响应的属性不应记录")
+	}
+}
+
+func TestBACnetAPDU(t *testing.T) {
+	if apdu := bacnetAPDU(fixture(bacnetError)); len(apdu) != 7 || apdu[0] != 0x50 {
+		t.Errorf("apdu = %x", apdu)
+	}
+	// 带源网络（网络号2、6字节MAC）的NPDU
+	routed := fixture("810a001d01080002060a000002bac030010c0c0200007b19783e21053f")
+	if apdu := bacnetAPDU(routed); len(apdu) == 0 || apdu[0] != 0x30 {
+		t.Errorf("带源网络的apdu = %x", apdu)
+	}
+	for _, reply := range []string{"", "810a0006", "810b00060100", "810a000c01000000", "810a00060180"} {
+		if apdu := bacnetAPDU(fixture(reply)); apdu != nil {
+			t.Errorf("%s 不应解析出APDU: %x", reply, apdu)
+		}
+	}
+}
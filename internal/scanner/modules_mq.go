@@ -0,0 +1,487 @@
+package scanner
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerModules(
+		&serviceModule{name: "kafka", ports: []uint16{9092}, tags: "detect,mq,kafka", probe: probeKafka},
+		&serviceModule{name: "zookeeper", ports: []uint16{2181}, tags: "detect,middleware,zookeeper", probe: probeZooKeeper},
+		&serviceModule{name: "amqp", ports: []uint16{5672}, tags: "detect,mq,amqp", probe: probeAMQP},
+		&serviceModule{name: "mqtt", ports: []uint16{1883}, tags: "detect,mq,mqtt,iot", probe: probeMQTT},
+		&serviceModule{name: "nats", ports: []uint16{4222}, tags: "detect,mq,nats", detect: isNATSInfo, probe: probeNATS},
+		&serviceModule{name: "openwire", ports: []uint16{61616}, tags: "detect,mq,activemq", detect: isOpenWireInfo, probe: probeOpenWire},
+		&serviceModule{name: "rocketmq", ports: []uint16{9876, 10911}, tags: "detect,mq,rocketmq", probe: probeRocketMQ},
+		&serviceModule{name: "memcached", ports: []uint16{11211}, tags: "detect,database,memcached", probe: probeMemcached},
+		&serviceModule{name: "etcd", ports: []uint16{2379}, tags: "detect,middleware,etcd", probe: probeEtcd},
+	)
+}
+
+// ---------------- Kafka ----------------
+
+// kafkaVersions 支持的最大API key对应的最低Kafka版本，ApiVersions响应不带版本号，只能据此推断下限
+var kafkaVersions = []struct {
+	maxKey  int
+	version string
+}{
+	{18, "0.10.0"},
+	{20, "0.10.1"},
+	{33, "0.11.0"},
+	{37, "1.0"},
+	{41, "1.1"},
+	{42, "2.0"},
+	{43, "2.2"},
+	{44, "2.3"},
+	{47, "2.4"},
+	{49, "2.6"},
+	{57, "2.7"},
+	{61, "2.8"},
+	{67, "3.0"},
+}
+
+// probeKafka 发送ApiVersions v0请求，根据支持的API推断版本下限；该请求在SASL认证之前也会被响应
+func probeKafka(s *Scanner, target moduleTarget) *moduleResult {
+	const correlationID = 0x4e46
+	clientID := "nebulafinger"
+	request := binary.BigEndian.AppendUint32(nil, uint32(2+2+4+2+len(clientID)))
+	request = binary.BigEndian.AppendUint16(request, 18) // ApiVersions
+	request = binary.BigEndian.AppendUint16(request, 0)
+	request = binary.BigEndian.AppendUint32(request, correlationID)
+	request = binary.BigEndian.AppendUint16(request, uint16(len(clientID)))
+	request = append(request, clientID...)
+
+	reply, _ := s.moduleExchange(target, request, lengthComplete(func(b []byte) int {
+		if len(b) < 4 {
+			return -1
+		}
+		return 4 + int(binary.BigEndian.Uint32(b[0:4]))
+	}))
+	if len(reply) < 14 || binary.BigEndian.Uint32(reply[4:8]) != correlationID {
+		return nil
+	}
+	errorCode := binary.BigEndian.Uint16(reply[8:10])
+	count := binary.BigEndian.Uint32(reply[10:14])
+	entries := reply[14:]
+	if count == 0 || count > 1000 || uint64(len(entries)) < uint64(count)*6 {
+		return nil
+	}
+
+	maxKey := -1
+	for i := 0; i < int(count); i++ {
+		if key := int(int16(binary.BigEndian.Uint16(entries[i*6:]))); key > maxKey {
+			maxKey = key
+		}
+	}
+	result := &moduleResult{product: "Apache Kafka", details: map[string]string{}, response: latin1(reply)}
+	result.details["api_keys"] = strconv.Itoa(int(count))
+	result.details["max_api_key"] = strconv.Itoa(maxKey)
+	if errorCode != 0 {
+		result.details["error_code"] = strconv.Itoa(int(errorCode))
+	}
+	for _, v := range kafkaVersions {
+		if maxKey >= v.maxKey {
+			result.details["version_min"] = v.version
+		}
+	}
+	return result
+}
+
+// ---------------- ZooKeeper ----------------
+
+var (
+	zookeeperVersion = regexp.MustCompile(`Zookeeper version: ([\d.]+)`)
+	zookeeperMode    = regexp.MustCompile(`Mode: (\w+)`)
+	zookeeperNodes   = regexp.MustCompile(`Node count: (\d+)`)
+)
+
+// probeZooKeeper 发送四字命令srvr，读取版本和运行模式；四字命令未加入白名单时也能确认是ZooKeeper
+func probeZooKeeper(s *Scanner, target moduleTarget) *moduleResult {
+	reply, _ := s.moduleExchange(target, []byte("srvr"), func([]byte) bool { return false })
+	response := string(reply)
+	result := &moduleResult{product: "Apache ZooKeeper", details: map[string]string{}, response: response}
+
+	if strings.Contains(response, "is not executed because it is not in the whitelist") {
+		result.details["four_letter_words"] = "disabled"
+		return result
+	}
+	m := zookeeperVersion.FindStringSubmatch(response)
+	if m == nil {
+		return nil
+	}
+	result.version = strings.TrimSuffix(m[1], ".")
+	result.details["four_letter_words"] = "enabled"
+	if m := zookeeperMode.FindStringSubmatch(response); m != nil {
+		result.details["mode"] = m[1]
+	}
+	if m := zookeeperNodes.FindStringSubmatch(response); m != nil {
+		result.details["node_count"] = m[1]
+	}
+	return result
+}
+
+// ---------------- AMQP ----------------
+
+// probeAMQP 发送AMQP 0-9-1协议头，解析Connection.Start中的服务端属性（产品、版本、平台）和认证机制；
+// 只支持其他协议版本的服务端会回复自己的协议头
+func probeAMQP(s *Scanner, target moduleTarget) *moduleResult {
+	reply, _ := s.moduleExchange(target, []byte("AMQP\x00\x00\x09\x01"), func(b []byte) bool {
+		if bytes.HasPrefix(b, []byte("AMQP")) {
+			return len(b) >= 8
+		}
+		return len(b) >= 7 && len(b) >= 7+int(binary.BigEndian.Uint32(b[3:7]))+1
+	})
+
+	// 协议头不匹配：回复服务端支持的协议
+	if bytes.HasPrefix(reply, []byte("AMQP")) && len(reply) >= 8 {
+		result := &moduleResult{product: "AMQP", details: map[string]string{}, response: latin1(reply)}
+		switch reply[4] {
+		case 0, 1:
+			result.details["protocol"] = fmt.Sprintf("%d.%d.%d", reply[5], reply[6], reply[7])
+		case 2:
+			result.details["protocol"] = fmt.Sprintf("%d.%d.%d tls", reply[5], reply[6], reply[7])
+		case 3:
+			// AMQP 1.0的SASL层
+			result.details["protocol"] = fmt.Sprintf("%d.%d.%d sasl", reply[5], reply[6], reply[7])
+			result.authRequired = "true"
+		}
+		return result
+	}
+
+	// 方法帧: type(1)=1 channel(2) size(4) class(2)=10 method(2)=10 version-major(1) version-minor(1) server-properties
+	if len(reply) < 15 || reply[0] != 1 || binary.BigEndian.Uint16(reply[7:9]) != 10 || binary.BigEndian.Uint16(reply[9:11]) != 10 {
+		return nil
+	}
+	result := &moduleResult{product: "AMQP", details: map[string]string{}, response: latin1(reply)}
+	result.details["protocol"] = fmt.Sprintf("%d-%d", reply[11], reply[12])
+
+	properties, rest := parseAMQPTable(reply[13:])
+	if product := properties["product"]; product != "" {
+		result.product = product
+	}
+	result.version = properties["version"]
+	for _, key := range []string{"platform", "cluster_name"} {
+		if properties[key] != "" {
+			result.details[key] = properties[key]
+		}
+	}
+	if len(rest) >= 4 {
+		if size := binary.BigEndian.Uint32(rest[0:4]); uint64(size) <= uint64(len(rest)-4) {
+			mechanisms := string(rest[4 : 4+int(size)])
+			result.details["mechanisms"] = mechanisms
+			result.authRequired = "true"
+			for _, mechanism := range strings.Fields(mechanisms) {
+				if mechanism == "ANONYMOUS" {
+					result.authRequired = "false"
+				}
+			}
+		}
+	}
+	return result
+}
+
+// amqpFieldSizes AMQP字段表中定长类型的长度
+var amqpFieldSizes = map[byte]int{
+	't': 1, 'b': 1, 'B': 1, 's': 2, 'u': 2, 'I': 4, 'i': 4, 'f': 4,
+	'l': 8, 'L': 8, 'd': 8, 'T': 8, 'D': 5, 'V': 0,
+}
+
+// parseAMQPTable 解析AMQP字段表，返回顶层的字符串字段和表之后的数据；
+// 长度字段按无符号数与剩余数据比较，超出时停止解析
+func parseAMQPTable(data []byte) (map[string]string, []byte) {
+	fields := make(map[string]string)
+	if len(data) < 4 {
+		return fields, nil
+	}
+	size := binary.BigEndian.Uint32(data[0:4])
+	if uint64(size) > uint64(len(data)-4) {
+		return fields, nil
+	}
+	table, rest := data[4:4+int(size)], data[4+int(size):]
+	for len(table) > 0 {
+		keyLength := int(table[0])
+		if len(table) < 1+keyLength+1 {
+			break
+		}
+		key := string(table[1 : 1+keyLength])
+		kind := table[1+keyLength]
+		table = table[1+keyLength+1:]
+
+		n, fixed := amqpFieldSizes[kind]
+		if !fixed {
+			// S、x为长字符串，F为字段表，A为数组，都以4字节长度开头
+			if len(table) < 4 {
+				break
+			}
+			length := binary.BigEndian.Uint32(table[0:4])
+			if uint64(length) > uint64(len(table)-4) {
+				break
+			}
+			n = 4 + int(length)
+		}
+		if n > len(table) {
+			break
+		}
+		if kind == 'S' {
+			fields[key] = string(table[4:n])
+		}
+		table = table[n:]
+	}
+	return fields, rest
+}
+
+// ---------------- MQTT ----------------
+
+// mqttConnect MQTT 3.1.1 CONNECT，clean session，客户端ID为nebulafinger，不带用户名和密码
+var mqttConnect = []byte("\x10\x18\x00\x04MQTT\x04\x02\x00\x3c\x00\x0cnebulafinger")
+
+// mqttReturnCodes CONNACK返回码
+var mqttReturnCodes = map[byte]string{
+	0: "accepted",
+	1: "unacceptable_protocol_version",
+	2: "identifier_rejected",
+	3: "server_unavailable",
+	4: "bad_username_or_password",
+	5: "not_authorized",
+}
+
+// probeMQTT 发送不带凭据的CONNECT，根据CONNACK的返回码判断是否允许匿名连接
+func probeMQTT(s *Scanner, target moduleTarget) *moduleResult {
+	conn, err := s.moduleDial(target)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+
+	reply, _ := exchange(conn, string(mqttConnect), func(b []byte) bool { return len(b) >= 4 })
+	if len(reply) < 4 || reply[0] != 0x20 || reply[1] != 0x02 {
+		return nil
+	}
+	code, ok := mqttReturnCodes[reply[3]]
+	if !ok {
+		return nil
+	}
+	conn.Write([]byte{0xe0, 0x00}) // DISCONNECT
+
+	result := &moduleResult{product: "MQTT Broker", details: map[string]string{}, response: latin1([]byte(reply))}
+	result.details["protocol_version"] = "3.1.1"
+	result.details["connack"] = code
+	switch reply[3] {
+	case 0:
+		result.authRequired = "false"
+	case 4, 5:
+		result.authRequired = "true"
+	}
+	return result
+}
+
+// ---------------- NATS ----------------
+
+// isNATSInfo 判断banner是否为NATS服务端连接后发送的INFO
+func isNATSInfo(banner string) bool {
+	return strings.HasPrefix(banner, "INFO {")
+}
+
+// probeNATS 解析服务端连接后发送的INFO中的JSON：版本、Go版本、认证和TLS要求、JetStream
+func probeNATS(s *Scanner, target moduleTarget) *moduleResult {
+	greeting := string(s.moduleGreeting(target))
+	if !isNATSInfo(greeting) {
+		return nil
+	}
+	line, _, _ := strings.Cut(strings.TrimPrefix(greeting, "INFO "), "\n")
+	var info struct {
+		ServerID     string `json:"server_id"`
+		ServerName   string `json:"server_name"`
+		Version      string `json:"version"`
+		Go           string `json:"go"`
+		AuthRequired bool   `json:"auth_required"`
+		TLSRequired  bool   `json:"tls_required"`
+		JetStream    bool   `json:"jetstream"`
+		Cluster      string `json:"cluster"`
+	}
+	if json.Unmarshal([]byte(strings.TrimSpace(line)), &info) != nil {
+		return nil
+	}
+
+	result := &moduleResult{product: "NATS Server", version: info.Version, details: map[string]string{}, response: greeting}
+	result.authRequired = strconv.FormatBool(info.AuthRequired)
+	result.details["tls_required"] = strconv.FormatBool(info.TLSRequired)
+	result.details["jetstream"] = strconv.FormatBool(info.JetStream)
+	for key, value := range map[string]string{"server_id": info.ServerID, "server_name": info.ServerName, "go": info.Go, "cluster": info.Cluster} {
+		if value != "" {
+			result.details[key] = value
+		}
+	}
+	return result
+}
+
+// ---------------- ActiveMQ OpenWire ----------------
+
+// isOpenWireInfo 判断banner是否为ActiveMQ连接后发送的WireFormatInfo
+func isOpenWireInfo(banner string) bool {
+	return len(banner) >= 17 && banner[4] == 0x01 && banner[5:13] == "ActiveMQ"
+}
+
+// probeOpenWire 解析WireFormatInfo：OpenWire版本和属性中的ProviderName、ProviderVersion、PlatformDetails
+func probeOpenWire(s *Scanner, target moduleTarget) *moduleResult {
+	greeting := string(s.moduleGreeting(target))
+	if !isOpenWireInfo(greeting) {
+		return nil
+	}
+	result := &moduleResult{product: "ActiveMQ", details: map[string]string{}, response: greeting}
+	result.details["openwire_version"] = strconv.Itoa(int(binary.BigEndian.Uint32([]byte(greeting[13:17]))))
+	if name := openWireProperty(greeting, "ProviderName"); name != "" {
+		result.product = name
+	}
+	result.version = openWireProperty(greeting, "ProviderVersion")
+	if platform := openWireProperty(greeting, "PlatformDetails"); platform != "" {
+		result.details["platform"] = platform
+	}
+	return result
+}
+
+// openWireProperty 从编组的属性表中读取字符串属性：键之后为类型9（字符串）、2字节长度和内容
+func openWireProperty(data, key string) string {
+	i := strings.Index(data, key)
+	if i < 0 {
+		return ""
+	}
+	rest := data[i+len(key):]
+	if len(rest) < 3 || rest[0] != 9 {
+		return ""
+	}
+	size := int(binary.BigEndian.Uint16([]byte(rest[1:3])))
+	if size > len(rest)-3 {
+		return ""
+	}
+	return rest[3 : 3+size]
+}
+
+// ---------------- RocketMQ ----------------
+
+// RocketMQ远程调用的请求码和响应码
+const (
+	rocketMQGetBrokerClusterInfo = 106
+	rocketMQNoPermission         = 16
+)
+
+// probeRocketMQ 发送GET_BROKER_CLUSTER_INFO，NameServer返回集群信息，Broker返回不支持的请求码，
+// 两者的响应头中都带有服务端的版本号（MQVersion枚举序号）；启用ACL时返回无权限
+func probeRocketMQ(s *Scanner, target moduleTarget) *moduleResult {
+	header := fmt.Sprintf(`{"code":%d,"extFields":{},"flag":0,"language":"JAVA","opaque":1,"serializeTypeCurrentRPC":"JSON","version":401}`, rocketMQGetBrokerClusterInfo)
+	request := binary.BigEndian.AppendUint32(nil, uint32(4+len(header)))
+	request = binary.BigEndian.AppendUint32(request, uint32(len(header)))
+	request = append(request, header...)
+
+	reply, _ := s.moduleExchange(target, request, lengthComplete(func(b []byte) int {
+		if len(b) < 4 {
+			return -1
+		}
+		return 4 + int(binary.BigEndian.Uint32(b[0:4]))
+	}))
+	if len(reply) < 8 {
+		return nil
+	}
+	headerLength := int(binary.BigEndian.Uint32(reply[4:8]) & 0xffffff)
+	if reply[4] != 0 || headerLength > len(reply)-8 {
+		return nil
+	}
+	var response struct {
+		Code     *int   `json:"code"`
+		Flag     int    `json:"flag"`
+		Language string `json:"language"`
+		Opaque   int    `json:"opaque"`
+		Version  int    `json:"version"`
+		Remark   string `json:"remark"`
+	}
+	if json.Unmarshal(reply[8:8+headerLength], &response) != nil || response.Code == nil || response.Opaque != 1 || response.Flag&1 == 0 {
+		return nil
+	}
+
+	result := &moduleResult{product: "Apache RocketMQ", details: map[string]string{}, response: latin1(reply)}
+	result.details["version_code"] = strconv.Itoa(response.Version)
+	result.details["response_code"] = strconv.Itoa(*response.Code)
+	if response.Language != "" {
+		result.details["language"] = response.Language
+	}
+	if response.Remark != "" {
+		result.details["remark"] = response.Remark
+	}
+	if *response.Code == rocketMQNoPermission {
+		result.authRequired = "true"
+	}
+
+	var body struct {
+		ClusterAddrTable map[string]json.RawMessage `json:"clusterAddrTable"`
+	}
+	if json.Unmarshal(reply[8+headerLength:], &body) == nil && body.ClusterAddrTable != nil {
+		result.details["role"] = "nameserver"
+		clusters := make([]string, 0, len(body.ClusterAddrTable))
+		for name := range body.ClusterAddrTable {
+			clusters = append(clusters, name)
+		}
+		sort.Strings(clusters)
+		result.details["clusters"] = strings.Join(clusters, ",")
+		result.authRequired = "false"
+	}
+	return result
+}
+
+// ---------------- Memcached ----------------
+
+// probeMemcached 发送文本协议的version命令；启用SASL时文本命令返回未认证错误
+func probeMemcached(s *Scanner, target moduleTarget) *moduleResult {
+	reply, _ := s.moduleExchange(target, []byte("version\r\n"), lineComplete)
+	response := strings.TrimSpace(string(reply))
+	result := &moduleResult{product: "Memcached", details: map[string]string{}, response: response}
+	switch {
+	case strings.HasPrefix(response, "VERSION "):
+		result.version = strings.TrimPrefix(response, "VERSION ")
+		result.authRequired = "false"
+	case strings.HasPrefix(response, "CLIENT_ERROR unauthenticated"):
+		result.authRequired = "true"
+	default:
+		return nil
+	}
+	return result
+}
+
+// ---------------- etcd ----------------
+
+// probeEtcd 请求/version读取版本，再用v3 gRPC网关读取一个键判断是否启用了认证
+func probeEtcd(s *Scanner, target moduleTarget) *moduleResult {
+	_, body, err := s.moduleHTTPGet(target, "/version")
+	if err != nil {
+		return nil
+	}
+	var version struct {
+		Server  string `json:"etcdserver"`
+		Cluster string `json:"etcdcluster"`
+	}
+	if json.Unmarshal(body, &version) != nil || version.Server == "" {
+		return nil
+	}
+	result := &moduleResult{product: "etcd", version: version.Server, details: map[string]string{}, response: string(body)}
+	if version.Cluster != "" {
+		result.details["cluster_version"] = version.Cluster
+	}
+
+	// 键为base64编码的"/"
+	resp, body, err := s.moduleHTTPRequest(target, "POST", "/v3/kv/range", `{"key":"Lw==","limit":1}`)
+	if err == nil {
+		switch {
+		case resp.StatusCode == 401, bytes.Contains(body, []byte("user name is empty")), bytes.Contains(body, []byte("invalid auth token")):
+			result.authRequired = "true"
+		case resp.StatusCode == 200 && bytes.Contains(body, []byte(`"header"`)):
+			result.authRequired = "false"
+		}
+	}
+	return result
+}
@@ -0,0 +1,447 @@
+package scanner
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"nebulafinger/internal"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// replyStandIn 读取与request相同的请求后回复reply并关闭连接，请求不同时直接关闭
+func replyStandIn(t *testing.T, request, reply []byte) uint16 {
+	t.Helper()
+	return tcpStandIn(t, func(conn net.Conn) {
+		received := make([]byte, len(request))
+		if _, err := io.ReadFull(conn, received); err != nil || !bytes.Equal(received, request) {
+			return
+		}
+		conn.Write(reply)
+	})
+}
+
+// checkModuleResult 比较模块结果的产品、版本、认证要求和详情，want为nil时期望没有识别
+func checkModuleResult(t *testing.T, result *moduleResult, want *moduleResult) {
+	t.Helper()
+	if want == nil {
+		if result != nil {
+			t.Errorf("不应识别，得到 %+v", result)
+		}
+		return
+	}
+	if result == nil {
+		t.Fatal("没有识别")
+	}
+	if result.product != want.product || result.version != want.version || result.authRequired != want.authRequired {
+		t.Errorf("product/version/auth_required = %s/%s/%s，期望 %s/%s/%s",
+			result.product, result.version, result.authRequired, want.product, want.version, want.authRequired)
+	}
+	for k, v := range want.details {
+		if result.details[k] != v {
+			t.Errorf("%s = %q，期望 %q", k, result.details[k], v)
+		}
+	}
+}
+
+// Kafka 0.10.0的ApiVersions v0响应：19个API，最大API key为18（ApiVersions）
+const kafkaAPIVersions = "0000007c00004e460000000000130000000000020001000000020002000000000003000000010004000000000005000000000006000000020007000100010008" +
+	"00000002000900000001000a00000000000b00000000000c00000000000d00000000000e00000000000f00000000001000000000001100000000001200000000"
+
+// kafkaRequest probeKafka发送的ApiVersions v0请求
+var kafkaRequest = fixture("00000016 0012 0000 00004e46 000c" + "6e6562756c6166696e676572")
+
+func TestProbeKafka(t *testing.T) {
+	reply := fixture(kafkaAPIVersions)
+	otherCorrelation := append([]byte(nil), reply...)
+	otherCorrelation[7] = 0x47
+
+	tests := []struct {
+		name  string
+		reply []byte
+		want  *moduleResult
+	}{
+		{"ApiVersions", reply, &moduleResult{product: "Apache Kafka", details: map[string]string{
+			"api_keys": "19", "max_api_key": "18", "version_min": "0.10.0", "error_code": "",
+		}}},
+		{"关联ID不一致", otherCorrelation, nil},
+		// 声明了19个API，但连接在第5个API之后关闭
+		{"截断的响应", reply[:44], nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := replyStandIn(t, kafkaRequest, tt.reply)
+			checkModuleResult(t, probeKafka(newTestScanner(nil, ScannerConfig{}), localTarget(port)), tt.want)
+		})
+	}
+}
+
+func TestProbeZooKeeper(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		want  *moduleResult
+	}{
+		{"srvr", "Zookeeper version: 3.8.3-6ad6d364c7c0bcf0de452d54ebefa3058098ab56, built on 2023-10-05 10:34 UTC\n" +
+			"Latency min/avg/max: 0/0.0/0\nReceived: 1\nSent: 0\nConnections: 1\nOutstanding: 0\nZxid: 0x0\nMode: standalone\nNode count: 5\n",
+			&moduleResult{product: "Apache ZooKeeper", version: "3.8.3", details: map[string]string{
+				"four_letter_words": "enabled", "mode": "standalone", "node_count": "5",
+			}}},
+		{"四字命令未加入白名单", "srvr is not executed because it is not in the whitelist.\n",
+			&moduleResult{product: "Apache ZooKeeper", details: map[string]string{"four_letter_words": "disabled"}}},
+		{"其他服务", "-ERR unknown command 'srvr'\r\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := replyStandIn(t, []byte("srvr"), []byte(tt.reply))
+			checkModuleResult(t, probeZooKeeper(newTestScanner(nil, ScannerConfig{}), localTarget(port)), tt.want)
+		})
+	}
+}
+
+// RabbitMQ 3.12.12的Connection.Start：服务端属性带capabilities字段表，认证机制为AMQPLAIN和PLAIN
+const rabbitMQ3Start = "010000000001f8000a000a0009000001d30c6361706162696c697469657346000000c7127075626c69736865725f636f6e6669726d7374011a65786368616e67" +
+	"655f65786368616e67655f62696e64696e677374010a62617369632e6e61636b740116636f6e73756d65725f63616e63656c5f6e6f74696679740112636f6e6e" +
+	"656374696f6e2e626c6f636b6564740113636f6e73756d65725f7072696f72697469657374011c61757468656e7469636174696f6e5f6661696c7572655f636c" +
+	"6f73657401107065725f636f6e73756d65725f716f7374010f6469726563745f7265706c795f746f74010c636c75737465725f6e616d65530000000b72616262" +
+	"6974406d71303109636f70797269676874530000003c436f707972696768742028632920323030372d323032342042726f6164636f6d20496e6320616e642f6f" +
+	"7220697473207375627369646961726965730b696e666f726d6174696f6e53000000394c6963656e73656420756e64657220746865204d504c20322e302e2057" +
+	"6562736974653a2068747470733a2f2f7261626269746d712e636f6d08706c6174666f726d530000001145726c616e672f4f54502032362e322e310770726f64" +
+	"75637453000000085261626269744d510776657273696f6e5300000007332e31322e31320000000e414d51504c41494e20504c41494e00000005656e5f5553ce"
+
+// RabbitMQ 4.0.5的Connection.Start，默认允许ANONYMOUS认证
+const rabbitMQ4Start = "01000000000169000a000a00090000013a0c6361706162696c697469657346000000c7127075626c69736865725f636f6e6669726d7374011a65786368616e67" +
+	"655f65786368616e67655f62696e64696e677374010a62617369632e6e61636b740116636f6e73756d65725f63616e63656c5f6e6f74696679740112636f6e6e" +
+	"656374696f6e2e626c6f636b6564740113636f6e73756d65725f7072696f72697469657374011c61757468656e7469636174696f6e5f6661696c7572655f636c" +
+	"6f73657401107065725f636f6e73756d65725f716f7374010f6469726563745f7265706c795f746f74010c636c75737465725f6e616d65530000000b72616262" +
+	"6974406d71303208706c6174666f726d530000000f45726c616e672f4f54502032372e320770726f6475637453000000085261626269744d510776657273696f" +
+	"6e5300000005342e302e3500000018504c41494e20414d51504c41494e20414e4f4e594d4f555300000005656e5f5553ce"
+
+func TestProbeAMQP(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply []byte
+		want  *moduleResult
+	}{
+		{"RabbitMQ 3", fixture(rabbitMQ3Start), &moduleResult{product: "RabbitMQ", version: "3.12.12", authRequired: "true", details: map[string]string{
+			"protocol": "0-9", "platform": "Erlang/OTP 26.2.1", "cluster_name": "rabbit@mq01", "mechanisms": "AMQPLAIN PLAIN",
+		}}},
+		{"允许匿名认证", fixture(rabbitMQ4Start), &moduleResult{product: "RabbitMQ", version: "4.0.5", authRequired: "false", details: map[string]string{
+			"mechanisms": "PLAIN AMQPLAIN ANONYMOUS",
+		}}},
+		// 只支持AMQP 1.0的服务端回复自己的协议头
+		{"AMQP 1.0", []byte("AMQP\x00\x01\x00\x00"), &moduleResult{product: "AMQP", details: map[string]string{"protocol": "1.0.0"}}},
+		{"AMQP 1.0 SASL", []byte("AMQP\x03\x01\x00\x00"), &moduleResult{product: "AMQP", authRequired: "true", details: map[string]string{"protocol": "1.0.0 sasl"}}},
+		{"其他服务", []byte("HTTP/1.1 400 Bad Request\r\n\r\n"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := replyStandIn(t, []byte("AMQP\x00\x00\x09\x01"), tt.reply)
+			checkModuleResult(t, probeAMQP(newTestScanner(nil, ScannerConfig{}), localTarget(port)), tt.want)
+		})
+	}
+}
+
+// amqpField 返回AMQP字段表中的一个字段
+func amqpField(key string, kind byte, value []byte) []byte {
+	field := append([]byte{byte(len(key))}, key...)
+	return append(append(field, kind), value...)
+}
+
+// amqpLong 返回以4字节长度开头的值
+func amqpLong(value []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(value))), value...)
+}
+
+func TestParseAMQPTable(t *testing.T) {
+	nested := amqpField("version", 'S', amqpLong([]byte("9.9")))
+	table := bytes.Join([][]byte{
+		amqpField("product", 'S', amqpLong([]byte("RabbitMQ"))),
+		amqpField("publisher_confirms", 't', []byte{1}),
+		amqpField("frame_max", 'l', make([]byte, 8)),
+		amqpField("version", 'S', amqpLong([]byte("3.12.12"))),
+		amqpField("capabilities", 'F', amqpLong(nested)),
+	}, nil)
+	// 字段长度超出表：长度按无符号数比较，0xffffffff在32位平台上也不会越界
+	oversized := append(amqpField("product", 'S', amqpLong([]byte("RabbitMQ"))), amqpField("version", 'S', []byte{0xff, 0xff, 0xff, 0xff, '3'})...)
+
+	tests := []struct {
+		name       string
+		data       []byte
+		wantFields map[string]string
+		wantRest   []byte
+	}{
+		// 嵌套表中的字段不出现在顶层
+		{"字段表", append(amqpLong(table), "tail"...), map[string]string{"product": "RabbitMQ", "version": "3.12.12"}, []byte("tail")},
+		{"空表", amqpLong(nil), map[string]string{}, []byte{}},
+		{"少于4字节", []byte{0, 0, 1}, map[string]string{}, nil},
+		{"表长度超出数据", append(binary.BigEndian.AppendUint32(nil, 100), table[:20]...), map[string]string{}, nil},
+		{"字段长度超出表", append(amqpLong(oversized), "tail"...), map[string]string{"product": "RabbitMQ"}, []byte("tail")},
+		{"键长度超出表", append(amqpLong([]byte{200, 'k', 'e', 'y'}), "tail"...), map[string]string{}, []byte("tail")},
+		{"截断的值", amqpLong(amqpField("frame_max", 'l', []byte{0, 0, 0})), map[string]string{}, []byte{}},
+		{"未知类型", amqpLong(amqpField("custom", 'Z', nil)), map[string]string{}, []byte{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, rest := parseAMQPTable(tt.data)
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("字段 = %v，期望 %v", fields, tt.wantFields)
+			}
+			if !bytes.Equal(rest, tt.wantRest) || (rest == nil) != (tt.wantRest == nil) {
+				t.Errorf("剩余数据 = %q，期望 %q", rest, tt.wantRest)
+			}
+		})
+	}
+}
+
+func TestProbeMQTT(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply []byte
+		want  *moduleResult
+	}{
+		{"允许匿名连接", []byte{0x20, 0x02, 0x00, 0x00}, &moduleResult{product: "MQTT Broker", authRequired: "false", details: map[string]string{
+			"protocol_version": "3.1.1", "connack": "accepted",
+		}}},
+		{"未授权", []byte{0x20, 0x02, 0x00, 0x05}, &moduleResult{product: "MQTT Broker", authRequired: "true", details: map[string]string{"connack": "not_authorized"}}},
+		{"用户名或密码错误", []byte{0x20, 0x02, 0x00, 0x04}, &moduleResult{product: "MQTT Broker", authRequired: "true", details: map[string]string{"connack": "bad_username_or_password"}}},
+		// 拒绝客户端ID时无法判断是否需要认证
+		{"拒绝客户端ID", []byte{0x20, 0x02, 0x00, 0x02}, &moduleResult{product: "MQTT Broker", details: map[string]string{"connack": "identifier_rejected"}}},
+		{"未知返回码", []byte{0x20, 0x02, 0x00, 0x09}, nil},
+		{"其他服务", []byte("HTTP/1.1 400 Bad Request\r\n\r\n"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := replyStandIn(t, mqttConnect, tt.reply)
+			checkModuleResult(t, probeMQTT(newTestScanner(nil, ScannerConfig{}), localTarget(port)), tt.want)
+		})
+	}
+}
+
+// NATS 2.10.7启用认证和JetStream时连接后发送的INFO
+const natsInfo = `INFO {"server_id":"NDJWE4SOUJOJT2TFZ6QLOLWR3BLTW5ZYCSKTEA4LFXG5BYIRBGO2FNBY","server_name":"nats-1","version":"2.10.7",` +
+	`"proto":1,"git_commit":"fa8464d","go":"go1.21.5","host":"0.0.0.0","port":4222,"headers":true,"auth_required":true,` +
+	`"max_payload":1048576,"jetstream":true,"client_id":5,"client_ip":"192.0.2.9","cluster":"east"} ` + "\r\n"
+
+func TestProbeNATS(t *testing.T) {
+	tests := []struct {
+		name   string
+		banner string
+		want   *moduleResult
+	}{
+		{"启用认证", natsInfo, &moduleResult{product: "NATS Server", version: "2.10.7", authRequired: "true", details: map[string]string{
+			"server_name": "nats-1", "go": "go1.21.5", "tls_required": "false", "jetstream": "true", "cluster": "east",
+			"server_id": "NDJWE4SOUJOJT2TFZ6QLOLWR3BLTW5ZYCSKTEA4LFXG5BYIRBGO2FNBY",
+		}}},
+		{"不需要认证", `INFO {"server_id":"N1","version":"2.9.21","go":"go1.20.10","tls_required":true}` + "\r\n",
+			&moduleResult{product: "NATS Server", version: "2.9.21", authRequired: "false", details: map[string]string{
+				"tls_required": "true", "jetstream": "false", "server_name": "",
+			}}},
+		{"JSON格式错误", "INFO {\"version\":\r\n", nil},
+	}
+	s := newTestScanner(nil, ScannerConfig{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := localTarget(0)
+			target.banner.response = tt.banner
+			checkModuleResult(t, probeNATS(s, target), tt.want)
+		})
+	}
+
+	for _, banner := range []string{"", "+OK\r\n", "-ERR 'Authorization Violation'\r\n"} {
+		if isNATSInfo(banner) {
+			t.Errorf("%q 不应识别为NATS INFO", banner)
+		}
+	}
+}
+
+// ActiveMQ 5.18.3连接后发送的WireFormatInfo：OpenWire版本12，属性表中带ProviderName、ProviderVersion和PlatformDetails
+const activeMQWireFormat = "00000183014163746976654d510000000c01000001710000000c00115463704e6f44656c6179456e61626c65640101001253697a655072656669784469736162" +
+	"6c656401000009436163686553697a650500000400000c50726f76696465724e616d650900084163746976654d510011537461636b5472616365456e61626c65" +
+	"640101000f506c6174666f726d44657461696c7309004c4a564d3a2031372e302e392c2031372e302e392b392c2045636c697073652041646f707469756d2c20" +
+	"4f533a204c696e75782c20352e31352e302d39312d67656e657269632c20616d643634000c4361636865456e61626c6564010100145469676874456e636f6469" +
+	"6e67456e61626c65640101000c4d61784672616d6553697a6506000000000640000000154d6178496e61637469766974794475726174696f6e06000000000000" +
+	"753000204d6178496e61637469766974794475726174696f6e496e6974616c44656c6179060000000000002710000f50726f766964657256657273696f6e0900" +
+	"06352e31382e33"
+
+func TestProbeOpenWire(t *testing.T) {
+	greeting := string(fixture(activeMQWireFormat))
+	if !isOpenWireInfo(greeting) {
+		t.Fatal("没有识别为WireFormatInfo")
+	}
+	target := localTarget(0)
+	target.banner.response = greeting
+	checkModuleResult(t, probeOpenWire(newTestScanner(nil, ScannerConfig{}), target), &moduleResult{
+		product: "ActiveMQ", version: "5.18.3", details: map[string]string{
+			"openwire_version": "12", "platform": "JVM: 17.0.9, 17.0.9+9, Eclipse Adoptium, OS: Linux, 5.15.0-91-generic, amd64",
+		},
+	})
+
+	// 属性值不是字符串或长度超出数据时不读取
+	for _, data := range []string{"ProviderVersion\x06\x00\x00", "ProviderVersion\x09\x00\x10abc", "ProviderVersion\x09"} {
+		if value := openWireProperty(data, "ProviderVersion"); value != "" {
+			t.Errorf("%q 读取到 %q", data, value)
+		}
+	}
+	for _, banner := range []string{"", "\x00\x00\x00\x10\x01ActiveM", "\x00\x00\x00\x10\x02ActiveMQ\x00\x00\x00\x0c"} {
+		if isOpenWireInfo(banner) {
+			t.Errorf("%q 不应识别为WireFormatInfo", banner)
+		}
+	}
+}
+
+// rocketMQFrame 按RocketMQ远程调用协议分帧：总长度、JSON序列化的头长度、头和消息体
+func rocketMQFrame(header, body string) []byte {
+	frame := binary.BigEndian.AppendUint32(nil, uint32(4+len(header)+len(body)))
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(header)))
+	return append(append(frame, header...), body...)
+}
+
+func TestProbeRocketMQ(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply []byte
+		want  *moduleResult
+	}{
+		{"NameServer", rocketMQFrame(`{"code":0,"flag":1,"language":"JAVA","opaque":1,"serializeTypeCurrentRPC":"JSON","version":475}`,
+			`{"brokerAddrTable":{"broker-a":{"brokerAddrs":{"0":"10.0.0.5:10911"},"brokerName":"broker-a","cluster":"DefaultCluster"}},`+
+				`"clusterAddrTable":{"DefaultCluster":["broker-a"],"TestCluster":["broker-b"]}}`),
+			&moduleResult{product: "Apache RocketMQ", authRequired: "false", details: map[string]string{
+				"version_code": "475", "response_code": "0", "language": "JAVA", "role": "nameserver", "clusters": "DefaultCluster,TestCluster",
+			}}},
+		{"Broker", rocketMQFrame(`{"code":3,"flag":1,"language":"JAVA","opaque":1,"remark":" request type 106 not supported","serializeTypeCurrentRPC":"JSON","version":475}`, ""),
+			&moduleResult{product: "Apache RocketMQ", details: map[string]string{
+				"response_code": "3", "remark": " request type 106 not supported", "role": "",
+			}}},
+		{"启用ACL", rocketMQFrame(`{"code":16,"flag":1,"language":"JAVA","opaque":1,"remark":"No accessKey is configured","serializeTypeCurrentRPC":"JSON","version":475}`, ""),
+			&moduleResult{product: "Apache RocketMQ", authRequired: "true", details: map[string]string{"response_code": "16"}}},
+		{"不是响应", rocketMQFrame(`{"code":0,"flag":0,"language":"JAVA","opaque":1,"version":475}`, ""), nil},
+		{"opaque不一致", rocketMQFrame(`{"code":0,"flag":1,"language":"JAVA","opaque":7,"version":475}`, ""), nil},
+		{"头长度超出数据", append(binary.BigEndian.AppendUint32(nil, 8), 0, 0, 1, 0, '{', '}'), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := tcpStandIn(t, func(conn net.Conn) {
+				header := make([]byte, 4)
+				if _, err := io.ReadFull(conn, header); err != nil {
+					return
+				}
+				request := make([]byte, binary.BigEndian.Uint32(header))
+				if _, err := io.ReadFull(conn, request); err != nil || !bytes.Contains(request, []byte(`"code":106`)) {
+					return
+				}
+				conn.Write(tt.reply)
+			})
+			checkModuleResult(t, probeRocketMQ(newTestScanner(nil, ScannerConfig{}), localTarget(port)), tt.want)
+		})
+	}
+}
+
+func TestProbeMemcached(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		want  *moduleResult
+	}{
+		{"version", "VERSION 1.6.21\r\n", &moduleResult{product: "Memcached", version: "1.6.21", authRequired: "false"}},
+		{"启用SASL", "CLIENT_ERROR unauthenticated\r\n", &moduleResult{product: "Memcached", authRequired: "true"}},
+		{"其他服务", "ERROR\r\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := replyStandIn(t, []byte("version\r\n"), []byte(tt.reply))
+			checkModuleResult(t, probeMemcached(newTestScanner(nil, ScannerConfig{}), localTarget(port)), tt.want)
+		})
+	}
+}
+
+// etcdHandler etcd 3.5.11的/version和v3 gRPC网关，auth为true时读取键返回用户名为空的错误
+func etcdHandler(auth bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/version":
+			w.Write([]byte(`{"etcdserver":"3.5.11","etcdcluster":"3.5.0"}`))
+		case r.URL.Path == "/v3/kv/range" && r.Method == "POST" && auth:
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"etcdserver: user name is empty","code":16,"message":"etcdserver: user name is empty"}`))
+		case r.URL.Path == "/v3/kv/range" && r.Method == "POST":
+			w.Write([]byte(`{"header":{"cluster_id":"14841639068965178418","member_id":"10276657743932975437","revision":"1","raft_term":"2"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}
+}
+
+func TestProbeEtcd(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.Handler
+		want    *moduleResult
+	}{
+		{"启用认证", etcdHandler(true), &moduleResult{product: "etcd", version: "3.5.11", authRequired: "true", details: map[string]string{"cluster_version": "3.5.0"}}},
+		{"未启用认证", etcdHandler(false), &moduleResult{product: "etcd", version: "3.5.11", authRequired: "false"}},
+		{"其他HTTP服务", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"version":"1.0.0"}`))
+		}), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := httpStandIn(t, tt.handler)
+			checkModuleResult(t, probeEtcd(newTestScanner(nil, ScannerConfig{}), localTarget(port)), tt.want)
+		})
+	}
+}
+
+// TestModuleParts 模块识别出的产品、版本和认证要求作为part供指纹的匹配器使用，并记录在结果详情中
+func TestModuleParts(t *testing.T) {
+	port := tcpStandIn(t, func(conn net.Conn) {
+		request := make([]byte, len("version\r\n"))
+		if _, err := io.ReadFull(conn, request); err == nil {
+			conn.Write([]byte("VERSION 1.6.21\r\n"))
+		}
+	})
+	tests := []struct {
+		name    string
+		matcher internal.Matchers
+		wantID  string
+	}{
+		{"product", internal.Matchers{Type: "word", Part: "product", Words: []string{"Memcached"}}, "memcached-product"},
+		{"version", internal.Matchers{Type: "regex", Part: "version", Regex: []string{`^1\.6\.`}}, "memcached-product"},
+		{"auth_required", internal.Matchers{Type: "word", Part: "auth_required", Words: []string{"false"}}, "memcached-product"},
+		{"module", internal.Matchers{Type: "word", Part: "module", Words: []string{"memcached"}}, "memcached-product"},
+		// 没有命中时使用模块的内置结果
+		{"没有命中", internal.Matchers{Type: "word", Part: "auth_required", Words: []string{"true"}}, "memcached"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fingerprints := []internal.Fingerprint{{
+				ID:   "memcached-product",
+				Info: internal.Info{Name: "Memcached", Tags: "memcached"},
+				TCP:  []internal.TCPRequest{{Probe: "memcached", Matchers: []internal.Matchers{tt.matcher}}},
+			}}
+			s := newTestScanner(fingerprints, ScannerConfig{ServicePorts: map[string][]uint16{"memcached": {port}}})
+			results, ok := s.matchModules("127.0.0.1", port, &tcpBanner{})
+			if !ok || len(results) != 1 {
+				t.Fatalf("结果 = %+v", results)
+			}
+			if results[0].ID != tt.wantID {
+				t.Errorf("命中 %s，期望 %s", results[0].ID, tt.wantID)
+			}
+			want := map[string]string{"product": "Memcached", "version": "1.6.21", "auth_required": "false", "module": "memcached", "port_match": PortMatchExpected}
+			for k, v := range want {
+				if results[0].Details[k] != v {
+					t.Errorf("%s = %q，期望 %q", k, results[0].Details[k], v)
+				}
+			}
+		})
+	}
+}
+
+// TestMQTTConnectRequest CONNECT的剩余长度与实际长度一致
+func TestMQTTConnectRequest(t *testing.T) {
+	if length := int(mqttConnect[1]); length != len(mqttConnect)-2 {
+		t.Errorf("MQTT CONNECT剩余长度 = %d，实际 %d", length, len(mqttConnect)-2)
+	}
+}
@@ -0,0 +1,346 @@
+package scanner
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+func init() {
+	registerModules(
+		&serviceModule{name: "ntlm", ports: []uint16{80, 443, 5985}, tags: "detect,windows,ntlm", probe: probeNTLM, extra: true},
+	)
+}
+
+// ntlmSignature NTLMSSP消息的签名
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+// ntlmNegotiateFlags NEGOTIATE消息的标志：UNICODE、OEM、REQUEST_TARGET、NTLM、ALWAYS_SIGN、EXTENDED_SESSIONSECURITY、VERSION、128、56
+const ntlmNegotiateFlags = 0xa2088207
+
+// ntlmFlagVersion CHALLENGE消息带有版本字段的标志
+const ntlmFlagVersion = 0x02000000
+
+// ntlmAVFields CHALLENGE消息TargetInfo中的AV对及对应字段
+var ntlmAVFields = map[uint16]string{
+	1: "netbios_computer",
+	2: "netbios_domain",
+	3: "dns_computer",
+	4: "dns_domain",
+	5: "dns_tree",
+}
+
+// ntlmAVTimestamp TargetInfo中的服务端时间（FILETIME）
+const ntlmAVTimestamp = 7
+
+// windowsBuilds NTLM版本字段中的内部版本号对应的Windows版本，客户端和服务端版本共用内部版本号
+var windowsBuilds = map[string]string{
+	"5.1.2600":   "Windows XP",
+	"5.2.3790":   "Windows Server 2003",
+	"6.0.6002":   "Windows Vista / Windows Server 2008",
+	"6.1.7600":   "Windows 7 / Windows Server 2008 R2",
+	"6.1.7601":   "Windows 7 SP1 / Windows Server 2008 R2 SP1",
+	"6.2.9200":   "Windows 8 / Windows Server 2012",
+	"6.3.9600":   "Windows 8.1 / Windows Server 2012 R2",
+	"10.0.10240": "Windows 10 1507",
+	"10.0.14393": "Windows 10 1607 / Windows Server 2016",
+	"10.0.17763": "Windows 10 1809 / Windows Server 2019",
+	"10.0.19041": "Windows 10 2004",
+	"10.0.19044": "Windows 10 21H2",
+	"10.0.19045": "Windows 10 22H2",
+	"10.0.20348": "Windows Server 2022",
+	"10.0.22000": "Windows 11 21H2",
+	"10.0.22621": "Windows 11 22H2",
+	"10.0.22631": "Windows 11 23H2",
+	"10.0.26100": "Windows 11 24H2 / Windows Server 2025",
+}
+
+// ntlmHTTPPaths 依次尝试的HTTP路径：根路径（IIS集成认证）、Exchange的EWS、自动发现和RPC over HTTP、WinRM
+var ntlmHTTPPaths = []string{"/", "/ews/", "/autodiscover/autodiscover.xml", "/rpc/", "/wsman"}
+
+// ntlmHTTPServers 根路径没有要求NTLM认证时，Server头为这些前缀才继续尝试其他路径：IIS（Exchange）和HTTP.sys（WinRM）
+var ntlmHTTPServers = []string{"Microsoft-IIS", "Microsoft-HTTPAPI"}
+
+// ntlmTransports NTLM信息的获取方式
+var ntlmTransports = map[string]func(s *Scanner, target moduleTarget, result *moduleResult) []byte{
+	"http": ntlmOverHTTP,
+	"smb":  ntlmOverSMB,
+	"rdp":  ntlmOverRDP,
+}
+
+// probeNTLM 发送NTLM NEGOTIATE消息，从服务端的CHALLENGE消息中提取主机名、域名和操作系统版本
+// 按端口选择承载协议：445为SMB2会话建立，3389为RDP的CredSSP，其他端口为HTTP认证；端口无法判断时依次尝试
+func probeNTLM(s *Scanner, target moduleTarget) *moduleResult {
+	transports := []string{"http", "smb", "rdp"}
+	switch {
+	case target.port == 445 || s.portHasService(target.port, "smb"):
+		transports = []string{"smb"}
+	case target.port == 3389 || s.portHasService(target.port, "rdp"):
+		transports = []string{"rdp"}
+	case target.port == 80 || target.port == 443 || target.port == 5985 || s.portHasService(target.port, "http", "https"):
+		transports = []string{"http"}
+	}
+
+	for _, transport := range transports {
+		result := &moduleResult{product: "NTLM", details: map[string]string{"transport": transport}}
+		challenge := ntlmTransports[transport](s, target, result)
+		if challenge == nil || !parseNTLMChallenge(result, challenge) {
+			continue
+		}
+		result.response = latin1(challenge)
+		return result
+	}
+	return nil
+}
+
+// portHasService 判断端口配置中该端口是否对应其中一个服务
+func (s *Scanner) portHasService(port uint16, services ...string) bool {
+	for _, service := range s.portServices[port] {
+		for _, name := range services {
+			if service == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ntlmNegotiate 返回NTLM NEGOTIATE（Type 1）消息，不带域名和工作站名
+func ntlmNegotiate() []byte {
+	message := append([]byte{}, ntlmSignature...)
+	message = binary.LittleEndian.AppendUint32(message, 1)
+	message = binary.LittleEndian.AppendUint32(message, ntlmNegotiateFlags)
+	message = append(message, make([]byte, 16)...)              // 域名和工作站名
+	message = append(message, 10, 0, 0x61, 0x4a, 0, 0, 0, 0x0f) // 版本：10.0.19041，NTLM修订版15
+	return message
+}
+
+// findNTLMChallenge 在承载协议的数据（SPNEGO、CredSSP等）中查找CHALLENGE消息
+func findNTLMChallenge(data []byte) []byte {
+	i := bytes.Index(data, ntlmSignature)
+	if i < 0 || len(data) < i+12 || binary.LittleEndian.Uint32(data[i+8:i+12]) != 2 {
+		return nil
+	}
+	return data[i:]
+}
+
+// parseNTLMChallenge 解析CHALLENGE（Type 2）消息：目标名、标志、TargetInfo中的AV对和版本字段
+func parseNTLMChallenge(result *moduleResult, message []byte) bool {
+	if len(message) < 48 || !bytes.HasPrefix(message, ntlmSignature) || binary.LittleEndian.Uint32(message[8:12]) != 2 {
+		return false
+	}
+	if name := ntlmSecurityBuffer(message, 12); name != nil {
+		result.setDetail("ntlm_target", decodeUTF16(name))
+	}
+	flags := binary.LittleEndian.Uint32(message[20:24])
+
+	pairs := ntlmSecurityBuffer(message, 40)
+	for len(pairs) >= 4 {
+		id := binary.LittleEndian.Uint16(pairs[0:2])
+		length := int(binary.LittleEndian.Uint16(pairs[2:4]))
+		if id == 0 || len(pairs) < 4+length {
+			break
+		}
+		value := pairs[4 : 4+length]
+		if key, ok := ntlmAVFields[id]; ok {
+			result.setDetail(key, decodeUTF16(value))
+		} else if id == ntlmAVTimestamp && length == 8 {
+			result.details["system_time"] = filetime(binary.LittleEndian.Uint64(value)).Format(time.RFC3339)
+		}
+		pairs = pairs[4+length:]
+	}
+
+	if flags&ntlmFlagVersion != 0 && len(message) >= 56 && message[48] != 0 {
+		build := binary.LittleEndian.Uint16(message[50:52])
+		version := fmt.Sprintf("%d.%d.%d", message[48], message[49], build)
+		result.details["os_version"] = version
+		result.details["os_build"] = fmt.Sprintf("%d", build)
+		if name, ok := windowsBuilds[version]; ok {
+			result.details["os"] = name
+		}
+	}
+	return true
+}
+
+// ntlmSecurityBuffer 返回消息中offset处的安全缓冲区（长度、最大长度、偏移）指向的数据
+func ntlmSecurityBuffer(message []byte, offset int) []byte {
+	if len(message) < offset+8 {
+		return nil
+	}
+	length := int(binary.LittleEndian.Uint16(message[offset : offset+2]))
+	start := int(binary.LittleEndian.Uint32(message[offset+4 : offset+8]))
+	if length == 0 || start+length > len(message) {
+		return nil
+	}
+	return message[start : start+length]
+}
+
+// decodeUTF16 解码UTF-16LE字符串
+func decodeUTF16(b []byte) string {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+	return string(utf16.Decode(units))
+}
+
+// filetime 将FILETIME（1601年起的100纳秒数）转换为时间
+func filetime(t uint64) time.Time {
+	const epochDelta = 116444736000000000 // 1601-01-01到1970-01-01的100纳秒数
+	if t < epochDelta {
+		return time.Unix(0, 0).UTC()
+	}
+	return time.Unix(0, int64(t-epochDelta)*100).UTC()
+}
+
+// ---------------- HTTP ----------------
+
+// ntlmOverHTTP 依次请求各路径，返回401且WWW-Authenticate提供NTLM或Negotiate时带NEGOTIATE消息重新请求，
+// 从响应的WWW-Authenticate中取得CHALLENGE消息；根路径没有要求NTLM认证且不是IIS或HTTP.sys时不再尝试其他路径，
+// 其他Web服务上只多一次请求
+func ntlmOverHTTP(s *Scanner, target moduleTarget, result *moduleResult) []byte {
+	for _, path := range ntlmHTTPPaths {
+		resp, _, err := s.moduleHTTPGet(target, path)
+		if err != nil {
+			return nil
+		}
+		scheme := ntlmAuthScheme(resp)
+		if resp.StatusCode != http.StatusUnauthorized || scheme == "" {
+			if path == "/" && !microsoftHTTPServer(resp) {
+				return nil
+			}
+			continue
+		}
+
+		header := "Authorization: " + scheme + " " + base64.StdEncoding.EncodeToString(ntlmNegotiate())
+		resp, _, err = s.moduleHTTPRequest(target, "GET", path, "", header)
+		if err != nil {
+			return nil
+		}
+		for _, value := range resp.Header.Values("WWW-Authenticate") {
+			token, ok := strings.CutPrefix(value, scheme+" ")
+			if !ok {
+				continue
+			}
+			data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(token))
+			if challenge := findNTLMChallenge(data); err == nil && challenge != nil {
+				result.details["http_path"] = path
+				result.authRequired = "true"
+				if server := resp.Header.Get("Server"); server != "" {
+					result.details["http_server"] = server
+				}
+				return challenge
+			}
+		}
+	}
+	return nil
+}
+
+// microsoftHTTPServer 判断响应是否来自IIS或HTTP.sys
+func microsoftHTTPServer(resp *http.Response) bool {
+	server := resp.Header.Get("Server")
+	for _, prefix := range ntlmHTTPServers {
+		if strings.HasPrefix(server, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ntlmAuthScheme 返回响应提供的NTLM认证方式：优先NTLM，其次Negotiate（SPNEGO也接受原始的NTLM消息）
+func ntlmAuthScheme(resp *http.Response) string {
+	scheme := ""
+	for _, value := range resp.Header.Values("WWW-Authenticate") {
+		name, _, _ := strings.Cut(strings.TrimSpace(value), " ")
+		switch {
+		case strings.EqualFold(name, "NTLM"):
+			return "NTLM"
+		case strings.EqualFold(name, "Negotiate"):
+			scheme = "Negotiate"
+		}
+	}
+	return scheme
+}
+
+// ---------------- SMB2 ----------------
+
+// ntlmOverSMB 完成SMB2协商后发送携带NEGOTIATE消息的SESSION_SETUP，从响应的安全缓冲区中取得CHALLENGE消息
+func ntlmOverSMB(s *Scanner, target moduleTarget, result *moduleResult) []byte {
+	conn, err := s.smbDial(target)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+
+	negotiate, ok := parseSMB2Negotiate(smbExchange(conn, smb2NegotiateRequest(smb2Dialects)))
+	if !ok {
+		return nil
+	}
+	result.details["smb_dialect"] = smbDialectName(negotiate.dialect)
+
+	message := smbExchange(conn, smb2SessionSetupRequest(ntlmNegotiate()))
+	if len(message) < 64+8 || !bytes.HasPrefix(message, smb2Magic) {
+		return nil
+	}
+	offset := int(binary.LittleEndian.Uint16(message[64+4 : 64+6]))
+	length := int(binary.LittleEndian.Uint16(message[64+6 : 64+8]))
+	if offset+length > len(message) {
+		return nil
+	}
+	return findNTLMChallenge(message[offset : offset+length])
+}
+
+// ---------------- RDP CredSSP ----------------
+
+// derTLV 返回DER编码的标签-长度-值
+func derTLV(tag byte, value []byte) []byte {
+	n := len(value)
+	switch {
+	case n < 0x80:
+		return append([]byte{tag, byte(n)}, value...)
+	case n < 0x100:
+		return append([]byte{tag, 0x81, byte(n)}, value...)
+	default:
+		return append([]byte{tag, 0x82, byte(n >> 8), byte(n)}, value...)
+	}
+}
+
+// credsspNegotiate 返回携带NTLM NEGOTIATE消息的TSRequest：version [0]和negoTokens [1]
+func credsspNegotiate() []byte {
+	version := derTLV(0xa0, derTLV(0x02, []byte{6}))
+	token := derTLV(0x30, derTLV(0xa0, derTLV(0x04, ntlmNegotiate())))
+	negoTokens := derTLV(0xa1, derTLV(0x30, token))
+	return derTLV(0x30, append(version, negoTokens...))
+}
+
+// ntlmOverRDP 协商CredSSP后完成TLS握手，发送TSRequest，从服务端的TSRequest中取得CHALLENGE消息
+func ntlmOverRDP(s *Scanner, target moduleTarget, result *moduleResult) []byte {
+	conn, err := s.moduleDial(target)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+
+	reply, _ := exchangeLimit(conn, string(rdpConnectionRequest(rdpProtocolSSL|rdpProtocolHybrid)), tpktComplete, moduleReplyLimit)
+	negotiation, ok := parseRDPConnectionConfirm([]byte(reply))
+	if !ok || negotiation.selected&(rdpProtocolHybrid|rdpProtocolHybridEx) == 0 {
+		return nil
+	}
+
+	tlsConn, cert, err := s.handshake(conn, target.hostname)
+	if err != nil {
+		return nil
+	}
+	// RDP证书的主题通常为主机的完整计算机名
+	for k, v := range cert {
+		result.details[k] = v
+	}
+	tlsConn.SetDeadline(time.Now().Add(s.readTimeout()))
+	reply, _ = exchangeLimit(tlsConn, string(credsspNegotiate()), berMessageComplete, moduleReplyLimit)
+	return findNTLMChallenge([]byte(reply))
+}
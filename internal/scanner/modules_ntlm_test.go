@@ -0,0 +1,384 @@
+package scanner
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"nebulafinger/internal"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ntlmChallengeServer2019 Windows Server 2019域控（DC01.corp.local）返回的CHALLENGE消息：
+// 目标名CORP，标志0xe28a8215，TargetInfo依次为NetBIOS域名、计算机名、DNS域名、主机名、林名和时间戳，版本10.0.17763
+const ntlmChallengeServer2019 = "" +
+	"4e544c4d5353500002000000080008003800000015828ae28d2fa1c2b3e4f506" +
+	"00000000000000007a007a00400000000a0063450000000f43004f0052005000" +
+	"0200080043004f00520050000100080044004300300031000400140063006f00" +
+	"720070002e006c006f00630061006c0003001e0044004300300031002e006300" +
+	"6f00720070002e006c006f00630061006c000500140063006f00720070002e00" +
+	"6c006f00630061006c000700080000001752b9e1db0100000000"
+
+// ntlmChallengeNLMP MS-NLMP 4.2.4.3中的CHALLENGE消息示例：目标名Server，NetBIOS域名Domain、计算机名Server，版本6.0.6000
+const ntlmChallengeNLMP = "" +
+	"4e544c4d53535000020000000c000c003800000033828ae20123456789abcdef" +
+	"00000000000000002400240044000000060070170000000f5300650072007600" +
+	"6500720002000c0044006f006d00610069006e0001000c005300650072007600" +
+	"6500720000000000"
+
+// SMB2 SESSION_SETUP响应中包裹CHALLENGE消息的SPNEGO negTokenResp头部：accept-incomplete、NTLMSSP机制和responseToken
+const spnegoChallengePrefix = "a181d63081d3a0030a0101a10c060a2b06010401823702020aa281bd0481ba"
+
+// CredSSP服务端TSRequest头部：version 6和包含CHALLENGE消息的negoTokens
+const tsRequestChallengePrefix = "3081cea003020106a181c63081c33081c0a081bd0481ba"
+
+// smb2SessionSetupChallengeHeader SMB2 SESSION_SETUP响应的消息头和固定部分：
+// 状态STATUS_MORE_PROCESSING_REQUIRED，安全缓冲区偏移72、长度217
+const smb2SessionSetupChallengeHeader = "" +
+	"fe534d4240000000160000c00100010001000000000000000100000000000000" +
+	"fffe000000000000050000000004000000000000000000000000000000000000" +
+	"090000004800d900"
+
+// smb2NegotiateReply311 Windows Server 2019的SMB2 NEGOTIATE响应：方言3.1.1，启用但不要求签名，能力0x2f，
+// 最大读写长度8MB，两个协商上下文（SHA-512预认证完整性、AES-128-GCM加密）
+const smb2NegotiateReply311 = "" +
+	"fe534d4240000000000000000000010001000000000000000000000000000000" +
+	"fffe000000000000000000000000000000000000000000000000000000000000" +
+	"41000100110302005c3e9b1d7a2f4e48b1c0d2e3f4a5b6c72f00000000008000" +
+	"000080000000800000001752b9e1db01000000000000000080002a00b0000000" +
+	"602806062b0601050502a01e301ca01a3018060a2b06010401823702021e060a" +
+	"2b06010401823702020a00000000000001002600000000000100200001002021" +
+	"22232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f0000" +
+	"020004000000000001000200"
+
+// readNetBIOSFrame 读取一个NetBIOS会话消息，返回去掉会话头的SMB消息
+func readNetBIOSFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	message := make([]byte, int(header[1])<<16|int(header[2])<<8|int(header[3]))
+	_, err := io.ReadFull(r, message)
+	return message, err
+}
+
+func TestParseNTLMChallenge(t *testing.T) {
+	server2019 := fixture(ntlmChallengeServer2019)
+
+	// 清除版本标志的消息不解析版本字段
+	noVersion := append([]byte{}, server2019...)
+	binary.LittleEndian.PutUint32(noVersion[20:24], binary.LittleEndian.Uint32(noVersion[20:24])&^ntlmFlagVersion)
+
+	// 第二个AV对（NetBIOS计算机名）的长度超出TargetInfo时停止解析AV对
+	badPair := append([]byte{}, server2019...)
+	binary.LittleEndian.PutUint16(badPair[64+12+2:], 0xff)
+
+	// TargetInfo超出消息长度时不解析AV对，目标名和版本仍然有效
+	truncatedInfo := server2019[:len(server2019)-20]
+
+	tests := []struct {
+		name    string
+		message []byte
+		ok      bool
+		want    map[string]string
+		absent  []string
+	}{
+		{
+			name:    "Windows Server 2019",
+			message: server2019,
+			ok:      true,
+			want: map[string]string{
+				"ntlm_target":      "CORP",
+				"netbios_domain":   "CORP",
+				"netbios_computer": "DC01",
+				"dns_domain":       "corp.local",
+				"dns_computer":     "DC01.corp.local",
+				"dns_tree":         "corp.local",
+				"system_time":      "2025-06-20T08:00:00Z",
+				"os_version":       "10.0.17763",
+				"os_build":         "17763",
+				"os":               "Windows 10 1809 / Windows Server 2019",
+			},
+		},
+		{
+			name:    "MS-NLMP示例",
+			message: fixture(ntlmChallengeNLMP),
+			ok:      true,
+			want: map[string]string{
+				"ntlm_target":      "Server",
+				"netbios_domain":   "Domain",
+				"netbios_computer": "Server",
+				"os_version":       "6.0.6000",
+				"os_build":         "6000",
+			},
+			absent: []string{"os", "system_time", "dns_computer"},
+		},
+		{
+			name:    "没有版本标志",
+			message: noVersion,
+			ok:      true,
+			want:    map[string]string{"dns_computer": "DC01.corp.local"},
+			absent:  []string{"os_version", "os_build", "os"},
+		},
+		{
+			name:    "AV对长度越界",
+			message: badPair,
+			ok:      true,
+			want:    map[string]string{"netbios_domain": "CORP", "os_version": "10.0.17763"},
+			absent:  []string{"netbios_computer", "dns_computer", "system_time"},
+		},
+		{
+			name:    "TargetInfo被截断",
+			message: truncatedInfo,
+			ok:      true,
+			want:    map[string]string{"ntlm_target": "CORP", "os_version": "10.0.17763"},
+			absent:  []string{"netbios_domain", "dns_computer"},
+		},
+		{name: "消息头被截断", message: server2019[:40]},
+		{name: "NEGOTIATE消息", message: ntlmNegotiate()},
+		{name: "签名错误", message: append([]byte("NTLMSSX\x00"), server2019[8:]...)},
+		{name: "空消息", message: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &moduleResult{details: map[string]string{}}
+			if ok := parseNTLMChallenge(result, tt.message); ok != tt.ok {
+				t.Fatalf("parseNTLMChallenge = %v，期望 %v", ok, tt.ok)
+			}
+			for k, v := range tt.want {
+				if result.details[k] != v {
+					t.Errorf("%s = %q，期望 %q", k, result.details[k], v)
+				}
+			}
+			for _, k := range tt.absent {
+				if v, ok := result.details[k]; ok {
+					t.Errorf("不应有 %s，得到 %q", k, v)
+				}
+			}
+		})
+	}
+}
+
+func TestFindNTLMChallenge(t *testing.T) {
+	challenge := fixture(ntlmChallengeServer2019)
+	tests := []struct {
+		name string
+		data []byte
+		ok   bool
+	}{
+		{"HTTP WWW-Authenticate中的原始消息", challenge, true},
+		{"SMB2的SPNEGO negTokenResp", fixture(spnegoChallengePrefix + ntlmChallengeServer2019), true},
+		{"CredSSP TSRequest", fixture(tsRequestChallengePrefix + ntlmChallengeServer2019), true},
+		{"NEGOTIATE消息", ntlmNegotiate(), false},
+		{"只有签名", ntlmSignature, false},
+		{"没有NTLM消息", []byte("HTTP/1.1 401 Unauthorized\r\n\r\n"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found := findNTLMChallenge(tt.data)
+			if (found != nil) != tt.ok {
+				t.Fatalf("findNTLMChallenge 找到 = %v，期望 %v", found != nil, tt.ok)
+			}
+			if tt.ok && string(found) != string(challenge) {
+				t.Errorf("找到的消息与CHALLENGE消息不一致")
+			}
+		})
+	}
+}
+
+// ntlmHTTPHandler 模拟IIS的NTLM认证：请求路径在protected中时，没有凭据返回401并提供Negotiate和NTLM，
+// 收到NEGOTIATE消息时返回CHALLENGE消息；其他路径返回200
+func ntlmHTTPHandler(server string, protected map[string]bool, requests *int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(requests, 1)
+		w.Header().Set("Server", server)
+		if !protected[r.URL.Path] {
+			fmt.Fprint(w, "<html>welcome</html>")
+			return
+		}
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "NTLM "); ok {
+			if message, err := base64.StdEncoding.DecodeString(token); err == nil && len(message) > 12 && message[8] == 1 {
+				w.Header().Set("WWW-Authenticate", "NTLM "+base64.StdEncoding.EncodeToString(fixture(ntlmChallengeServer2019)))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		w.Header().Add("WWW-Authenticate", "Negotiate")
+		w.Header().Add("WWW-Authenticate", "NTLM")
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+}
+
+func TestNTLMOverHTTP(t *testing.T) {
+	tests := []struct {
+		name      string
+		server    string
+		protected map[string]bool
+		path      string // 期望取得CHALLENGE消息的路径，为空时不应取得
+		requests  int64  // 期望的请求数
+	}{
+		{"IIS根路径集成认证", "Microsoft-IIS/10.0", map[string]bool{"/": true}, "/", 2},
+		{"Exchange EWS", "Microsoft-IIS/10.0", map[string]bool{"/ews/": true}, "/ews/", 3},
+		{"WinRM", "Microsoft-HTTPAPI/2.0", map[string]bool{"/wsman": true}, "/wsman", 6},
+		{"IIS没有NTLM认证", "Microsoft-IIS/10.0", nil, "", 5},
+		{"其他Web服务只请求根路径", "nginx/1.24.0", map[string]bool{"/ews/": true}, "", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int64
+			port := httpStandIn(t, ntlmHTTPHandler(tt.server, tt.protected, &requests))
+			s := newTestScanner(nil, ScannerConfig{})
+			result := &moduleResult{details: map[string]string{}}
+
+			challenge := ntlmOverHTTP(s, localTarget(port), result)
+			if n := atomic.LoadInt64(&requests); n != tt.requests {
+				t.Errorf("请求数 = %d，期望 %d", n, tt.requests)
+			}
+			if tt.path == "" {
+				if challenge != nil {
+					t.Fatalf("不应取得CHALLENGE消息")
+				}
+				return
+			}
+			if challenge == nil || !parseNTLMChallenge(result, challenge) {
+				t.Fatalf("没有取得CHALLENGE消息")
+			}
+			if result.details["http_path"] != tt.path || result.details["http_server"] != tt.server || result.authRequired != "true" {
+				t.Errorf("http_path = %q, http_server = %q, auth_required = %q", result.details["http_path"], result.details["http_server"], result.authRequired)
+			}
+			if result.details["dns_computer"] != "DC01.corp.local" {
+				t.Errorf("dns_computer = %q", result.details["dns_computer"])
+			}
+		})
+	}
+}
+
+func TestNTLMOverSMB(t *testing.T) {
+	port := tcpStandIn(t, func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		for {
+			message, err := readNetBIOSFrame(reader)
+			if err != nil || len(message) < 64 {
+				return
+			}
+			switch binary.LittleEndian.Uint16(message[12:14]) {
+			case smb2Negotiate:
+				conn.Write(netbiosFrame(fixture(smb2NegotiateReply311)))
+			case smb2SessionSetup:
+				// 请求的安全缓冲区必须是NEGOTIATE消息
+				if !strings.Contains(string(message), string(ntlmNegotiate())) {
+					return
+				}
+				conn.Write(netbiosFrame(fixture(smb2SessionSetupChallengeHeader + spnegoChallengePrefix + ntlmChallengeServer2019)))
+			}
+		}
+	})
+	s := newTestScanner(nil, ScannerConfig{})
+	result := &moduleResult{details: map[string]string{}}
+
+	challenge := ntlmOverSMB(s, localTarget(port), result)
+	if challenge == nil || !parseNTLMChallenge(result, challenge) {
+		t.Fatalf("没有取得CHALLENGE消息")
+	}
+	if result.details["smb_dialect"] != "3.1.1" || result.details["netbios_computer"] != "DC01" || result.details["os_version"] != "10.0.17763" {
+		t.Errorf("详情 = %v", result.details)
+	}
+}
+
+func TestNTLMOverRDP(t *testing.T) {
+	tlsConfig := selfSignedTLSConfig(t, "DC01.corp.local")
+	port := tcpStandIn(t, func(conn net.Conn) {
+		request := make([]byte, 19)
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+		// 请求中包含CredSSP时选择CredSSP，随后在TLS上收到TSRequest并返回带CHALLENGE消息的TSRequest
+		if binary.LittleEndian.Uint32(request[15:19])&rdpProtocolHybrid == 0 {
+			conn.Write(fixture(rdpConfirmHybridRequired))
+			return
+		}
+		conn.Write(fixture(rdpConfirmHybrid))
+		tlsConn := tls.Server(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return
+		}
+		reply, _ := exchangeLimit(tlsConn, "", berMessageComplete, moduleReplyLimit)
+		if findNTLMNegotiate([]byte(reply)) {
+			tlsConn.Write(fixture(tsRequestChallengePrefix + ntlmChallengeServer2019))
+		}
+	})
+	s := newTestScanner(nil, ScannerConfig{})
+	result := &moduleResult{details: map[string]string{}}
+
+	challenge := ntlmOverRDP(s, localTarget(port), result)
+	if challenge == nil || !parseNTLMChallenge(result, challenge) {
+		t.Fatalf("没有取得CHALLENGE消息")
+	}
+	if result.details["dns_computer"] != "DC01.corp.local" || result.details["tls_cert_subject"] == "" {
+		t.Errorf("详情 = %v", result.details)
+	}
+}
+
+// findNTLMNegotiate 判断数据中是否包含NTLM NEGOTIATE消息
+func findNTLMNegotiate(data []byte) bool {
+	i := strings.Index(string(data), string(ntlmSignature))
+	return i >= 0 && len(data) >= i+12 && binary.LittleEndian.Uint32(data[i+8:i+12]) == 1
+}
+
+// X.224连接确认：要求CredSSP时拒绝其他协议（HYBRID_REQUIRED_BY_SERVER），请求CredSSP时选择CredSSP
+const (
+	rdpConfirmHybridRequired = "030000130ed000001234000300080005000000"
+	rdpConfirmHybrid         = "030000130ed00000123400021f080002000000"
+)
+
+// TestNTLMModuleIsSupplementary NTLM补充模块不参与端口的协议模块识别，结果在端口识别完成后追加
+func TestNTLMModuleIsSupplementary(t *testing.T) {
+	var requests int64
+	port := httpStandIn(t, ntlmHTTPHandler("Microsoft-IIS/10.0", map[string]bool{"/": true}, &requests))
+	s := newTestScanner(nil, ScannerConfig{Timeout: 300 * time.Millisecond, ServicePorts: map[string][]uint16{"ntlm": {port}}})
+
+	if _, ok := s.matchModules("127.0.0.1", port, &tcpBanner{}); ok {
+		t.Errorf("补充模块不应作为协议模块的识别结果")
+	}
+	results, ok := s.matchTcpPortFingerprints("127.0.0.1", port, nil)
+	if !ok || len(results) != 1 || results[0].ID != "ntlm" {
+		t.Fatalf("结果 = %+v，期望一条NTLM结果", results)
+	}
+	if results[0].Details["dns_computer"] != "DC01.corp.local" || results[0].Details["transport"] != "http" {
+		t.Errorf("NTLM详情 = %v", results[0].Details)
+	}
+}
+
+// TestNTLMModuleKeepsOtherResults NTLM补充模块的结果追加到端口的其他识别结果之后
+func TestNTLMModuleKeepsOtherResults(t *testing.T) {
+	var requests int64
+	port := httpStandIn(t, ntlmHTTPHandler("Microsoft-IIS/10.0", map[string]bool{"/": true}, &requests))
+	fingerprints := []internal.Fingerprint{{
+		ID:   "http-get",
+		Info: internal.Info{Name: "http", Tags: "http"},
+		TCP: []internal.TCPRequest{{
+			Name:     "GetRequest",
+			Inputs:   []internal.Input{{Data: "GET / HTTP/1.0\r\n\r\n"}},
+			Matchers: []internal.Matchers{{Type: "regex", Part: "response", Regex: []string{`^HTTP/1\.[01] \d\d\d`}}},
+		}},
+	}}
+	s := newTestScanner(fingerprints, ScannerConfig{Timeout: 300 * time.Millisecond, ServicePorts: map[string][]uint16{"http": {port}, "ntlm": {port}}})
+
+	results, ok := s.matchTcpPortFingerprints("127.0.0.1", port, nil)
+	if !ok || len(results) != 2 {
+		t.Fatalf("结果数 = %d，期望HTTP和NTLM两条", len(results))
+	}
+	if results[0].ID != "http-get" || results[1].ID != "ntlm" {
+		t.Errorf("结果 = %s, %s，期望 http-get, ntlm", results[0].ID, results[1].ID)
+	}
+	if results[1].Details["dns_computer"] != "DC01.corp.local" || results[1].Details["transport"] != "http" {
+		t.Errorf("NTLM详情 = %v", results[1].Details)
+	}
+}
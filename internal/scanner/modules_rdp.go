@@ -0,0 +1,159 @@
+package scanner
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerModules(
+		&serviceModule{name: "rdp", ports: []uint16{3389}, tags: "detect,windows,rdp", probe: probeRDP},
+	)
+}
+
+// RDP_NEG_REQ中的安全协议
+const (
+	rdpProtocolRDP      = 0x00000000 // 标准RDP安全
+	rdpProtocolSSL      = 0x00000001 // TLS
+	rdpProtocolHybrid   = 0x00000002 // CredSSP（NLA）
+	rdpProtocolRDSTLS   = 0x00000004 // RDSTLS
+	rdpProtocolHybridEx = 0x00000008 // CredSSP并带Early User Authorization Result
+)
+
+// rdpProtocols 逐个协商的安全协议及名称
+var rdpProtocols = []struct {
+	protocol uint32
+	name     string
+}{
+	{rdpProtocolRDP, "rdp"},
+	{rdpProtocolSSL, "tls"},
+	{rdpProtocolHybrid, "credssp"},
+	{rdpProtocolRDSTLS, "rdstls"},
+	{rdpProtocolHybridEx, "credssp_ex"},
+}
+
+// rdpFailures RDP_NEG_FAILURE中的失败码
+var rdpFailures = map[uint32]string{
+	1: "SSL_REQUIRED_BY_SERVER",
+	2: "SSL_NOT_ALLOWED_BY_SERVER",
+	3: "SSL_CERT_NOT_ON_SERVER",
+	4: "INCONSISTENT_FLAGS",
+	5: "HYBRID_REQUIRED_BY_SERVER",
+	6: "SSL_WITH_USER_AUTH_REQUIRED_BY_SERVER",
+}
+
+// rdpServerFlags RDP_NEG_RSP中的服务端标志
+var rdpServerFlags = []struct {
+	flag byte
+	name string
+}{
+	{0x01, "extended_client_data"},
+	{0x02, "dynvc_gfx"},
+	{0x08, "restricted_admin"},
+	{0x10, "redirected_authentication"},
+}
+
+// rdpNegotiation X.224连接确认中的协商结果
+type rdpNegotiation struct {
+	negotiated bool   // 是否带有RDP_NEG_RSP或RDP_NEG_FAILURE，旧版本服务端不带，只支持标准RDP安全
+	selected   uint32 // 服务端选择的安全协议
+	flags      byte   // RDP_NEG_RSP中的服务端标志
+	failure    uint32 // RDP_NEG_FAILURE中的失败码，0表示协商成功
+}
+
+// probeRDP 逐个请求各安全协议，根据X.224连接确认判断服务端支持的协议和是否要求NLA；支持CredSSP时再获取NTLM信息
+func probeRDP(s *Scanner, target moduleTarget) *moduleResult {
+	var result *moduleResult
+	var supported []string
+	accepted := make(map[string]bool)
+	var flags byte
+	for _, p := range rdpProtocols {
+		reply, _ := s.moduleExchange(target, rdpConnectionRequest(p.protocol), tpktComplete)
+		negotiation, ok := parseRDPConnectionConfirm(reply)
+		if !ok {
+			// 第一次协商就不是X.224连接确认时不是RDP
+			if result == nil {
+				return nil
+			}
+			continue
+		}
+		if result == nil {
+			result = &moduleResult{product: "RDP", details: map[string]string{}, response: latin1(reply)}
+		}
+
+		switch {
+		case !negotiation.negotiated:
+			// 旧版本服务端忽略协商请求，只支持标准RDP安全
+			result.details["security_protocols"] = "rdp"
+			result.details["nla_required"] = "false"
+			return result
+		case negotiation.failure != 0:
+			if p.protocol == rdpProtocolRDP {
+				result.details["rdp_failure"] = rdpFailures[negotiation.failure]
+				if result.details["rdp_failure"] == "" {
+					result.details["rdp_failure"] = strconv.Itoa(int(negotiation.failure))
+				}
+			}
+		case negotiation.selected == p.protocol:
+			supported = append(supported, p.name)
+			accepted[p.name] = true
+			flags |= negotiation.flags
+		}
+	}
+	if result == nil {
+		return nil
+	}
+
+	result.details["security_protocols"] = strings.Join(supported, ",")
+	credssp := accepted["credssp"] || accepted["credssp_ex"]
+	// 标准RDP安全和单独的TLS都被拒绝、只接受CredSSP时要求NLA
+	nla := !accepted["rdp"] && !accepted["tls"] && credssp
+	result.details["nla_required"] = strconv.FormatBool(nla)
+	var names []string
+	for _, f := range rdpServerFlags {
+		if flags&f.flag != 0 {
+			names = append(names, f.name)
+		}
+	}
+	if len(names) > 0 {
+		result.details["server_flags"] = strings.Join(names, ",")
+	}
+
+	if credssp {
+		if challenge := ntlmOverRDP(s, target, result); challenge != nil {
+			parseNTLMChallenge(result, challenge)
+		}
+	}
+	return result
+}
+
+// rdpConnectionRequest 返回X.224连接请求，RDP_NEG_REQ请求指定的安全协议
+func rdpConnectionRequest(protocols uint32) []byte {
+	request := []byte{0x03, 0x00, 0x00, 0x13, 0x0e, 0xe0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x08, 0x00}
+	return binary.LittleEndian.AppendUint32(request, protocols)
+}
+
+// parseRDPConnectionConfirm 解析X.224连接确认：TPKT头、X.224 CC（长度、0xD0、目标和源引用、类别），
+// 之后为可选的RDP_NEG_RSP（类型2）或RDP_NEG_FAILURE（类型3）：类型、标志、长度和4字节的协议或失败码
+func parseRDPConnectionConfirm(reply []byte) (rdpNegotiation, bool) {
+	var negotiation rdpNegotiation
+	if len(reply) < 11 || reply[0] != 0x03 || reply[5]&0xf0 != 0xd0 {
+		return negotiation, false
+	}
+	if len(reply) < 19 {
+		return negotiation, true
+	}
+	negotiation.negotiated = true
+	value := binary.LittleEndian.Uint32(reply[15:19])
+	switch reply[11] {
+	case 0x02:
+		negotiation.flags = reply[12]
+		negotiation.selected = value
+	case 0x03:
+		negotiation.failure = value
+	default:
+		negotiation.negotiated = false
+	}
+	return negotiation, true
+}
@@ -0,0 +1,189 @@
+package scanner
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+// rdpConfirmLegacy 不支持协商的旧版本服务端（Windows XP/2003）返回的X.224连接确认，没有RDP_NEG_RSP
+const rdpConfirmLegacy = "0300000b06d00000123400"
+
+func TestParseRDPConnectionConfirm(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		ok    bool
+		want  rdpNegotiation
+	}{
+		{
+			name:  "要求CredSSP",
+			reply: rdpConfirmHybridRequired,
+			ok:    true,
+			want:  rdpNegotiation{negotiated: true, failure: 5},
+		},
+		{
+			name:  "选择CredSSP并带服务端标志",
+			reply: rdpConfirmHybrid,
+			ok:    true,
+			want:  rdpNegotiation{negotiated: true, selected: rdpProtocolHybrid, flags: 0x1f},
+		},
+		{
+			name:  "选择标准RDP安全",
+			reply: "030000130ed0000012340002000800" + "00000000",
+			ok:    true,
+			want:  rdpNegotiation{negotiated: true, selected: rdpProtocolRDP},
+		},
+		{
+			name:  "旧版本服务端",
+			reply: rdpConfirmLegacy,
+			ok:    true,
+			want:  rdpNegotiation{},
+		},
+		{
+			name:  "未知协商类型",
+			reply: "030000130ed0000012340009000800" + "02000000",
+			ok:    true,
+			want:  rdpNegotiation{},
+		},
+		{name: "不是TPKT", reply: "485454502f312e31203430300d0a0d0a"},
+		{name: "不是连接确认", reply: "030000130ee00000000000010008000b000000"},
+		{name: "响应被截断", reply: "0300000b06d0"},
+		{name: "空响应", reply: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			negotiation, ok := parseRDPConnectionConfirm(fixture(tt.reply))
+			if ok != tt.ok {
+				t.Fatalf("parseRDPConnectionConfirm = %v，期望 %v", ok, tt.ok)
+			}
+			if negotiation != tt.want {
+				t.Errorf("协商结果 = %+v，期望 %+v", negotiation, tt.want)
+			}
+		})
+	}
+}
+
+// rdpConfirm 返回X.224连接确认，negType为2时value为选择的协议，为3时为失败码
+func rdpConfirm(negType, flags byte, value uint32) []byte {
+	reply := []byte{0x03, 0x00, 0x00, 0x13, 0x0e, 0xd0, 0x00, 0x00, 0x12, 0x34, 0x00, negType, flags, 0x08, 0x00}
+	return binary.LittleEndian.AppendUint32(reply, value)
+}
+
+// rdpStandIn 模拟RDP服务端：请求的协议中有protocols支持的协议时选择该协议（优先CredSSP），否则返回失败码failure；
+// 选择CredSSP时完成TLS握手并在TSRequest中返回NTLM CHALLENGE消息
+func rdpStandIn(t *testing.T, protocols map[uint32]bool, failure uint32) uint16 {
+	tlsConfig := selfSignedTLSConfig(t, "DC01.corp.local")
+	return tcpStandIn(t, func(conn net.Conn) {
+		request := make([]byte, 19)
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+		requested := binary.LittleEndian.Uint32(request[15:19])
+		for _, p := range []uint32{rdpProtocolHybridEx, rdpProtocolHybrid, rdpProtocolSSL, rdpProtocolRDSTLS, rdpProtocolRDP} {
+			if !protocols[p] || (p == rdpProtocolRDP) != (requested == rdpProtocolRDP) || p != rdpProtocolRDP && requested&p == 0 {
+				continue
+			}
+			conn.Write(rdpConfirm(2, 0x1f, p))
+			if p != rdpProtocolHybrid && p != rdpProtocolHybridEx {
+				return
+			}
+			tlsConn := tls.Server(conn, tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			if reply, _ := exchangeLimit(tlsConn, "", berMessageComplete, moduleReplyLimit); findNTLMNegotiate([]byte(reply)) {
+				tlsConn.Write(fixture(tsRequestChallengePrefix + ntlmChallengeServer2019))
+			}
+			return
+		}
+		conn.Write(rdpConfirm(3, 0, failure))
+	})
+}
+
+func TestProbeRDP(t *testing.T) {
+	tests := []struct {
+		name      string
+		protocols map[uint32]bool
+		failure   uint32
+		want      map[string]string
+		absent    []string
+	}{
+		{
+			name:      "要求NLA",
+			protocols: map[uint32]bool{rdpProtocolHybrid: true, rdpProtocolHybridEx: true},
+			failure:   5,
+			want: map[string]string{
+				"security_protocols": "credssp,credssp_ex",
+				"nla_required":       "true",
+				"rdp_failure":        "HYBRID_REQUIRED_BY_SERVER",
+				"server_flags":       "extended_client_data,dynvc_gfx,restricted_admin,redirected_authentication",
+				"dns_computer":       "DC01.corp.local",
+				"os_version":         "10.0.17763",
+			},
+		},
+		{
+			name:      "不要求NLA",
+			protocols: map[uint32]bool{rdpProtocolRDP: true, rdpProtocolSSL: true, rdpProtocolHybrid: true},
+			failure:   2,
+			want: map[string]string{
+				"security_protocols": "rdp,tls,credssp",
+				"nla_required":       "false",
+				"netbios_computer":   "DC01",
+			},
+			absent: []string{"rdp_failure"},
+		},
+		{
+			name:      "只支持TLS",
+			protocols: map[uint32]bool{rdpProtocolSSL: true},
+			failure:   1,
+			want: map[string]string{
+				"security_protocols": "tls",
+				"nla_required":       "false",
+				"rdp_failure":        "SSL_REQUIRED_BY_SERVER",
+			},
+			absent: []string{"dns_computer"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := rdpStandIn(t, tt.protocols, tt.failure)
+			result := probeRDP(newTestScanner(nil, ScannerConfig{}), localTarget(port))
+			if result == nil {
+				t.Fatal("没有识别为RDP")
+			}
+			for k, v := range tt.want {
+				if result.details[k] != v {
+					t.Errorf("%s = %q，期望 %q", k, result.details[k], v)
+				}
+			}
+			for _, k := range tt.absent {
+				if v, ok := result.details[k]; ok {
+					t.Errorf("不应有 %s，得到 %q", k, v)
+				}
+			}
+		})
+	}
+}
+
+func TestProbeRDPLegacy(t *testing.T) {
+	port := tcpStandIn(t, func(conn net.Conn) {
+		io.ReadFull(conn, make([]byte, 19))
+		conn.Write(fixture(rdpConfirmLegacy))
+	})
+	result := probeRDP(newTestScanner(nil, ScannerConfig{}), localTarget(port))
+	if result == nil || result.details["security_protocols"] != "rdp" || result.details["nla_required"] != "false" {
+		t.Errorf("旧版本服务端结果 = %v", result)
+	}
+}
+
+func TestProbeRDPNotRDP(t *testing.T) {
+	port := tcpStandIn(t, func(conn net.Conn) {
+		conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+	})
+	if result := probeRDP(newTestScanner(nil, ScannerConfig{}), localTarget(port)); result != nil {
+		t.Errorf("非RDP服务不应识别，得到 %v", result.details)
+	}
+}
@@ -0,0 +1,351 @@
+package scanner
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerModules(
+		&serviceModule{name: "smb", ports: []uint16{445, 139}, tags: "detect,windows,smb", probe: probeSMB},
+	)
+}
+
+// SMB消息的协议标识
+var (
+	smb1Magic = []byte("\xffSMB")
+	smb2Magic = []byte("\xfeSMB")
+)
+
+// SMB2命令
+const (
+	smb2Negotiate    = 0x0000
+	smb2SessionSetup = 0x0001
+)
+
+// smb2Dialects NEGOTIATE请求中的方言：2.0.2、2.1、3.0、3.0.2、3.1.1
+var smb2Dialects = []uint16{0x0202, 0x0210, 0x0300, 0x0302, 0x0311}
+
+// smb2Capabilities NEGOTIATE响应中的服务端能力
+var smb2Capabilities = []struct {
+	flag uint32
+	name string
+}{
+	{0x01, "dfs"},
+	{0x02, "leasing"},
+	{0x04, "large_mtu"},
+	{0x08, "multi_channel"},
+	{0x10, "persistent_handles"},
+	{0x20, "directory_leasing"},
+	{0x40, "encryption"},
+}
+
+// smb2Ciphers SMB 3.1.1加密能力上下文中的加密算法
+var smb2Ciphers = map[uint16]string{
+	0x0001: "AES-128-CCM",
+	0x0002: "AES-128-GCM",
+	0x0003: "AES-256-CCM",
+	0x0004: "AES-256-GCM",
+}
+
+// smb2NegotiateInfo SMB2 NEGOTIATE响应中的服务端信息
+type smb2NegotiateInfo struct {
+	securityMode uint16   // 安全模式：0x01启用签名，0x02要求签名
+	dialect      uint16   // 服务端选择的方言
+	serverGUID   [16]byte // 服务端GUID
+	capabilities uint32   // 服务端能力
+	maxReadSize  uint32   // 最大读取长度
+	systemTime   uint64   // 服务端时间（FILETIME）
+	startTime    uint64   // 服务启动时间（FILETIME），多数服务端为0
+	cipher       uint16   // 3.1.1协商的加密算法，0表示未协商
+}
+
+// probeSMB 用SMB2 NEGOTIATE获取最高方言、签名要求、服务端GUID和时间，再分别协商每个方言得到支持的方言列表；
+// 另用只包含NT LM 0.12方言的SMB1 NEGOTIATE判断是否支持SMBv1，最后通过SESSION_SETUP获取NTLM信息
+func probeSMB(s *Scanner, target moduleTarget) *moduleResult {
+	message := s.smbRequest(target, smb2NegotiateRequest(smb2Dialects))
+	negotiate, smb2 := parseSMB2Negotiate(message)
+	smb1 := parseSMB1Negotiate(s.smbRequest(target, smb1NegotiateRequest()))
+	if !smb2 && !smb1 {
+		return nil
+	}
+
+	result := &moduleResult{product: "SMB", details: map[string]string{}, response: latin1(message)}
+	result.details["smbv1"] = strconv.FormatBool(smb1)
+	if !smb2 {
+		result.details["smb_dialects"] = "NT LM 0.12"
+		return result
+	}
+	negotiate.fields(result.details)
+
+	var dialects []string
+	for _, dialect := range smb2Dialects {
+		if dialect != negotiate.dialect {
+			info, ok := parseSMB2Negotiate(s.smbRequest(target, smb2NegotiateRequest([]uint16{dialect})))
+			if !ok || info.dialect != dialect {
+				continue
+			}
+		}
+		dialects = append(dialects, smbDialectName(dialect))
+	}
+	result.details["smb_dialects"] = strings.Join(dialects, ",")
+
+	if challenge := ntlmOverSMB(s, target, result); challenge != nil {
+		parseNTLMChallenge(result, challenge)
+	}
+	return result
+}
+
+// fields 将NEGOTIATE响应中的信息记录到详情中
+func (n smb2NegotiateInfo) fields(details map[string]string) {
+	details["smb_dialect"] = smbDialectName(n.dialect)
+	details["signing_enabled"] = strconv.FormatBool(n.securityMode&0x01 != 0)
+	details["signing_required"] = strconv.FormatBool(n.securityMode&0x02 != 0)
+	details["server_guid"] = formatGUID(n.serverGUID[:])
+	var capabilities []string
+	for _, c := range smb2Capabilities {
+		if n.capabilities&c.flag != 0 {
+			capabilities = append(capabilities, c.name)
+		}
+	}
+	details["capabilities"] = strings.Join(capabilities, ",")
+	details["max_read_size"] = strconv.FormatUint(uint64(n.maxReadSize), 10)
+	if n.systemTime != 0 {
+		details["system_time"] = filetime(n.systemTime).Format(time.RFC3339)
+	}
+	if n.startTime != 0 {
+		details["server_start_time"] = filetime(n.startTime).Format(time.RFC3339)
+	}
+	if cipher, ok := smb2Ciphers[n.cipher]; ok {
+		details["encryption_cipher"] = cipher
+	}
+}
+
+// smbDialectNames 方言的版本号
+var smbDialectNames = map[uint16]string{
+	0x0202: "2.0.2",
+	0x0210: "2.1",
+	0x0300: "3.0",
+	0x0302: "3.0.2",
+	0x0311: "3.1.1",
+}
+
+// smbDialectName 返回方言的版本号，未知方言返回十六进制值
+func smbDialectName(dialect uint16) string {
+	if name, ok := smbDialectNames[dialect]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04x", dialect)
+}
+
+// formatGUID 按Windows的混合字节序格式化GUID
+func formatGUID(b []byte) string {
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x", binary.LittleEndian.Uint32(b[0:4]), binary.LittleEndian.Uint16(b[4:6]),
+		binary.LittleEndian.Uint16(b[6:8]), b[8:10], b[10:16])
+}
+
+// smbDial 建立SMB连接：139端口先完成NetBIOS会话请求，445端口直接承载SMB
+func (s *Scanner) smbDial(target moduleTarget) (net.Conn, error) {
+	conn, err := s.moduleDial(target)
+	if err != nil || target.port != 139 {
+		return conn, err
+	}
+	reply, _ := exchangeLimit(conn, string(netbiosSessionRequest()), netbiosComplete, moduleReplyLimit)
+	if len(reply) < 4 || reply[0] != 0x82 {
+		conn.Close()
+		return nil, errors.New("netbios session rejected")
+	}
+	return conn, nil
+}
+
+// smbRequest 建立新连接发送一个SMB请求，返回去掉NetBIOS会话头的响应
+func (s *Scanner) smbRequest(target moduleTarget, request []byte) []byte {
+	conn, err := s.smbDial(target)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	return smbExchange(conn, request)
+}
+
+// smbExchange 在已有连接上发送SMB请求，返回去掉NetBIOS会话头的响应
+func smbExchange(conn net.Conn, request []byte) []byte {
+	reply, _ := exchangeLimit(conn, string(request), netbiosComplete, moduleReplyLimit)
+	if len(reply) < 4 {
+		return nil
+	}
+	return []byte(reply[4:])
+}
+
+// netbiosFrame 为SMB消息加上4字节的NetBIOS会话头
+func netbiosFrame(message []byte) []byte {
+	return append([]byte{0, byte(len(message) >> 16), byte(len(message) >> 8), byte(len(message))}, message...)
+}
+
+// netbiosComplete 按NetBIOS会话头中的长度判断消息是否读取完整
+var netbiosComplete = lengthComplete(func(b []byte) int {
+	if len(b) < 4 {
+		return -1
+	}
+	return 4 + (int(b[1])<<16 | int(b[2])<<8 | int(b[3]))
+})
+
+// netbiosSessionRequest 返回139端口的NetBIOS会话请求，被叫名使用Windows和Samba都接受的*SMBSERVER
+func netbiosSessionRequest() []byte {
+	names := append(netbiosName("*SMBSERVER", 0x20), netbiosName("NEBULAFINGER", 0x00)...)
+	return append([]byte{0x81, 0, byte(len(names) >> 8), byte(len(names))}, names...)
+}
+
+// netbiosName 返回NetBIOS名称的一级编码：名称补齐到15字节并加上后缀，每个字节拆成两个'A'起始的字符
+func netbiosName(name string, suffix byte) []byte {
+	padded := append([]byte(fmt.Sprintf("%-15.15s", name)), suffix)
+	encoded := []byte{32}
+	for _, c := range padded {
+		encoded = append(encoded, 'A'+c>>4, 'A'+c&0x0f)
+	}
+	return append(encoded, 0)
+}
+
+// smb2Header 返回SMB2消息头
+func smb2Header(command uint16, messageID uint64) []byte {
+	header := append([]byte{}, smb2Magic...)
+	header = binary.LittleEndian.AppendUint16(header, 64) // 结构长度
+	header = binary.LittleEndian.AppendUint16(header, 0)  // CreditCharge
+	header = binary.LittleEndian.AppendUint32(header, 0)  // 状态
+	header = binary.LittleEndian.AppendUint16(header, command)
+	header = binary.LittleEndian.AppendUint16(header, 31) // 请求的信用数
+	header = binary.LittleEndian.AppendUint32(header, 0)  // 标志
+	header = binary.LittleEndian.AppendUint32(header, 0)  // NextCommand
+	header = binary.LittleEndian.AppendUint64(header, messageID)
+	return append(header, make([]byte, 4+4+8+16)...) // 保留、TreeId、SessionId、签名
+}
+
+// smb2NegotiateRequest 返回SMB2 NEGOTIATE请求，包含3.1.1时附加预认证完整性和加密能力协商上下文
+func smb2NegotiateRequest(dialects []uint16) []byte {
+	smb311 := false
+	for _, dialect := range dialects {
+		smb311 = smb311 || dialect == 0x0311
+	}
+
+	body := binary.LittleEndian.AppendUint16(nil, 36) // 结构长度
+	body = binary.LittleEndian.AppendUint16(body, uint16(len(dialects)))
+	body = binary.LittleEndian.AppendUint16(body, 1) // 安全模式：启用签名
+	body = binary.LittleEndian.AppendUint16(body, 0)
+	body = binary.LittleEndian.AppendUint32(body, 0x7f) // 能力
+	guid := make([]byte, 16)
+	rand.Read(guid)
+	body = append(body, guid...)
+
+	// 协商上下文从SMB2消息头起8字节对齐
+	contextOffset := (64 + 36 + 2*len(dialects) + 7) &^ 7
+	if smb311 {
+		body = binary.LittleEndian.AppendUint32(body, uint32(contextOffset))
+		body = binary.LittleEndian.AppendUint16(body, 2)
+		body = binary.LittleEndian.AppendUint16(body, 0)
+	} else {
+		body = append(body, make([]byte, 8)...) // ClientStartTime
+	}
+	for _, dialect := range dialects {
+		body = binary.LittleEndian.AppendUint16(body, dialect)
+	}
+
+	if smb311 {
+		body = append(body, make([]byte, contextOffset-64-len(body))...)
+		salt := make([]byte, 32)
+		rand.Read(salt)
+		preauth := append([]byte{1, 0, 32, 0, 1, 0}, salt...) // 一个哈希算法SHA-512，32字节盐
+		body = append(body, smb2NegotiateContext(1, preauth)...)
+		body = append(body, make([]byte, (8-len(body)%8)%8)...)
+		body = append(body, smb2NegotiateContext(2, []byte{4, 0, 2, 0, 1, 0, 4, 0, 3, 0})...)
+	}
+	return netbiosFrame(append(smb2Header(smb2Negotiate, 0), body...))
+}
+
+// smb2NegotiateContext 返回协商上下文：类型、数据长度、保留和数据
+func smb2NegotiateContext(contextType uint16, data []byte) []byte {
+	context := binary.LittleEndian.AppendUint16(nil, contextType)
+	context = binary.LittleEndian.AppendUint16(context, uint16(len(data)))
+	context = append(context, 0, 0, 0, 0)
+	return append(context, data...)
+}
+
+// smb2SessionSetupRequest 返回携带安全令牌的SMB2 SESSION_SETUP请求
+func smb2SessionSetupRequest(token []byte) []byte {
+	body := binary.LittleEndian.AppendUint16(nil, 25) // 结构长度
+	body = append(body, 0, 1)                         // 标志、安全模式：启用签名
+	body = binary.LittleEndian.AppendUint32(body, 0)  // 能力
+	body = binary.LittleEndian.AppendUint32(body, 0)  // Channel
+	body = binary.LittleEndian.AppendUint16(body, 64+24)
+	body = binary.LittleEndian.AppendUint16(body, uint16(len(token)))
+	body = binary.LittleEndian.AppendUint64(body, 0) // PreviousSessionId
+	body = append(body, token...)
+	return netbiosFrame(append(smb2Header(smb2SessionSetup, 1), body...))
+}
+
+// parseSMB2Negotiate 解析去掉NetBIOS会话头的SMB2 NEGOTIATE响应，状态不为成功时返回false
+// 响应体依次为结构长度、安全模式、方言、协商上下文数、服务端GUID、能力、最大事务/读/写长度、服务端时间、启动时间、
+// 安全缓冲区偏移和长度、协商上下文偏移
+func parseSMB2Negotiate(message []byte) (smb2NegotiateInfo, bool) {
+	var info smb2NegotiateInfo
+	if len(message) < 64+64 || !bytes.HasPrefix(message, smb2Magic) ||
+		binary.LittleEndian.Uint32(message[8:12]) != 0 || binary.LittleEndian.Uint16(message[12:14]) != smb2Negotiate {
+		return info, false
+	}
+	body := message[64:]
+	info.securityMode = binary.LittleEndian.Uint16(body[2:4])
+	info.dialect = binary.LittleEndian.Uint16(body[4:6])
+	copy(info.serverGUID[:], body[8:24])
+	info.capabilities = binary.LittleEndian.Uint32(body[24:28])
+	info.maxReadSize = binary.LittleEndian.Uint32(body[32:36])
+	info.systemTime = binary.LittleEndian.Uint64(body[40:48])
+	info.startTime = binary.LittleEndian.Uint64(body[48:56])
+
+	if info.dialect == 0x0311 {
+		count := int(binary.LittleEndian.Uint16(body[6:8]))
+		offset := int(binary.LittleEndian.Uint32(body[60:64]))
+		for i := 0; i < count && offset+8 <= len(message); i++ {
+			contextType := binary.LittleEndian.Uint16(message[offset : offset+2])
+			length := int(binary.LittleEndian.Uint16(message[offset+2 : offset+4]))
+			data := message[offset+8:]
+			if len(data) < length {
+				break
+			}
+			if contextType == 2 && length >= 4 && binary.LittleEndian.Uint16(data[0:2]) > 0 {
+				info.cipher = binary.LittleEndian.Uint16(data[2:4])
+			}
+			offset = (offset + 8 + length + 7) &^ 7
+		}
+	}
+	return info, true
+}
+
+// smb1NegotiateRequest 返回只包含NT LM 0.12方言的SMB1 NEGOTIATE请求，不支持SMBv1的服务端会拒绝或断开
+func smb1NegotiateRequest() []byte {
+	header := append([]byte{}, smb1Magic...)
+	header = append(header, 0x72)                             // 命令：NEGOTIATE
+	header = append(header, 0, 0, 0, 0)                       // 状态
+	header = append(header, 0x18)                             // 标志：路径不区分大小写、规范化路径
+	header = binary.LittleEndian.AppendUint16(header, 0xc801) // 标志2：Unicode、NT状态码、扩展安全、长文件名
+	header = append(header, make([]byte, 2+8+2+2)...)         // PIDHigh、安全特征、保留、TID
+	header = binary.LittleEndian.AppendUint16(header, 0xfeff) // PIDLow
+	header = append(header, 0, 0, 0, 0)                       // UID、MID
+	dialects := []byte("\x02NT LM 0.12\x00")
+	body := append([]byte{0}, byte(len(dialects)), 0) // WordCount、ByteCount
+	return netbiosFrame(append(append(header, body...), dialects...))
+}
+
+// parseSMB1Negotiate 判断去掉NetBIOS会话头的响应是否为成功的SMB1 NEGOTIATE响应：状态为0且选择了方言
+func parseSMB1Negotiate(message []byte) bool {
+	if len(message) < 35 || !bytes.HasPrefix(message, smb1Magic) || message[4] != 0x72 ||
+		binary.LittleEndian.Uint32(message[5:9]) != 0 || message[32] == 0 {
+		return false
+	}
+	return binary.LittleEndian.Uint16(message[33:35]) != 0xffff
+}
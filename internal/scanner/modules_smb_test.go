@@ -0,0 +1,269 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// smb2NegotiateReply21 Windows Server 2008 R2的SMB2 NEGOTIATE响应：方言2.1，要求签名，能力0x07，没有协商上下文
+const smb2NegotiateReply21 = "" +
+	"fe534d4240000000000000000000010001000000000000000000000000000000" +
+	"fffe000000000000000000000000000000000000000000000000000000000000" +
+	"41000300100200005c3e9b1d7a2f4e48b1c0d2e3f4a5b6c70700000000008000" +
+	"000080000000800000001752b9e1db01000000000000000080002a0000000000" +
+	"602806062b0601050502a01e301ca01a3018060a2b06010401823702021e060a" +
+	"2b06010401823702020a"
+
+// smb1NegotiateReply 接受NT LM 0.12方言（索引0）的SMB1 NEGOTIATE响应，WordCount为17
+const smb1NegotiateReply = "" +
+	"ff534d4272000000009853c80000000000000000000000000000fffe00000000" +
+	"1100000332000100044100000000010000000000fdf3018000001752b9e1db01" +
+	"0000003a005c3e9b1d7a2f4e48b1c0d2e3f4a5b6c7602806062b0601050502a0" +
+	"1e301ca01a3018060a2b06010401823702021e060a2b06010401823702020a"
+
+// smb1NegotiateReject 没有可用方言（索引0xffff）的SMB1 NEGOTIATE响应
+const smb1NegotiateReject = "" +
+	"ff534d4272000000009853c80000000000000000000000000000fffe00000000" +
+	"01ffff0000"
+
+func TestParseSMB2Negotiate(t *testing.T) {
+	reply311 := fixture(smb2NegotiateReply311)
+
+	// 加密上下文的长度超出消息时忽略该上下文
+	badContext := append([]byte{}, reply311...)
+	binary.LittleEndian.PutUint16(badContext[224+2:], 0xff)
+
+	// STATUS_NOT_SUPPORTED
+	failed := append([]byte{}, reply311...)
+	binary.LittleEndian.PutUint32(failed[8:12], 0xc00000bb)
+
+	tests := []struct {
+		name    string
+		message []byte
+		ok      bool
+		want    map[string]string
+		absent  []string
+	}{
+		{
+			name:    "3.1.1启用签名和AES-128-GCM",
+			message: reply311,
+			ok:      true,
+			want: map[string]string{
+				"smb_dialect":       "3.1.1",
+				"signing_enabled":   "true",
+				"signing_required":  "false",
+				"server_guid":       "1d9b3e5c-2f7a-484e-b1c0-d2e3f4a5b6c7",
+				"capabilities":      "dfs,leasing,large_mtu,multi_channel,directory_leasing",
+				"max_read_size":     "8388608",
+				"system_time":       "2025-06-20T08:00:00Z",
+				"encryption_cipher": "AES-128-GCM",
+			},
+			absent: []string{"server_start_time"},
+		},
+		{
+			name:    "2.1要求签名",
+			message: fixture(smb2NegotiateReply21),
+			ok:      true,
+			want: map[string]string{
+				"smb_dialect":      "2.1",
+				"signing_enabled":  "true",
+				"signing_required": "true",
+				"capabilities":     "dfs,leasing,large_mtu",
+			},
+			absent: []string{"encryption_cipher", "server_start_time"},
+		},
+		{
+			name:    "加密上下文越界",
+			message: badContext,
+			ok:      true,
+			want:    map[string]string{"smb_dialect": "3.1.1"},
+			absent:  []string{"encryption_cipher"},
+		},
+		{name: "响应被截断", message: reply311[:100]},
+		{name: "状态不为成功", message: failed},
+		{name: "SMB1响应", message: fixture(smb1NegotiateReply)},
+		{name: "空响应", message: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, ok := parseSMB2Negotiate(tt.message)
+			if ok != tt.ok {
+				t.Fatalf("parseSMB2Negotiate = %v，期望 %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			details := map[string]string{}
+			info.fields(details)
+			for k, v := range tt.want {
+				if details[k] != v {
+					t.Errorf("%s = %q，期望 %q", k, details[k], v)
+				}
+			}
+			for _, k := range tt.absent {
+				if v, ok := details[k]; ok {
+					t.Errorf("不应有 %s，得到 %q", k, v)
+				}
+			}
+		})
+	}
+}
+
+func TestParseSMB1Negotiate(t *testing.T) {
+	accepted := fixture(smb1NegotiateReply)
+	failed := append([]byte{}, accepted...)
+	binary.LittleEndian.PutUint32(failed[5:9], 0xc0000002)
+
+	tests := []struct {
+		name    string
+		message []byte
+		want    bool
+	}{
+		{"接受NT LM 0.12", accepted, true},
+		{"没有可用方言", fixture(smb1NegotiateReject), false},
+		{"状态不为成功", failed, false},
+		{"SMB2响应", fixture(smb2NegotiateReply311), false},
+		{"响应被截断", accepted[:34], false},
+		{"空响应", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseSMB1Negotiate(tt.message); got != tt.want {
+				t.Errorf("parseSMB1Negotiate = %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// smbStandIn 模拟SMB服务端：支持dialects中的SMB2方言，最高方言为3.1.1时使用Windows Server 2019的响应；
+// smb1为true时接受SMBv1，否则拒绝；SESSION_SETUP返回NTLM CHALLENGE消息。返回端口和每次NEGOTIATE请求中的方言
+func smbStandIn(t *testing.T, dialects []uint16, smb1 bool) (uint16, func() [][]uint16) {
+	var mu sync.Mutex
+	var requests [][]uint16
+	port := tcpStandIn(t, func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		for {
+			message, err := readNetBIOSFrame(reader)
+			if err != nil {
+				return
+			}
+			if bytes.HasPrefix(message, smb1Magic) {
+				if smb1 {
+					conn.Write(netbiosFrame(fixture(smb1NegotiateReply)))
+				} else {
+					conn.Write(netbiosFrame(fixture(smb1NegotiateReject)))
+				}
+				continue
+			}
+			if len(message) < 64 {
+				return
+			}
+			switch binary.LittleEndian.Uint16(message[12:14]) {
+			case smb2Negotiate:
+				count := int(binary.LittleEndian.Uint16(message[64+2:]))
+				var offered []uint16
+				for i := 0; i < count; i++ {
+					offered = append(offered, binary.LittleEndian.Uint16(message[64+36+2*i:]))
+				}
+				mu.Lock()
+				requests = append(requests, offered)
+				mu.Unlock()
+				// 选择双方都支持的最高方言，没有时返回STATUS_NOT_SUPPORTED
+				var selected uint16
+				for _, d := range offered {
+					if d > selected && containsDialect(dialects, d) {
+						selected = d
+					}
+				}
+				reply := fixture(smb2NegotiateReply311)
+				if selected == 0 {
+					binary.LittleEndian.PutUint32(reply[8:12], 0xc00000bb)
+				}
+				binary.LittleEndian.PutUint16(reply[64+4:], selected)
+				conn.Write(netbiosFrame(reply))
+			case smb2SessionSetup:
+				conn.Write(netbiosFrame(fixture(smb2SessionSetupChallengeHeader + spnegoChallengePrefix + ntlmChallengeServer2019)))
+			}
+		}
+	})
+	return port, func() [][]uint16 {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+func containsDialect(dialects []uint16, dialect uint16) bool {
+	for _, d := range dialects {
+		if d == dialect {
+			return true
+		}
+	}
+	return false
+}
+
+func TestProbeSMB(t *testing.T) {
+	port, requests := smbStandIn(t, []uint16{0x0210, 0x0300, 0x0302, 0x0311}, false)
+	s := newTestScanner(nil, ScannerConfig{})
+
+	result := probeSMB(s, localTarget(port))
+	if result == nil {
+		t.Fatal("没有识别为SMB")
+	}
+	want := map[string]string{
+		"smb_dialect":       "3.1.1",
+		"smb_dialects":      "2.1,3.0,3.0.2,3.1.1",
+		"smbv1":             "false",
+		"signing_required":  "false",
+		"encryption_cipher": "AES-128-GCM",
+		"netbios_domain":    "CORP",
+		"dns_computer":      "DC01.corp.local",
+		"os":                "Windows 10 1809 / Windows Server 2019",
+	}
+	for k, v := range want {
+		if result.details[k] != v {
+			t.Errorf("%s = %q，期望 %q", k, result.details[k], v)
+		}
+	}
+
+	// 识别和获取NTLM信息时各请求一次所有方言，另外逐个协商最高方言以外的方言
+	got := requests()
+	sort.Slice(got, func(i, j int) bool {
+		return len(got[i]) > len(got[j]) || len(got[i]) == len(got[j]) && got[i][0] < got[j][0]
+	})
+	wantRequests := [][]uint16{smb2Dialects, smb2Dialects, {0x0202}, {0x0210}, {0x0300}, {0x0302}}
+	if !reflect.DeepEqual(got, wantRequests) {
+		t.Errorf("NEGOTIATE请求 = %v，期望 %v", got, wantRequests)
+	}
+}
+
+func TestProbeSMBv1Only(t *testing.T) {
+	port, _ := smbStandIn(t, nil, true)
+	s := newTestScanner(nil, ScannerConfig{})
+
+	result := probeSMB(s, localTarget(port))
+	if result == nil {
+		t.Fatal("没有识别为SMB")
+	}
+	if result.details["smbv1"] != "true" || result.details["smb_dialects"] != "NT LM 0.12" {
+		t.Errorf("详情 = %v", result.details)
+	}
+	if _, ok := result.details["smb_dialect"]; ok {
+		t.Errorf("不支持SMB2时不应有smb_dialect")
+	}
+}
+
+func TestProbeSMBNotSMB(t *testing.T) {
+	port := tcpStandIn(t, func(conn net.Conn) {
+		conn.Write([]byte("HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\n\r\n"))
+	})
+	if result := probeSMB(newTestScanner(nil, ScannerConfig{}), localTarget(port)); result != nil {
+		t.Errorf("非SMB服务不应识别，得到 %v", result.details)
+	}
+}
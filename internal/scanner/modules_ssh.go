@@ -0,0 +1,338 @@
+package scanner
+
+import (
+	"bufio"
+	"crypto/ecdh"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/big"
+	"strings"
+)
+
+func init() {
+	registerModules(
+		&serviceModule{name: "ssh", ports: []uint16{22}, tags: "detect,remote,ssh", detect: isSSHIdent, probe: probeSSH},
+	)
+}
+
+// SSH消息类型
+const (
+	sshMsgDisconnect = 1
+	sshMsgIgnore     = 2
+	sshMsgDebug      = 4
+	sshMsgKexInit    = 20
+	sshMsgKexDHInit  = 30 // 也是SSH_MSG_KEX_ECDH_INIT
+	sshMsgKexDHReply = 31 // 也是SSH_MSG_KEX_ECDH_REPLY
+)
+
+// sshPacketLimit SSH报文长度上限，超过时视为不是SSH
+const sshPacketLimit = 256 * 1024
+
+// sshKexMethods 获取主机公钥时支持的密钥交换算法，按优先级排序；不需要完成密钥交换，只需服务端回复KEXDH_REPLY
+var sshKexMethods = []string{
+	"curve25519-sha256",
+	"curve25519-sha256@libssh.org",
+	"ecdh-sha2-nistp256",
+	"ecdh-sha2-nistp384",
+	"ecdh-sha2-nistp521",
+	"diffie-hellman-group14-sha256",
+	"diffie-hellman-group16-sha512",
+	"diffie-hellman-group18-sha512",
+	"diffie-hellman-group14-sha1",
+	"diffie-hellman-group1-sha1",
+}
+
+// sshKexInit 服务端KEXINIT中的算法列表，按RFC 4253的顺序
+type sshKexInit struct {
+	kex             string
+	hostKey         string
+	encryptionC2S   string
+	encryptionS2C   string
+	macC2S          string
+	macS2C          string
+	compressionC2S  string
+	compressionS2C  string
+	languagesC2S    string
+	languagesS2C    string
+	firstKexFollows bool
+}
+
+// isSSHIdent 判断banner是否为SSH版本标识
+func isSSHIdent(banner string) bool {
+	return strings.HasPrefix(banner, "SSH-")
+}
+
+// probeSSH 完成版本交换并读取服务端的KEXINIT，记录各类算法列表并计算HASSHServer；
+// 再用服务端支持的密钥交换算法发送KEXDH_INIT，从KEXDH_REPLY中取得主机公钥，收到后即断开，不进行认证
+func probeSSH(s *Scanner, target moduleTarget) *moduleResult {
+	conn, err := s.moduleDial(target)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	// 服务端可以在版本标识之前发送其他文本行
+	var ident string
+	for i := 0; i < 20 && ident == ""; i++ {
+		line, err := reader.ReadString('\n')
+		if strings.HasPrefix(line, "SSH-") {
+			ident = strings.TrimRight(line, "\r\n")
+		} else if err != nil {
+			return nil
+		}
+	}
+	if ident == "" {
+		return nil
+	}
+
+	result := &moduleResult{details: map[string]string{}, response: ident}
+	parseSSHIdent(result, ident)
+	if !strings.HasPrefix(ident, "SSH-2.0-") && !strings.HasPrefix(ident, "SSH-1.99-") {
+		return result
+	}
+
+	if _, err := conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n")); err != nil {
+		return result
+	}
+	payload, err := readSSHMessage(reader)
+	if err != nil || payload[0] != sshMsgKexInit {
+		return result
+	}
+	kexInit, ok := parseSSHKexInit(payload)
+	if !ok {
+		return result
+	}
+	result.response += "\r\n" + latin1(payload)
+
+	result.details["kex_algorithms"] = kexInit.kex
+	result.details["host_key_algorithms"] = kexInit.hostKey
+	result.details["encryption_algorithms"] = kexInit.encryptionS2C
+	result.details["mac_algorithms"] = kexInit.macS2C
+	result.details["compression_algorithms"] = kexInit.compressionS2C
+	hasshAlgorithms := strings.Join([]string{kexInit.kex, kexInit.encryptionS2C, kexInit.macS2C, kexInit.compressionS2C}, ";")
+	hash := md5.Sum([]byte(hasshAlgorithms))
+	result.details["hassh_server"] = hex.EncodeToString(hash[:])
+	result.details["hassh_server_algorithms"] = hasshAlgorithms
+
+	if hostKey := sshHostKey(conn, reader, kexInit); hostKey != nil {
+		keyType, _, _ := sshString(hostKey)
+		sha := sha256.Sum256(hostKey)
+		md := md5.Sum(hostKey)
+		result.details["host_key_type"] = string(keyType)
+		result.details["host_key_fingerprint"] = "SHA256:" + base64.RawStdEncoding.EncodeToString(sha[:])
+		result.details["host_key_md5"] = colonHex(md[:])
+	}
+	return result
+}
+
+// parseSSHIdent 从版本标识（SSH-协议版本-软件版本 注释）中提取产品和版本
+// 软件版本通常为 产品_版本（OpenSSH_8.9p1、dropbear_2022.83），也有 产品-版本（Cisco-1.25）
+func parseSSHIdent(result *moduleResult, ident string) {
+	fields := strings.SplitN(ident, "-", 3)
+	if len(fields) < 3 {
+		return
+	}
+	result.details["protocol_version"] = fields[1]
+	software, comments, _ := strings.Cut(fields[2], " ")
+	result.details["software"] = software
+	if comments = strings.TrimSpace(comments); comments != "" {
+		result.details["comments"] = comments
+	}
+
+	result.product = software
+	if product, version, ok := strings.Cut(software, "_"); ok {
+		result.product, result.version = product, version
+	} else if i := strings.LastIndex(software, "-"); i > 0 && i+1 < len(software) && software[i+1] >= '0' && software[i+1] <= '9' {
+		result.product, result.version = software[:i], software[i+1:]
+	}
+}
+
+// readSSHMessage 读取一个未加密的二进制报文，跳过IGNORE和DEBUG消息，返回消息负载
+func readSSHMessage(reader *bufio.Reader) ([]byte, error) {
+	for {
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return nil, err
+		}
+		length := int(binary.BigEndian.Uint32(header[0:4]))
+		padding := int(header[4])
+		if length < 2 || length > sshPacketLimit || padding >= length {
+			return nil, errors.New("invalid ssh packet")
+		}
+		body := make([]byte, length-1)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return nil, err
+		}
+		payload := body[:len(body)-padding]
+		if len(payload) == 0 {
+			return nil, errors.New("empty ssh packet")
+		}
+		switch payload[0] {
+		case sshMsgIgnore, sshMsgDebug:
+			continue
+		case sshMsgDisconnect:
+			return nil, errors.New("ssh disconnect")
+		}
+		return payload, nil
+	}
+}
+
+// sshPacket 将消息负载封装为未加密的二进制报文，填充后长度为8的倍数且填充至少4字节
+func sshPacket(payload []byte) []byte {
+	padding := 8 - (5+len(payload))%8
+	if padding < 4 {
+		padding += 8
+	}
+	packet := binary.BigEndian.AppendUint32(nil, uint32(1+len(payload)+padding))
+	packet = append(packet, byte(padding))
+	packet = append(packet, payload...)
+	return append(packet, make([]byte, padding)...)
+}
+
+// sshString 读取uint32长度前缀的字符串，返回字符串和剩余数据
+func sshString(b []byte) ([]byte, []byte, bool) {
+	if len(b) < 4 {
+		return nil, nil, false
+	}
+	n := binary.BigEndian.Uint32(b[0:4])
+	if uint64(len(b)-4) < uint64(n) {
+		return nil, nil, false
+	}
+	return b[4 : 4+n], b[4+n:], true
+}
+
+// appendSSHString 追加uint32长度前缀的字符串
+func appendSSHString(b []byte, s []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// parseSSHKexInit 解析KEXINIT消息：消息类型、16字节cookie、10个算法名列表和first_kex_packet_follows
+func parseSSHKexInit(payload []byte) (sshKexInit, bool) {
+	var kexInit sshKexInit
+	if len(payload) < 17 {
+		return kexInit, false
+	}
+	rest := payload[17:]
+	lists := []*string{
+		&kexInit.kex, &kexInit.hostKey,
+		&kexInit.encryptionC2S, &kexInit.encryptionS2C,
+		&kexInit.macC2S, &kexInit.macS2C,
+		&kexInit.compressionC2S, &kexInit.compressionS2C,
+		&kexInit.languagesC2S, &kexInit.languagesS2C,
+	}
+	for _, list := range lists {
+		value, next, ok := sshString(rest)
+		if !ok {
+			return kexInit, false
+		}
+		*list, rest = string(value), next
+	}
+	kexInit.firstKexFollows = len(rest) > 0 && rest[0] != 0
+	return kexInit, true
+}
+
+// sshHostKey 用服务端支持的密钥交换算法发送KEXINIT和KEXDH_INIT，返回KEXDH_REPLY中的主机公钥
+// 其余算法列表直接使用服务端的列表，保证协商成功；服务端不支持任何已知的密钥交换算法时返回nil
+func sshHostKey(conn io.Writer, reader *bufio.Reader, server sshKexInit) []byte {
+	method := ""
+	for _, m := range sshKexMethods {
+		if containsName(server.kex, m) {
+			method = m
+			break
+		}
+	}
+	publicValue := sshKexPublicValue(method)
+	if publicValue == nil {
+		return nil
+	}
+
+	kexInit := []byte{sshMsgKexInit}
+	cookie := make([]byte, 16)
+	rand.Read(cookie)
+	kexInit = append(kexInit, cookie...)
+	for _, list := range []string{method, server.hostKey, server.encryptionC2S, server.encryptionS2C, server.macC2S, server.macS2C,
+		server.compressionC2S, server.compressionS2C, "", ""} {
+		kexInit = appendSSHString(kexInit, []byte(list))
+	}
+	kexInit = append(kexInit, 0, 0, 0, 0, 0)
+
+	request := append(sshPacket(kexInit), sshPacket(append([]byte{sshMsgKexDHInit}, publicValue...))...)
+	if _, err := conn.Write(request); err != nil {
+		return nil
+	}
+	for i := 0; i < 4; i++ {
+		payload, err := readSSHMessage(reader)
+		if err != nil {
+			return nil
+		}
+		if payload[0] == sshMsgKexDHReply {
+			hostKey, _, ok := sshString(payload[1:])
+			if !ok || len(hostKey) == 0 {
+				return nil
+			}
+			return hostKey
+		}
+	}
+	return nil
+}
+
+// sshKexPublicValue 生成KEXDH_INIT中客户端的公开值（已编码为SSH字符串或mpint）
+// 不需要计算共享密钥，DH只需一个在(1, p-1)范围内的值，取1000位的随机数即可满足所有MODP组
+func sshKexPublicValue(method string) []byte {
+	var curve ecdh.Curve
+	switch {
+	case strings.HasPrefix(method, "curve25519-"):
+		curve = ecdh.X25519()
+	case method == "ecdh-sha2-nistp256":
+		curve = ecdh.P256()
+	case method == "ecdh-sha2-nistp384":
+		curve = ecdh.P384()
+	case method == "ecdh-sha2-nistp521":
+		curve = ecdh.P521()
+	case strings.HasPrefix(method, "diffie-hellman-group"):
+		e, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 999))
+		if err != nil {
+			return nil
+		}
+		e.SetBit(e, 999, 1)
+		mpint := e.Bytes()
+		if mpint[0]&0x80 != 0 {
+			mpint = append([]byte{0}, mpint...)
+		}
+		return appendSSHString(nil, mpint)
+	default:
+		return nil
+	}
+	key, err := curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil
+	}
+	return appendSSHString(nil, key.PublicKey().Bytes())
+}
+
+// containsName 判断逗号分隔的名称列表中是否包含name
+func containsName(list, name string) bool {
+	for _, item := range strings.Split(list, ",") {
+		if item == name {
+			return true
+		}
+	}
+	return false
+}
+
+// colonHex 返回冒号分隔的十六进制字符串
+func colonHex(b []byte) string {
+	parts := make([]string, len(b))
+	for i, c := range b {
+		parts[i] = hex.EncodeToString([]byte{c})
+	}
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,129 @@
+package scanner
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+)
+
+// OpenSSH 7.4服务端KEXINIT消息的负载（不含报文长度和填充）
+const openSSH74KexInit = "14101112131415161718191a1b1c1d1e1f00000096637572766532353531392d736861323536406c69627373682e6f72672c656364682d736861322d6e697374" +
+	"703235362c656364682d736861322d6e697374703338342c656364682d736861322d6e697374703532312c6469666669652d68656c6c6d616e2d67726f75702d" +
+	"65786368616e67652d7368613235362c6469666669652d68656c6c6d616e2d67726f757031342d73686131000000417373682d7273612c7273612d736861322d" +
+	"3531322c7273612d736861322d3235362c65636473612d736861322d6e697374703235362c7373682d656432353531390000006c63686163686132302d706f6c" +
+	"7931333035406f70656e7373682e636f6d2c6165733132382d6374722c6165733139322d6374722c6165733235362d6374722c6165733132382d67636d406f70" +
+	"656e7373682e636f6d2c6165733235362d67636d406f70656e7373682e636f6d0000006c63686163686132302d706f6c7931333035406f70656e7373682e636f" +
+	"6d2c6165733132382d6374722c6165733139322d6374722c6165733235362d6374722c6165733132382d67636d406f70656e7373682e636f6d2c616573323536" +
+	"2d67636d406f70656e7373682e636f6d000000d5756d61632d36342d65746d406f70656e7373682e636f6d2c756d61632d3132382d65746d406f70656e737368" +
+	"2e636f6d2c686d61632d736861322d3235362d65746d406f70656e7373682e636f6d2c686d61632d736861322d3531322d65746d406f70656e7373682e636f6d" +
+	"2c686d61632d736861312d65746d406f70656e7373682e636f6d2c756d61632d3634406f70656e7373682e636f6d2c756d61632d313238406f70656e7373682e" +
+	"636f6d2c686d61632d736861322d3235362c686d61632d736861322d3531322c686d61632d73686131000000d5756d61632d36342d65746d406f70656e737368" +
+	"2e636f6d2c756d61632d3132382d65746d406f70656e7373682e636f6d2c686d61632d736861322d3235362d65746d406f70656e7373682e636f6d2c686d6163" +
+	"2d736861322d3531322d65746d406f70656e7373682e636f6d2c686d61632d736861312d65746d406f70656e7373682e636f6d2c756d61632d3634406f70656e" +
+	"7373682e636f6d2c756d61632d313238406f70656e7373682e636f6d2c686d61632d736861322d3235362c686d61632d736861322d3531322c686d61632d7368" +
+	"6131000000156e6f6e652c7a6c6962406f70656e7373682e636f6d000000156e6f6e652c7a6c6962406f70656e7373682e636f6d000000000000000000000000" +
+	"00"
+
+// openSSH74HASSH 上面KEXINIT的HASSHServer：kex;encryption;mac;compression 的MD5
+const openSSH74HASSH = "d43d91bc39d5aaed819ad9f6b57b7348"
+
+// ed25519HostKey ssh-ed25519主机公钥，公钥为0x00-0x1f
+const ed25519HostKey = "0000000b7373682d6564323535313900000020000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+func TestParseSSHKexInit(t *testing.T) {
+	kexInit, ok := parseSSHKexInit(fixture(openSSH74KexInit))
+	if !ok {
+		t.Fatal("KEXINIT解析失败")
+	}
+	if !strings.HasPrefix(kexInit.kex, "curve25519-sha256@libssh.org,") || !containsName(kexInit.hostKey, "ssh-ed25519") {
+		t.Errorf("kex/hostKey = %s / %s", kexInit.kex, kexInit.hostKey)
+	}
+	if kexInit.compressionS2C != "none,zlib@openssh.com" || kexInit.languagesS2C != "" || kexInit.firstKexFollows {
+		t.Errorf("kexInit = %+v", kexInit)
+	}
+
+	payload := fixture(openSSH74KexInit)
+	for _, n := range []int{0, 10, 17, 200, len(payload) - 6} {
+		if _, ok := parseSSHKexInit(payload[:n]); ok {
+			t.Errorf("截断为 %d 字节的KEXINIT不应解析成功", n)
+		}
+	}
+}
+
+func TestParseSSHIdent(t *testing.T) {
+	tests := []struct {
+		ident, product, version, comments string
+	}{
+		{"SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.6", "OpenSSH", "8.9p1", "Ubuntu-3ubuntu0.6"},
+		{"SSH-2.0-dropbear_2022.83", "dropbear", "2022.83", ""},
+		{"SSH-1.99-Cisco-1.25", "Cisco", "1.25", ""},
+		{"SSH-2.0-RomSShell_5.40", "RomSShell", "5.40", ""},
+		{"SSH-2.0-Go", "Go", "", ""},
+	}
+	for _, tt := range tests {
+		result := &moduleResult{details: map[string]string{}}
+		parseSSHIdent(result, tt.ident)
+		if result.product != tt.product || result.version != tt.version || result.details["comments"] != tt.comments {
+			t.Errorf("%s = %s/%s/%q", tt.ident, result.product, result.version, result.details["comments"])
+		}
+	}
+}
+
+// TestProbeSSH 版本交换后读取KEXINIT计算HASSHServer，再从KEXDH_REPLY中取得主机公钥
+func TestProbeSSH(t *testing.T) {
+	port := tcpStandIn(t, func(conn net.Conn) {
+		conn.Write([]byte("Welcome\r\nSSH-2.0-OpenSSH_7.4\r\n"))
+		reader := bufio.NewReader(conn)
+		if _, err := reader.ReadString('\n'); err != nil {
+			return
+		}
+		conn.Write(sshPacket(fixture(openSSH74KexInit)))
+		// 客户端的KEXINIT和KEXDH_INIT
+		for i := 0; i < 2; i++ {
+			if _, err := readSSHMessage(reader); err != nil {
+				return
+			}
+		}
+		reply := appendSSHString([]byte{sshMsgKexDHReply}, fixture(ed25519HostKey))
+		reply = appendSSHString(reply, make([]byte, 32))
+		reply = appendSSHString(reply, []byte("signature"))
+		conn.Write(append(sshPacket([]byte{sshMsgIgnore}), sshPacket(reply)...))
+	})
+
+	result := probeSSH(newTestScanner(nil, ScannerConfig{}), localTarget(port))
+	if result == nil {
+		t.Fatal("没有识别为SSH")
+	}
+	want := map[string]string{
+		"protocol_version":       "2.0",
+		"software":               "OpenSSH_7.4",
+		"hassh_server":           openSSH74HASSH,
+		"compression_algorithms": "none,zlib@openssh.com",
+		"host_key_type":          "ssh-ed25519",
+		"host_key_fingerprint":   "SHA256:ZkAslGjFiUHdGf/WUL8rQvkib4PTvQatUV0OUQSncCA",
+		"host_key_md5":           "0f:a2:0a:d7:38:3e:65:45:08:6b:63:84:1c:ff:dc:ba",
+	}
+	for k, v := range want {
+		if result.details[k] != v {
+			t.Errorf("%s = %q，期望 %q", k, result.details[k], v)
+		}
+	}
+	if result.product != "OpenSSH" || result.version != "7.4" {
+		t.Errorf("product/version = %s/%s", result.product, result.version)
+	}
+	if !strings.HasPrefix(result.details["hassh_server_algorithms"], "curve25519-sha256@libssh.org,") {
+		t.Errorf("hassh_server_algorithms = %s", result.details["hassh_server_algorithms"])
+	}
+}
+
+// TestProbeSSHv1 只支持SSH-1的服务端只记录版本标识
+func TestProbeSSHv1(t *testing.T) {
+	port := tcpStandIn(t, func(conn net.Conn) {
+		conn.Write([]byte("SSH-1.5-1.2.27\r\n"))
+	})
+	result := probeSSH(newTestScanner(nil, ScannerConfig{}), localTarget(port))
+	if result == nil || result.details["protocol_version"] != "1.5" || result.details["hassh_server"] != "" {
+		t.Errorf("结果 = %v", result)
+	}
+}
@@ -0,0 +1,18 @@
+package scanner
+
+import (
+	"nebulafinger/internal"
+	"strings"
+	"testing"
+)
+
+// TestCheckModuleProbes 引用不存在的协议模块时返回警告，大小写和空白不影响已有模块
+func TestCheckModuleProbes(t *testing.T) {
+	s := newTestScanner([]internal.Fingerprint{
+		{ID: "ok", TCP: []internal.TCPRequest{{Probe: " MySQL "}, {}}},
+		{ID: "typo", TCP: []internal.TCPRequest{{Probe: "mysq"}}},
+	}, ScannerConfig{})
+	if len(s.Warnings) != 1 || !strings.Contains(s.Warnings[0], "typo") || !strings.Contains(s.Warnings[0], "mysq") {
+		t.Errorf("警告 = %q", s.Warnings)
+	}
+}
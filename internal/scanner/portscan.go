@@ -0,0 +1,136 @@
+package scanner
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"nebulafinger/internal/matcher"
+)
+
+// 端口状态
+const (
+	PortOpen     = "open"     // 接受连接
+	PortClosed   = "closed"   // 连接被拒绝（RST）
+	PortFiltered = "filtered" // 连接超时或主机/网络不可达
+)
+
+// defaultPortConcurrency 未配置时单个目标同时探测的端口数
+const defaultPortConcurrency = 20
+
+// PortResult 单个端口的探测结果
+type PortResult struct {
+	Host     string    `json:"host"`          // 主机名或IP
+	IP       string    `json:"ip,omitempty"`  // 实际连接的IP地址
+	Port     int       `json:"port"`          // 端口
+	Protocol string    `json:"protocol"`      // 传输层协议: tcp, udp
+	State    string    `json:"state"`         // 端口状态: open, closed, filtered, open|filtered（仅UDP）
+	Web      []PortWeb `json:"web,omitempty"` // 端口是Web服务时探测到的Web服务，HTTP和HTTPS都提供服务时各一项
+}
+
+// PortWeb 端口上探测到的一个Web服务
+type PortWeb struct {
+	Scheme  string                `json:"scheme"`            // 协议: http, https
+	URL     string                `json:"url"`               // 做Web指纹识别的URL
+	Results []matcher.MatchResult `json:"results,omitempty"` // 该URL的Web指纹识别结果，同时合并到WebResults中
+}
+
+// PortSummary 端口状态统计
+type PortSummary struct {
+	Open         int `json:"open"`                    // 开放端口数
+	Closed       int `json:"closed"`                  // 关闭端口数
+	Filtered     int `json:"filtered"`                // 被过滤端口数
+	OpenFiltered int `json:"open_filtered,omitempty"` // 没有响应的UDP端口数
+}
+
+// add 累加另一组统计
+func (p *PortSummary) add(other PortSummary) {
+	p.Open += other.Open
+	p.Closed += other.Closed
+	p.Filtered += other.Filtered
+	p.OpenFiltered += other.OpenFiltered
+}
+
+// portConcurrency 返回单个目标同时探测的端口数
+func (s *Scanner) portConcurrency() int {
+	if s.Config == nil || s.Config.PortConcurrency <= 0 {
+		return defaultPortConcurrency
+	}
+	return s.Config.PortConcurrency
+}
+
+// scanPorts 对目标的各个端口做TCP连接扫描，按输入顺序返回每个端口的状态
+// 连接受全局连接预算限制；连接超时随主机的RTT估计收紧，被过滤的端口不必等满配置的超时
+func (s *Scanner) scanPorts(hostname string, ports []uint16) []PortResult {
+	results := make([]PortResult, len(ports))
+
+	parallel(len(ports), s.portConcurrency(), func(i int) {
+		results[i] = PortResult{Host: hostname, IP: s.pinnedIP, Port: int(ports[i]), Protocol: ProtocolTCP}
+
+		conn, err := s.dialTimeout("tcp", net.JoinHostPort(hostname, strconv.Itoa(int(ports[i]))), s.connectTimeout())
+		if conn != nil {
+			conn.Close()
+		}
+		results[i].State = classifyDialError(err)
+	})
+	return results
+}
+
+// classifyDialError 根据连接结果判断端口状态
+func classifyDialError(err error) string {
+	switch {
+	case err == nil:
+		return PortOpen
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET):
+		// 对端以RST回应，端口可达但没有服务监听
+		return PortClosed
+	}
+	// 超时、主机不可达、网络不可达等都视为被过滤
+	return PortFiltered
+}
+
+// summarizePorts 统计端口状态，返回开放的端口
+func summarizePorts(results []PortResult) ([]PortResult, PortSummary) {
+	var open []PortResult
+	var summary PortSummary
+	for _, r := range results {
+		switch r.State {
+		case PortOpen:
+			open = append(open, r)
+			summary.Open++
+		case PortClosed:
+			summary.Closed++
+		case PortOpenFiltered:
+			summary.OpenFiltered++
+		default:
+			summary.Filtered++
+		}
+	}
+	return open, summary
+}
+
+// parallel 以最多limit个goroutine并发执行fn(0..n-1)，全部完成后返回
+func parallel(n, limit int, fn func(i int)) {
+	if limit <= 0 || limit > n {
+		limit = n
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < limit; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
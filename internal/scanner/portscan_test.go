@@ -0,0 +1,72 @@
+package scanner
+
+import (
+	"context"
+	"net"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClassifyDialError(t *testing.T) {
+	dialErr := func(err error) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: err}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"连接成功", nil, PortOpen},
+		{"连接被拒绝", dialErr(os.NewSyscallError("connect", syscall.ECONNREFUSED)), PortClosed},
+		{"连接被重置", dialErr(os.NewSyscallError("connect", syscall.ECONNRESET)), PortClosed},
+		{"连接超时", dialErr(context.DeadlineExceeded), PortFiltered},
+		{"i/o超时", dialErr(os.ErrDeadlineExceeded), PortFiltered},
+		{"主机不可达", dialErr(os.NewSyscallError("connect", syscall.EHOSTUNREACH)), PortFiltered},
+		{"网络不可达", dialErr(os.NewSyscallError("connect", syscall.ENETUNREACH)), PortFiltered},
+	}
+	for _, tt := range tests {
+		if got := classifyDialError(tt.err); got != tt.want {
+			t.Errorf("%s = %s，期望 %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestScanPorts(t *testing.T) {
+	open := tcpStandIn(t, func(conn net.Conn) {})
+	closed := closedPort(t)
+	s := newTestScanner(nil, ScannerConfig{Timeout: 500 * time.Millisecond, MaxConnections: 2})
+
+	results := s.scanPorts("127.0.0.1", []uint16{closed, open})
+	want := []PortResult{
+		{Host: "127.0.0.1", Port: int(closed), Protocol: ProtocolTCP, State: PortClosed},
+		{Host: "127.0.0.1", Port: int(open), Protocol: ProtocolTCP, State: PortOpen},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("结果 = %+v，期望 %+v", results, want)
+	}
+	// 开放端口的连接关闭后、失败的连接立即归还预算
+	if n := len(s.budget); n != 0 {
+		t.Errorf("扫描结束后仍占用 %d 个连接预算", n)
+	}
+}
+
+func TestSummarizePorts(t *testing.T) {
+	results := []PortResult{
+		{Port: 22, State: PortOpen},
+		{Port: 23, State: PortClosed},
+		{Port: 25, State: PortFiltered},
+		{Port: 53, Protocol: ProtocolUDP, State: PortOpenFiltered},
+		{Port: 80, State: PortOpen},
+		{Port: 81, State: PortFiltered},
+	}
+	open, summary := summarizePorts(results)
+	if len(open) != 2 || open[0].Port != 22 || open[1].Port != 80 {
+		t.Errorf("开放端口 = %+v", open)
+	}
+	if want := (PortSummary{Open: 2, Closed: 1, Filtered: 2, OpenFiltered: 1}); summary != want {
+		t.Errorf("统计 = %+v，期望 %+v", summary, want)
+	}
+}
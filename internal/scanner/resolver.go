@@ -0,0 +1,109 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Resolver 主机名解析器，hosts文件中的记录优先，其次使用指定的DNS服务器，都未指定时使用系统解析
+type Resolver struct {
+	hosts    map[string][]string // 主机名（小写）到地址的覆盖记录
+	resolver *net.Resolver       // DNS解析器
+}
+
+// NewResolver 创建解析器
+// servers为DNS服务器列表，格式为IP或IP:端口，默认端口53，多个服务器轮流使用；hostsFile为hosts格式的覆盖文件，为空时不加载
+func NewResolver(servers []string, hostsFile string) (*Resolver, error) {
+	r := &Resolver{
+		hosts:    make(map[string][]string),
+		resolver: net.DefaultResolver,
+	}
+
+	if hostsFile != "" {
+		if err := r.loadHostsFile(hostsFile); err != nil {
+			return nil, fmt.Errorf("读取hosts文件失败: %v", err)
+		}
+	}
+
+	var addrs []string
+	for _, server := range servers {
+		server = strings.TrimSpace(server)
+		if server == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+		}
+		if _, err := netip.ParseAddrPort(server); err != nil {
+			return nil, fmt.Errorf("无效的DNS服务器: %s", server)
+		}
+		addrs = append(addrs, server)
+	}
+
+	if len(addrs) > 0 {
+		// Go解析器对每次查询和重试都会调用Dial，轮流选择服务器实现故障切换
+		var next uint32
+		dialer := &net.Dialer{}
+		r.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				server := addrs[int(atomic.AddUint32(&next, 1)-1)%len(addrs)]
+				return dialer.DialContext(ctx, network, server)
+			},
+		}
+	}
+	return r, nil
+}
+
+// loadHostsFile 读取hosts格式文件：每行一个IP和若干主机名，#之后为注释
+func (r *Resolver) loadHostsFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		addr, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			continue
+		}
+		for _, name := range fields[1:] {
+			name = strings.ToLower(strings.TrimSuffix(name, "."))
+			r.hosts[name] = append(r.hosts[name], addr.String())
+		}
+	}
+	return scanner.Err()
+}
+
+// LookupHost 解析主机名的全部地址，hosts文件中有记录时不再查询DNS
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if addrs, ok := r.hosts[strings.ToLower(strings.TrimSuffix(host, "."))]; ok {
+		return addrs, nil
+	}
+
+	ipAddrs, err := r.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(ipAddrs))
+	for _, a := range ipAddrs {
+		addrs = append(addrs, a.String())
+	}
+	return addrs, nil
+}
@@ -0,0 +1,186 @@
+package scanner
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"nebulafinger/internal"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// dnsStandIn 在本地启动只回答A记录的DNS服务，records为小写主机名（不带结尾的点）到IPv4地址的映射，
+// 返回服务地址和收到的查询数
+func dnsStandIn(t *testing.T, records map[string][]string) (string, *int64) {
+	t.Helper()
+	port, queries := udpStandIn(t, func(query []byte) []byte { return dnsReply(query, records) })
+	return net.JoinHostPort("127.0.0.1", fmt.Sprint(port)), queries
+}
+
+// dnsReply 构造查询的响应：A记录查询返回records中的地址，其他类型返回空应答，未知主机返回NXDOMAIN
+func dnsReply(query []byte, records map[string][]string) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	var labels []string
+	offset := 12
+	for offset < len(query) && query[offset] != 0 {
+		length := int(query[offset])
+		if offset+1+length > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[offset+1:offset+1+length]))
+		offset += 1 + length
+	}
+	offset++
+	if offset+4 > len(query) {
+		return nil
+	}
+	qtype := binary.BigEndian.Uint16(query[offset:])
+	question := query[12 : offset+4]
+
+	addrs, known := records[strings.ToLower(strings.Join(labels, "."))]
+	var answers []byte
+	count := 0
+	if qtype == 1 {
+		for _, addr := range addrs {
+			// 名称压缩指针指向问题中的主机名，类型A，类IN，TTL 60，4字节地址
+			answers = append(answers, 0xc0, 0x0c, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4)
+			answers = append(answers, net.ParseIP(addr).To4()...)
+			count++
+		}
+	}
+
+	flags := uint16(0x8180)
+	if !known {
+		flags |= 3
+	}
+	reply := make([]byte, 12, 12+len(question)+len(answers))
+	copy(reply, query[:2])
+	binary.BigEndian.PutUint16(reply[2:], flags)
+	binary.BigEndian.PutUint16(reply[4:], 1)
+	binary.BigEndian.PutUint16(reply[6:], uint16(count))
+	reply = append(reply, question...)
+	return append(reply, answers...)
+}
+
+func TestResolverLookupHost(t *testing.T) {
+	server, _ := dnsStandIn(t, map[string][]string{"multi.nebula.test": {"127.0.0.1", "127.0.0.2"}})
+	r, err := NewResolver([]string{server}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addrs, err := r.LookupHost(context.Background(), "multi.nebula.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"127.0.0.1", "127.0.0.2"}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("LookupHost = %v，期望 %v", addrs, want)
+	}
+
+	if _, err := r.LookupHost(context.Background(), "missing.nebula.test"); err == nil {
+		t.Errorf("不存在的主机应返回错误")
+	}
+}
+
+func TestResolverHostsFileOverride(t *testing.T) {
+	server, queries := dnsStandIn(t, map[string][]string{"override.nebula.test": {"127.0.0.1"}})
+	hostsFile := filepath.Join(t.TempDir(), "hosts")
+	content := "# 覆盖记录\n127.0.0.3 override.nebula.test Alias.Nebula.Test.\n::1 override.nebula.test # IPv6\ninvalid line\n"
+	if err := os.WriteFile(hostsFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewResolver([]string{server}, hostsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addrs, err := r.LookupHost(context.Background(), "override.nebula.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"127.0.0.3", "::1"}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("LookupHost = %v，期望hosts文件中的 %v", addrs, want)
+	}
+	if addrs, _ := r.LookupHost(context.Background(), "alias.nebula.test"); !reflect.DeepEqual(addrs, []string{"127.0.0.3"}) {
+		t.Errorf("别名不区分大小写、忽略结尾的点，得到 %v", addrs)
+	}
+	if n := atomic.LoadInt64(queries); n != 0 {
+		t.Errorf("hosts文件中有记录时不应查询DNS，收到 %d 次查询", n)
+	}
+}
+
+func TestNewResolverInvalidServer(t *testing.T) {
+	if _, err := NewResolver([]string{"not-an-ip"}, ""); err == nil {
+		t.Errorf("无效的DNS服务器应返回错误")
+	}
+}
+
+// TestScanPerIPAttribution 主机名解析出多个地址时分别扫描每个地址，结果中的ip为实际连接的地址
+func TestScanPerIPAttribution(t *testing.T) {
+	// 同一端口在两个回环地址上监听，banner中带有各自的标识
+	var port int
+	for _, ip := range []string{"127.0.0.1", "127.0.0.2"} {
+		listener, err := net.Listen("tcp", net.JoinHostPort(ip, fmt.Sprint(port)))
+		if err != nil {
+			t.Skipf("无法监听 %s: %v", ip, err)
+		}
+		t.Cleanup(func() { listener.Close() })
+		port = listener.Addr().(*net.TCPAddr).Port
+		go func(ip string) {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				fmt.Fprintf(conn, "HELLO %s\r\n", ip)
+				time.AfterFunc(time.Second, func() { conn.Close() })
+			}
+		}(ip)
+	}
+
+	server, _ := dnsStandIn(t, map[string][]string{"multi.nebula.test": {"127.0.0.1", "127.0.0.2"}})
+	resolver, err := NewResolver([]string{server}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprints := []internal.Fingerprint{{
+		ID:   "hello-banner",
+		Info: internal.Info{Name: "hello", Tags: "hello"},
+		TCP: []internal.TCPRequest{{
+			Name:       "null",
+			Matchers:   []internal.Matchers{{Type: "regex", Part: "response", Regex: []string{`^HELLO `}}},
+			Extractors: []internal.Extractors{{Type: "regex", Name: "who", Regex: []string{`^HELLO (\S+)`}}},
+		}},
+	}}
+	s := newTestScanner(fingerprints, ScannerConfig{EnableTCP: true, PerIP: true})
+	s.Resolver = resolver
+
+	result, err := s.Scan(fmt.Sprintf("multi.nebula.test:%d", port), "service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, r := range result.TCPResults {
+		ip := r.Details["ip"]
+		if r.Details["who"] != ip {
+			t.Errorf("地址 %s 的结果来自 %s 的banner", ip, r.Details["who"])
+		}
+		seen[ip] = true
+	}
+	if !seen["127.0.0.1"] || !seen["127.0.0.2"] || len(result.TCPResults) != 2 {
+		t.Errorf("期望两个地址各一条结果，得到 %d 条: %v", len(result.TCPResults), seen)
+	}
+	for _, p := range result.Ports {
+		if p.IP != "127.0.0.1" && p.IP != "127.0.0.2" {
+			t.Errorf("端口结果的地址 = %q", p.IP)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package scanner
+
+import (
+	"nebulafinger/internal/cluster"
+	"sort"
+	"strings"
+)
+
+// 匹配结果中记录的端口与服务的关系
+const (
+	PortMatchExpected = "expected" // 服务运行在它的常用端口上
+	PortMatchUnusual  = "unusual"  // 服务运行在不常见的端口上
+)
+
+// 服务指纹按端口的探测优先级，数值越小越先匹配
+const (
+	tierServicePort     = iota // 端口配置中该端口对应的服务
+	tierFingerprintPort        // 指纹自身声明了该端口
+	tierGeneric                // 其他指纹，作为兜底
+)
+
+// indexServicePorts 将服务到端口的映射反转为端口到服务名（小写）的映射
+func indexServicePorts(servicePorts map[string][]uint16) map[uint16][]string {
+	index := make(map[uint16][]string)
+	for service, ports := range servicePorts {
+		service = strings.ToLower(strings.TrimSpace(service))
+		if service == "" {
+			continue
+		}
+		for _, port := range ports {
+			index[port] = append(index[port], service)
+		}
+	}
+	for port := range index {
+		sort.Strings(index[port])
+	}
+	return index
+}
+
+// isServicePort 判断指纹对应的服务是否是端口配置中该端口的服务：
+// 服务名与指纹名称或任一标签相同（不区分大小写）即视为同一服务
+func (s *Scanner) isServicePort(port uint16, fingerprint cluster.ClusteredFingerprint) bool {
+	services := s.portServices[port]
+	if len(services) == 0 {
+		return false
+	}
+	names := []string{fingerprint.Info.Name}
+	names = append(names, strings.Split(fingerprint.Info.Tags, ",")...)
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		for _, service := range services {
+			if name == service {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// probeTier 计算指纹聚类在该端口上的探测优先级
+func (s *Scanner) probeTier(port uint16, clusterExec cluster.ClusterExecute) int {
+	for _, fingerprint := range clusterExec.Operators {
+		if s.isServicePort(port, fingerprint) {
+			return tierServicePort
+		}
+	}
+	if portInList(clusterExec.Port, int(port)) {
+		return tierFingerprintPort
+	}
+	return tierGeneric
+}
+
+// portMatch 判断命中的指纹是在常用端口上还是不常见的端口上：
+// 端口配置或指纹自身声明了该端口时为expected，否则为unusual
+func (s *Scanner) portMatch(port uint16, ports string, fingerprint cluster.ClusteredFingerprint) string {
+	if s.isServicePort(port, fingerprint) || portInList(ports, int(port)) {
+		return PortMatchExpected
+	}
+	return PortMatchUnusual
+}
+
+// sortByTier 按探测优先级排序，同一优先级内按稀有度从小到大
+func sortByTier(clusters []ClusterInfo) {
+	sort.SliceStable(clusters, func(i, j int) bool {
+		if clusters[i].Tier != clusters[j].Tier {
+			return clusters[i].Tier < clusters[j].Tier
+		}
+		return clusters[i].Rarity < clusters[j].Rarity
+	})
+}
@@ -0,0 +1,133 @@
+package scanner
+
+import (
+	"nebulafinger/internal"
+	"nebulafinger/internal/cluster"
+	"reflect"
+	"testing"
+)
+
+// serviceFingerprint 返回名称和标签为name、tags，用正则regex匹配banner的服务指纹
+func serviceFingerprint(id, name, tags, regex string) cluster.ClusteredFingerprint {
+	return cluster.ClusteredFingerprint{
+		ID:         id,
+		Info:       internal.Info{Name: name, Tags: tags},
+		Extractors: []internal.Extractors{{Name: "version", Type: "regex", Regex: []string{regex}}},
+	}
+}
+
+func TestIndexServicePorts(t *testing.T) {
+	index := indexServicePorts(map[string][]uint16{
+		"Redis":    {6379},
+		" http ":   {80, 8080},
+		"http-alt": {8080},
+		"":         {1},
+	})
+	want := map[uint16][]string{
+		6379: {"redis"},
+		80:   {"http"},
+		8080: {"http", "http-alt"},
+	}
+	if !reflect.DeepEqual(index, want) {
+		t.Errorf("索引 = %v，期望 %v", index, want)
+	}
+}
+
+func TestProbeTier(t *testing.T) {
+	s := newTestScanner(nil, ScannerConfig{ServicePorts: map[string][]uint16{"redis": {6379}, "ssh": {22}}})
+	tests := []struct {
+		name    string
+		cluster cluster.ClusterExecute
+		port    uint16
+		want    int
+	}{
+		{"服务名与指纹名称相同", cluster.ClusterExecute{Operators: []cluster.ClusteredFingerprint{serviceFingerprint("redis", "Redis", "", "")}}, 6379, tierServicePort},
+		{"服务名与标签相同", cluster.ClusterExecute{Operators: []cluster.ClusteredFingerprint{serviceFingerprint("kv", "Key-Value Store", "db, REDIS", "")}}, 6379, tierServicePort},
+		// 端口配置优先于指纹声明的端口
+		{"端口配置和指纹端口", cluster.ClusterExecute{Port: "6379", Operators: []cluster.ClusteredFingerprint{serviceFingerprint("redis", "Redis", "", "")}}, 6379, tierServicePort},
+		{"其他端口上的服务", cluster.ClusterExecute{Operators: []cluster.ClusteredFingerprint{serviceFingerprint("redis", "Redis", "", "")}}, 22, tierGeneric},
+		{"指纹声明的端口", cluster.ClusterExecute{Port: "80,6379", Operators: []cluster.ClusteredFingerprint{serviceFingerprint("keydb", "KeyDB", "", "")}}, 6379, tierFingerprintPort},
+		{"指纹声明的端口范围", cluster.ClusterExecute{Port: "6000-7000", Operators: []cluster.ClusteredFingerprint{serviceFingerprint("keydb", "KeyDB", "", "")}}, 6379, tierFingerprintPort},
+		{"没有配置的端口", cluster.ClusterExecute{Port: "80", Operators: []cluster.ClusteredFingerprint{serviceFingerprint("keydb", "KeyDB", "", "")}}, 7000, tierGeneric},
+		// 聚类中任一指纹是该端口的服务即可
+		{"聚类中的第二个指纹", cluster.ClusterExecute{Operators: []cluster.ClusteredFingerprint{
+			serviceFingerprint("keydb", "KeyDB", "", ""), serviceFingerprint("openssh", "OpenSSH", "ssh", ""),
+		}}, 22, tierServicePort},
+	}
+	for _, tt := range tests {
+		if got := s.probeTier(tt.port, tt.cluster); got != tt.want {
+			t.Errorf("%s = %d，期望 %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPortMatch(t *testing.T) {
+	s := newTestScanner(nil, ScannerConfig{ServicePorts: map[string][]uint16{"redis": {6379}}})
+	redis := serviceFingerprint("redis", "Redis", "db", "")
+	tests := []struct {
+		name  string
+		port  uint16
+		ports string
+		want  string
+	}{
+		{"端口配置中的端口", 6379, "", PortMatchExpected},
+		{"指纹声明的端口", 16379, "6379,16379", PortMatchExpected},
+		{"指纹声明的端口范围", 16379, "16000-17000", PortMatchExpected},
+		{"不常见的端口", 16379, "6379", PortMatchUnusual},
+		{"没有端口信息", 8080, "", PortMatchUnusual},
+	}
+	for _, tt := range tests {
+		if got := s.portMatch(tt.port, tt.ports, redis); got != tt.want {
+			t.Errorf("%s = %s，期望 %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSortByTier(t *testing.T) {
+	clusters := []ClusterInfo{
+		{Name: "generic-rare", Tier: tierGeneric, Rarity: 9},
+		{Name: "declared", Tier: tierFingerprintPort, Rarity: 5},
+		{Name: "generic-common", Tier: tierGeneric, Rarity: 1},
+		{Name: "service-rare", Tier: tierServicePort, Rarity: 8},
+		{Name: "service-common", Tier: tierServicePort, Rarity: 2},
+		{Name: "generic-common-2", Tier: tierGeneric, Rarity: 1},
+	}
+	sortByTier(clusters)
+	var got []string
+	for _, c := range clusters {
+		got = append(got, c.Name)
+	}
+	// 同一优先级和稀有度时保持原有顺序
+	want := []string{"service-common", "service-rare", "declared", "generic-common", "generic-common-2", "generic-rare"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("顺序 = %v，期望 %v", got, want)
+	}
+}
+
+// TestMatchTCPOtherOrder 端口配置中该端口的服务先于更常见的通用指纹匹配，并记录port_match
+func TestMatchTCPOtherOrder(t *testing.T) {
+	s := newTestScanner(nil, ScannerConfig{ServicePorts: map[string][]uint16{"redis": {6379}}})
+	s.WebCluster.TCPOther = map[string]cluster.ClusterExecute{
+		"generic": {Rarity: 1, Operators: []cluster.ClusteredFingerprint{serviceFingerprint("generic-error", "Generic Error", "", `^-ERR (\S+)`)}},
+		"redis":   {Rarity: 9, Operators: []cluster.ClusteredFingerprint{serviceFingerprint("redis", "Redis", "db", `^-ERR (unknown) command`)}},
+	}
+	banner := &tcpBanner{response: "-ERR unknown command 'GET'\r\n"}
+
+	tests := []struct {
+		port      uint16
+		wantID    string
+		wantMatch string
+	}{
+		{6379, "redis", PortMatchExpected},
+		{7000, "generic-error", PortMatchUnusual},
+	}
+	for _, tt := range tests {
+		results, matched := s.matchTCPOther("127.0.0.1", tt.port, banner, nil)
+		if !matched || len(results) != 1 {
+			t.Fatalf("端口 %d 结果 = %+v", tt.port, results)
+		}
+		if results[0].ID != tt.wantID || results[0].Details["port_match"] != tt.wantMatch {
+			t.Errorf("端口 %d 命中 %s（%s），期望 %s（%s）", tt.port, results[0].ID, results[0].Details["port_match"], tt.wantID, tt.wantMatch)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"nebulafinger/internal/matcher"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 协议探测的结论
+const (
+	SniffTLS            = "tls"               // 只有TLS握手后响应HTTP
+	SniffPlain          = "plain"             // 只有明文HTTP响应
+	SniffHTTPSErrorPage = "https-error-page"  // 明文请求返回"HTTP sent to HTTPS port"类错误页，实际是HTTPS
+	SniffRedirect       = "redirect-to-https" // 明文请求被重定向到同一端口的HTTPS
+	SniffBothDifferent  = "both-different"    // 明文和TLS都响应HTTP且内容不同，两者都扫描
+	SniffBothSame       = "both-same"         // 明文和TLS都响应HTTP且内容相同，只扫描HTTPS
+	SniffNoResponse     = "no-response"       // 明文和TLS都没有HTTP响应
+)
+
+// sniffBodyLimit 协议探测时读取的响应体上限，用于识别错误页和比较内容
+const sniffBodyLimit = 8192
+
+// HTTPS端口收到明文HTTP请求时常见的错误页内容（小写）
+var httpsErrorPages = []string{
+	"plain http request was sent to https port",         // nginx、Tengine、OpenResty
+	"speaking plain http to an ssl-enabled server port", // Apache
+	"client sent an http request to an https server",    // Go net/http
+	"this combination of host and port requires tls",    // Go net/http
+	"http request was sent to https port",               // 其他
+	"this web server is running in ssl mode",            // 部分嵌入式设备
+}
+
+// SchemeDecision 单个 主机:端口 的协议探测结论
+type SchemeDecision struct {
+	Host    string   `json:"host"`              // 主机名或IP
+	Port    int      `json:"port"`              // 端口
+	Schemes []string `json:"schemes,omitempty"` // 选用的协议，为空时不做Web扫描
+	Reason  string   `json:"reason"`            // 结论依据，见Sniff*常量
+}
+
+// sniffTitleRegex 提取页面标题，用于比较明文和TLS的响应
+var sniffTitleRegex = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// sniffResponse 协议探测得到的HTTP响应摘要
+type sniffResponse struct {
+	status   int
+	location string
+	title    string
+	length   int64 // 响应体长度，优先使用Content-Length
+	body     []byte
+}
+
+// sameContent 判断两个响应是否为同一页面：状态码、标题和长度都相同。
+// 页面中的时间戳、CSRF令牌等动态内容每次请求都不同，不直接比较响应体
+func (r *sniffResponse) sameContent(other *sniffResponse) bool {
+	return r.status == other.status && r.title == other.title && r.length == other.length
+}
+
+// sniffSchemes 先发送TLS ClientHello，再发送明文HTTP请求，根据两者的响应选择端口上的Web协议：
+// 明文请求得到"HTTP sent to HTTPS port"错误页或被重定向到同一端口的HTTPS时只选HTTPS，
+// 两者都响应且内容不同时都选，都没有HTTP响应时不选
+func (s *Scanner) sniffSchemes(hostname string, port int) SchemeDecision {
+	decision := SchemeDecision{Host: hostname, Port: port}
+	tlsResp := s.sniffHTTP(hostname, port, true)
+	plainResp := s.sniffHTTP(hostname, port, false)
+
+	switch {
+	case tlsResp == nil && plainResp == nil:
+		decision.Reason = SniffNoResponse
+	case plainResp == nil:
+		decision.Schemes, decision.Reason = []string{"https"}, SniffTLS
+	case isHTTPSErrorPage(plainResp):
+		// 有的服务端对明文请求返回错误页但TLS探测失败（如只支持更新的TLS版本），仍然按HTTPS扫描
+		decision.Schemes, decision.Reason = []string{"https"}, SniffHTTPSErrorPage
+	case tlsResp == nil:
+		decision.Schemes, decision.Reason = []string{"http"}, SniffPlain
+	case isRedirectToHTTPS(plainResp, hostname, port):
+		decision.Schemes, decision.Reason = []string{"https"}, SniffRedirect
+	case plainResp.sameContent(tlsResp):
+		decision.Schemes, decision.Reason = []string{"https"}, SniffBothSame
+	default:
+		decision.Schemes, decision.Reason = []string{"http", "https"}, SniffBothDifferent
+	}
+	return decision
+}
+
+// sniffHTTP 连接端口发送最简单的HTTP请求，useTLS时先完成TLS握手；没有HTTP响应时返回nil
+func (s *Scanner) sniffHTTP(hostname string, port int, useTLS bool) *sniffResponse {
+	conn, err := s.dial("tcp", net.JoinHostPort(hostname, strconv.Itoa(port)))
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+
+	readTimeout := s.readTimeout()
+	if useTLS {
+		tlsConn := tls.Client(conn, sniffTLSConfig(hostname))
+		ctx, cancel := context.WithTimeout(context.Background(), readTimeout)
+		defer cancel()
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return nil
+		}
+		conn = tlsConn
+	}
+
+	conn.SetDeadline(time.Now().Add(readTimeout))
+	request := fmt.Sprintf("GET / HTTP/1.0\r\nHost: %s:%d\r\nConnection: close\r\n\r\n", hostHeader(hostname), port)
+	if _, err := conn.Write([]byte(request)); err != nil {
+		return nil
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, sniffBodyLimit))
+	sniffed := &sniffResponse{
+		status:   resp.StatusCode,
+		location: resp.Header.Get("Location"),
+		length:   resp.ContentLength,
+		body:     body,
+	}
+	if sniffed.length < 0 {
+		sniffed.length = int64(len(body))
+	}
+	if m := sniffTitleRegex.FindSubmatch(body); m != nil {
+		sniffed.title = strings.TrimSpace(string(m[1]))
+	}
+	return sniffed
+}
+
+// isHTTPSErrorPage 判断明文请求的响应是否为"HTTP sent to HTTPS port"类错误页，nginx对此返回497状态码
+func isHTTPSErrorPage(resp *sniffResponse) bool {
+	if resp.status == 497 {
+		return true
+	}
+	body := strings.ToLower(string(resp.body))
+	for _, page := range httpsErrorPages {
+		if strings.Contains(body, page) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRedirectToHTTPS 判断明文请求是否被重定向到同一主机、同一端口的HTTPS
+func isRedirectToHTTPS(resp *sniffResponse, hostname string, port int) bool {
+	if resp.status < 300 || resp.status >= 400 {
+		return false
+	}
+	u, err := url.Parse(resp.location)
+	if err != nil || u.Scheme != "https" {
+		return false
+	}
+	if u.Hostname() != "" && !strings.EqualFold(u.Hostname(), hostname) {
+		return false
+	}
+	return urlPortNumber(u) == port
+}
+
+// sniffTLSConfig 探测用的TLS配置：不校验证书，兼容旧版本TLS，主机名不是IP时发送SNI
+func sniffTLSConfig(hostname string) *tls.Config {
+	config := &tls.Config{
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS10,
+	}
+	if _, err := netip.ParseAddr(hostname); err != nil {
+		config.ServerName = hostname
+	}
+	return config
+}
+
+// urlPortNumber 返回URL中的端口，未显式指定时按协议推断
+func urlPortNumber(u *url.URL) int {
+	if port, err := strconv.Atoi(u.Port()); err == nil {
+		return port
+	}
+	if u.Scheme == "https" {
+		return 443
+	}
+	return 80
+}
+
+// webEndpoints 探测没有协议头的目标实际提供的Web服务，返回需要做Web扫描的URL和每个端口的探测结论
+// 目标带端口时只探测该端口，否则探测80和443端口；路径保持不变
+func (s *Scanner) webEndpoints(target string) ([]*url.URL, []SchemeDecision, error) {
+	base, err := parseURL("http://" + target)
+	if err != nil {
+		return nil, nil, err
+	}
+	hostname := base.Hostname()
+
+	ports := []int{80, 443}
+	explicitPort := base.Port() != ""
+	if explicitPort {
+		ports = []int{urlPortNumber(base)}
+	}
+
+	decisions := make([]SchemeDecision, len(ports))
+	parallel(len(ports), len(ports), func(i int) {
+		decisions[i] = s.sniffSchemes(hostname, ports[i])
+	})
+
+	var endpoints []*url.URL
+	for _, d := range decisions {
+		for _, scheme := range d.Schemes {
+			endpoint := *base
+			endpoint.Scheme = scheme
+			// 协议的默认端口不写入URL，与用户输入保持一致
+			endpoint.Host = urlHost(hostname)
+			if explicitPort || d.Port != urlPortNumber(&url.URL{Scheme: scheme}) {
+				endpoint.Host = escapeZone(net.JoinHostPort(hostname, strconv.Itoa(d.Port)))
+			}
+			endpoints = append(endpoints, &endpoint)
+		}
+	}
+	return endpoints, decisions, nil
+}
+
+// scanWebEndpoints 探测目标的Web协议后对每个实际的Web服务执行一次完整的Web扫描，返回探测过的端口
+func (s *Scanner) scanWebEndpoints(result *ScanResult, target string) map[int]bool {
+	endpoints, decisions, err := s.webEndpoints(target)
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+		return nil
+	}
+	result.SchemeDecisions = append(result.SchemeDecisions, decisions...)
+
+	sniffed := make(map[int]bool)
+	for _, d := range decisions {
+		sniffed[d.Port] = true
+	}
+	if len(endpoints) == 0 {
+		result.Errors = append(result.Errors, fmt.Sprintf("%s 没有发现HTTP或HTTPS服务", target))
+		return sniffed
+	}
+
+	var allResults []matcher.MatchResult
+	for _, endpoint := range endpoints {
+		webResults, err := s.httpScan(endpoint)
+		if err != nil {
+			result.Errors = append(result.Errors, err.Error())
+			continue
+		}
+		allResults = append(allResults, deletehttpstatuscode(webResults)...)
+	}
+	if len(allResults) > 0 {
+		result.WebResults = UniqueResults(allResults)
+	}
+	return sniffed
+}
+
+// scanWebPorts 对服务扫描发现的开放端口探测是否为Web服务，是则按探测到的协议执行完整的Web指纹识别，
+// 结果记录在对应端口的Web中并去重合并到WebResults；skipPorts为已经探测过的端口
+func (s *Scanner) scanWebPorts(result *ScanResult, skipPorts map[int]bool) {
+	webErrors := make([][]string, len(result.Ports))
+	decisions := make([]*SchemeDecision, len(result.Ports))
+	parallel(len(result.Ports), s.portConcurrency(), func(i int) {
+		p := &result.Ports[i]
+		if skipPorts[p.Port] || p.Protocol == ProtocolUDP {
+			return
+		}
+		decision := s.sniffSchemes(p.Host, p.Port)
+		if len(decision.Schemes) == 0 {
+			return
+		}
+		decisions[i] = &decision
+
+		// 两种协议都提供服务时每种协议单独记录一个Web服务
+		for _, scheme := range decision.Schemes {
+			parsedURL, err := parseURL(scheme + "://" + escapeZone(net.JoinHostPort(p.Host, strconv.Itoa(p.Port))))
+			if err != nil {
+				continue
+			}
+			web := PortWeb{Scheme: scheme, URL: parsedURL.String()}
+			results, err := s.httpScan(parsedURL)
+			if err != nil {
+				webErrors[i] = append(webErrors[i], fmt.Sprintf("%s: %v", web.URL, err))
+			} else {
+				web.Results = deletehttpstatuscode(results)
+			}
+			p.Web = append(p.Web, web)
+		}
+	})
+
+	for i, p := range result.Ports {
+		if decisions[i] != nil {
+			result.SchemeDecisions = append(result.SchemeDecisions, *decisions[i])
+		}
+		// 同一站点多条规则命中的相同指纹只保留一条
+		var webResults []matcher.MatchResult
+		for _, web := range p.Web {
+			webResults = append(webResults, web.Results...)
+		}
+		result.WebResults = append(result.WebResults, UniqueResults(webResults)...)
+		result.Errors = append(result.Errors, webErrors[i]...)
+	}
+}
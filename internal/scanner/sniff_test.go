@@ -0,0 +1,219 @@
+package scanner
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// page 返回固定标题和正文的处理函数
+func page(title, body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "<html><title>%s</title><body>%s</body></html>", title, body)
+	})
+}
+
+func TestSniffSchemes(t *testing.T) {
+	tests := []struct {
+		name        string
+		plain, tls  http.Handler
+		wantSchemes []string
+		wantReason  string
+	}{
+		{"只有明文", page("home", ""), nil, []string{"http"}, SniffPlain},
+		{"只有TLS", nil, page("home", ""), []string{"https"}, SniffTLS},
+		{"497错误页", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(497)
+		}), nil, []string{"https"}, SniffHTTPSErrorPage},
+		{"nginx错误页", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "<center>The plain HTTP request was sent to HTTPS port</center>")
+		}), page("home", ""), []string{"https"}, SniffHTTPSErrorPage},
+		{"重定向到同一端口", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "https://"+r.Host+"/login", http.StatusFound)
+		}), page("login", ""), []string{"https"}, SniffRedirect},
+		{"重定向到其他端口", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "https://127.0.0.1:1/", http.StatusMovedPermanently)
+		}), page("home", ""), []string{"http", "https"}, SniffBothDifferent},
+		{"内容相同", page("home", "same"), page("home", "same"), []string{"https"}, SniffBothSame},
+		{"动态内容长度相同", page("home", "token=aaaa"), page("home", "token=bbbb"), []string{"https"}, SniffBothSame},
+		{"标题不同", page("public", "x"), page("admin", "x"), []string{"http", "https"}, SniffBothDifferent},
+		{"长度不同", page("home", "short"), page("home", "much longer"), []string{"http", "https"}, SniffBothDifferent},
+		{"都没有响应", nil, nil, nil, SniffNoResponse},
+	}
+	s := newTestScanner(nil, ScannerConfig{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := int(dualStandIn(t, "127.0.0.1", tt.plain, tt.tls))
+			d := s.sniffSchemes("127.0.0.1", port)
+			if !reflect.DeepEqual(d.Schemes, tt.wantSchemes) || d.Reason != tt.wantReason {
+				t.Errorf("结论 = %v %s，期望 %v %s", d.Schemes, d.Reason, tt.wantSchemes, tt.wantReason)
+			}
+			if d.Host != "127.0.0.1" || d.Port != port {
+				t.Errorf("host/port = %s/%d", d.Host, d.Port)
+			}
+		})
+	}
+}
+
+// TestSniffGoHTTPSServer Go的HTTPS服务对明文请求返回"Client sent an HTTP request to an HTTPS server"
+func TestSniffGoHTTPSServer(t *testing.T) {
+	server := httptest.NewTLSServer(page("home", ""))
+	defer server.Close()
+	host, port := splitServerAddr(t, server.Listener.Addr().String())
+
+	d := newTestScanner(nil, ScannerConfig{}).sniffSchemes(host, port)
+	if !reflect.DeepEqual(d.Schemes, []string{"https"}) || d.Reason != SniffHTTPSErrorPage {
+		t.Errorf("结论 = %v %s", d.Schemes, d.Reason)
+	}
+}
+
+func TestIsHTTPSErrorPage(t *testing.T) {
+	tests := []struct {
+		resp sniffResponse
+		want bool
+	}{
+		{sniffResponse{status: 497}, true},
+		{sniffResponse{status: 400, body: []byte("Bad Request\nThis combination of host and port requires TLS.\n")}, true},
+		{sniffResponse{status: 400, body: []byte("Your browser sent a request that this server could not understand.<br />Reason: You're speaking plain HTTP to an SSL-enabled server port.")}, true},
+		{sniffResponse{status: 400, body: []byte("Bad Request")}, false},
+		{sniffResponse{status: 200, body: []byte("<title>home</title>")}, false},
+	}
+	for _, tt := range tests {
+		if got := isHTTPSErrorPage(&tt.resp); got != tt.want {
+			t.Errorf("%d %q = %v，期望 %v", tt.resp.status, tt.resp.body, got, tt.want)
+		}
+	}
+}
+
+func TestIsRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		status   int
+		location string
+		host     string
+		port     int
+		want     bool
+	}{
+		{301, "https://example.com:8080/", "example.com", 8080, true},
+		{302, "https://EXAMPLE.com/", "example.com", 443, true},
+		{302, "https:///login", "example.com", 443, true},
+		{307, "https://[2001:db8::1]:8443/", "2001:db8::1", 8443, true},
+		{302, "https://example.com/", "example.com", 8080, false},
+		{302, "https://example.com:8443/", "example.com", 8080, false},
+		{302, "https://other.example.com:8080/", "example.com", 8080, false},
+		{302, "http://example.com:8080/login", "example.com", 8080, false},
+		{302, "/login", "example.com", 8080, false},
+		{200, "https://example.com:8080/", "example.com", 8080, false},
+	}
+	for _, tt := range tests {
+		resp := &sniffResponse{status: tt.status, location: tt.location}
+		if got := isRedirectToHTTPS(resp, tt.host, tt.port); got != tt.want {
+			t.Errorf("%d %s (%s:%d) = %v，期望 %v", tt.status, tt.location, tt.host, tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestWebEndpoints(t *testing.T) {
+	s := newTestScanner(nil, ScannerConfig{Timeout: 500 * time.Millisecond})
+	port := dualStandIn(t, "127.0.0.1", page("public", ""), page("admin", ""))
+
+	endpoints, decisions, err := s.webEndpoints(fmt.Sprintf("127.0.0.1:%d/app", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		fmt.Sprintf("http://127.0.0.1:%d/app", port),
+		fmt.Sprintf("https://127.0.0.1:%d/app", port),
+	}
+	if got := endpointStrings(endpoints); !reflect.DeepEqual(got, want) {
+		t.Errorf("endpoints = %v，期望 %v", got, want)
+	}
+	if len(decisions) != 1 || decisions[0].Reason != SniffBothDifferent {
+		t.Errorf("decisions = %+v", decisions)
+	}
+}
+
+func TestWebEndpointsIPv6(t *testing.T) {
+	s := newTestScanner(nil, ScannerConfig{Timeout: 500 * time.Millisecond})
+	port := dualStandIn(t, "::1", nil, page("home", ""))
+
+	endpoints, decisions, err := s.webEndpoints(fmt.Sprintf("[::1]:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := endpointStrings(endpoints), []string{fmt.Sprintf("https://[::1]:%d", port)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("endpoints = %v，期望 %v", got, want)
+	}
+	if len(decisions) != 1 || decisions[0].Host != "::1" || decisions[0].Reason != SniffTLS {
+		t.Errorf("decisions = %+v", decisions)
+	}
+}
+
+func TestSniffResponseSameContent(t *testing.T) {
+	base := sniffResponse{status: 200, title: "home", length: 120}
+	for _, other := range []sniffResponse{
+		{status: 404, title: "home", length: 120},
+		{status: 200, title: "admin", length: 120},
+		{status: 200, title: "home", length: 121},
+	} {
+		if base.sameContent(&other) {
+			t.Errorf("%+v 不应与 %+v 视为相同", other, base)
+		}
+	}
+	if same := (sniffResponse{status: 200, title: "home", length: 120, body: []byte("a")}); !base.sameContent(&same) {
+		t.Errorf("状态码、标题和长度相同时应视为相同")
+	}
+}
+
+// splitServerAddr 拆分测试服务的地址
+func splitServerAddr(t *testing.T, addr string) (string, int) {
+	t.Helper()
+	host, portText, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(portText)
+	return host, port
+}
+
+// endpointStrings 将URL列表转换为字符串
+func endpointStrings(endpoints []*url.URL) []string {
+	var urls []string
+	for _, u := range endpoints {
+		urls = append(urls, u.String())
+	}
+	return urls
+}
+
+// TestScanWebPortsBothSchemes 同一端口HTTP和HTTPS内容不同时两者都记录在端口的Web中
+func TestScanWebPortsBothSchemes(t *testing.T) {
+	s := newTestScanner(nil, ScannerConfig{Timeout: 500 * time.Millisecond})
+	both := int(dualStandIn(t, "127.0.0.1", page("public", ""), page("admin", "")))
+	skipped := int(dualStandIn(t, "127.0.0.1", page("home", ""), nil))
+	result := &ScanResult{Ports: []PortResult{
+		{Host: "127.0.0.1", Port: both, Protocol: ProtocolTCP, State: PortOpen},
+		{Host: "127.0.0.1", Port: skipped, Protocol: ProtocolTCP, State: PortOpen},
+		{Host: "127.0.0.1", Port: both, Protocol: ProtocolUDP, State: PortOpen},
+	}}
+
+	s.scanWebPorts(result, map[int]bool{skipped: true})
+	web := result.Ports[0].Web
+	if len(web) != 2 || web[0].Scheme != "http" || web[1].Scheme != "https" {
+		t.Fatalf("web = %+v", web)
+	}
+	if want := fmt.Sprintf("https://127.0.0.1:%d", both); web[1].URL != want {
+		t.Errorf("url = %s，期望 %s", web[1].URL, want)
+	}
+	if len(result.Ports[1].Web) != 0 || len(result.Ports[2].Web) != 0 {
+		t.Errorf("跳过的端口和UDP端口不应探测: %+v", result.Ports[1:])
+	}
+	if len(result.SchemeDecisions) != 1 || result.SchemeDecisions[0].Reason != SniffBothDifferent {
+		t.Errorf("decisions = %+v", result.SchemeDecisions)
+	}
+}
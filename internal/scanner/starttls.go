@@ -0,0 +1,504 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"nebulafinger/internal"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 服务探测使用TLS的方式，记录在匹配结果的tls详情中
+const (
+	TLSImplicit = "implicit" // 连接建立后直接TLS握手
+	TLSStartTLS = "starttls" // 先用明文协议请求升级，再TLS握手
+)
+
+// bannerLimit 读取服务响应的上限
+const bannerLimit = 2048
+
+// implicitTLSPorts 常见的隐式TLS端口，连接后先尝试TLS握手
+var implicitTLSPorts = map[uint16]bool{
+	465:  true, // SMTPS
+	563:  true, // NNTPS
+	636:  true, // LDAPS
+	853:  true, // DNS over TLS
+	989:  true, // FTPS数据
+	990:  true, // FTPS
+	992:  true, // Telnet over TLS
+	993:  true, // IMAPS
+	994:  true, // IRCS
+	995:  true, // POP3S
+	3269: true, // LDAPS全局编录
+	5061: true, // SIP over TLS
+	5223: true, // XMPP over TLS
+	5671: true, // AMQPS
+	6697: true, // IRC over TLS
+	8883: true, // MQTT over TLS
+}
+
+// tcpBanner 端口上读取到的服务响应和TLS信息
+type tcpBanner struct {
+	response string            // 用于匹配指纹的响应：隐式TLS为TLS内的响应，其他为明文响应
+	tls      string            // TLS方式: 空, implicit, starttls
+	protocol string            // STARTTLS使用的协议
+	inner    string            // TLS建立后读取到的响应
+	cert     map[string]string // 服务端证书信息
+}
+
+// overTLS 响应是否在TLS连接上得到或连接已升级为TLS
+func (b *tcpBanner) overTLS() bool {
+	return b.tls != ""
+}
+
+// details 返回记录到匹配结果中的TLS信息
+func (b *tcpBanner) details() map[string]string {
+	if !b.overTLS() {
+		return nil
+	}
+	details := map[string]string{"tls": b.tls}
+	if b.protocol != "" {
+		details["starttls"] = b.protocol
+	}
+	if line := firstLine(b.inner); line != "" {
+		details["tls_banner"] = line
+	}
+	for k, v := range b.cert {
+		details[k] = v
+	}
+	return details
+}
+
+// startTLSUpgrader 协议内的STARTTLS升级方式
+type startTLSUpgrader struct {
+	name        string                                               // 协议名，与端口配置中的服务名对应
+	aliases     []string                                             // 协议的其他服务名
+	clientFirst bool                                                 // 客户端先发送数据的协议，没有banner时按端口对应的服务选择
+	detect      *regexp.Regexp                                       // 根据明文banner识别协议，客户端先发送数据的协议为nil
+	hint        *regexp.Regexp                                       // banner中的协议或产品关键字，用于区分detect相同的协议（SMTP和FTP都以220开头）
+	negotiate   func(conn net.Conn, hostname string) (string, error) // 请求升级，返回升级前服务端的响应
+	inner       string                                               // TLS建立后发送的命令，用于读取TLS内的响应，为空时不发送
+}
+
+// startTLSUpgraders 支持的STARTTLS协议，按banner识别出多个协议时默认按此顺序尝试
+var startTLSUpgraders = []startTLSUpgrader{
+	{
+		name:   "smtp",
+		detect: regexp.MustCompile(`^220[ -]`),
+		hint:   regexp.MustCompile(`(?i)smtp|mail|postfix|exim|sendmail`),
+		negotiate: func(conn net.Conn, hostname string) (string, error) {
+			reply, err := exchange(conn, "EHLO nebulafinger\r\n", smtpReplyComplete)
+			if err != nil {
+				return reply, err
+			}
+			if !strings.Contains(strings.ToUpper(reply), "STARTTLS") {
+				return reply, errors.New("服务端不支持STARTTLS")
+			}
+			return expectReply(conn, "STARTTLS\r\n", smtpReplyComplete, "220")
+		},
+		inner: "EHLO nebulafinger\r\n",
+	},
+	{
+		name:   "ftp",
+		detect: regexp.MustCompile(`^220[ -]`),
+		hint:   regexp.MustCompile(`(?i)ftp|filezilla|serv-u`),
+		negotiate: func(conn net.Conn, hostname string) (string, error) {
+			return expectReply(conn, "AUTH TLS\r\n", smtpReplyComplete, "234")
+		},
+		inner: "FEAT\r\n",
+	},
+	{
+		name:   "imap",
+		detect: regexp.MustCompile(`(?i)^\* (OK|PREAUTH)`),
+		negotiate: func(conn net.Conn, hostname string) (string, error) {
+			return expectReply(conn, "a001 STARTTLS\r\n", taggedReplyComplete("a001 "), "a001 OK")
+		},
+		inner: "a002 CAPABILITY\r\n",
+	},
+	{
+		name:   "pop3",
+		detect: regexp.MustCompile(`^\+OK`),
+		negotiate: func(conn net.Conn, hostname string) (string, error) {
+			return expectReply(conn, "STLS\r\n", lineComplete, "+OK")
+		},
+		inner: "CAPA\r\n",
+	},
+	{
+		name:        "xmpp",
+		aliases:     []string{"jabber", "xmpp-client"},
+		clientFirst: true,
+		negotiate: func(conn net.Conn, hostname string) (string, error) {
+			header := fmt.Sprintf("<?xml version='1.0'?><stream:stream to='%s' xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>", hostname)
+			features, err := exchange(conn, header, containsAny("</stream:features>", "</stream:stream>"))
+			if err != nil {
+				return features, err
+			}
+			if !strings.Contains(features, "urn:ietf:params:xml:ns:xmpp-tls") {
+				return features, errors.New("服务端不支持STARTTLS")
+			}
+			reply, err := exchange(conn, "<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>", containsAny("<proceed", "<failure"))
+			if err != nil {
+				return features, err
+			}
+			if !strings.Contains(reply, "<proceed") {
+				return features, errors.New("服务端拒绝STARTTLS")
+			}
+			return features, nil
+		},
+	},
+	{
+		name:        "ldap",
+		clientFirst: true,
+		negotiate: func(conn net.Conn, hostname string) (string, error) {
+			// ExtendedRequest，requestName为StartTLS的OID 1.3.6.1.4.1.1466.20037
+			request := "\x30\x1d\x02\x01\x01\x77\x18\x80\x16" + "1.3.6.1.4.1.1466.20037"
+			reply, err := exchange(conn, request, berMessageComplete)
+			if err != nil {
+				return reply, err
+			}
+			// ExtendedResponse中resultCode为success(0)
+			if !strings.Contains(reply, "\x78") || !strings.Contains(reply, "\x0a\x01\x00") {
+				return reply, errors.New("服务端拒绝StartTLS")
+			}
+			return reply, nil
+		},
+	},
+	{
+		name:        "postgresql",
+		aliases:     []string{"postgres"},
+		clientFirst: true,
+		negotiate: func(conn net.Conn, hostname string) (string, error) {
+			// SSLRequest: 长度8，请求码80877103
+			reply, err := exchange(conn, "\x00\x00\x00\x08\x04\xd2\x16\x2f", func(b []byte) bool { return len(b) > 0 })
+			if err != nil {
+				return reply, err
+			}
+			if reply != "S" {
+				return reply, errors.New("服务端不支持SSL")
+			}
+			return reply, nil
+		},
+	},
+}
+
+// grabTCPBanner 读取端口上的服务响应，按需处理TLS：
+// 隐式TLS端口和声明了tls: true的指纹端口先尝试TLS握手；明文banner属于可升级的协议时执行STARTTLS；
+// 没有明文banner时尝试TLS握手，仍然失败时按端口对应的服务尝试客户端先发送数据的协议（XMPP、LDAP、PostgreSQL）的STARTTLS
+func (s *Scanner) grabTCPBanner(hostname string, port uint16) *tcpBanner {
+	tlsTried := false
+	if implicitTLSPorts[port] || s.declaresTLS(port) {
+		if banner := s.grabTLSBanner(hostname, port); banner != nil {
+			return banner
+		}
+		tlsTried = true
+	}
+
+	banner, err := s.grabPlainBanner(hostname, port)
+	if err != nil || banner.response != "" {
+		return banner
+	}
+
+	if !tlsTried {
+		if tlsBanner := s.grabTLSBanner(hostname, port); tlsBanner != nil {
+			return tlsBanner
+		}
+	}
+
+	for _, upgrader := range startTLSUpgraders {
+		if upgrader.clientFirst && s.portRunsService(port, upgrader) {
+			if upgraded := s.clientFirstStartTLS(hostname, port, upgrader); upgraded != nil {
+				return upgraded
+			}
+		}
+	}
+	return banner
+}
+
+// grabTLSBanner 连接后直接TLS握手并读取TLS内的响应，握手失败时返回nil
+func (s *Scanner) grabTLSBanner(hostname string, port uint16) *tcpBanner {
+	conn, err := s.dial("tcp", net.JoinHostPort(hostname, strconv.Itoa(int(port))))
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+
+	tlsConn, cert, err := s.handshake(conn, hostname)
+	if err != nil {
+		return nil
+	}
+	inner := s.readBanner(tlsConn)
+	return &tcpBanner{response: inner, tls: TLSImplicit, inner: inner, cert: cert}
+}
+
+// grabPlainBanner 读取明文banner，属于可升级的协议时在同一连接上执行STARTTLS
+// 升级失败不影响明文banner的匹配；连接失败时返回错误
+func (s *Scanner) grabPlainBanner(hostname string, port uint16) (*tcpBanner, error) {
+	conn, err := s.dial("tcp", net.JoinHostPort(hostname, strconv.Itoa(int(port))))
+	if err != nil {
+		return &tcpBanner{}, err
+	}
+	defer conn.Close()
+
+	banner := &tcpBanner{response: s.readBanner(conn)}
+	if banner.response == "" {
+		return banner, nil
+	}
+
+	for _, upgrader := range s.startTLSCandidates(port, banner.response) {
+		conn.SetDeadline(time.Now().Add(s.readTimeout()))
+		if _, err := upgrader.negotiate(conn, hostname); err != nil {
+			// 服务端不认识该协议的命令时连接仍然可用，继续尝试下一个协议
+			continue
+		}
+		s.upgrade(banner, conn, hostname, upgrader)
+		break
+	}
+	return banner, nil
+}
+
+// startTLSCandidates 返回明文banner可能属于的升级协议，按尝试顺序排列：
+// banner中有协议关键字的优先，其次是端口对应的服务，其余保持startTLSUpgraders中的顺序
+func (s *Scanner) startTLSCandidates(port uint16, response string) []startTLSUpgrader {
+	var candidates []startTLSUpgrader
+	for _, upgrader := range startTLSUpgraders {
+		if !upgrader.clientFirst && upgrader.detect.MatchString(response) {
+			candidates = append(candidates, upgrader)
+		}
+	}
+	rank := func(upgrader startTLSUpgrader) int {
+		switch {
+		case upgrader.hint != nil && upgrader.hint.MatchString(response):
+			return 0
+		case s.portRunsService(port, upgrader):
+			return 1
+		}
+		return 2
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return rank(candidates[i]) < rank(candidates[j])
+	})
+	return candidates
+}
+
+// clientFirstStartTLS 对客户端先发送数据的协议请求升级，服务端的协商响应作为匹配用的响应
+func (s *Scanner) clientFirstStartTLS(hostname string, port uint16, upgrader startTLSUpgrader) *tcpBanner {
+	conn, err := s.dial("tcp", net.JoinHostPort(hostname, strconv.Itoa(int(port))))
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(s.readTimeout()))
+	reply, err := upgrader.negotiate(conn, hostname)
+	if reply == "" {
+		return nil
+	}
+	banner := &tcpBanner{response: reply}
+	if err == nil {
+		s.upgrade(banner, conn, hostname, upgrader)
+	}
+	return banner
+}
+
+// upgrade 协商成功后在连接上TLS握手，记录证书和TLS内的响应
+func (s *Scanner) upgrade(banner *tcpBanner, conn net.Conn, hostname string, upgrader startTLSUpgrader) {
+	tlsConn, cert, err := s.handshake(conn, hostname)
+	if err != nil {
+		return
+	}
+	banner.tls = TLSStartTLS
+	banner.protocol = upgrader.name
+	banner.cert = cert
+	if upgrader.inner != "" {
+		tlsConn.SetDeadline(time.Now().Add(s.readTimeout()))
+		if _, err := tlsConn.Write([]byte(upgrader.inner)); err == nil {
+			banner.inner = s.readBanner(tlsConn)
+		}
+	}
+}
+
+// handshake 在连接上完成TLS握手，返回TLS连接和服务端证书信息
+func (s *Scanner) handshake(conn net.Conn, hostname string) (*tls.Conn, map[string]string, error) {
+	tlsConn := tls.Client(conn, sniffTLSConfig(hostname))
+	ctx, cancel := context.WithTimeout(context.Background(), s.readTimeout())
+	defer cancel()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, nil, err
+	}
+	state := tlsConn.ConnectionState()
+	return tlsConn, tlsCertDetails(&state), nil
+}
+
+// readBanner 在读取超时内读取一次服务端主动发送的数据，没有数据时返回空字符串
+func (s *Scanner) readBanner(conn net.Conn) string {
+	conn.SetDeadline(time.Now().Add(s.readTimeout()))
+	buffer := make([]byte, bannerLimit)
+	n, _ := conn.Read(buffer)
+	return string(buffer[:n])
+}
+
+// declaresTLS 判断是否有声明了该端口的服务指纹要求TLS
+func (s *Scanner) declaresTLS(port uint16) bool {
+	for _, c := range s.tcpClusters() {
+		if !portInList(c.Port, int(port)) {
+			continue
+		}
+		for _, fingerprint := range c.Operators {
+			if fingerprint.TLS == internal.TLSRequired {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// portRunsService 判断端口配置或服务指纹中该端口对应的服务是否为升级协议
+func (s *Scanner) portRunsService(port uint16, upgrader startTLSUpgrader) bool {
+	names := append([]string{upgrader.name}, upgrader.aliases...)
+	matches := func(service string) bool {
+		service = strings.ToLower(strings.TrimSpace(service))
+		for _, name := range names {
+			if service == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, service := range s.portServices[port] {
+		if matches(service) {
+			return true
+		}
+	}
+	if s.WebCluster != nil {
+		for name, c := range s.WebCluster.TCPOther {
+			if matches(name) && portInList(c.Port, int(port)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// exchange 发送请求并读取响应，直到complete返回true、连接关闭、超时或达到读取上限
+func exchange(conn net.Conn, request string, complete func([]byte) bool) (string, error) {
+	return exchangeLimit(conn, request, complete, bannerLimit)
+}
+
+// exchangeLimit 同exchange，读取上限为limit字节
+func exchangeLimit(conn net.Conn, request string, complete func([]byte) bool, limit int) (string, error) {
+	if _, err := conn.Write([]byte(request)); err != nil {
+		return "", err
+	}
+	var reply []byte
+	buffer := make([]byte, bannerLimit)
+	for len(reply) < limit {
+		n, err := conn.Read(buffer)
+		reply = append(reply, buffer[:n]...)
+		if complete(reply) {
+			return string(reply), nil
+		}
+		if err != nil {
+			return string(reply), err
+		}
+	}
+	return string(reply), nil
+}
+
+// expectReply 发送请求，响应不以prefix开头时返回错误
+func expectReply(conn net.Conn, request string, complete func([]byte) bool, prefix string) (string, error) {
+	reply, err := exchange(conn, request, complete)
+	if err != nil {
+		return reply, err
+	}
+	if !strings.HasPrefix(reply, prefix) {
+		return reply, fmt.Errorf("服务端拒绝升级: %s", firstLine(reply))
+	}
+	return reply, nil
+}
+
+// lineComplete 读取到完整的一行
+func lineComplete(b []byte) bool {
+	return bytes.HasSuffix(b, []byte("\n"))
+}
+
+// smtpReplyComplete SMTP和FTP的多行响应以"三位状态码+空格"开头的行结束
+func smtpReplyComplete(b []byte) bool {
+	if !lineComplete(b) {
+		return false
+	}
+	lines := strings.Split(strings.TrimRight(string(b), "\r\n"), "\n")
+	last := lines[len(lines)-1]
+	return len(last) >= 4 && last[3] == ' '
+}
+
+// taggedReplyComplete IMAP的响应以带请求标签的行结束
+func taggedReplyComplete(tag string) func([]byte) bool {
+	return func(b []byte) bool {
+		if !lineComplete(b) {
+			return false
+		}
+		lines := strings.Split(strings.TrimRight(string(b), "\r\n"), "\n")
+		return strings.HasPrefix(lines[len(lines)-1], tag)
+	}
+}
+
+// containsAny 响应中出现任一标记
+func containsAny(marks ...string) func([]byte) bool {
+	return func(b []byte) bool {
+		for _, mark := range marks {
+			if bytes.Contains(b, []byte(mark)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// berMessageComplete 读取到完整的BER编码LDAP消息
+func berMessageComplete(b []byte) bool {
+	if len(b) < 2 {
+		return false
+	}
+	length, header := int(b[1]), 2
+	if b[1]&0x80 != 0 {
+		n := int(b[1] & 0x7f)
+		if n == 0 || n > 4 || len(b) < 2+n {
+			return n == 0 || n > 4
+		}
+		length = 0
+		for _, c := range b[2 : 2+n] {
+			length = length<<8 | int(c)
+		}
+		header += n
+	}
+	return len(b) >= header+length
+}
+
+// firstLine 返回响应中第一行非空的可打印文本
+func firstLine(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && isPrintable(line) {
+			return line
+		}
+	}
+	return ""
+}
+
+// isPrintable 判断文本是否全部为可打印字符
+func isPrintable(s string) bool {
+	for _, r := range s {
+		if r < 0x20 && r != '\t' || r == 0x7f || r == 0xfffd {
+			return false
+		}
+	}
+	return true
+}
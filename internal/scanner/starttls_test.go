@@ -0,0 +1,126 @@
+package scanner
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// textStandIn 模拟明文行协议服务：发送banner后按replies回复命令，不认识的命令回复500；
+// 回复upgrade命令后切换为TLS，TLS内对收到的第一条命令回复inner
+func textStandIn(t *testing.T, banner string, replies map[string]string, upgrade, inner string) uint16 {
+	t.Helper()
+	config := selfSignedTLSConfig(t, "localhost")
+	return tcpStandIn(t, func(conn net.Conn) {
+		conn.Write([]byte(banner))
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.TrimSpace(line)
+			reply, ok := replies[command]
+			if !ok {
+				reply = "500 Unknown command\r\n"
+			}
+			conn.Write([]byte(reply))
+			if command != upgrade {
+				continue
+			}
+			tlsConn := tls.Server(conn, config)
+			if _, err := bufio.NewReader(tlsConn).ReadString('\n'); err == nil {
+				tlsConn.Write([]byte(inner))
+			}
+			return
+		}
+	})
+}
+
+var (
+	smtpReplies = map[string]string{
+		"EHLO nebulafinger": "250-mx.example.com\r\n250-PIPELINING\r\n250 STARTTLS\r\n",
+		"STARTTLS":          "220 2.0.0 Ready to start TLS\r\n",
+	}
+	ftpReplies = map[string]string{
+		"AUTH TLS": "234 AUTH TLS successful\r\n",
+	}
+)
+
+func TestStartTLSCandidates(t *testing.T) {
+	s := newTestScanner(nil, ScannerConfig{ServicePorts: map[string][]uint16{"ftp": {2121}, "smtp": {2525}}})
+	names := func(port uint16, banner string) []string {
+		var got []string
+		for _, upgrader := range s.startTLSCandidates(port, banner) {
+			got = append(got, upgrader.name)
+		}
+		return got
+	}
+	tests := []struct {
+		port   uint16
+		banner string
+		want   []string
+	}{
+		{1, "220 mx.example.com ESMTP Postfix\r\n", []string{"smtp", "ftp"}},
+		{1, "220 (vsFTPd 3.0.5)\r\n", []string{"ftp", "smtp"}},
+		{1, "220-FileZilla Server 1.8.0\r\n", []string{"ftp", "smtp"}},
+		{1, "220 Service ready\r\n", []string{"smtp", "ftp"}},
+		{2121, "220 Service ready\r\n", []string{"ftp", "smtp"}},
+		{2525, "220 Service ready\r\n", []string{"smtp", "ftp"}},
+		{2121, "220 mx.example.com ESMTP\r\n", []string{"smtp", "ftp"}},
+		{1, "* OK [CAPABILITY IMAP4rev1 STARTTLS] Dovecot ready.\r\n", []string{"imap"}},
+		{1, "+OK POP3 ready\r\n", []string{"pop3"}},
+		{1, "SSH-2.0-OpenSSH_9.6\r\n", nil},
+	}
+	for _, tt := range tests {
+		if got := names(tt.port, tt.banner); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("端口 %d %q = %v，期望 %v", tt.port, tt.banner, got, tt.want)
+		}
+	}
+}
+
+func TestGrabTCPBannerStartTLS(t *testing.T) {
+	tests := []struct {
+		name     string
+		banner   string
+		replies  map[string]string
+		upgrade  string
+		inner    string
+		protocol string
+	}{
+		{"SMTP", "220 mx.example.com ESMTP Postfix\r\n", smtpReplies, "STARTTLS", "250 mx.example.com\r\n", "smtp"},
+		{"FTP", "220 (vsFTPd 3.0.5)\r\n", ftpReplies, "AUTH TLS", "211 Features\r\n", "ftp"},
+		// 主机名中带mail的FTP服务先按SMTP尝试，EHLO被拒绝后在同一连接上改用AUTH TLS
+		{"FTP banner中有mail", "220 ProFTPD Server (mail.example.com)\r\n", ftpReplies, "AUTH TLS", "211 Features\r\n", "ftp"},
+		{"没有关键字的FTP", "220 Service ready\r\n", ftpReplies, "AUTH TLS", "211 Features\r\n", "ftp"},
+		{"没有关键字的SMTP", "220 Service ready\r\n", smtpReplies, "STARTTLS", "250 mx.example.com\r\n", "smtp"},
+	}
+	s := newTestScanner(nil, ScannerConfig{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := textStandIn(t, tt.banner, tt.replies, tt.upgrade, tt.inner)
+			banner := s.grabTCPBanner("127.0.0.1", port)
+			if banner.tls != TLSStartTLS || banner.protocol != tt.protocol {
+				t.Fatalf("tls/protocol = %q/%q，期望 starttls/%s", banner.tls, banner.protocol, tt.protocol)
+			}
+			if banner.response != tt.banner || banner.inner != tt.inner {
+				t.Errorf("response/inner = %q/%q", banner.response, banner.inner)
+			}
+			if banner.cert["tls_cert_subject"] == "" {
+				t.Errorf("缺少证书信息: %v", banner.cert)
+			}
+		})
+	}
+}
+
+// TestGrabTCPBannerStartTLSRefused 两种协议的升级都被拒绝时保留明文banner
+func TestGrabTCPBannerStartTLSRefused(t *testing.T) {
+	port := textStandIn(t, "220 Service ready\r\n", nil, "", "")
+	banner := newTestScanner(nil, ScannerConfig{}).grabTCPBanner("127.0.0.1", port)
+	if banner.overTLS() || banner.response != "220 Service ready\r\n" {
+		t.Errorf("banner = %+v", banner)
+	}
+}
@@ -9,7 +9,6 @@ import (
 	"nebulafinger/internal/matcher"
 	"net"
 	"net/url"
-	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -22,6 +21,7 @@ type ClusterInfo struct {
 	Name    string
 	Cluster cluster.ClusterExecute
 	Rarity  int
+	Tier    int // 在当前端口上的探测优先级
 }
 
 // quickTCPProbe 执行快速TCP探测
@@ -75,11 +75,11 @@ func (s *Scanner) quickTCPProbe(host string) ([]internal.FeatureKey, error) {
 			defer wg.Done()
 			defer func() { <-semaphore }() // 释放信号量
 
-			address := fmt.Sprintf("%s:%s", host, port)
+			address := net.JoinHostPort(host, port)
 			fmt.Printf("[TCP] 尝试连接 %s\n", address)
 
 			// 连接TCP服务
-			conn, err := net.DialTimeout("tcp", address, s.Config.Timeout)
+			conn, err := s.dial("tcp", address)
 			if err != nil {
 				fmt.Printf("[TCP] 连接失败 %s: %v\n", address, err)
 				resultChan <- probeResult{Port: port, Error: err}
@@ -89,10 +89,10 @@ func (s *Scanner) quickTCPProbe(host string) ([]internal.FeatureKey, error) {
 			fmt.Printf("[TCP] 连接成功 %s\n", address)
 
 			// 设置读取超时
-			conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+			conn.SetReadDeadline(time.Now().Add(s.readTimeout()))
 
 			// 发送HTTP GET请求（通用探测）
-			httpRequest := fmt.Sprintf("GET / HTTP/1.1\r\nHost: %s\r\nUser-Agent: Mozilla/5.0\r\nConnection: close\r\n\r\n", host)
+			httpRequest := fmt.Sprintf("GET / HTTP/1.1\r\nHost: %s\r\nUser-Agent: Mozilla/5.0\r\nConnection: close\r\n\r\n", hostHeader(host))
 			_, err = conn.Write([]byte(httpRequest))
 			if err != nil {
 				conn.Close()
@@ -207,6 +207,23 @@ func extractValidPorts(portsStr string) []string {
 	return result
 }
 
+// portInList 判断端口是否在逗号分隔的端口列表中，支持 1000-2000 形式的范围
+func portInList(portsStr string, port int) bool {
+	for _, part := range strings.Split(portsStr, ",") {
+		part = strings.TrimSpace(part)
+		start, end := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			start, end = part[:i], part[i+1:]
+		}
+		lo, err1 := strconv.Atoi(start)
+		hi, err2 := strconv.Atoi(end)
+		if err1 == nil && err2 == nil && lo <= port && port <= hi {
+			return true
+		}
+	}
+	return false
+}
+
 // 获取常见端口
 func getCommonPorts(webCluster cluster.ClusterType) []string {
 	portMap := make(map[string]bool)
@@ -250,8 +267,8 @@ func getCommonPorts(webCluster cluster.ClusterType) []string {
 	return ports
 }
 
-// preciseTCPMatch 执行精确TCP匹配
-func (s *Scanner) preciseTCPMatch(host string, candidates []string) ([]matcher.MatchResult, error) {
+// preciseTCPMatch 执行精确TCP匹配，同时返回全部端口的探测结果
+func (s *Scanner) preciseTCPMatch(host string, candidates []string) ([]matcher.MatchResult, []PortResult, error) {
 	var results []matcher.MatchResult
 
 	// 记录开始扫描
@@ -269,8 +286,10 @@ func (s *Scanner) preciseTCPMatch(host string, candidates []string) ([]matcher.M
 			targetPorts = append(targetPorts, targetPort)
 		}
 	} else {
-		// URI中没有指定端口，使用默认端口序列
-		if s.Config != nil && len(s.Config.DefaultTCPPorts) > 0 {
+		// URI中没有指定端口，优先使用 -p 指定的端口，其次使用默认端口序列
+		if s.Config != nil && len(s.Config.Ports) > 0 {
+			targetPorts = s.Config.Ports
+		} else if s.Config != nil && len(s.Config.DefaultTCPPorts) > 0 {
 			// 使用配置中的默认端口
 			targetPorts = s.Config.DefaultTCPPorts
 			//fmt.Printf("[TCP] 使用配置文件中的默认端口列表，共 %d 个端口\n", len(targetPorts))
@@ -278,91 +297,107 @@ func (s *Scanner) preciseTCPMatch(host string, candidates []string) ([]matcher.M
 			//fmt.Printf("[TCP] 未找到配置文件或配置为空，使用硬编码默认端口序列\n")
 			targetPorts = []uint16{21, 22, 25, 80, 443, 1521, 3306, 5432, 6379, 8080, 8443, 9200, 27017}
 		}
+		// 启用工控协议识别时，默认端口之外再探测工控协议的常用端口
+		if s.Config == nil || len(s.Config.Ports) == 0 {
+			targetPorts = appendPorts(targetPorts, s.moduleDefaultPorts(false))
+		}
 	}
 
-	// 对每个端口执行匹配，收集所有匹配结果
-	for _, port := range targetPorts {
-		//fmt.Printf("[TCP] 开始探测端口 %d\n", port)
-		portResults, found := s.matchTcpPortFingerprints(host, port, candidates)
-		if found {
-			//fmt.Printf("[TCP] 端口 %d 匹配成功，找到 %d 个结果\n", port, len(portResults))
-			results = append(results, portResults...)
-			// 继续探测其他端口
+	// 第一层：并行探测端口是否开放，只对接受连接的端口做指纹识别
+	portStates := s.scanPorts(hostnameOf(host), targetPorts)
+	var openPorts []uint16
+	for _, p := range portStates {
+		if p.State == PortOpen {
+			openPorts = append(openPorts, uint16(p.Port))
 		}
 	}
 
+	// 第二层：并行匹配开放端口的指纹，结果按端口顺序合并
+	portResults := make([][]matcher.MatchResult, len(openPorts))
+	parallel(len(openPorts), s.portConcurrency(), func(i int) {
+		//fmt.Printf("[TCP] 开始探测端口 %d\n", openPorts[i])
+		portResults[i], _ = s.matchTcpPortFingerprints(host, openPorts[i], candidates)
+	})
+	for _, r := range portResults {
+		results = append(results, r...)
+	}
+
 	// 去重结果
 	if len(results) > 0 {
 		//fmt.Printf("[TCP] 共完成 %d 个端口的探测，找到 %d 个匹配结果\n", len(targetPorts), len(results))
-		return UniqueResults(results), nil
+		return UniqueResults(results), portStates, nil
 	}
 
-	return results, nil
+	return results, portStates, nil
 }
 
 // matchPortFingerprints 对指定端口执行指纹匹配
 func (s *Scanner) matchTcpPortFingerprints(host string, port uint16, candidates []string) ([]matcher.MatchResult, bool) {
 	//fmt.Printf("[TCP] 尝试匹配端口 %d 的指纹\n", port)
 
-	// 1. 首先匹配TCPOther中的指纹
-	tcpOtherResults, otherMatched := s.matchTCPOther(host, port, candidates)
-	if otherMatched {
-		return tcpOtherResults, true
+	// 读取一次服务响应，按需处理隐式TLS和STARTTLS，TCPOther和TCPNull共用
+	banner := s.grabTCPBanner(hostnameOf(host), port)
+	results, matched := s.identifyTCPPort(host, port, banner, candidates)
+
+	// 5. 补充模块（如NTLM）的结果追加到端口的识别结果之后
+	if extra := s.matchExtraModules(host, port, banner); len(extra) > 0 {
+		return append(results, extra...), true
 	}
+	return results, matched
+}
+
+// identifyTCPPort 依次用协议模块、TCPOther、TCPNull和TCP探针识别端口上的服务，第一个命中的即为结果
+func (s *Scanner) identifyTCPPort(host string, port uint16, banner *tcpBanner, candidates []string) ([]matcher.MatchResult, bool) {
+	// 1. 首先执行该端口对应的协议模块，从协议握手中提取产品和版本
+	if moduleResults, moduleMatched := s.matchModules(host, port, banner); moduleMatched {
+		return moduleResults, true
+	}
+	if banner.response != "" {
+		// 2. 匹配TCPOther中的指纹
+		tcpOtherResults, otherMatched := s.matchTCPOther(host, port, banner, candidates)
+		if otherMatched {
+			return tcpOtherResults, true
+		}
 
-	// 2. 如果TCPOther没有匹配，尝试TCPNull
-	tcpNullResults, nullMatched := s.matchTCPNull(host, port, candidates)
-	if nullMatched {
-		return tcpNullResults, true
+		// 3. 如果TCPOther没有匹配，尝试TCPNull
+		tcpNullResults, nullMatched := s.matchTCPNull(host, port, banner, candidates)
+		if nullMatched {
+			return tcpNullResults, true
+		}
 	}
 
-	// 没有找到匹配
-	return nil, false
+	// 4. 最后用TCP探针匹配banner，并逐个发送探针数据匹配响应
+	return s.matchTCPProbes(host, port, banner)
 }
 
 // matchTCPOther 匹配TCPOther中的指纹
-func (s *Scanner) matchTCPOther(host string, port uint16, candidates []string) ([]matcher.MatchResult, bool) {
-	// 收集包含该端口的TCPOther指纹
+// 端口配置中该端口对应服务的指纹最先匹配，其次是声明了该端口的指纹，最后是其余指纹，同一优先级内按稀有度排序
+func (s *Scanner) matchTCPOther(host string, port uint16, banner *tcpBanner, candidates []string) ([]matcher.MatchResult, bool) {
 	var matchingClusters []ClusterInfo
-
-	// 遍历TCPOther，找出包含指定端口的指纹
 	for name, clusterExec := range s.WebCluster.TCPOther {
-		portStr := strconv.Itoa(int(port))
-		// 检查端口是否在Port字符串中
-		if clusterExec.Port != "" {
-			// 提取有效端口
-			validPorts := extractValidPorts(clusterExec.Port)
-			for _, p := range validPorts {
-				if p == portStr {
-					matchingClusters = append(matchingClusters, ClusterInfo{
-						Name:    name,
-						Cluster: clusterExec,
-						Rarity:  clusterExec.Rarity,
-					})
-					continue
-				}
-			}
-		}
+		matchingClusters = append(matchingClusters, ClusterInfo{
+			Name:    name,
+			Cluster: clusterExec,
+			Rarity:  clusterExec.Rarity,
+			Tier:    s.probeTier(port, clusterExec),
+		})
 	}
 
-	//fmt.Printf("[TCP] 找到 %d 个包含端口 %d 的TCPOther指纹\n", len(matchingClusters), port)
+	//fmt.Printf("[TCP] 端口 %d 共有 %d 个TCPOther指纹\n", port, len(matchingClusters))
 	if len(matchingClusters) == 0 {
-		//fmt.Printf("[TCP] 未找到匹配的TCPOther指纹\n\n")
 		return nil, false
 	}
 
-	// 按Rarity从小到大排序
-	sort.Slice(matchingClusters, func(i, j int) bool {
-		return matchingClusters[i].Rarity < matchingClusters[j].Rarity
-	})
+	// 按优先级和Rarity排序
+	sortByTier(matchingClusters)
 
 	// 优化：将整个matchingClusters传给probeTCPService函数
-	matched, results := s.probeTCPService(host, port, matchingClusters, candidates)
+	matched, results := s.probeTCPService(host, port, banner, matchingClusters, candidates)
 	return results, matched
 }
 
 // matchTCPNull 匹配TCPNull中的指纹
-func (s *Scanner) matchTCPNull(host string, port uint16, candidates []string) ([]matcher.MatchResult, bool) {
+func (s *Scanner) matchTCPNull(host string, port uint16, banner *tcpBanner, candidates []string) ([]matcher.MatchResult, bool) {
 	// 收集包含该端口的TCPNull指纹
 	var matchingClusters []ClusterInfo
 
@@ -373,87 +408,29 @@ func (s *Scanner) matchTCPNull(host string, port uint16, candidates []string) ([
 			Name:    name,
 			Cluster: clusterExec,
 			Rarity:  clusterExec.Rarity,
+			Tier:    s.probeTier(port, clusterExec),
 		})
 	}
 
-	// 按Rarity从小到大排序
-	sort.Slice(matchingClusters, func(i, j int) bool {
-		return matchingClusters[i].Rarity < matchingClusters[j].Rarity
-	})
+	// 按优先级和Rarity排序
+	sortByTier(matchingClusters)
 
 	// 优化：将整个matchingClusters传给probeTCPServiceNull函数
-	matched, results := s.probeTCPServiceNull(host, port, matchingClusters, candidates)
+	matched, results := s.probeTCPServiceNull(host, port, banner, matchingClusters, candidates)
 	return results, matched
 }
 
 // probeTCPService 探测单个TCP服务
-func (s *Scanner) probeTCPService(host string, port uint16, matchingClusters []ClusterInfo, candidates []string) (bool, []matcher.MatchResult) {
-	parts := strings.SplitN(host, "://", 2)
-	if len(parts) > 1 {
-		host = parts[1]
-	}
+func (s *Scanner) probeTCPService(host string, port uint16, banner *tcpBanner, matchingClusters []ClusterInfo, candidates []string) (bool, []matcher.MatchResult) {
 	//fmt.Printf("[TCP] 探测tcp other\n")
-	// 分离主机名和端口
-	hostParts := strings.SplitN(host, ":", 2)
-	hostname := hostParts[0]
-	address := fmt.Sprintf("%s:%d", hostname, port)
-	//fmt.Printf("[TCP] 连接到: %s\n", address)
-
-	// 连接到服务，使用较短的超时时间
-	dialer := &net.Dialer{
-		Timeout: s.Config.Timeout,
-	}
-	conn, err := dialer.Dial("tcp", address)
-	if err != nil {
-		//fmt.Printf("[TCP] 连接失败: %v\n", err)
-		return false, nil
-	}
-	defer conn.Close()
-
-	// 设置读写超时
-	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	// 分离主机名和端口，IPv6地址不带方括号
+	hostname := hostnameOf(host)
 
-	// 读取响应数据
-	buffer := make([]byte, 2048)
-	var banner strings.Builder
-
-	// 使用带超时的读取操作
-	readDone := make(chan bool, 1)
-	go func() {
-		// 读取响应
-		n, err := conn.Read(buffer)
-		if err != nil && err != io.EOF {
-			//fmt.Printf("[TCP] 读取响应失败: %v\n", err)
-			readDone <- false
-			return
-		}
-		if n > 0 {
-			banner.Write(buffer[:n])
-			//fmt.Printf("[TCP] 收到响应: %d 字节\n", n)
-		}
-		readDone <- true
-	}()
-
-	// 等待读取完成或超时
-	var readSuccess bool
-	select {
-	case readSuccess = <-readDone:
-		// 读取操作完成，根据结果处理
-	case <-time.After(3 * time.Second):
-		//fmt.Printf("[TCP] 读取操作超时，继续处理\n")
-		readSuccess = false
-	}
-
-	// 如果读取失败且没有任何数据，直接返回
-	if !readSuccess && banner.Len() == 0 {
-		return false, nil
-	}
-
-	// 创建TCP响应对象
+	// 创建TCP响应对象，响应由grabTCPBanner读取
 	tcpResp := &matcher.TCPResponse{
 		Host:     hostname,
 		Port:     strconv.Itoa(int(port)),
-		Response: banner.String(),
+		Response: banner.response,
 	}
 	/*
 			tcpResp.Response = `HTTP/1.0 404 Not Found
@@ -469,7 +446,13 @@ func (s *Scanner) probeTCPService(host string, port uint16, matchingClusters []C
 	for _, clusterInfo := range matchingClusters {
 		// 遍历集群中的每个操作符（指纹）
 		for _, fingerprint := range clusterInfo.Cluster.Operators {
+			// 声明了probe的指纹由协议模块匹配；声明了tls时，只匹配对应连接上得到的响应
+			if fingerprint.Probe != "" || !fingerprint.TLS.Allows(banner.overTLS()) {
+				continue
+			}
+
 			matched := false
+			evidence := ""
 
 			// 遍历所有Extractors进行匹配
 			for _, extractor := range fingerprint.Extractors {
@@ -480,6 +463,7 @@ func (s *Scanner) probeTCPService(host string, port uint16, matchingClusters []C
 					// 检查响应中是否包含这个关键字
 					if strings.Contains(tcpResp.Response, word) {
 						matched = true
+						evidence = word
 						//fmt.Printf("[TCP] 指纹 %s 的word匹配成功: %s\n", fingerprint.ID, word)
 						break
 					}
@@ -488,8 +472,8 @@ func (s *Scanner) probeTCPService(host string, port uint16, matchingClusters []C
 				// 检查regex类型提取器
 				if extractor.Type == "regex" && len(extractor.Regex) > 0 {
 					for _, regexStr := range extractor.Regex {
-						// 编译正则表达式
-						regex, err := regexp.Compile(regexStr)
+						// 编译正则表达式，已编译的正则在端口之间复用
+						regex, err := matcher.CompileRegex(regexStr)
 						if err != nil {
 							//fmt.Printf("[TCP] 正则表达式编译失败: %v\n", err)
 							continue
@@ -499,6 +483,7 @@ func (s *Scanner) probeTCPService(host string, port uint16, matchingClusters []C
 						tcpResp.Response = strings.TrimRight(tcpResp.Response, "\r")
 						if regex.MatchString(tcpResp.Response) {
 							matched = true
+							evidence = regexStr
 							break
 						}
 
@@ -556,23 +541,32 @@ func (s *Scanner) probeTCPService(host string, port uint16, matchingClusters []C
 					confidence = s.ConfidenceConfig.MinConfidence
 				}
 
+				metadata := fingerprint.Info.Metadata
 				result := matcher.MatchResult{
 					ID:         fingerprint.ID,
 					Name:       fingerprint.Info.Name,
 					Confidence: confidence, // 使用计算的置信度
 					Details:    make(map[string]string),
 					Tags:       []string{fingerprint.Info.Tags},
+					Metadata:   &metadata,
+					Evidence:   evidence,
 				}
 
-				// 添加主机和端口信息
+				// 添加主机和端口信息，并记录服务是否运行在常用端口上
 				result.Details["host"] = hostname
 				result.Details["port"] = tcpResp.Port
+				result.Details["port_match"] = s.portMatch(port, clusterInfo.Cluster.Port, fingerprint)
+
+				// 记录TLS方式、证书和TLS内的响应
+				for k, v := range banner.details() {
+					result.Details[k] = v
+				}
 
 				// 提取详细信息
 				for _, extractor := range fingerprint.Extractors {
 					if extractor.Type == "regex" && len(extractor.Regex) > 0 {
 						regexStr := extractor.Regex[0]
-						regex, err := regexp.Compile(regexStr)
+						regex, err := matcher.CompileRegex(regexStr)
 						if err == nil {
 							// 对每一行尝试提取详细信息
 							responseLines := strings.Split(tcpResp.Response, "\n")
@@ -597,7 +591,7 @@ func (s *Scanner) probeTCPService(host string, port uint16, matchingClusters []C
 					}
 				}
 
-				fmt.Printf("[TCP] 成功匹配TCPother指纹 ")
+				//fmt.Printf("[TCP] 成功匹配TCPother指纹 %s\n", fingerprint.ID)
 				return true, []matcher.MatchResult{result}
 			}
 		}
@@ -612,83 +606,30 @@ func (s *Scanner) probeTCPService(host string, port uint16, matchingClusters []C
 }
 
 // probeTCPServiceNull 探测TCP服务（专门处理name为null的TCP指纹）
-func (s *Scanner) probeTCPServiceNull(host string, port uint16, matchingClusters []ClusterInfo, candidates []string) (bool, []matcher.MatchResult) {
+func (s *Scanner) probeTCPServiceNull(host string, port uint16, banner *tcpBanner, matchingClusters []ClusterInfo, candidates []string) (bool, []matcher.MatchResult) {
 	//fmt.Printf("[TCP] 开始探测TCP Null服务，端口: %d\n", port)
 
-	// 移除协议前缀
-	parts := strings.SplitN(host, "://", 2)
-	if len(parts) > 1 {
-		host = parts[1]
-	}
-
-	// 分离主机名和端口
-	hostParts := strings.SplitN(host, ":", 2)
-	hostname := hostParts[0]
-	address := fmt.Sprintf("%s:%d", hostname, port)
-	//fmt.Printf("[TCP] 连接到: %s\n", address)
-
-	// 连接到服务，使用较短的超时时间
-	dialer := &net.Dialer{
-		Timeout: s.Config.Timeout,
-	}
-	conn, err := dialer.Dial("tcp", address)
-	if err != nil {
-		//fmt.Printf("[TCP] 连接失败: %v\n", err)
-		return false, nil
-	}
-	defer conn.Close()
-
-	// 设置读写超时
-	conn.SetDeadline(time.Now().Add(3 * time.Second))
-
-	// 读取响应数据
-	buffer := make([]byte, 2048)
-	var banner strings.Builder
-
-	// 使用带超时的读取操作
-	readDone := make(chan bool, 1)
-	go func() {
-		// 读取响应
-		n, err := conn.Read(buffer)
-		if err != nil && err != io.EOF {
-			//fmt.Printf("[TCP] 读取响应失败: %v\n", err)
-			readDone <- false
-			return
-		}
-		if n > 0 {
-			banner.Write(buffer[:n])
-			//fmt.Printf("[TCP] 收到响应: %d 字节\n", n)
-		}
-		readDone <- true
-	}()
-
-	// 等待读取完成或超时
-	var readSuccess bool
-	select {
-	case readSuccess = <-readDone:
-		// 读取操作完成，根据结果处理
-	case <-time.After(3 * time.Second):
-		//fmt.Printf("[TCP] 读取操作超时，继续处理\n")
-		readSuccess = false
-	}
-
-	// 如果读取失败且没有任何数据，直接返回
-	if !readSuccess && banner.Len() == 0 {
-		return false, nil
-	}
+	// 分离主机名和端口，IPv6地址不带方括号
+	hostname := hostnameOf(host)
 
-	// 创建TCP响应对象
+	// 创建TCP响应对象，响应由grabTCPBanner读取
 	tcpResp := &matcher.TCPResponse{
 		Host:     hostname,
 		Port:     strconv.Itoa(int(port)),
-		Response: banner.String(),
+		Response: banner.response,
 	}
 
 	// 遍历所有集群进行匹配
 	for _, clusterInfo := range matchingClusters {
 		// 直接匹配每个指纹
 		for _, fingerprint := range clusterInfo.Cluster.Operators {
+			// 声明了probe的指纹由协议模块匹配；声明了tls时，只匹配对应连接上得到的响应
+			if fingerprint.Probe != "" || !fingerprint.TLS.Allows(banner.overTLS()) {
+				continue
+			}
+
 			matched := false
+			evidence := ""
 
 			// 遍历所有Extractors进行匹配
 			for _, extractor := range fingerprint.Extractors {
@@ -699,6 +640,7 @@ func (s *Scanner) probeTCPServiceNull(host string, port uint16, matchingClusters
 					// 检查响应中是否包含这个关键字
 					if strings.Contains(tcpResp.Response, word) {
 						matched = true
+						evidence = word
 						//fmt.Printf("[TCP] 指纹 %s 的word匹配成功: %s\n", fingerprint.ID, word)
 						break
 					}
@@ -707,8 +649,8 @@ func (s *Scanner) probeTCPServiceNull(host string, port uint16, matchingClusters
 				// 检查regex类型提取器
 				if extractor.Type == "regex" && len(extractor.Regex) > 0 {
 					for _, regexStr := range extractor.Regex {
-						// 编译正则表达式
-						regex, err := regexp.Compile(regexStr)
+						// 编译正则表达式，已编译的正则在端口之间复用
+						regex, err := matcher.CompileRegex(regexStr)
 						if err != nil {
 							//fmt.Printf("[TCP] 正则表达式编译失败: %v\n", err)
 							continue
@@ -719,6 +661,7 @@ func (s *Scanner) probeTCPServiceNull(host string, port uint16, matchingClusters
 
 						if regex.MatchString(tcpResp.Response) {
 							matched = true
+							evidence = regexStr
 							break
 						}
 
@@ -776,23 +719,32 @@ func (s *Scanner) probeTCPServiceNull(host string, port uint16, matchingClusters
 					confidence = s.ConfidenceConfig.MinConfidence
 				}
 
+				metadata := fingerprint.Info.Metadata
 				result := matcher.MatchResult{
 					ID:         fingerprint.ID,
 					Name:       fingerprint.Info.Name,
 					Confidence: confidence, // 使用计算的置信度
 					Details:    make(map[string]string),
 					Tags:       []string{fingerprint.Info.Tags},
+					Metadata:   &metadata,
+					Evidence:   evidence,
 				}
 
-				// 添加主机和端口信息
+				// 添加主机和端口信息，并记录服务是否运行在常用端口上
 				result.Details["host"] = hostname
 				result.Details["port"] = tcpResp.Port
+				result.Details["port_match"] = s.portMatch(port, clusterInfo.Cluster.Port, fingerprint)
+
+				// 记录TLS方式、证书和TLS内的响应
+				for k, v := range banner.details() {
+					result.Details[k] = v
+				}
 
 				// 提取详细信息
 				for _, extractor := range fingerprint.Extractors {
 					if extractor.Type == "regex" && len(extractor.Regex) > 0 {
 						regexStr := extractor.Regex[0]
-						regex, err := regexp.Compile(regexStr)
+						regex, err := matcher.CompileRegex(regexStr)
 						if err == nil {
 							// 对每一行尝试提取详细信息
 							responseLines := strings.Split(tcpResp.Response, "\n")
@@ -0,0 +1,353 @@
+package scanner
+
+import (
+	"nebulafinger/internal"
+	"nebulafinger/internal/cluster"
+	"nebulafinger/internal/matcher"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tcpProbeIntensity 没有对应该端口的TCP探针只发送稀有度不超过该值的，与nmap默认的版本探测强度一致
+const tcpProbeIntensity = 7
+
+// probeReadGap 收到数据后继续等待后续数据的时间
+const probeReadGap = 300 * time.Millisecond
+
+// tcpProbeFingerprint TCP探针中的一个指纹
+type tcpProbeFingerprint struct {
+	fingerprint cluster.ClusteredFingerprint // 所属指纹
+	request     internal.TCPRequest          // 请求定义
+}
+
+// tcpProbe 同名且发送相同数据的TCP请求合并成的探针
+type tcpProbe struct {
+	name         string                // 请求名，fallback按名称（不区分大小写）引用
+	payload      []byte                // 发送的数据，为空时匹配端口上已读取的banner
+	wait         time.Duration         // 等待响应的最长时间，为0时使用读取超时
+	ports        []string              // 各请求声明的端口
+	rarity       int                   // 稀有度，取第一个指纹的稀有度
+	fallback     []string              // 响应未命中时继续匹配的探针名
+	fingerprints []tcpProbeFingerprint // 探针的指纹，按指纹库中的顺序匹配
+}
+
+// buildTCPProbes 从服务指纹中收集作为TCP探针发送的请求，同名且数据相同的请求合并为一个探针
+func buildTCPProbes(fingerprints []internal.Fingerprint) []*tcpProbe {
+	var probes []*tcpProbe
+	index := make(map[string]*tcpProbe)
+	for _, fp := range fingerprints {
+		for _, req := range fp.TCP {
+			if !req.Probed() {
+				continue
+			}
+			name := strings.TrimSpace(req.Name)
+			payload := req.Payload()
+			key := strings.ToLower(name) + "\x00" + payload
+			probe := index[key]
+			if probe == nil {
+				probe = &tcpProbe{name: name, payload: []byte(payload), rarity: fp.Info.Metadata.Rarity}
+				index[key] = probe
+				probes = append(probes, probe)
+			}
+			for _, input := range req.Inputs {
+				if input.Wait > 0 {
+					probe.wait = time.Duration(input.Wait) * time.Millisecond
+				}
+			}
+			if req.Port != "" && !containsString(probe.ports, req.Port) {
+				probe.ports = append(probe.ports, req.Port)
+			}
+			for _, fallback := range req.Fallback {
+				if fallback = strings.TrimSpace(fallback); fallback != "" && !containsString(probe.fallback, fallback) {
+					probe.fallback = append(probe.fallback, fallback)
+				}
+			}
+			probe.fingerprints = append(probe.fingerprints, tcpProbeFingerprint{
+				fingerprint: cluster.ClusteredFingerprint{ID: fp.ID, Info: fp.Info, Matchers: req.Matchers, Extractors: req.Extractors, TLS: req.TLS},
+				request:     req,
+			})
+		}
+	}
+	return probes
+}
+
+// declares 判断探针是否声明了该端口
+func (p *tcpProbe) declares(port uint16) bool {
+	for _, ports := range p.ports {
+		if portInList(ports, int(port)) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasService 判断探针是否有该服务的指纹
+func (p *tcpProbe) hasService(service string) bool {
+	for _, pf := range p.fingerprints {
+		if strings.EqualFold(pf.fingerprint.Info.Name, service) {
+			return true
+		}
+	}
+	return false
+}
+
+// bannerTCPProbes 返回不发送数据、匹配端口banner的探针
+func (s *Scanner) bannerTCPProbes() []*tcpProbe {
+	var probes []*tcpProbe
+	for _, probe := range s.tcpProbes {
+		if len(probe.payload) == 0 {
+			probes = append(probes, probe)
+		}
+	}
+	return probes
+}
+
+// tcpProbesForPort 返回在端口上发送数据的探针：端口配置中该端口对应服务的探针最先发送，其次是声明了该端口的探针，
+// 最后是稀有度不超过tcpProbeIntensity的其他探针，同一优先级内按稀有度从小到大
+func (s *Scanner) tcpProbesForPort(port uint16) []*tcpProbe {
+	type tieredProbe struct {
+		probe *tcpProbe
+		tier  int
+	}
+	var tiered []tieredProbe
+	for _, probe := range s.tcpProbes {
+		if len(probe.payload) == 0 {
+			continue
+		}
+		tier := tierGeneric
+		for _, pf := range probe.fingerprints {
+			if s.isServicePort(port, pf.fingerprint) {
+				tier = tierServicePort
+				break
+			}
+		}
+		if tier == tierGeneric && probe.declares(port) {
+			tier = tierFingerprintPort
+		}
+		if tier == tierGeneric && probe.rarity > tcpProbeIntensity {
+			continue
+		}
+		tiered = append(tiered, tieredProbe{probe, tier})
+	}
+	sort.SliceStable(tiered, func(i, j int) bool {
+		if tiered[i].tier != tiered[j].tier {
+			return tiered[i].tier < tiered[j].tier
+		}
+		return tiered[i].probe.rarity < tiered[j].probe.rarity
+	})
+
+	probes := make([]*tcpProbe, len(tiered))
+	for i, t := range tiered {
+		probes[i] = t.probe
+	}
+	return probes
+}
+
+// fallbackProbes 返回探针fallback中引用的探针
+func (s *Scanner) fallbackProbes(probe *tcpProbe) []*tcpProbe {
+	var probes []*tcpProbe
+	for _, name := range probe.fallback {
+		for _, p := range s.tcpProbes {
+			if strings.EqualFold(p.name, name) && p != probe {
+				probes = append(probes, p)
+			}
+		}
+	}
+	return probes
+}
+
+// matchTCPProbes 用TCP探针识别端口上的服务：先用不发送数据的探针匹配已读取的banner，再依次发送各探针，
+// 响应先匹配探针自身的指纹，再匹配fallback中的探针和不发送数据的探针的指纹。
+// 命中softmatch的指纹时记录服务类型，之后只发送有该服务指纹的探针、只接受该服务的结果，都没有命中时返回softmatch的结果
+func (s *Scanner) matchTCPProbes(host string, port uint16, banner *tcpBanner) ([]matcher.MatchResult, bool) {
+	if len(s.tcpProbes) == 0 {
+		return nil, false
+	}
+	hostname := hostnameOf(host)
+	bannerProbes := s.bannerTCPProbes()
+
+	var soft *matcher.MatchResult
+	service := ""
+	if banner.response != "" {
+		if result, isSoft, ok := s.matchProbeResponse(hostname, port, banner, "null", bannerProbes, service, []byte(banner.response)); ok {
+			if !isSoft {
+				return []matcher.MatchResult{result}, true
+			}
+			soft, service = &result, result.Name
+		}
+	}
+
+	for _, probe := range s.tcpProbesForPort(port) {
+		if service != "" && !probe.hasService(service) {
+			continue
+		}
+		response, err := s.sendTCPProbe(hostname, port, banner, probe)
+		if err != nil {
+			// 端口已无法连接，不再发送其他探针
+			break
+		}
+		if len(response) == 0 {
+			continue
+		}
+
+		probes := append([]*tcpProbe{probe}, s.fallbackProbes(probe)...)
+		probes = append(probes, bannerProbes...)
+		if result, isSoft, ok := s.matchProbeResponse(hostname, port, banner, probe.name, probes, service, response); ok {
+			if !isSoft {
+				return []matcher.MatchResult{result}, true
+			}
+			if soft == nil {
+				soft, service = &result, result.Name
+			}
+		}
+	}
+
+	if soft != nil {
+		return []matcher.MatchResult{*soft}, true
+	}
+	return nil, false
+}
+
+// sendTCPProbe 建立新连接发送探针数据并读取响应；端口是隐式TLS时在TLS连接上发送。连接失败时返回错误
+func (s *Scanner) sendTCPProbe(hostname string, port uint16, banner *tcpBanner, probe *tcpProbe) ([]byte, error) {
+	conn, err := s.moduleDial(moduleTarget{hostname: hostname, port: port, banner: banner})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	wait := s.readTimeout()
+	if probe.wait > 0 && probe.wait < wait {
+		wait = probe.wait
+	}
+	deadline := time.Now().Add(wait)
+	conn.SetDeadline(deadline)
+	if _, err := conn.Write(probe.payload); err != nil {
+		return nil, nil
+	}
+	return readProbeReply(conn, deadline), nil
+}
+
+// readProbeReply 读取响应，直到连接关闭、到达deadline或达到读取上限；收到数据后最多再等待probeReadGap
+func readProbeReply(conn net.Conn, deadline time.Time) []byte {
+	var reply []byte
+	buffer := make([]byte, bannerLimit)
+	for len(reply) < moduleReplyLimit {
+		n, err := conn.Read(buffer)
+		reply = append(reply, buffer[:n]...)
+		if err != nil {
+			break
+		}
+		if gap := time.Now().Add(probeReadGap); n > 0 && gap.Before(deadline) {
+			deadline = gap
+			conn.SetReadDeadline(deadline)
+		}
+	}
+	return reply
+}
+
+// matchProbeResponse 依次用各探针的指纹匹配响应，service不为空时只接受该服务的指纹，返回结果和命中的是否为softmatch
+// 响应按字节（Latin-1）转换为字符串后匹配，正则中的\xNN对应单个字节
+func (s *Scanner) matchProbeResponse(hostname string, port uint16, banner *tcpBanner, sent string, probes []*tcpProbe, service string, response []byte) (matcher.MatchResult, bool, bool) {
+	resp := &matcher.TCPResponse{
+		Host:     hostname,
+		Port:     strconv.Itoa(int(port)),
+		Response: latin1(response),
+		Raw:      response,
+	}
+
+	for _, probe := range probes {
+		for _, pf := range probe.fingerprints {
+			if service != "" && !strings.EqualFold(pf.fingerprint.Info.Name, service) {
+				continue
+			}
+			if !pf.request.TLS.Allows(banner.overTLS()) {
+				continue
+			}
+			hit, ok := probeFingerprintHit(pf, resp)
+			if !ok {
+				continue
+			}
+
+			fingerprint := pf.fingerprint
+			metadata := fingerprint.Info.Metadata
+			result := matcher.MatchResult{
+				ID:         fingerprint.ID,
+				Name:       fingerprint.Info.Name,
+				Confidence: internal.CalculateMatcherConfidence(hit, resp.Response, nil, s.ConfidenceConfig),
+				Details:    make(map[string]string),
+				Tags:       []string{fingerprint.Info.Tags},
+				Metadata:   &metadata,
+				Evidence:   matcherEvidence(hit),
+			}
+
+			// 添加主机、端口和发送的探针，并记录服务是否运行在常用端口上
+			result.Details["host"] = hostname
+			result.Details["port"] = resp.Port
+			result.Details["probe"] = sent
+			result.Details["port_match"] = s.portMatch(port, pf.request.Port, fingerprint)
+			if pf.request.Softmatch {
+				result.Details["softmatch"] = "true"
+			}
+			for k, v := range banner.details() {
+				result.Details[k] = v
+			}
+
+			// 提取详细信息，模板提取出的产品和版本补充到元数据
+			for _, extractor := range pf.request.Extractors {
+				value := matcher.ExtractTCP(extractor, resp)
+				if extractor.Type == "word" {
+					value = extractValue(extractor, resp.Response)
+				}
+				if value != "" {
+					result.Details[extractor.Name] = value
+				}
+			}
+			fillMetadata(result.Metadata, result.Details)
+			return result, pf.request.Softmatch, true
+		}
+	}
+	return matcher.MatchResult{}, false, false
+}
+
+// probeFingerprintHit 判断指纹是否命中响应，返回命中的匹配器：
+// 指纹有匹配器时任一匹配器命中即可，只有提取器时至少一个提取器命中
+func probeFingerprintHit(pf tcpProbeFingerprint, resp *matcher.TCPResponse) (internal.Matchers, bool) {
+	if len(pf.request.Matchers) > 0 {
+		for _, m := range pf.request.Matchers {
+			if matcher.MatchTCP(m, resp) {
+				return m, true
+			}
+		}
+		return internal.Matchers{}, false
+	}
+	for _, extractor := range pf.request.Extractors {
+		if extractValue(extractor, resp.Response) != "" {
+			return internal.Matchers{Type: extractor.Type, Regex: extractor.Regex}, true
+		}
+	}
+	return internal.Matchers{}, false
+}
+
+// fillMetadata 指纹没有给出产品或版本时使用提取出的product和version
+func fillMetadata(metadata *internal.Metadata, details map[string]string) {
+	if metadata.Product == "" {
+		metadata.Product = details[DetailProduct]
+	}
+	if metadata.Version == "" {
+		metadata.Version = details[DetailVersion]
+	}
+}
+
+// containsString 判断列表中是否包含s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package scanner
+
+import (
+	"bufio"
+	"fmt"
+	"nebulafinger/internal/matcher"
+	"nebulafinger/internal/nmap"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// nmapTestProbes 与nmap-service-probes中FTP、HTTP规则写法一致的小型探针库
+const nmapTestProbes = `Probe TCP NULL q||
+totalwaitms 6000
+match ftp m/^220 ProFTPD (\d\S+) Server \(([^)]+)\)/ p/ProFTPD/ v/$1/ i/$2/ cpe:/a:proftpd:proftpd:$1/
+softmatch ftp m/^220[- ]/
+
+Probe TCP GetRequest q|GET / HTTP/1.0\r\n\r\n|
+rarity 1
+ports 80,8080
+fallback NULL
+match http m|^HTTP/1\.[01] \d\d\d.*\r\nServer: nginx/([\d.]+)\r\n|s p/nginx/ v/$1/ cpe:/a:f5:nginx:$1/
+`
+
+// newNmapTestScanner 创建使用nmapTestProbes的扫描器
+func newNmapTestScanner(t *testing.T) *Scanner {
+	t.Helper()
+	fingerprints, warnings, err := nmap.ParseServiceProbes(strings.NewReader(nmapTestProbes))
+	if err != nil || len(warnings) > 0 {
+		t.Fatalf("转换探针库失败: %v %v", err, warnings)
+	}
+	return newTestScanner(fingerprints, ScannerConfig{Timeout: 300 * time.Millisecond})
+}
+
+// checkDetails 检查唯一的识别结果及其详情
+func checkDetails(t *testing.T, results []matcher.MatchResult, ok bool, want map[string]string) {
+	t.Helper()
+	if !ok || len(results) != 1 {
+		t.Fatalf("结果数 = %d，期望 1", len(results))
+	}
+	for k, v := range want {
+		if results[0].Details[k] != v {
+			t.Errorf("%s = %q，期望 %q", k, results[0].Details[k], v)
+		}
+	}
+}
+
+func TestNmapProbeFTPBanner(t *testing.T) {
+	port := tcpStandIn(t, func(conn net.Conn) {
+		fmt.Fprint(conn, "220 ProFTPD 1.3.8b Server (Debian) [::ffff:127.0.0.1]\r\n")
+		time.Sleep(time.Second)
+	})
+	s := newNmapTestScanner(t)
+
+	results, ok := s.matchTcpPortFingerprints("127.0.0.1", port, nil)
+	checkDetails(t, results, ok, map[string]string{
+		"product": "ProFTPD",
+		"version": "1.3.8b",
+		"info":    "Debian",
+		"cpe":     "cpe:/a:proftpd:proftpd:1.3.8b",
+		"probe":   "null",
+	})
+	if m := results[0].Metadata; m.Product != "ProFTPD" || m.Version != "1.3.8b" {
+		t.Errorf("元数据 = %s %s", m.Product, m.Version)
+	}
+}
+
+func TestNmapProbeHTTP(t *testing.T) {
+	port := httpStandIn(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "nginx/1.24.0")
+		fmt.Fprint(w, "<html></html>")
+	}))
+	s := newNmapTestScanner(t)
+
+	results, ok := s.matchTcpPortFingerprints("127.0.0.1", port, nil)
+	checkDetails(t, results, ok, map[string]string{
+		"product": "nginx",
+		"version": "1.24.0",
+		"cpe":     "cpe:/a:f5:nginx:1.24.0",
+		"probe":   "GetRequest",
+	})
+}
+
+// TestNmapProbeFallback 收到数据后才发送banner的服务，GetRequest的响应按fallback匹配NULL探针的规则
+func TestNmapProbeFallback(t *testing.T) {
+	port := tcpStandIn(t, func(conn net.Conn) {
+		if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil {
+			return
+		}
+		fmt.Fprint(conn, "220 ProFTPD 1.3.5 Server (ProFTPD Default Installation)\r\n")
+	})
+	s := newNmapTestScanner(t)
+
+	results, ok := s.matchTcpPortFingerprints("127.0.0.1", port, nil)
+	checkDetails(t, results, ok, map[string]string{
+		"version": "1.3.5",
+		"info":    "ProFTPD Default Installation",
+		"probe":   "GetRequest",
+	})
+}
+
+// TestNmapProbeSoftmatch 只命中softmatch时返回服务类型，不带产品和版本
+func TestNmapProbeSoftmatch(t *testing.T) {
+	port := tcpStandIn(t, func(conn net.Conn) {
+		fmt.Fprint(conn, "220 Welcome to the file server\r\n")
+		time.Sleep(time.Second)
+	})
+	s := newNmapTestScanner(t)
+
+	results, ok := s.matchTcpPortFingerprints("127.0.0.1", port, nil)
+	checkDetails(t, results, ok, map[string]string{"softmatch": "true"})
+	if results[0].Name != "ftp" || results[0].Details["version"] != "" {
+		t.Errorf("结果 = %s %v", results[0].Name, results[0].Details)
+	}
+}
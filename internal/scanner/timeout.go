@@ -0,0 +1,177 @@
+package scanner
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 自适应超时的默认边界
+const (
+	defaultMinTimeout = 300 * time.Millisecond
+	defaultMaxTimeout = 10 * time.Second
+)
+
+// readTimeoutFactor 读取超时相对连接超时的倍数，为服务端生成响应留出时间
+const readTimeoutFactor = 4
+
+// TimeoutStats 单个地址的RTT估计和超时统计
+type TimeoutStats struct {
+	IP             string        `json:"ip,omitempty"`    // 连接的IP地址
+	Samples        int           `json:"samples"`         // RTT样本数（成功或被拒绝的连接）
+	Timeouts       int           `json:"timeouts"`        // 连接超时次数
+	SRTT           time.Duration `json:"srtt"`            // 平滑RTT
+	RTTVar         time.Duration `json:"rttvar"`          // RTT偏差
+	MinRTT         time.Duration `json:"min_rtt"`         // 最小RTT
+	MaxRTT         time.Duration `json:"max_rtt"`         // 最大RTT
+	ConnectTimeout time.Duration `json:"connect_timeout"` // 扫描结束时的连接超时
+	ReadTimeout    time.Duration `json:"read_timeout"`    // 扫描结束时的读取超时
+}
+
+// rttEstimator 按RFC 6298估计单个主机的RTT，由连接耗时推导连接和读取超时
+// 没有样本时使用初始超时，所有超时都限制在[min, max]之内
+type rttEstimator struct {
+	mu       sync.Mutex
+	initial  time.Duration
+	min      time.Duration
+	max      time.Duration
+	samples  int
+	timeouts int
+	srtt     time.Duration
+	rttvar   time.Duration
+	minRTT   time.Duration
+	maxRTT   time.Duration
+}
+
+// newRTTEstimator 根据扫描配置创建RTT估计器，未启用自适应超时时返回nil
+func newRTTEstimator(config *ScannerConfig) *rttEstimator {
+	if config == nil || !config.AdaptiveTimeout {
+		return nil
+	}
+	e := &rttEstimator{
+		initial: config.Timeout,
+		min:     config.MinTimeout,
+		max:     config.MaxTimeout,
+	}
+	if e.min <= 0 {
+		e.min = defaultMinTimeout
+	}
+	if e.max <= 0 {
+		e.max = defaultMaxTimeout
+	}
+	if e.max < e.min {
+		e.max = e.min
+	}
+	if e.initial <= 0 {
+		e.initial = e.max
+	}
+	return e
+}
+
+// observe 记录一次连接的耗时：成功和被拒绝的连接都是有效的RTT样本，超时只计数
+func (e *rttEstimator) observe(rtt time.Duration, err error) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var netErr net.Error
+	switch {
+	case err == nil, errors.Is(err, syscall.ECONNREFUSED):
+	case errors.As(err, &netErr) && netErr.Timeout():
+		e.timeouts++
+		return
+	default:
+		return
+	}
+
+	if e.samples == 0 {
+		e.srtt = rtt
+		e.rttvar = rtt / 2
+		e.minRTT = rtt
+		e.maxRTT = rtt
+	} else {
+		delta := e.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		e.rttvar = (3*e.rttvar + delta) / 4
+		e.srtt = (7*e.srtt + rtt) / 8
+		if rtt < e.minRTT {
+			e.minRTT = rtt
+		}
+		if rtt > e.maxRTT {
+			e.maxRTT = rtt
+		}
+	}
+	e.samples++
+}
+
+// connectTimeout 返回当前的连接超时：SRTT + 4*RTTVAR，没有样本时为初始超时
+func (e *rttEstimator) connectTimeout() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.samples == 0 {
+		return e.clamp(e.initial)
+	}
+	return e.clamp(e.srtt + 4*e.rttvar)
+}
+
+// readTimeout 返回当前的读取超时，为连接超时的readTimeoutFactor倍
+func (e *rttEstimator) readTimeout() time.Duration {
+	timeout := e.connectTimeout()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.samples == 0 {
+		return timeout
+	}
+	return e.clamp(timeout * readTimeoutFactor)
+}
+
+// clamp 将超时限制在[min, max]之内
+func (e *rttEstimator) clamp(d time.Duration) time.Duration {
+	if d < e.min {
+		return e.min
+	}
+	if d > e.max {
+		return e.max
+	}
+	return d
+}
+
+// stats 返回当前的统计信息
+func (e *rttEstimator) stats(ip string) TimeoutStats {
+	connect, read := e.connectTimeout(), e.readTimeout()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return TimeoutStats{
+		IP:             ip,
+		Samples:        e.samples,
+		Timeouts:       e.timeouts,
+		SRTT:           e.srtt,
+		RTTVar:         e.rttvar,
+		MinRTT:         e.minRTT,
+		MaxRTT:         e.maxRTT,
+		ConnectTimeout: connect,
+		ReadTimeout:    read,
+	}
+}
+
+// connectTimeout 返回连接目标的超时，未启用自适应超时时为配置的超时
+func (s *Scanner) connectTimeout() time.Duration {
+	if s.rtt == nil {
+		return s.Config.Timeout
+	}
+	return s.rtt.connectTimeout()
+}
+
+// readTimeout 返回读取目标响应的超时，未启用自适应超时时为配置的超时
+func (s *Scanner) readTimeout() time.Duration {
+	if s.rtt == nil {
+		return s.Config.Timeout
+	}
+	return s.rtt.readTimeout()
+}
@@ -0,0 +1,105 @@
+package scanner
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// rttSample 一次连接的耗时和结果
+type rttSample struct {
+	rtt time.Duration
+	err error
+}
+
+func TestRTTEstimator(t *testing.T) {
+	const ms = time.Millisecond
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	timedOut := &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}
+	unreachable := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.EHOSTUNREACH)}
+	repeat := func(n int, sample rttSample) []rttSample {
+		samples := make([]rttSample, n)
+		for i := range samples {
+			samples[i] = sample
+		}
+		return samples
+	}
+
+	tests := []struct {
+		name             string
+		config           ScannerConfig
+		samples          []rttSample
+		wantSamples      int
+		wantTimeouts     int
+		wantSRTT         time.Duration
+		wantConnect      time.Duration
+		wantRead         time.Duration
+		connectTolerance time.Duration
+	}{
+		{"没有样本时使用初始超时", ScannerConfig{Timeout: 2 * time.Second}, nil, 0, 0, 0, 2 * time.Second, 2 * time.Second, 0},
+		{"初始超时超过上限", ScannerConfig{Timeout: 30 * time.Second, MaxTimeout: 5 * time.Second}, nil, 0, 0, 0, 5 * time.Second, 5 * time.Second, 0},
+		// 第一个样本：SRTT=R，RTTVAR=R/2，超时为 R+4*R/2 = 3R
+		{"第一个样本", ScannerConfig{Timeout: 2 * time.Second, MinTimeout: ms}, []rttSample{{10 * ms, nil}}, 1, 0, 10 * ms, 30 * ms, 120 * ms, 0},
+		{"被拒绝的连接也是样本", ScannerConfig{Timeout: 2 * time.Second, MinTimeout: ms}, []rttSample{{10 * ms, refused}}, 1, 0, 10 * ms, 30 * ms, 120 * ms, 0},
+		// 第二个样本：RTTVAR=(3*5+|10-30|)/4=8.75，SRTT=(7*10+30)/8=12.5
+		{"第二个样本", ScannerConfig{Timeout: 2 * time.Second, MinTimeout: ms}, []rttSample{{10 * ms, nil}, {30 * ms, nil}}, 2, 0, 12500 * time.Microsecond, 47500 * time.Microsecond, 190 * ms, 0},
+		// 稳定的RTT使RTTVAR趋近于0，超时收敛到RTT
+		{"收敛", ScannerConfig{Timeout: 2 * time.Second, MinTimeout: ms}, repeat(60, rttSample{40 * ms, nil}), 60, 0, 40 * ms, 40 * ms, 160 * ms, ms},
+		{"下限", ScannerConfig{Timeout: 2 * time.Second, MinTimeout: 300 * ms}, repeat(10, rttSample{ms, nil}), 10, 0, ms, 300 * ms, 1200 * ms, 0},
+		{"上限", ScannerConfig{Timeout: 2 * time.Second, MaxTimeout: 10 * time.Second}, []rttSample{{5 * time.Second, nil}}, 1, 0, 5 * time.Second, 10 * time.Second, 10 * time.Second, 0},
+		// 超时只计数，不影响RTT估计；其他错误（如不可达）既不是样本也不计为超时
+		{"只有超时", ScannerConfig{Timeout: 2 * time.Second}, repeat(3, rttSample{2 * time.Second, timedOut}), 0, 3, 0, 2 * time.Second, 2 * time.Second, 0},
+		{"超时和样本", ScannerConfig{Timeout: 2 * time.Second, MinTimeout: ms}, []rttSample{{2 * time.Second, timedOut}, {10 * ms, nil}, {2 * time.Second, timedOut}}, 1, 2, 10 * ms, 30 * ms, 120 * ms, 0},
+		{"不可达", ScannerConfig{Timeout: 2 * time.Second}, []rttSample{{ms, unreachable}}, 0, 0, 0, 2 * time.Second, 2 * time.Second, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.AdaptiveTimeout = true
+			e := newRTTEstimator(&tt.config)
+			for _, sample := range tt.samples {
+				e.observe(sample.rtt, sample.err)
+			}
+			stats := e.stats("192.0.2.1")
+			if stats.Samples != tt.wantSamples || stats.Timeouts != tt.wantTimeouts {
+				t.Errorf("样本/超时 = %d/%d，期望 %d/%d", stats.Samples, stats.Timeouts, tt.wantSamples, tt.wantTimeouts)
+			}
+			if stats.SRTT != tt.wantSRTT {
+				t.Errorf("SRTT = %v，期望 %v", stats.SRTT, tt.wantSRTT)
+			}
+			if diff := stats.ConnectTimeout - tt.wantConnect; diff < 0 || diff > tt.connectTolerance {
+				t.Errorf("连接超时 = %v，期望 %v", stats.ConnectTimeout, tt.wantConnect)
+			}
+			if diff := stats.ReadTimeout - tt.wantRead; diff < 0 || diff > 4*tt.connectTolerance {
+				t.Errorf("读取超时 = %v，期望 %v", stats.ReadTimeout, tt.wantRead)
+			}
+		})
+	}
+}
+
+func TestNewRTTEstimator(t *testing.T) {
+	if e := newRTTEstimator(&ScannerConfig{Timeout: time.Second}); e != nil {
+		t.Errorf("未启用自适应超时时应返回nil")
+	}
+	// nil估计器忽略样本
+	var e *rttEstimator
+	e.observe(time.Millisecond, nil)
+
+	e = newRTTEstimator(&ScannerConfig{AdaptiveTimeout: true})
+	if e.min != defaultMinTimeout || e.max != defaultMaxTimeout || e.initial != defaultMaxTimeout {
+		t.Errorf("默认边界 = %v/%v/%v", e.min, e.max, e.initial)
+	}
+	e = newRTTEstimator(&ScannerConfig{AdaptiveTimeout: true, Timeout: time.Second, MinTimeout: 3 * time.Second, MaxTimeout: time.Second})
+	if e.max != e.min || e.connectTimeout() != 3*time.Second {
+		t.Errorf("上限小于下限时取下限: max=%v connect=%v", e.max, e.connectTimeout())
+	}
+}
+
+// TestScannerTimeoutsWithoutEstimator 未启用自适应超时时连接和读取都使用配置的超时
+func TestScannerTimeoutsWithoutEstimator(t *testing.T) {
+	s := newTestScanner(nil, ScannerConfig{Timeout: 1500 * time.Millisecond})
+	if s.connectTimeout() != 1500*time.Millisecond || s.readTimeout() != 1500*time.Millisecond {
+		t.Errorf("超时 = %v/%v", s.connectTimeout(), s.readTimeout())
+	}
+}
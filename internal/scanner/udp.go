@@ -0,0 +1,380 @@
+package scanner
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"nebulafinger/internal"
+	"nebulafinger/internal/cluster"
+	"nebulafinger/internal/matcher"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// PortOpenFiltered UDP端口没有任何响应，无法区分开放和被过滤
+const PortOpenFiltered = "open|filtered"
+
+// 端口结果中记录的传输层协议
+const (
+	ProtocolTCP = "tcp"
+	ProtocolUDP = "udp"
+)
+
+// defaultUDPRate 未配置时每秒发送的UDP报文数上限
+const defaultUDPRate = 100
+
+// udpBufferSize 读取UDP响应的缓冲区大小
+const udpBufferSize = 4096
+
+// udpProbe 解码后的UDP探针
+type udpProbe struct {
+	fingerprint cluster.ClusteredFingerprint // 所属指纹
+	request     internal.UDPRequest          // 探针定义
+	payload     []byte                       // 解码后的发送数据
+}
+
+// buildUDPProbes 从服务指纹中收集UDP探针并解码payload，无法解码的探针会被跳过并返回警告
+func buildUDPProbes(fingerprints []internal.Fingerprint) ([]udpProbe, []string) {
+	var probes []udpProbe
+	var warnings []string
+	for _, fp := range fingerprints {
+		for _, req := range fp.UDP {
+			payload, err := hex.DecodeString(strings.ReplaceAll(req.Payload, " ", ""))
+			if err != nil || len(payload) == 0 {
+				warnings = append(warnings, fmt.Sprintf("指纹 %s 的UDP探针payload无效: %v，已跳过", fp.ID, err))
+				continue
+			}
+			probes = append(probes, udpProbe{
+				fingerprint: cluster.ClusteredFingerprint{ID: fp.ID, Info: fp.Info, Matchers: req.Matchers, Extractors: req.Extractors},
+				request:     req,
+				payload:     payload,
+			})
+		}
+	}
+	return probes, warnings
+}
+
+// rateLimiter 全局发包速率限制，所有目标和端口共享，为nil时不限制
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// newRateLimiter 创建每秒最多发送rate个报文的限速器，rate<=0时不限制
+func newRateLimiter(rate int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Second / time.Duration(rate)}
+}
+
+// wait 等待到可以发送下一个报文
+func (l *rateLimiter) wait() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// udpScan 对目标做UDP服务识别，返回匹配结果和每个端口的状态
+// 目标带端口时只探测该端口，否则使用配置的UDP端口，都没有时探测各探针声明的端口
+func (s *Scanner) udpScan(parsedURL *url.URL) ([]matcher.MatchResult, []PortResult) {
+	var ports []uint16
+	if port, err := strconv.ParseUint(parsedURL.Port(), 10, 16); err == nil {
+		ports = []uint16{uint16(port)}
+	} else if s.Config != nil && len(s.Config.UDPPorts) > 0 {
+		ports = s.Config.UDPPorts
+	} else {
+		ports = s.udpProbePorts()
+	}
+
+	hostname := parsedURL.Hostname()
+	portStates := make([]PortResult, len(ports))
+	portResults := make([][]matcher.MatchResult, len(ports))
+	parallel(len(ports), s.portConcurrency(), func(i int) {
+		portStates[i], portResults[i] = s.scanUDPPort(hostname, ports[i])
+	})
+
+	var results []matcher.MatchResult
+	for _, r := range portResults {
+		results = append(results, r...)
+	}
+	return UniqueResults(results), portStates
+}
+
+// udpProbePorts 返回各探针声明的端口和已启用的UDP协议模块的常用端口，按端口号排序
+func (s *Scanner) udpProbePorts() []uint16 {
+	seen := make(map[uint16]bool)
+	var ports []uint16
+	for _, port := range s.moduleDefaultPorts(true) {
+		seen[port] = true
+		ports = append(ports, port)
+	}
+	for _, probe := range s.udpProbes {
+		for _, p := range extractValidPorts(probe.request.Port) {
+			port, err := strconv.ParseUint(p, 10, 16)
+			if err != nil || seen[uint16(port)] {
+				continue
+			}
+			seen[uint16(port)] = true
+			ports = append(ports, uint16(port))
+		}
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+	return ports
+}
+
+// udpModulesForPort 返回在端口上执行的UDP协议模块：端口配置中该端口对应服务的模块和常用端口包含该端口的模块
+func (s *Scanner) udpModulesForPort(port uint16) []*serviceModule {
+	var modules []*serviceModule
+	for _, name := range ModuleNames() {
+		module := serviceModules[name]
+		if !module.udp || !s.moduleEnabled(module) {
+			continue
+		}
+		matched := false
+		for _, service := range s.portServices[port] {
+			matched = matched || service == name
+		}
+		for _, p := range module.ports {
+			matched = matched || p == port
+		}
+		if matched {
+			modules = append(modules, module)
+		}
+	}
+	return modules
+}
+
+// udpProbesForPort 返回在端口上发送的探针：端口配置中该端口对应服务的探针最先发送，其次是声明了该端口的探针；
+// 没有探针对应该端口时发送全部探针
+func (s *Scanner) udpProbesForPort(port uint16) []udpProbe {
+	type tieredProbe struct {
+		probe udpProbe
+		tier  int
+	}
+	var tiered []tieredProbe
+	for _, probe := range s.udpProbes {
+		tier := tierGeneric
+		if s.isServicePort(port, probe.fingerprint) {
+			tier = tierServicePort
+		} else if portInList(probe.request.Port, int(port)) {
+			tier = tierFingerprintPort
+		}
+		tiered = append(tiered, tieredProbe{probe, tier})
+	}
+	sort.SliceStable(tiered, func(i, j int) bool { return tiered[i].tier < tiered[j].tier })
+
+	// 有探针对应该端口时只发送这些探针
+	if len(tiered) > 0 && tiered[0].tier != tierGeneric {
+		for tiered[len(tiered)-1].tier == tierGeneric {
+			tiered = tiered[:len(tiered)-1]
+		}
+	}
+	probes := make([]udpProbe, len(tiered))
+	for i, t := range tiered {
+		probes[i] = t.probe
+	}
+	return probes
+}
+
+// scanUDPPort 依次发送端口上的探针，第一个命中的探针即为识别结果
+// 收到任何响应时端口为open；收到ICMP端口不可达时为closed，不再发送其他探针；都没有响应时为open|filtered
+func (s *Scanner) scanUDPPort(hostname string, port uint16) (PortResult, []matcher.MatchResult) {
+	state := PortResult{Host: hostname, IP: s.pinnedIP, Port: int(port), Protocol: ProtocolUDP, State: PortOpenFiltered}
+
+	// UDP协议模块先于探针执行，模块在端口不可达时不返回结果，由探针确认端口状态
+	target := moduleTarget{hostname: hostname, port: port, banner: &tcpBanner{}}
+	for _, module := range s.udpModulesForPort(port) {
+		if result := module.probe(s, target); result != nil {
+			state.State = PortOpen
+			return state, []matcher.MatchResult{s.moduleMatchResult(target, module, result)}
+		}
+	}
+
+	// 相同payload的探针只发送一次，之后复用响应
+	responses := make(map[string][]byte)
+	for _, probe := range s.udpProbesForPort(port) {
+		response, sent := responses[string(probe.payload)]
+		if !sent {
+			var err error
+			response, err = s.sendUDPProbe(hostname, port, probe)
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				state.State = PortClosed
+				return state, nil
+			}
+			responses[string(probe.payload)] = response
+		}
+		if response == nil {
+			continue
+		}
+		state.State = PortOpen
+
+		if result, ok := s.matchUDPResponse(hostname, port, probe, response); ok {
+			return state, []matcher.MatchResult{result}
+		}
+	}
+	return state, nil
+}
+
+// sendUDPProbe 发送探针并等待响应，没有响应时按探针的重传次数重发
+// 探针允许服务端从其他端口回复时使用未连接的套接字，只接受来自目标地址的报文，此时收不到ICMP不可达
+func (s *Scanner) sendUDPProbe(hostname string, port uint16, probe udpProbe) ([]byte, error) {
+	ip, err := s.udpTargetIP(hostname)
+	if err != nil {
+		return nil, err
+	}
+	target := &net.UDPAddr{IP: ip.IP, Port: int(port), Zone: ip.Zone}
+
+	if err := s.budget.acquire(context.Background()); err != nil {
+		return nil, err
+	}
+	defer s.budget.release()
+
+	var conn *net.UDPConn
+	if probe.request.AnySourcePort {
+		conn, err = net.ListenUDP("udp", nil)
+	} else {
+		conn, err = net.DialUDP("udp", nil, target)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	buffer := make([]byte, udpBufferSize)
+	for attempt := 0; attempt <= probe.request.Retransmits; attempt++ {
+		s.udpLimiter.wait()
+		start := time.Now()
+		if probe.request.AnySourcePort {
+			_, err = conn.WriteToUDP(probe.payload, target)
+		} else {
+			_, err = conn.Write(probe.payload)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		deadline := start.Add(s.readTimeout())
+		conn.SetReadDeadline(deadline)
+		for {
+			n, from, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+				// 连接的套接字收到ICMP端口不可达时读取返回ECONNREFUSED，往返耗时也是有效的RTT样本
+				s.rtt.observe(time.Since(start), err)
+				return nil, err
+			}
+			if probe.request.AnySourcePort && !from.IP.Equal(ip.IP) {
+				continue
+			}
+			s.rtt.observe(time.Since(start), nil)
+			return append([]byte(nil), buffer[:n]...), nil
+		}
+	}
+	return nil, nil
+}
+
+// udpTargetIP 返回UDP探测的目标地址：目标主机使用扫描开始时固定的地址，其他主机按地址族偏好解析
+func (s *Scanner) udpTargetIP(hostname string) (*net.IPAddr, error) {
+	var host string
+	if s.pinnedIP != "" && strings.EqualFold(hostname, s.pinnedHost) {
+		host = s.pinnedIP
+	} else {
+		addrs, err := s.resolveHost(context.Background(), hostname)
+		if err != nil {
+			return nil, err
+		}
+		host = addrs[0]
+	}
+	return net.ResolveIPAddr("ip", host)
+}
+
+// matchUDPResponse 用探针的匹配器检查响应，任一匹配器命中即识别成功
+// 响应按字节（Latin-1）转换为字符串后匹配，正则中的\xNN对应单个字节
+func (s *Scanner) matchUDPResponse(hostname string, port uint16, probe udpProbe, response []byte) (matcher.MatchResult, bool) {
+	resp := &matcher.TCPResponse{
+		Host:     hostname,
+		Port:     strconv.Itoa(int(port)),
+		Response: latin1(response),
+		Raw:      response,
+	}
+
+	fingerprint := probe.fingerprint
+	for _, m := range probe.request.Matchers {
+		if !matcher.MatchTCP(m, resp) {
+			continue
+		}
+
+		confidence := internal.CalculateMatcherConfidence(m, resp.Response, nil, s.ConfidenceConfig)
+		metadata := fingerprint.Info.Metadata
+		result := matcher.MatchResult{
+			ID:         fingerprint.ID,
+			Name:       fingerprint.Info.Name,
+			Confidence: confidence,
+			Details:    make(map[string]string),
+			Tags:       []string{fingerprint.Info.Tags},
+			Metadata:   &metadata,
+			Evidence:   matcherEvidence(m),
+		}
+
+		// 添加主机、端口和协议信息，并记录服务是否运行在常用端口上
+		result.Details["host"] = hostname
+		result.Details["port"] = resp.Port
+		result.Details["protocol"] = ProtocolUDP
+		result.Details["port_match"] = s.portMatch(port, probe.request.Port, fingerprint)
+
+		// 提取详细信息，模板提取出的产品和版本补充到元数据
+		for _, extractor := range probe.request.Extractors {
+			if value := matcher.ExtractTCP(extractor, resp); value != "" {
+				result.Details[extractor.Name] = value
+			}
+		}
+		fillMetadata(result.Metadata, result.Details)
+		return result, true
+	}
+	return matcher.MatchResult{}, false
+}
+
+// matcherEvidence 返回匹配器的名称，没有名称时返回第一条规则
+func matcherEvidence(m internal.Matchers) string {
+	switch {
+	case m.Name != "":
+		return m.Name
+	case len(m.Regex) > 0:
+		return m.Regex[0]
+	case len(m.Words) > 0:
+		return m.Words[0]
+	case len(m.Binary) > 0:
+		return m.Binary[0]
+	}
+	return ""
+}
+
+// latin1 将每个字节转换为同值的字符，使正则可以按字节匹配二进制响应
+func latin1(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
@@ -0,0 +1,148 @@
+package scanner
+
+import (
+	"encoding/hex"
+	"nebulafinger/internal"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newUDPTestScanner 创建只带一个UDP探针的扫描器，payload为"PING"，响应以"PONG "开头时识别为pong服务
+func newUDPTestScanner(retransmits int, rate int) *Scanner {
+	fingerprints := []internal.Fingerprint{{
+		ID:   "pong-udp",
+		Info: internal.Info{Name: "pong", Tags: "pong"},
+		UDP: []internal.UDPRequest{{
+			Name:        "pong",
+			Payload:     hex.EncodeToString([]byte("PING")),
+			Retransmits: retransmits,
+			Matchers:    []internal.Matchers{{Type: "regex", Part: "response", Regex: []string{`^PONG `}}},
+			Extractors:  []internal.Extractors{{Type: "regex", Name: "version", Regex: []string{`^PONG ([\d.]+)`}}},
+		}},
+	}}
+	return newTestScanner(fingerprints, ScannerConfig{Timeout: 200 * time.Millisecond, EnableUDP: true, UDPRate: rate})
+}
+
+// TestBuildUDPProbesWarnings 无效的payload跳过并作为警告返回，不直接输出
+func TestBuildUDPProbesWarnings(t *testing.T) {
+	s := newTestScanner([]internal.Fingerprint{{
+		ID:  "broken-udp",
+		UDP: []internal.UDPRequest{{Name: "bad", Payload: "zz"}, {Name: "empty"}, {Name: "ok", Payload: "00 01"}},
+	}}, ScannerConfig{})
+	if len(s.udpProbes) != 1 || string(s.udpProbes[0].payload) != "\x00\x01" {
+		t.Errorf("探针 = %+v", s.udpProbes)
+	}
+	if len(s.Warnings) != 2 || !strings.Contains(s.Warnings[0], "broken-udp") {
+		t.Errorf("警告 = %q", s.Warnings)
+	}
+}
+
+func TestScanUDPPortOpen(t *testing.T) {
+	port, _ := udpStandIn(t, func(data []byte) []byte {
+		if string(data) == "PING" {
+			return []byte("PONG 1.2.3\n")
+		}
+		return nil
+	})
+	s := newUDPTestScanner(0, 0)
+
+	state, results := s.scanUDPPort("127.0.0.1", port)
+	if state.State != PortOpen || state.Protocol != ProtocolUDP {
+		t.Fatalf("端口状态 = %s/%s，期望 open/udp", state.State, state.Protocol)
+	}
+	if len(results) != 1 {
+		t.Fatalf("结果数 = %d，期望 1", len(results))
+	}
+	if results[0].ID != "pong-udp" || results[0].Details["version"] != "1.2.3" || results[0].Details["protocol"] != ProtocolUDP {
+		t.Errorf("结果 = %s %v", results[0].ID, results[0].Details)
+	}
+}
+
+func TestScanUDPPortOpenWithoutMatch(t *testing.T) {
+	port, _ := udpStandIn(t, func([]byte) []byte { return []byte("HELLO") })
+	s := newUDPTestScanner(0, 0)
+
+	state, results := s.scanUDPPort("127.0.0.1", port)
+	if state.State != PortOpen || len(results) != 0 {
+		t.Fatalf("端口状态 = %s，结果数 = %d，期望 open 且没有结果", state.State, len(results))
+	}
+}
+
+func TestScanUDPPortClosed(t *testing.T) {
+	// 绑定后立即关闭得到一个未监听的端口，本地发送会收到ICMP端口不可达
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := uint16(conn.LocalAddr().(*net.UDPAddr).Port)
+	conn.Close()
+	s := newUDPTestScanner(2, 0)
+
+	start := time.Now()
+	state, results := s.scanUDPPort("127.0.0.1", port)
+	if state.State != PortClosed || len(results) != 0 {
+		t.Fatalf("端口状态 = %s，结果数 = %d，期望 closed 且没有结果", state.State, len(results))
+	}
+	// 收到不可达后不再重传和等待超时
+	if elapsed := time.Since(start); elapsed >= s.Config.Timeout {
+		t.Errorf("关闭端口耗时 %s，期望不等待读取超时", elapsed)
+	}
+}
+
+func TestScanUDPPortOpenFiltered(t *testing.T) {
+	port, received := udpStandIn(t, func([]byte) []byte { return nil })
+	s := newUDPTestScanner(1, 0)
+
+	state, results := s.scanUDPPort("127.0.0.1", port)
+	if state.State != PortOpenFiltered || len(results) != 0 {
+		t.Fatalf("端口状态 = %s，结果数 = %d，期望 open|filtered 且没有结果", state.State, len(results))
+	}
+	if n := atomic.LoadInt64(received); n != 2 {
+		t.Errorf("收到 %d 个报文，期望首次发送加1次重传共 2 个", n)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	if limiter := newRateLimiter(0); limiter != nil {
+		t.Fatalf("rate为0时应不限速")
+	}
+	// 未限速时wait不阻塞
+	var unlimited *rateLimiter
+	unlimited.wait()
+
+	// 每秒50个报文，并发发送的11个报文至少间隔10个20ms
+	limiter := newRateLimiter(50)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 11; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limiter.wait()
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("11个报文耗时 %s，期望至少 200ms", elapsed)
+	}
+}
+
+func TestScanUDPPortRateLimited(t *testing.T) {
+	port, received := udpStandIn(t, func([]byte) []byte { return nil })
+	// 每秒10个报文，首次发送加2次重传至少需要200ms，每次等待读取超时后才重传，扫描不会因限速而漏发
+	s := newUDPTestScanner(2, 10)
+	s.Config.Timeout = 20 * time.Millisecond
+
+	start := time.Now()
+	s.scanUDPPort("127.0.0.1", port)
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("3个报文耗时 %s，期望限速后至少 200ms", elapsed)
+	}
+	if n := atomic.LoadInt64(received); n != 3 {
+		t.Errorf("收到 %d 个报文，期望 3 个", n)
+	}
+}
@@ -0,0 +1,290 @@
+package targets
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Expand 展开一个目标表达式，逐个回调生成的目标，回调返回false时停止
+//
+// 支持的写法：
+//   - 带协议头的URL（http://、https://、tcp://）原样输出
+//   - CIDR：10.0.0.0/24、2001:db8::/120
+//   - IP范围：10.0.0.1-50、10.0.0.1-10.0.0.50、2001:db8::1-2001:db8::ff
+//   - 端口列表和范围：host:80,443、host:1000-2000、10.0.0.0/24:80,8080、[2001:db8::/120]:443
+//
+// 展开过程不预先生成列表，大网段也只占用常量内存
+func Expand(spec string, emit func(string) bool) error {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil
+	}
+	if strings.Contains(spec, "://") {
+		emit(spec)
+		return nil
+	}
+
+	hostSpec, portSpec := splitPortSpec(spec)
+
+	ports, err := ParsePorts(portSpec)
+	if err != nil {
+		return fmt.Errorf("%s: %v", spec, err)
+	}
+
+	emitHost := func(host string) bool {
+		if len(ports) == 0 {
+			// 不带端口时原样输出
+			return emit(host)
+		}
+		for _, p := range ports {
+			if !emit(net.JoinHostPort(host, strconv.Itoa(int(p)))) {
+				return false
+			}
+		}
+		return true
+	}
+
+	first, last, ok, err := parseAddrRange(hostSpec)
+	if err != nil {
+		return fmt.Errorf("%s: %v", spec, err)
+	}
+	if !ok {
+		// 普通主机名或单个IP
+		emitHost(hostSpec)
+		return nil
+	}
+
+	for addr := first; ; addr = addr.Next() {
+		if !emitHost(addr.String()) || addr == last {
+			return nil
+		}
+	}
+}
+
+// splitPortSpec 拆分主机部分和端口部分，端口部分只在由数字、逗号和连字符组成时生效
+func splitPortSpec(spec string) (string, string) {
+	// [IPv6]:ports 或 [IPv6/prefix]:ports
+	if strings.HasPrefix(spec, "[") {
+		end := strings.Index(spec, "]")
+		if end < 0 {
+			return spec, ""
+		}
+		host := spec[1:end]
+		rest := spec[end+1:]
+		if strings.HasPrefix(rest, ":") && isPortSpec(rest[1:]) {
+			return host, rest[1:]
+		}
+		return host, ""
+	}
+
+	// 含多个冒号的是不带方括号的IPv6，没有端口
+	if strings.Count(spec, ":") != 1 {
+		return spec, ""
+	}
+	i := strings.LastIndex(spec, ":")
+	if isPortSpec(spec[i+1:]) {
+		return spec[:i], spec[i+1:]
+	}
+	return spec, ""
+}
+
+// isPortSpec 判断是否为端口表达式
+func isPortSpec(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && c != ',' && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseAddrRange 将CIDR或IP范围解析为首尾地址，ok为false表示不是地址范围
+func parseAddrRange(spec string) (first, last netip.Addr, ok bool, err error) {
+	// CIDR
+	if strings.Contains(spec, "/") {
+		prefix, err := netip.ParsePrefix(spec)
+		if err != nil {
+			return first, last, false, fmt.Errorf("无效的CIDR: %v", err)
+		}
+		prefix = prefix.Masked()
+		return prefix.Addr(), lastAddr(prefix), true, nil
+	}
+
+	// IP范围
+	i := strings.LastIndex(spec, "-")
+	if i < 0 {
+		return first, last, false, nil
+	}
+	first, err = netip.ParseAddr(spec[:i])
+	if err != nil {
+		// 主机名中可能包含连字符
+		return first, last, false, nil
+	}
+
+	end := spec[i+1:]
+	if last, err = netip.ParseAddr(end); err != nil {
+		// 10.0.0.1-50 形式，只替换最后一段
+		n, convErr := strconv.Atoi(end)
+		if !first.Is4() || convErr != nil || n < 0 || n > 255 {
+			return first, last, false, fmt.Errorf("无效的IP范围: %s", spec)
+		}
+		b := first.As4()
+		b[3] = byte(n)
+		last = netip.AddrFrom4(b)
+	}
+
+	if first.Is4() != last.Is4() || last.Less(first) {
+		return first, last, false, fmt.Errorf("无效的IP范围: %s", spec)
+	}
+	return first, last, true, nil
+}
+
+// lastAddr 返回网段中的最后一个地址
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().AsSlice()
+	bits := prefix.Bits()
+	for i := range b {
+		for j := 0; j < 8; j++ {
+			if i*8+j >= bits {
+				b[i] |= 1 << (7 - j)
+			}
+		}
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// StreamSpecs 从r读取目标表达式并逐个回调，回调返回false时停止
+// 目标列表逐行读取，不缓存整个输入；nmap、masscan和httpx的输出整体解析后再回调
+func StreamSpecs(r io.Reader, format string, emit func(string) bool) error {
+	reader := bufio.NewReaderSize(r, 64*1024)
+	if format == "" || format == FormatAuto {
+		// 只根据开头的内容识别格式，Peek在输入不足时返回已有的数据
+		head, _ := reader.Peek(4096)
+		format = DetectFormat(head)
+	}
+
+	if format != FormatList {
+		specs, err := parseReader(reader, format)
+		if err != nil {
+			return err
+		}
+		for _, spec := range specs {
+			if !emit(spec) {
+				return nil
+			}
+		}
+		return nil
+	}
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		spec := strings.TrimSpace(scanner.Text())
+		if spec == "" || strings.HasPrefix(spec, "#") {
+			continue
+		}
+		if !emit(spec) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// Excluder 排除列表，支持IP、CIDR、IP范围和主机名
+type Excluder struct {
+	prefixes []netip.Prefix
+	ranges   [][2]netip.Addr
+	hosts    map[string]bool
+}
+
+// NewExcluder 根据表达式创建排除列表
+func NewExcluder(specs []string) (*Excluder, error) {
+	e := &Excluder{hosts: make(map[string]bool)}
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		if strings.Contains(spec, "/") {
+			prefix, err := netip.ParsePrefix(spec)
+			if err != nil {
+				return nil, fmt.Errorf("无效的排除项 %s: %v", spec, err)
+			}
+			e.prefixes = append(e.prefixes, prefix.Masked())
+			continue
+		}
+
+		first, last, ok, err := parseAddrRange(spec)
+		if err != nil {
+			return nil, fmt.Errorf("无效的排除项 %s: %v", spec, err)
+		}
+		if ok {
+			e.ranges = append(e.ranges, [2]netip.Addr{first, last})
+			continue
+		}
+
+		if addr, err := netip.ParseAddr(spec); err == nil {
+			e.prefixes = append(e.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		e.hosts[strings.ToLower(spec)] = true
+	}
+	return e, nil
+}
+
+// Empty 排除列表是否为空
+func (e *Excluder) Empty() bool {
+	return e == nil || (len(e.prefixes) == 0 && len(e.ranges) == 0 && len(e.hosts) == 0)
+}
+
+// Excluded 判断目标是否在排除列表中，目标可以是URL、host:port或单独的主机
+func (e *Excluder) Excluded(target string) bool {
+	if e.Empty() {
+		return false
+	}
+
+	host := HostOf(target)
+	if e.hosts[strings.ToLower(host)] {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+	for _, p := range e.prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	for _, r := range e.ranges {
+		if !addr.Less(r[0]) && !r[1].Less(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// HostOf 提取目标中的主机部分，去掉协议、端口、路径和IPv6方括号
+func HostOf(target string) string {
+	if i := strings.Index(target, "://"); i >= 0 {
+		target = target[i+3:]
+	}
+	if i := strings.IndexAny(target, "/?#"); i >= 0 {
+		target = target[:i]
+	}
+	if host, _, err := net.SplitHostPort(target); err == nil {
+		return host
+	}
+	return strings.Trim(target, "[]")
+}
@@ -0,0 +1,216 @@
+package targets
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// expandAll 展开spec，最多收集limit个目标，limit为0时不限制
+func expandAll(t *testing.T, spec string, limit int) []string {
+	t.Helper()
+	var got []string
+	err := Expand(spec, func(target string) bool {
+		got = append(got, target)
+		return limit == 0 || len(got) < limit
+	})
+	if err != nil {
+		t.Fatalf("Expand(%q) 失败: %v", spec, err)
+	}
+	return got
+}
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		want []string
+	}{
+		{"主机名", "example.com", []string{"example.com"}},
+		{"URL原样输出", "https://10.0.0.0/24:8443", []string{"https://10.0.0.0/24:8443"}},
+		{"IPv4 CIDR", "192.0.2.0/30", []string{"192.0.2.0", "192.0.2.1", "192.0.2.2", "192.0.2.3"}},
+		{"CIDR按网络地址对齐", "192.0.2.5/31", []string{"192.0.2.4", "192.0.2.5"}},
+		{"单地址CIDR", "192.0.2.9/32", []string{"192.0.2.9"}},
+		{"IPv6 CIDR", "2001:db8::/126", []string{"2001:db8::", "2001:db8::1", "2001:db8::2", "2001:db8::3"}},
+		{"最后一段范围", "10.0.0.1-3", []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{"完整地址范围", "10.0.0.254-10.0.1.1", []string{"10.0.0.254", "10.0.0.255", "10.0.1.0", "10.0.1.1"}},
+		{"IPv6范围", "2001:db8::fe-2001:db8::101", []string{"2001:db8::fe", "2001:db8::ff", "2001:db8::100", "2001:db8::101"}},
+		{"带连字符的主机名", "my-host.example.com", []string{"my-host.example.com"}},
+		{"端口列表", "example.com:80,443", []string{"example.com:80", "example.com:443"}},
+		{"重复端口", "example.com:443,80,443", []string{"example.com:443", "example.com:80"}},
+		{"CIDR和端口", "192.0.2.0/31:80,8080", []string{"192.0.2.0:80", "192.0.2.0:8080", "192.0.2.1:80", "192.0.2.1:8080"}},
+		{"IPv6地址和端口", "[2001:db8::1]:80,443", []string{"[2001:db8::1]:80", "[2001:db8::1]:443"}},
+		{"IPv6 CIDR和端口", "[2001:db8::/127]:443", []string{"[2001:db8::]:443", "[2001:db8::1]:443"}},
+		{"不带方括号的IPv6地址", "2001:db8::1", []string{"2001:db8::1"}},
+		{"空白", "  ", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := expandAll(t, tt.spec, 0); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expand(%q) = %v，期望 %v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandPortRange(t *testing.T) {
+	got := expandAll(t, "example.com:1000-2000", 0)
+	if len(got) != 1001 || got[0] != "example.com:1000" || got[1000] != "example.com:2000" {
+		t.Errorf("端口范围展开为 %d 个目标，首尾为 %s、%s", len(got), got[0], got[len(got)-1])
+	}
+}
+
+// TestExpandStopsEarly 回调返回false时立即停止，大网段不会继续展开
+func TestExpandStopsEarly(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []string
+	}{
+		{"10.0.0.0/8", []string{"10.0.0.0", "10.0.0.1", "10.0.0.2"}},
+		{"2001:db8::/32", []string{"2001:db8::", "2001:db8::1", "2001:db8::2"}},
+		{"10.0.0.0/8:80,443", []string{"10.0.0.0:80", "10.0.0.0:443", "10.0.0.1:80"}},
+		{"example.com:1-65535", []string{"example.com:1", "example.com:2", "example.com:3"}},
+	}
+	for _, tt := range tests {
+		if got := expandAll(t, tt.spec, 3); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Expand(%q) = %v，期望 %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestExpandInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"10.0.0.0/33",
+		"10.0.0.5-1",
+		"10.0.0.1-300",
+		"10.0.0.1-2001:db8::1",
+		"2001:db8::1-5",
+		"example.com:0",
+		"example.com:70000",
+		"example.com:2000-1000",
+	} {
+		if err := Expand(spec, func(string) bool { return true }); err == nil {
+			t.Errorf("Expand(%q) 应返回错误", spec)
+		}
+	}
+}
+
+func TestParsePorts(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []uint16
+	}{
+		{"", nil},
+		{"80", []uint16{80}},
+		{"80,443,8000-8002", []uint16{80, 443, 8000, 8001, 8002}},
+		{" 443 , 80,443 ", []uint16{443, 80}},
+		{"top-3", []uint16{80, 23, 443}},
+		{"TOP-1", []uint16{80}},
+	}
+	for _, tt := range tests {
+		got, err := ParsePorts(tt.spec)
+		if err != nil {
+			t.Errorf("ParsePorts(%q) 失败: %v", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParsePorts(%q) = %v，期望 %v", tt.spec, got, tt.want)
+		}
+	}
+
+	for _, spec := range []string{"-", "all"} {
+		ports, err := ParsePorts(spec)
+		if err != nil || len(ports) != 65535 || ports[0] != 1 || ports[65534] != 65535 {
+			t.Errorf("ParsePorts(%q) 应返回全部端口，得到 %d 个: %v", spec, len(ports), err)
+		}
+	}
+	if ports, _ := ParsePorts("top-1000"); len(ports) != 1000 {
+		t.Errorf("top-1000 返回 %d 个端口", len(ports))
+	}
+	for _, spec := range []string{"0", "65536", "80,abc", "100-90", ",", "top-0", "top-1001", "top-x"} {
+		if _, err := ParsePorts(spec); err == nil {
+			t.Errorf("ParsePorts(%q) 应返回错误", spec)
+		}
+	}
+}
+
+func TestExcluder(t *testing.T) {
+	excluder, err := NewExcluder([]string{"192.0.2.0/30", "198.51.100.10-20", "203.0.113.7", "2001:db8::/64", "Skip.Example.com", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]bool{
+		"192.0.2.3":                  true,
+		"192.0.2.4":                  false,
+		"http://192.0.2.1:8080/path": true,
+		"198.51.100.15:22":           true,
+		"198.51.100.21":              false,
+		"203.0.113.7":                true,
+		"203.0.113.8":                false,
+		"::ffff:203.0.113.7":         true,
+		"[2001:db8::5]:443":          true,
+		"2001:db8:1::5":              false,
+		"skip.example.com":           true,
+		"https://skip.example.com/":  true,
+		"keep.example.com":           false,
+	}
+	for target, want := range tests {
+		if got := excluder.Excluded(target); got != want {
+			t.Errorf("Excluded(%q) = %v，期望 %v", target, got, want)
+		}
+	}
+
+	if _, err := NewExcluder([]string{"10.0.0.0/40"}); err == nil {
+		t.Errorf("无效的CIDR应返回错误")
+	}
+	var empty *Excluder
+	if !empty.Empty() || empty.Excluded("192.0.2.1") {
+		t.Errorf("nil排除列表不应排除任何目标")
+	}
+}
+
+// TestExpandWithExclusions 展开网段时跳过排除的地址
+func TestExpandWithExclusions(t *testing.T) {
+	excluder, err := NewExcluder([]string{"192.0.2.1-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	Expand("192.0.2.0/30:80", func(target string) bool {
+		if !excluder.Excluded(target) {
+			got = append(got, target)
+		}
+		return true
+	})
+	if want := []string{"192.0.2.0:80", "192.0.2.3:80"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("展开结果 = %v，期望 %v", got, want)
+	}
+}
+
+func TestStreamSpecs(t *testing.T) {
+	collect := func(input, format string, limit int) []string {
+		var got []string
+		err := StreamSpecs(strings.NewReader(input), format, func(spec string) bool {
+			got = append(got, spec)
+			return limit == 0 || len(got) < limit
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	list := "# targets\nexample.com\n\n  10.0.0.0/24:80  \nhttps://example.org\n"
+	if got, want := collect(list, "", 0), []string{"example.com", "10.0.0.0/24:80", "https://example.org"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("目标列表 = %v，期望 %v", got, want)
+	}
+	if got := collect(list, FormatList, 1); !reflect.DeepEqual(got, []string{"example.com"}) {
+		t.Errorf("回调返回false后应停止读取，得到 %v", got)
+	}
+	if got := collect(nmapXMLFixture, "", 0); len(got) != 4 || got[0] != "tcp://192.0.2.1:22" {
+		t.Errorf("自动识别nmap XML失败: %v", got)
+	}
+	if got := collect(masscanListFixture, FormatMasscanList, 0); len(got) != 2 {
+		t.Errorf("指定格式的masscan列表 = %v", got)
+	}
+}
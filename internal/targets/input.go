@@ -0,0 +1,369 @@
+package targets
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 支持的输入格式
+const (
+	FormatAuto        = "auto"         // 根据文件内容自动识别
+	FormatList        = "list"         // 每行一个目标
+	FormatNmapXML     = "nmap"         // nmap -oX
+	FormatMasscanJSON = "masscan-json" // masscan -oJ
+	FormatMasscanList = "masscan-list" // masscan -oL
+	FormatHttpx       = "httpx"        // httpx -json
+)
+
+// 常见的HTTP/HTTPS端口，端口扫描结果没有服务信息时据此选择协议
+var (
+	httpPorts  = map[int]bool{80: true, 81: true, 591: true, 3000: true, 5000: true, 7001: true, 8000: true, 8008: true, 8080: true, 8081: true, 8088: true, 8888: true, 9000: true, 9090: true}
+	httpsPorts = map[int]bool{443: true, 4443: true, 8443: true, 9443: true}
+)
+
+// LoadFile 按指定格式读取目标文件，format为空或auto时自动识别
+func LoadFile(path, format string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data, format)
+}
+
+// Parse 按指定格式解析目标数据
+func Parse(data []byte, format string) ([]string, error) {
+	if format == "" || format == FormatAuto {
+		format = DetectFormat(data)
+	}
+
+	return parseReader(bytes.NewReader(data), format)
+}
+
+// parseReader 按已确定的格式解析目标数据
+func parseReader(r io.Reader, format string) ([]string, error) {
+	switch format {
+	case FormatList:
+		return parseList(r)
+	case FormatNmapXML:
+		return parseNmapXML(r)
+	case FormatMasscanJSON:
+		return parseMasscanJSON(r)
+	case FormatMasscanList:
+		return parseMasscanList(r)
+	case FormatHttpx:
+		return parseHttpx(r)
+	}
+	return nil, fmt.Errorf("不支持的输入格式: %s（可选: auto, list, nmap, masscan-json, masscan-list, httpx）", format)
+}
+
+// DetectFormat 根据文件开头的内容识别输入格式
+func DetectFormat(data []byte) string {
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("<?xml")) || bytes.HasPrefix(trimmed, []byte("<nmaprun")):
+		return FormatNmapXML
+	case bytes.HasPrefix(trimmed, []byte("#masscan")) || bytes.HasPrefix(trimmed, []byte("open ")):
+		return FormatMasscanList
+	case bytes.HasPrefix(trimmed, []byte("[")) || bytes.HasPrefix(trimmed, []byte("{")):
+		// masscan的JSON每个对象包含ip和ports，httpx的JSONL包含url
+		head := trimmed
+		if len(head) > 4096 {
+			head = head[:4096]
+		}
+		if bytes.Contains(head, []byte(`"ports"`)) && bytes.Contains(head, []byte(`"ip"`)) {
+			return FormatMasscanJSON
+		}
+		if bytes.Contains(head, []byte(`"url"`)) {
+			return FormatHttpx
+		}
+	}
+	return FormatList
+}
+
+// parseList 每行一个目标，忽略空行和#开头的注释
+func parseList(r io.Reader) ([]string, error) {
+	var targets []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		target := strings.TrimSpace(scanner.Text())
+		if target != "" && !strings.HasPrefix(target, "#") {
+			targets = append(targets, target)
+		}
+	}
+	return targets, scanner.Err()
+}
+
+// nmap XML中需要的部分
+type nmapRun struct {
+	Hosts []struct {
+		Status struct {
+			State string `xml:"state,attr"`
+		} `xml:"status"`
+		Addresses []struct {
+			Addr     string `xml:"addr,attr"`
+			AddrType string `xml:"addrtype,attr"`
+		} `xml:"address"`
+		Ports struct {
+			Ports []struct {
+				Protocol string `xml:"protocol,attr"`
+				PortID   int    `xml:"portid,attr"`
+				State    struct {
+					State string `xml:"state,attr"`
+				} `xml:"state"`
+				Service struct {
+					Name   string `xml:"name,attr"`
+					Tunnel string `xml:"tunnel,attr"`
+				} `xml:"service"`
+			} `xml:"port"`
+		} `xml:"ports"`
+	} `xml:"host"`
+}
+
+// parseNmapXML 读取nmap XML中开放的TCP端口，根据服务名和ssl隧道选择协议
+func parseNmapXML(r io.Reader) ([]string, error) {
+	var run nmapRun
+	if err := xml.NewDecoder(r).Decode(&run); err != nil {
+		return nil, fmt.Errorf("解析nmap XML失败: %v", err)
+	}
+
+	var targets []string
+	for _, host := range run.Hosts {
+		if host.Status.State != "" && host.Status.State != "up" {
+			continue
+		}
+
+		// 优先使用IP地址，忽略MAC地址
+		addr := ""
+		for _, a := range host.Addresses {
+			if a.AddrType == "ipv4" || a.AddrType == "ipv6" {
+				addr = a.Addr
+				break
+			}
+		}
+		if addr == "" {
+			continue
+		}
+
+		for _, port := range host.Ports.Ports {
+			if port.Protocol != "tcp" || port.State.State != "open" {
+				continue
+			}
+			service := port.Service.Name
+			if port.Service.Tunnel == "ssl" {
+				service = "ssl/" + service
+			}
+			targets = append(targets, FormatTarget(addr, port.PortID, service))
+		}
+	}
+	return targets, nil
+}
+
+// masscan -oJ 中每个主机的记录
+type masscanRecord struct {
+	IP    string `json:"ip"`
+	Ports []struct {
+		Port    int    `json:"port"`
+		Proto   string `json:"proto"`
+		Status  string `json:"status"`
+		Service struct {
+			Name string `json:"name"`
+		} `json:"service"`
+	} `json:"ports"`
+}
+
+// parseMasscanJSON 读取masscan -oJ输出，兼容旧版本在最后一个对象后多出的逗号
+// 开启--banners时同一端口会出现多条记录，按host:port去重，协议由该端口所有记录的服务名共同决定
+func parseMasscanJSON(r io.Reader) ([]string, error) {
+	var order []string
+	services := make(map[string][]string)
+	hosts := make(map[string]masscanEndpoint)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		// masscan每行输出一个对象，数组括号和分隔逗号单独处理
+		line := strings.TrimSuffix(strings.TrimSpace(scanner.Text()), ",")
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+
+		var record masscanRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return nil, fmt.Errorf("解析masscan JSON失败: %v", err)
+		}
+		for _, p := range record.Ports {
+			if p.Proto != "" && p.Proto != "tcp" {
+				continue
+			}
+			if p.Status != "" && p.Status != "open" {
+				continue
+			}
+			key := net.JoinHostPort(record.IP, strconv.Itoa(p.Port))
+			if _, ok := hosts[key]; !ok {
+				hosts[key] = masscanEndpoint{ip: record.IP, port: p.Port}
+				order = append(order, key)
+			}
+			services[key] = append(services[key], p.Service.Name)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	targets := make([]string, 0, len(order))
+	for _, key := range order {
+		endpoint := hosts[key]
+		targets = append(targets, FormatTarget(endpoint.ip, endpoint.port, masscanService(services[key])))
+	}
+	return targets, nil
+}
+
+// masscanEndpoint masscan结果中的一个开放端口
+type masscanEndpoint struct {
+	ip   string
+	port int
+}
+
+// masscanService 合并同一端口多条记录的服务名，返回交给FormatTarget的服务名：
+// --banners输出中除协议名外还有banner类型，title、html表示HTTP，ssl、X509表示TLS
+func masscanService(names []string) string {
+	isHTTP, isTLS := false, false
+	other := ""
+	for _, name := range names {
+		switch strings.ToLower(name) {
+		case "":
+		case "http", "title", "html", "http.server":
+			isHTTP = true
+		case "ssl", "x509":
+			isTLS = true
+		default:
+			if other == "" {
+				other = name
+			}
+		}
+	}
+
+	switch {
+	case isHTTP && isTLS:
+		return "https"
+	case isHTTP:
+		return "http"
+	case other != "":
+		return other
+	case isTLS:
+		// 只知道是TLS时按端口推断是否为HTTPS
+		return "ssl/"
+	}
+	return ""
+}
+
+// parseMasscanList 读取masscan -oL输出，格式为: open tcp <端口> <IP> <时间戳>
+func parseMasscanList(r io.Reader) ([]string, error) {
+	var targets []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[0] != "open" || fields[1] != "tcp" {
+			continue
+		}
+		port, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		targets = append(targets, FormatTarget(fields[3], port, ""))
+	}
+	return targets, scanner.Err()
+}
+
+// httpx -json 中每行的记录
+type httpxRecord struct {
+	URL    string `json:"url"`
+	Input  string `json:"input"`
+	Scheme string `json:"scheme"`
+	Host   string `json:"host"`
+	Port   string `json:"port"`
+}
+
+// parseHttpx 读取httpx JSONL输出中的URL
+func parseHttpx(r io.Reader) ([]string, error) {
+	var targets []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var record httpxRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return nil, fmt.Errorf("解析httpx JSON失败: %v", err)
+		}
+
+		switch {
+		case record.URL != "":
+			targets = append(targets, record.URL)
+		case record.Host != "" && record.Port != "":
+			scheme := record.Scheme
+			if scheme == "" {
+				scheme = "http"
+			}
+			targets = append(targets, scheme+"://"+net.JoinHostPort(record.Host, record.Port))
+		case record.Input != "":
+			targets = append(targets, record.Input)
+		}
+	}
+	return targets, scanner.Err()
+}
+
+// FormatTarget 根据服务提示生成扫描目标：HTTP类服务使用http/https，其余使用tcp
+// service为端口扫描器给出的服务名，例如 http、https、ssl/http、http-proxy；为空时按常见端口推断
+func FormatTarget(host string, port int, service string) string {
+	hostPort := net.JoinHostPort(host, strconv.Itoa(port))
+	switch SchemeForService(service, port) {
+	case "https":
+		return "https://" + hostPort
+	case "http":
+		return "http://" + hostPort
+	}
+	return "tcp://" + hostPort
+}
+
+// SchemeForService 根据服务名和端口判断协议，返回 http、https 或 tcp
+func SchemeForService(service string, port int) string {
+	service = strings.ToLower(strings.TrimSpace(service))
+	tls := false
+	if strings.HasPrefix(service, "ssl/") || strings.HasPrefix(service, "tls/") {
+		tls = true
+		service = service[4:]
+	}
+
+	switch {
+	case service == "https" || service == "https-alt":
+		return "https"
+	case service == "http" || strings.HasPrefix(service, "http-"):
+		if tls {
+			return "https"
+		}
+		return "http"
+	case service != "":
+		return "tcp"
+	}
+
+	// 没有服务信息时按常见端口推断
+	switch {
+	case httpsPorts[port]:
+		return "https"
+	case httpPorts[port]:
+		return "http"
+	}
+	return "tcp"
+}
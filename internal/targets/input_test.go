@@ -0,0 +1,122 @@
+package targets
+
+import (
+	"reflect"
+	"testing"
+)
+
+// nmapXMLFixture nmap -sV -oX 输出：一台存活主机（带MAC地址）有HTTP、ssl/http、SSH和关闭的端口，另一台主机不在线
+const nmapXMLFixture = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE nmaprun>
+<nmaprun scanner="nmap" args="nmap -sV -oX ports.xml 192.0.2.0/30" start="1718870400" version="7.94">
+<host starttime="1718870400" endtime="1718870410"><status state="up" reason="arp-response" reason_ttl="0"/>
+<address addr="192.0.2.1" addrtype="ipv4"/>
+<address addr="00:11:22:33:44:55" addrtype="mac"/>
+<ports>
+<port protocol="tcp" portid="22"><state state="open" reason="syn-ack" reason_ttl="64"/><service name="ssh" product="OpenSSH" version="9.6p1" method="probed" conf="10"/></port>
+<port protocol="tcp" portid="80"><state state="open" reason="syn-ack" reason_ttl="64"/><service name="http" product="nginx" method="probed" conf="10"/></port>
+<port protocol="tcp" portid="8443"><state state="open" reason="syn-ack" reason_ttl="64"/><service name="http" tunnel="ssl" method="probed" conf="10"/></port>
+<port protocol="tcp" portid="3306"><state state="closed" reason="reset" reason_ttl="64"/><service name="mysql" method="table" conf="3"/></port>
+<port protocol="udp" portid="53"><state state="open" reason="udp-response" reason_ttl="64"/><service name="domain" method="probed" conf="10"/></port>
+</ports>
+</host>
+<host><status state="down" reason="no-response" reason_ttl="0"/>
+<address addr="192.0.2.2" addrtype="ipv4"/>
+<ports><port protocol="tcp" portid="80"><state state="open" reason="syn-ack" reason_ttl="64"/></port></ports>
+</host>
+<host><status state="up" reason="echo-reply" reason_ttl="57"/>
+<address addr="2001:db8::1" addrtype="ipv6"/>
+<ports><port protocol="tcp" portid="443"><state state="open" reason="syn-ack" reason_ttl="57"/></port></ports>
+</host>
+<runstats><finished time="1718870410" elapsed="10.00"/><hosts up="2" down="1" total="3"/></runstats>
+</nmaprun>
+`
+
+// masscanJSONFixture masscan --banners -oJ 输出：80端口有http、title两条banner记录，443端口有ssl、X509、title，
+// 22端口有ssh，8080端口没有banner，最后一个对象后带有旧版本的多余逗号
+const masscanJSONFixture = `[
+{   "ip": "192.0.2.1",   "timestamp": "1718870400", "ports": [ {"port": 80, "proto": "tcp", "status": "open", "reason": "syn-ack", "ttl": 64} ] },
+{   "ip": "192.0.2.1",   "timestamp": "1718870401", "ports": [ {"port": 80, "proto": "tcp", "service": {"name": "http", "banner": "HTTP/1.1 200 OK\r\nServer: nginx"} } ] },
+{   "ip": "192.0.2.1",   "timestamp": "1718870401", "ports": [ {"port": 80, "proto": "tcp", "service": {"name": "title", "banner": "Welcome"} } ] },
+{   "ip": "192.0.2.1",   "timestamp": "1718870402", "ports": [ {"port": 443, "proto": "tcp", "status": "open", "reason": "syn-ack", "ttl": 64} ] },
+{   "ip": "192.0.2.1",   "timestamp": "1718870403", "ports": [ {"port": 443, "proto": "tcp", "service": {"name": "ssl", "banner": "TLS/1.2 cipher:0xc02f"} } ] },
+{   "ip": "192.0.2.1",   "timestamp": "1718870403", "ports": [ {"port": 443, "proto": "tcp", "service": {"name": "X509", "banner": "MIIB"} } ] },
+{   "ip": "192.0.2.1",   "timestamp": "1718870403", "ports": [ {"port": 443, "proto": "tcp", "service": {"name": "title", "banner": "Login"} } ] },
+{   "ip": "192.0.2.1",   "timestamp": "1718870404", "ports": [ {"port": 22, "proto": "tcp", "status": "open", "reason": "syn-ack", "ttl": 64} ] },
+{   "ip": "192.0.2.1",   "timestamp": "1718870405", "ports": [ {"port": 22, "proto": "tcp", "service": {"name": "ssh", "banner": "SSH-2.0-OpenSSH_9.6"} } ] },
+{   "ip": "192.0.2.2",   "timestamp": "1718870406", "ports": [ {"port": 8080, "proto": "tcp", "status": "open", "reason": "syn-ack", "ttl": 64} ] },
+{   "ip": "192.0.2.2",   "timestamp": "1718870407", "ports": [ {"port": 53, "proto": "udp", "status": "open", "reason": "udp-response", "ttl": 64} ] },
+]
+`
+
+// masscanListFixture masscan -oL 输出
+const masscanListFixture = `#masscan
+open tcp 80 192.0.2.1 1718870400
+open tcp 6379 192.0.2.1 1718870401
+open udp 53 192.0.2.1 1718870402
+banner tcp 80 192.0.2.1 1718870403 http HTTP/1.1 200 OK
+# end
+`
+
+// httpxFixture httpx -json 输出：带url的记录、只有host和port的记录和只有input的记录
+const httpxFixture = `{"timestamp":"2025-06-20T08:00:00Z","url":"https://example.com:8443","input":"example.com:8443","scheme":"https","host":"example.com","port":"8443","status_code":200}
+{"timestamp":"2025-06-20T08:00:01Z","input":"192.0.2.1","scheme":"http","host":"192.0.2.1","port":"8080"}
+
+{"timestamp":"2025-06-20T08:00:02Z","input":"api.example.com"}
+`
+
+func TestParseFormats(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		format string
+		want   []string
+	}{
+		{"nmap", nmapXMLFixture, FormatNmapXML, []string{"tcp://192.0.2.1:22", "http://192.0.2.1:80", "https://192.0.2.1:8443", "https://[2001:db8::1]:443"}},
+		{"masscan-json", masscanJSONFixture, FormatMasscanJSON, []string{"http://192.0.2.1:80", "https://192.0.2.1:443", "tcp://192.0.2.1:22", "http://192.0.2.2:8080"}},
+		{"masscan-list", masscanListFixture, FormatMasscanList, []string{"http://192.0.2.1:80", "tcp://192.0.2.1:6379"}},
+		{"httpx", httpxFixture, FormatHttpx, []string{"https://example.com:8443", "http://192.0.2.1:8080", "api.example.com"}},
+		{"list", "# targets\nexample.com\n\n  192.0.2.0/30  \n", FormatList, []string{"example.com", "192.0.2.0/30"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectFormat([]byte(tt.data)); got != tt.format {
+				t.Errorf("DetectFormat = %s，期望 %s", got, tt.format)
+			}
+			got, err := Parse([]byte(tt.data), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse = %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseUnknownFormat(t *testing.T) {
+	if _, err := Parse([]byte("example.com"), "zmap"); err == nil {
+		t.Errorf("不支持的格式应返回错误")
+	}
+}
+
+func TestMasscanService(t *testing.T) {
+	tests := []struct {
+		names []string
+		port  int
+		want  string
+	}{
+		{[]string{"", "title"}, 8000, "http://192.0.2.1:8000"},
+		{[]string{"title", "X509", "ssl"}, 9000, "https://192.0.2.1:9000"},
+		{[]string{"ssl", "X509"}, 443, "https://192.0.2.1:443"},
+		{[]string{"ssl", "X509"}, 993, "tcp://192.0.2.1:993"},
+		{[]string{"smtp", "ssl"}, 465, "tcp://192.0.2.1:465"},
+		{[]string{""}, 8443, "https://192.0.2.1:8443"},
+		{[]string{""}, 6379, "tcp://192.0.2.1:6379"},
+	}
+	for _, tt := range tests {
+		if got := FormatTarget("192.0.2.1", tt.port, masscanService(tt.names)); got != tt.want {
+			t.Errorf("%v 端口 %d = %s，期望 %s", tt.names, tt.port, got, tt.want)
+		}
+	}
+}
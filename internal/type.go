@@ -2,6 +2,7 @@ package internal
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"strings"
 )
@@ -14,6 +15,7 @@ type Fingerprint struct {
 	Info Info          `json:"info"`           // 指纹信息
 	HTTP []HTTPRequest `json:"http,omitempty"` // HTTP 请求探针列表
 	TCP  []TCPRequest  `json:"tcp,omitempty"`  // TCP 请求探针列表
+	UDP  []UDPRequest  `json:"udp,omitempty"`  // UDP 请求探针列表
 }
 
 // 指纹的元数据信息
@@ -60,21 +62,117 @@ type TCPRequest struct {
 	Name       string       `json:"name"`                 // 服务名
 	Port       string       `json:"port"`                 // 目标端口
 	Inputs     []Input      `json:"inputs"`               // 发送给服务的输入数据
+	TLS        TLSMode      `json:"tls,omitempty"`        // 对TLS的要求: true, false, auto（默认）
+	Probe      string       `json:"probe,omitempty"`      // 使用内置协议模块探测（如mysql、redis），不再匹配banner
+	Softmatch  bool         `json:"softmatch,omitempty"`  // 只确定服务类型，命中后继续发送其他请求寻找同一服务的具体结果
+	Fallback   []string     `json:"fallback,omitempty"`   // 响应未命中时继续用这些请求（按name）的匹配器匹配
 	Matchers   []Matchers   `json:"matchers,omitempty"`   // 添加 omitempty // 这里原来漏了 TCPRequest 的 Matchers
 	Extractors []Extractors `json:"extractors,omitempty"` // 添加 omitempty
 }
 
+// Payload 返回请求发送的数据，各输入的数据按顺序拼接
+func (r TCPRequest) Payload() string {
+	var payload strings.Builder
+	for _, input := range r.Inputs {
+		payload.WriteString(input.Data)
+	}
+	return payload.String()
+}
+
+// Probed 判断请求是否作为TCP探针发送并用匹配器识别：发送数据或带匹配器、且没有使用协议模块的请求，
+// 这类请求只匹配自己发送的数据（没有数据时为端口的banner）得到的响应，不参与按banner的聚类匹配
+func (r TCPRequest) Probed() bool {
+	return r.Probe == "" && (r.Payload() != "" || len(r.Matchers) > 0)
+}
+
+// TLSMode 服务探针对TLS的要求，JSON中写作true、false或"auto"
+type TLSMode string
+
+const (
+	TLSAuto     TLSMode = ""      // 明文和TLS内的响应都可以匹配（默认）
+	TLSRequired TLSMode = "true"  // 服务运行在TLS之上：只匹配TLS内的响应，探测声明的端口时先尝试TLS握手
+	TLSDisabled TLSMode = "false" // 只匹配明文响应
+)
+
+// UnmarshalJSON 支持布尔值和"auto"、"true"、"false"字符串
+func (m *TLSMode) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case nil:
+		*m = TLSAuto
+	case bool:
+		*m = TLSDisabled
+		if v {
+			*m = TLSRequired
+		}
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "", "auto":
+			*m = TLSAuto
+		case "true":
+			*m = TLSRequired
+		case "false":
+			*m = TLSDisabled
+		default:
+			return fmt.Errorf("无效的tls取值: %q（可选: true, false, auto）", v)
+		}
+	default:
+		return fmt.Errorf("无效的tls取值: %s（可选: true, false, auto）", string(data))
+	}
+	return nil
+}
+
+// MarshalJSON true和false输出为布尔值，auto输出为字符串
+func (m TLSMode) MarshalJSON() ([]byte, error) {
+	switch m {
+	case TLSRequired:
+		return []byte("true"), nil
+	case TLSDisabled:
+		return []byte("false"), nil
+	}
+	return []byte(`"auto"`), nil
+}
+
+// Allows 判断在明文或TLS连接上得到的响应是否可以用于匹配
+func (m TLSMode) Allows(overTLS bool) bool {
+	switch m {
+	case TLSRequired:
+		return overTLS
+	case TLSDisabled:
+		return !overTLS
+	}
+	return true
+}
+
+// UDPRequest 定义了一个单独的 UDP 请求探针
+type UDPRequest struct {
+	Name          string       `json:"name"`                      // 服务名
+	Port          string       `json:"port"`                      // 期望的端口，支持逗号分隔和范围
+	Payload       string       `json:"payload"`                   // 发送的数据（十六进制）
+	Retransmits   int          `json:"retransmits,omitempty"`     // 没有响应时的重传次数
+	AnySourcePort bool         `json:"any_source_port,omitempty"` // 服务端可能从其他端口回复（如TFTP），此时无法识别ICMP不可达
+	Matchers      []Matchers   `json:"matchers,omitempty"`        // 响应匹配器，任一命中即识别
+	Extractors    []Extractors `json:"extractors,omitempty"`      // 从响应中提取详细信息
+}
+
 // Input 定义了发送给 TCP 服务的数据（简化版）
 type Input struct {
 	// Data 字段在这里不需要用于特征提取，所以注释掉
 	Read int    `json:"read,omitempty"` // 读取响应的字节数
 	Data string `json:"data,omitempty"` // 添加 Data 字段以匹配示例 JSON
+	Wait int    `json:"wait,omitempty"` // 发送后等待响应的最长时间（毫秒），为0或超过读取超时时使用读取超时
 }
 
 type Extractors struct {
 	Name  string   `json:"name,omitempty"`  // 添加 omitempty
 	Type  string   `json:"type,omitempty"`  // 添加 omitempty
 	Regex []string `json:"regex,omitempty"` // 修改为字符串数组以匹配JSON
+	// 提取结果的模板（nmap版本信息语法）：$1引用分组，$P(1)只保留可打印字符，
+	// $SUBST(1,"_",".")替换分组中的文本，$I(1,">")将分组按大端（"<"为小端）解析为整数；为空时返回第一个分组
+	Template string `json:"template,omitempty"`
 }
 
 type Matchers struct {
@@ -84,6 +182,7 @@ type Matchers struct {
 	Part            string   `json:"part,omitempty"`             // 匹配位置：header,body,response,favicon,all 默认：body
 	Favicon_hash    []string `json:"hash,omitempty"`             // 如果是favicon类型：hash为图标hash列表，支持md5和mmh3
 	Words           []string `json:"words,omitempty"`            // 关键词列表
+	Binary          []string `json:"binary,omitempty"`           // 十六进制字节序列列表，用于匹配二进制响应
 	Status          []int    `json:"status,omitempty"`           // 状态码列表 (用于 "status" 匹配器)
 	CaseInsensitive bool     `json:"case-insensitive,omitempty"` // 是否忽略大小写，默认为false
 	Negative        bool     `json:"negative,omitempty"`         // 是否将匹配结果取反，默认为false
@@ -181,6 +280,9 @@ func CalculateMatcherConfidence(matcher Matchers, content string, headers map[st
 				break
 			}
 		}
+	case "binary":
+		// 字节序列与正则同样精确，使用默认regex置信度
+		confidence = config.MatcherWeights.Regex["default"]
 	default:
 		// 其他类型的匹配器使用最低置信度
 		confidence = config.MinConfidence